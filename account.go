@@ -2,11 +2,13 @@ package trade
 
 import "time"
 
-// Account represents a trading account.
+// Account represents a trading account. Owner holds the _id of the user that
+// owns the account.
 type Account struct {
 	ID                string             `json:"_id"`
-	Owner             string             `json:"owner"`
-	Balances          map[string]float64 `json:"balances"`
+	Rev               string             `json:"_rev,omitempty"`
+	Owner             string             `json:"owner" validate:"required"`
+	Balances          map[string]float64 `json:"balances" validate:"currencies,nonnegative"`
 	Reputation        int                `json:"reputation"`
 	CreationTimestamp time.Time          `json:"creationTimestamp"`
 }
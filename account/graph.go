@@ -0,0 +1,134 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	arango "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/auth"
+	"github.com/go-chi/chi"
+)
+
+var (
+	DEFAULT_COUNTERPARTY_DEPTH = 1
+	MAX_COUNTERPARTY_DEPTH     = 10
+)
+
+var errNoGraph = errors.New("account graph is not configured")
+
+// handleGetCounterparties lists the accounts that have traded with an account,
+// directly or through up to ?depth= intermediaries. Like the other traversals
+// it reaches accounts of other users, so it requires reading any user.
+func (s *service) handleGetCounterparties() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		if s.graph == nil {
+			s.renderer.RenderError(w, r, errNoGraph, http.StatusNotImplemented, "%s", errNoGraph.Error())
+			return
+		}
+		if !s.authorize(w, r, auth.PERMISSION_READ_ANY, "") {
+			return
+		}
+
+		depth := DEFAULT_COUNTERPARTY_DEPTH
+		if depthStr := r.URL.Query().Get("depth"); depthStr != "" {
+			depth, err = strconv.Atoi(depthStr)
+			if err != nil || depth < 1 || depth > MAX_COUNTERPARTY_DEPTH {
+				s.renderer.RenderError(w, r, err, http.StatusBadRequest, "depth must be an integer between 1 and %d", MAX_COUNTERPARTY_DEPTH)
+				return
+			}
+		}
+
+		if !s.exists(ctx, w, r, chi.URLParam(r, "id")) {
+			return
+		}
+		resp, err := s.graph.Neighbors(ctx, trade.DocumentID("accounts", chi.URLParam(r, "id")), depth)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+// handleGetPath returns the shortest chain of transfers between two accounts.
+func (s *service) handleGetPath() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		if s.graph == nil {
+			s.renderer.RenderError(w, r, errNoGraph, http.StatusNotImplemented, "%s", errNoGraph.Error())
+			return
+		}
+		if !s.authorize(w, r, auth.PERMISSION_READ_ANY, "") {
+			return
+		}
+
+		if !s.exists(ctx, w, r, chi.URLParam(r, "id")) || !s.exists(ctx, w, r, chi.URLParam(r, "to")) {
+			return
+		}
+		from := trade.DocumentID("accounts", chi.URLParam(r, "id"))
+		to := trade.DocumentID("accounts", chi.URLParam(r, "to"))
+		resp, err := s.graph.ShortestPath(ctx, from, to)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+		if len(resp) < 1 {
+			s.renderer.RenderError(w, r, nil, http.StatusNotFound, "no path between %s and %s", from, to)
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+// handleGetCommunity lists every account connected to an account through any
+// chain of transactions, including the account itself.
+func (s *service) handleGetCommunity() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		if s.graph == nil {
+			s.renderer.RenderError(w, r, errNoGraph, http.StatusNotImplemented, "%s", errNoGraph.Error())
+			return
+		}
+		if !s.authorize(w, r, auth.PERMISSION_READ_ANY, "") {
+			return
+		}
+
+		if !s.exists(ctx, w, r, chi.URLParam(r, "id")) {
+			return
+		}
+		resp, err := s.graph.Component(ctx, trade.DocumentID("accounts", chi.URLParam(r, "id")))
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+// exists reports whether the account with id exists, as traversals from an
+// account that does not would find nothing rather than fail. Otherwise it
+// renders the error and returns false.
+func (s *service) exists(ctx context.Context, w http.ResponseWriter, r *http.Request, id string) bool {
+	_, err := s.database.Get(ctx, id)
+	if arango.IsNotFoundGeneral(err) {
+		s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+		return false
+	} else if err != nil {
+		s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+		return false
+	}
+	return true
+}
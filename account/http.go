@@ -2,29 +2,54 @@ package account
 
 import (
 	"context"
-	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	arango "github.com/arangodb/go-driver"
 	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/auth"
 	"github.com/go-chi/chi"
 )
 
 // request represents a request body containing account data.
 type request struct {
-	Owner string `json:"owner"`
+	// Owner is the _id, or _key, of the user that owns the account.
+	Owner string `json:"owner" validate:"required"`
+	// Balances are the opening balances of a new account. They are ignored
+	// when updating an account, whose balances only change by settling
+	// transactions.
+	Balances map[string]float64 `json:"balances" validate:"currencies,nonnegative"`
 }
 
-type response[T trade.Account | []trade.Account] struct {
-	Data T `json:"data"`
+// relations are the account fields that can be expanded with ?expand=.
+var relations = map[string]trade.Relation{
+	"owner": {Field: "owner", CollectionName: "users"},
 }
 
-func newResponse[T trade.Account | []trade.Account](data T) response[T] {
+// immutable are the account fields a patch may not change. Balances only
+// change by settling transactions.
+var immutable = []string{"_id", "_rev", "balances", "reputation", "creationTimestamp"}
+
+type response[T trade.Account | []trade.Account | map[string]interface{} | []map[string]interface{}] struct {
+	Data T      `json:"data"`
+	Next string `json:"next,omitempty"`
+}
+
+func newResponse[T trade.Account | []trade.Account | map[string]interface{} | []map[string]interface{}](data T) response[T] {
 	return response[T]{Data: data}
 }
 
+// newPageResponse returns a response holding a single page of results and the
+// cursor of the page after it.
+func newPageResponse[T trade.Account | []trade.Account | map[string]interface{} | []map[string]interface{}](data T, next string) response[T] {
+	return response[T]{Data: data, Next: next}
+}
+
+// handleCreate opens an account. Callers may only open accounts they own
+// unless they may write the resources of any user, which funding an account
+// with opening balances also requires.
 func (s *service) handleCreate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
@@ -37,6 +62,21 @@ func (s *service) handleCreate() http.HandlerFunc {
 			return
 		}
 
+		if reqData.Owner != "" && !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, trade.DocumentID("users", reqData.Owner)) {
+			return
+		}
+		for _, quantity := range reqData.Balances {
+			if quantity > 0 && !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, "") {
+				return
+			}
+		}
+
+		reqData.Owner, err = s.resolveOwner(ctx, reqData.Owner)
+		if err != nil {
+			s.renderOwnerError(w, r, err)
+			return
+		}
+
 		id, resp, err := s.database.Create(ctx, reqData)
 		if err != nil {
 			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
@@ -54,21 +94,41 @@ func (s *service) handleList() http.HandlerFunc {
 		ctx := context.TODO()
 
 		urlQueryParams := []string{"id", "owner", "reputation", "creationTimestamp"}
-		query, err := trade.BuildFilterQueryFromURLParams(trade.NewArangoQueryBuilder("users"), r, urlQueryParams, trade.NewPaginate(r))
-
+		query, err := trade.QueryFromURLParams[trade.Account](r, urlQueryParams, relations)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
 			return
 		}
+		if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_READ_ANY); restricted {
+			query = query.Where(trade.NewFilterKey("owner", trade.Eq, self))
+		}
 
-		resp, err := s.database.Query(ctx, query.String())
+		if query.IsProjected() {
+			resp, err := s.database.QueryRaw(ctx, query)
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+
+			s.renderer.RenderJSON(w, r, http.StatusOK, newPageResponse(resp, trade.NextCursor(query, len(resp))))
+			return
+		}
+
+		// Large exports are streamed from the cursor rather than paged in memory
+		if s.renderer.Streams(r) {
+			s.renderer.RenderStream(w, r, http.StatusOK, func(emit func(row interface{}) error) error {
+				return s.database.QueryEach(ctx, query, func(a trade.Account) error { return emit(a) })
+			})
+			return
+		}
+
+		resp, err := s.database.Query(ctx, query)
 		if err != nil {
 			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
 
-		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+		s.renderer.RenderJSON(w, r, http.StatusOK, newPageResponse(resp, trade.NextCursor(query, len(resp))))
 	}
 }
 
@@ -77,13 +137,38 @@ func (s *service) handleGet() http.HandlerFunc {
 		var err error
 		ctx := context.TODO()
 
-		resp, err := s.database.Get(ctx, chi.URLParam(r, "id"))
+		query, err := trade.QueryFromURLParams[trade.Account](r, nil, relations)
 		if err != nil {
-			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		account, ok := s.authorizeAccount(ctx, w, r, auth.PERMISSION_READ_ANY, chi.URLParam(r, "id"))
+		if !ok {
+			return
+		}
+		// Expanded owners have revisions of their own
+		if len(query.Relations) == 0 && trade.NotModified(w, r, account.Rev) {
 			return
 		}
 
-		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+		if query.IsProjected() {
+			query = query.Where(trade.NewFilterKey("_key", trade.Eq, trade.DocumentKey(chi.URLParam(r, "id")))).Page(0, 1)
+			resp, err := s.database.QueryRaw(ctx, query)
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+			if len(resp) < 1 {
+				s.renderer.RenderError(w, r, nil, http.StatusNotFound, "%s not found", chi.URLParam(r, "id"))
+				return
+			}
+
+			s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp[0]))
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(account))
 	}
 }
 
@@ -98,18 +183,40 @@ func (s *service) handlePut() http.HandlerFunc {
 			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
 			return
 		}
+		data.Balances = map[string]float64{}
+
+		// Moving an account to another user requires writing both users
+		existing, ok := s.authorizeAccount(ctx, w, r, auth.PERMISSION_WRITE_ANY, chi.URLParam(r, "id"))
+		if !ok {
+			return
+		}
+		data.Reputation = existing.Reputation
+		data.CreationTimestamp = existing.CreationTimestamp
+		if data.Owner != "" && !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, trade.DocumentID("users", data.Owner)) {
+			return
+		}
+
+		data.Owner, err = s.resolveOwner(ctx, data.Owner)
+		if err != nil {
+			s.renderOwnerError(w, r, err)
+			return
+		}
 
-		_, err = s.database.Update(ctx, chi.URLParam(r, "id"), data)
+		data, err = s.database.Update(trade.WithIfMatch(ctx, r), chi.URLParam(r, "id"), data)
 		if err != nil {
 			if arango.IsNotFoundGeneral(err) {
 				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
 				return
+			} else if arango.IsPreconditionFailed(err) {
+				s.renderer.RenderError(w, r, err, http.StatusPreconditionFailed, "%s", err.Error())
+				return
 			} else {
 				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 				return
 			}
 		}
 
+		w.Header().Set("ETag", trade.ETag(data.Rev))
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -119,11 +226,18 @@ func (s *service) handleDelete() http.HandlerFunc {
 		var err error
 		ctx := context.TODO()
 
-		err = s.database.Delete(ctx, chi.URLParam(r, "id"))
+		if _, ok := s.authorizeAccount(ctx, w, r, auth.PERMISSION_WRITE_ANY, chi.URLParam(r, "id")); !ok {
+			return
+		}
+
+		err = s.database.Delete(trade.WithIfMatch(ctx, r), chi.URLParam(r, "id"))
 		if err != nil {
 			if arango.IsNotFoundGeneral(err) {
 				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
 				return
+			} else if arango.IsPreconditionFailed(err) {
+				s.renderer.RenderError(w, r, err, http.StatusPreconditionFailed, "%s", err.Error())
+				return
 			} else {
 				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 				return
@@ -134,19 +248,211 @@ func (s *service) handleDelete() http.HandlerFunc {
 	}
 }
 
+// handlePatch applies a JSON Merge Patch or JSON Patch to an account.
+func (s *service) handlePatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		id := chi.URLParam(r, "id")
+
+		existing, ok := s.authorizeAccount(ctx, w, r, auth.PERMISSION_WRITE_ANY, id)
+		if !ok {
+			return
+		}
+
+		data, err := trade.Patch(r, existing, immutable...)
+		if err != nil {
+			s.renderPatchError(w, r, err)
+			return
+		}
+
+		// Moving an account to another user requires writing both users
+		if data.Owner != existing.Owner {
+			if data.Owner != "" && !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, trade.DocumentID("users", data.Owner)) {
+				return
+			}
+			data.Owner, err = s.resolveOwner(ctx, data.Owner)
+			if err != nil {
+				s.renderOwnerError(w, r, err)
+				return
+			}
+		}
+
+		// Replace only the revision the patch was applied to
+		rev := trade.IfMatch(r)
+		if rev == "" {
+			rev = existing.Rev
+		}
+		data, err = s.database.Replace(trade.WithRevision(ctx, rev), id, data)
+		if err != nil {
+			if arango.IsNotFoundGeneral(err) {
+				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+				return
+			} else if arango.IsPreconditionFailed(err) {
+				s.renderer.RenderError(w, r, err, http.StatusPreconditionFailed, "%s", err.Error())
+				return
+			} else {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+		}
+
+		w.Header().Set("ETag", trade.ETag(data.Rev))
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(data))
+	}
+}
+
+// batchOperation returns the opening, update or deletion of an account that a
+// batch item stands for, authorized and validated like the request to the
+// account's own route, or the error failing it.
+func (s *service) batchOperation(ctx context.Context, r *http.Request, item trade.BatchItem) (trade.BatchOperation[trade.Account], error) {
+	op := trade.BatchOperation[trade.Account]{Op: item.Op, ID: item.ID}
+	var reqBody request
+	if err := item.Decode(&reqBody); err != nil {
+		return op, err
+	}
+	authorize := func(owner string) error {
+		if err := auth.Authorize(r.Context(), auth.PERMISSION_WRITE_ANY, owner); err != nil {
+			return trade.WithErrorKind(err, trade.ERROR_FORBIDDEN)
+		}
+		return nil
+	}
+
+	switch item.Op {
+	case trade.BATCH_CREATE:
+		op.Data = trade.Account{Owner: reqBody.Owner, Balances: map[string]float64{}, Reputation: 100, CreationTimestamp: time.Now()}
+		for currency, quantity := range reqBody.Balances {
+			if quantity > 0 {
+				if err := authorize(""); err != nil {
+					return op, err
+				}
+			}
+			op.Data.Balances[currency] = quantity
+		}
+	default:
+		existing, err := s.database.Get(ctx, item.ID)
+		if err != nil {
+			return op, err
+		}
+		if err = authorize(existing.Owner); err != nil || item.Op == trade.BATCH_DELETE {
+			return op, err
+		}
+		op.Data = trade.Account{Owner: reqBody.Owner, Balances: map[string]float64{}, Reputation: existing.Reputation, CreationTimestamp: existing.CreationTimestamp}
+	}
+
+	// Moving an account to another user requires writing both users
+	if err := authorize(trade.DocumentID("users", op.Data.Owner)); err != nil {
+		return op, err
+	}
+	owner, err := s.resolveOwner(ctx, op.Data.Owner)
+	switch {
+	case errors.Is(err, errMissingOwner):
+		return op, trade.WithErrorKind(err, trade.ERROR_BAD_REQUEST)
+	case errors.Is(err, errUnknownOwner):
+		return op, trade.WithErrorKind(err, trade.ERROR_UNPROCESSABLE)
+	case err != nil:
+		return op, err
+	}
+	op.Data.Owner = owner
+	return op, nil
+}
+
+var (
+	errMissingOwner = errors.New("account owner is required")
+	errUnknownOwner = errors.New("account owner does not exist")
+)
+
+// resolveOwner returns the _id of the user identified by owner, which may be
+// either a user _key or _id. Returns errMissingOwner if owner is empty and
+// errUnknownOwner if no such user exists.
+func (s *service) resolveOwner(ctx context.Context, owner string) (string, error) {
+	if owner == "" {
+		return "", errMissingOwner
+	}
+
+	owner = trade.DocumentID("users", owner)
+	if s.users == nil {
+		return owner, nil
+	}
+
+	_, err := s.users.Get(ctx, owner)
+	if err != nil {
+		if arango.IsNotFoundGeneral(err) {
+			return "", fmt.Errorf("%w: %s", errUnknownOwner, owner)
+		}
+		return "", err
+	}
+
+	return owner, nil
+}
+
+// renderOwnerError renders an error returned by resolveOwner.
+func (s *service) renderOwnerError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errMissingOwner):
+		s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+	case errors.Is(err, errUnknownOwner):
+		s.renderer.RenderError(w, r, err, http.StatusUnprocessableEntity, "%s", err.Error())
+	default:
+		s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+	}
+}
+
+// renderPatchError renders an error returned by applying a patch to an
+// account.
+func (s *service) renderPatchError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, trade.ErrUnsupportedPatch):
+		s.renderer.RenderError(w, r, err, http.StatusUnsupportedMediaType, "%s", err.Error())
+	case errors.Is(err, trade.ErrInvalidPatch):
+		s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+	case errors.Is(err, trade.ErrImmutableField):
+		s.renderer.RenderError(w, r, err, http.StatusUnprocessableEntity, "%s", err.Error())
+	case errors.Is(err, trade.ErrPatchTestFailed):
+		s.renderer.RenderError(w, r, err, http.StatusConflict, "%s", err.Error())
+	default:
+		s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+	}
+}
+
+// authorize renders 403 Forbidden and returns false unless the caller of r is
+// the user with _id owner or holds perm.
+func (s *service) authorize(w http.ResponseWriter, r *http.Request, perm auth.Permission, owner string) bool {
+	if err := auth.Authorize(r.Context(), perm, owner); err != nil {
+		s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+		return false
+	}
+	return true
+}
+
+// authorizeAccount returns the account with id if the caller of r owns it or
+// holds perm. Otherwise it renders the error and returns false.
+func (s *service) authorizeAccount(ctx context.Context, w http.ResponseWriter, r *http.Request, perm auth.Permission, id string) (trade.Account, bool) {
+	account, err := s.database.Get(ctx, id)
+	if err != nil {
+		if arango.IsNotFoundGeneral(err) {
+			s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+		} else {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+		}
+		return trade.Account{}, false
+	}
+	return account, s.authorize(w, r, perm, account.Owner)
+}
+
 // bindRequest is a helper function for binding data from a request to an
 // account object.
 func bindRequest(r *http.Request, a *trade.Account) error {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
+	var reqBody request
+	if err := trade.DecodeRequest(r, &reqBody); err != nil {
 		return err
 	}
 
-	var reqBody request
-	err = json.Unmarshal(body, &reqBody)
-
 	a.Owner = reqBody.Owner
 	a.Balances = map[string]float64{}
+	for currency, quantity := range reqBody.Balances {
+		a.Balances[currency] = quantity
+	}
 	a.Reputation = 100
 	a.CreationTimestamp = time.Now()
 
@@ -11,7 +11,11 @@ func (s *service) Routes() chi.Router {
 	r.Route("/{id}", func(r chi.Router) {
 		r.Get("/", s.handleGet())
 		r.Put("/", s.handlePut())
+		r.Patch("/", s.handlePatch())
 		r.Delete("/", s.handleDelete())
+		r.Get("/counterparties", s.handleGetCounterparties())
+		r.Get("/path/{to}", s.handleGetPath())
+		r.Get("/community", s.handleGetCommunity())
 	})
 
 	return r
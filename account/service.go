@@ -11,14 +11,32 @@ import (
 // Repository is the API for the Account datastore.
 type Repository interface {
 	Create(ctx context.Context, a trade.Account) (string, trade.Account, error)
-	Query(ctx context.Context, query string) ([]trade.Account, error)
+	Query(ctx context.Context, q trade.Query) ([]trade.Account, error)
+	QueryEach(ctx context.Context, q trade.Query, fn func(a trade.Account) error) error
+	QueryRaw(ctx context.Context, q trade.Query) ([]map[string]interface{}, error)
 	Get(ctx context.Context, id string) (trade.Account, error)
 	Update(ctx context.Context, id string, a trade.Account) (trade.Account, error)
+	Replace(ctx context.Context, id string, a trade.Account) (trade.Account, error)
 	Delete(ctx context.Context, id string) error
+	Batch(ctx context.Context, ops []trade.BatchOperation[trade.Account], atomic bool) ([]trade.BatchResult[trade.Account], error)
+}
+
+// UserRepository is the API for looking up the users that own accounts.
+type UserRepository interface {
+	Get(ctx context.Context, id string) (trade.User, error)
+}
+
+// Graph is the API for traversing the accounts linked by transactions.
+type Graph interface {
+	Neighbors(ctx context.Context, start string, depth int) ([]trade.Account, error)
+	ShortestPath(ctx context.Context, from, to string) ([]trade.Account, error)
+	Component(ctx context.Context, start string) ([]trade.Account, error)
 }
 
 type Renderer interface {
 	RenderJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, body interface{})
+	Streams(r *http.Request) bool
+	RenderStream(w http.ResponseWriter, r *http.Request, httpStatusCode int, stream func(emit func(row interface{}) error) error)
 	RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any)
 }
 
@@ -27,6 +45,8 @@ type Renderer interface {
 type service struct {
 	router   chi.Router
 	database Repository
+	users    UserRepository
+	graph    Graph
 	renderer Renderer
 }
 
@@ -42,6 +62,8 @@ func New(r chi.Router, endpoint string, database Repository, renderer Renderer,
 	for _, option := range options {
 		option(svc)
 	}
+	// Registered once the options, which may replace the repository, are applied
+	r.Post(endpoint+":batch", trade.HandleBatch(svc.renderer, svc.batchOperation, svc.database.Batch))
 
 	return svc
 }
@@ -53,3 +75,19 @@ func WithRepository(repo Repository) func(*service) {
 		s.database = repo
 	}
 }
+
+// WithUserRepository is a functional option for configuring the repository an
+// account service checks account owners against.
+func WithUserRepository(repo UserRepository) func(*service) {
+	return func(s *service) {
+		s.users = repo
+	}
+}
+
+// WithGraph is a functional option for configuring the graph an account
+// service traverses for counterparty, path and community queries.
+func WithGraph(graph Graph) func(*service) {
+	return func(s *service) {
+		s.graph = graph
+	}
+}
@@ -0,0 +1,16 @@
+package trade
+
+import "time"
+
+// APIKey is a credential issued to a user. Only the SHA-256 hash of the key is
+// stored; the key itself is shown once, when it is issued. Prefix identifies
+// the key so it can be looked up without its secret part.
+type APIKey struct {
+	ID                string    `json:"_id"`
+	Owner             string    `json:"owner"`
+	Name              string    `json:"name"`
+	Prefix            string    `json:"prefix"`
+	Hash              string    `json:"hash"`
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+	Revoked           bool      `json:"revoked"`
+}
@@ -2,32 +2,97 @@ package app
 
 import (
 	"context"
+	"crypto/rsa"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	arangodriver "github.com/arangodb/go-driver"
 	"github.com/gabriel-ross/trade"
 	"github.com/gabriel-ross/trade/account"
+	"github.com/gabriel-ross/trade/auth"
+	"github.com/gabriel-ross/trade/idempotency"
+	"github.com/gabriel-ross/trade/ledger"
+	"github.com/gabriel-ross/trade/report"
+	"github.com/gabriel-ross/trade/seed"
+	"github.com/gabriel-ross/trade/simulate"
 	"github.com/gabriel-ross/trade/transaction"
 	"github.com/gabriel-ross/trade/user"
 	"github.com/go-chi/chi"
 )
 
-// Config contains all the settings for an application instance.
+// Config contains all the settings for an application instance. It is loaded
+// with the config package.
 type Config struct {
-	PORT             string `env:"PORT" default:"80" required:"false"`
-	DB_ADDRESS       string `env:"DB_ADDRESS" default:"8529" required:"true"`
-	DB_NAME          string `env:"DB_NAME" default:"demo" required:"false"`
-	createOnNotExist bool
+	PORT                      string        `env:"PORT" yaml:"port" default:"80" usage:"port the server listens on"`
+	DB_BACKEND                string        `env:"DB_BACKEND" yaml:"dbBackend" flag:"backend" default:"arango" usage:"database backend: arango, bolt or memory"`
+	DB_ADDRESS                []string      `env:"DB_ADDRESS" yaml:"dbAddress" default:"http://localhost:8529" required:"true" usage:"comma separated addresses of the ArangoDB coordinators, tried in turn when one cannot be reached"`
+	DB_NAME                   string        `env:"DB_NAME" yaml:"dbName" default:"trade" usage:"name of the ArangoDB database"`
+	DB_PATH                   string        `env:"DB_PATH" yaml:"dbPath" default:"trade.db" usage:"database file used by the bolt backend"`
+	DB_AUTH                   string        `env:"DB_AUTH" yaml:"dbAuth" default:"basic" usage:"ArangoDB authentication with DB_USERNAME and DB_PASSWORD: basic or jwt"`
+	DB_USERNAME               string        `env:"DB_USERNAME" yaml:"dbUsername" usage:"ArangoDB user; requests are not authenticated if neither it nor DB_JWT is set"`
+	DB_PASSWORD               string        `env:"DB_PASSWORD" yaml:"dbPassword" secret:"true" usage:"password of DB_USERNAME"`
+	DB_JWT                    string        `env:"DB_JWT" yaml:"dbJWT" secret:"true" usage:"JWT to authenticate to ArangoDB with instead of a username"`
+	DB_CA_FILE                string        `env:"DB_CA_FILE" yaml:"dbCAFile" usage:"PEM file of the certificate authorities trusted for https ArangoDB addresses"`
+	DB_CONN_LIMIT             int           `env:"DB_CONN_LIMIT" yaml:"dbConnLimit" default:"32" usage:"maximum number of connections to each ArangoDB coordinator"`
+	DB_TIMEOUT                time.Duration `env:"DB_TIMEOUT" yaml:"dbTimeout" default:"1m" usage:"timeout of ArangoDB requests"`
+	DB_CONNECT_ATTEMPTS       int           `env:"DB_CONNECT_ATTEMPTS" yaml:"dbConnectAttempts" default:"10" usage:"number of times to try reaching ArangoDB at startup"`
+	DB_CONNECT_BACKOFF        time.Duration `env:"DB_CONNECT_BACKOFF" yaml:"dbConnectBackoff" default:"500ms" usage:"wait before the second attempt to reach ArangoDB, doubled after each failure"`
+	AUTH_DISABLED             bool          `env:"AUTH_DISABLED" yaml:"authDisabled" usage:"serve every route without authentication; for local environments only"`
+	AUTH_JWT_ALGORITHM        string        `env:"AUTH_JWT_ALGORITHM" yaml:"authJWTAlgorithm" default:"HS256" usage:"algorithm JWTs are signed with: HS256 or RS256"`
+	AUTH_JWT_SECRET           string        `env:"AUTH_JWT_SECRET" yaml:"authJWTSecret" secret:"true" usage:"HS256 signing secret; JWTs are not accepted if it is unset"`
+	AUTH_JWT_PRIVATE_KEY_FILE string        `env:"AUTH_JWT_PRIVATE_KEY_FILE" yaml:"authJWTPrivateKeyFile" usage:"PEM file of the RS256 signing key"`
+	AUTH_JWT_PUBLIC_KEY_FILE  string        `env:"AUTH_JWT_PUBLIC_KEY_FILE" yaml:"authJWTPublicKeyFile" usage:"PEM file of the RS256 verification key, if JWTs are issued elsewhere"`
+	AUTH_JWT_ISSUER           string        `env:"AUTH_JWT_ISSUER" yaml:"authJWTIssuer" default:"trade" usage:"issuer of the JWTs the server signs and accepts"`
+	AUTH_TOKEN_TTL            time.Duration `env:"AUTH_TOKEN_TTL" yaml:"authTokenTTL" default:"15m" usage:"lifetime of the JWTs the server signs"`
+	IDEMPOTENCY_TTL           time.Duration `env:"IDEMPOTENCY_TTL" yaml:"idempotencyTTL" default:"24h" usage:"how long responses to requests with an Idempotency-Key are replayed for"`
+	createOnNotExist          bool
 }
 
+// Supported values of Config.DB_BACKEND.
+var (
+	BACKEND_ARANGO = "arango"
+	BACKEND_BOLT   = "bolt"
+	BACKEND_MEMORY = "memory"
+	SCHEMA_PATH    = "./db/arango_schema.json"
+)
+
+// Supported values of Config.DB_AUTH.
+var (
+	DB_AUTH_BASIC = "basic"
+	DB_AUTH_JWT   = "jwt"
+)
+
 // application is the entrypoint to the program and houses the necessary
 // dependencies.
 type application struct {
 	cnf      Config
 	router   chi.Router
 	dbClient arangodriver.Database
+	memoryDB *trade.MemoryDatabase
+	boltDB   *trade.BoltDatabase
+	backend  backend
+	keys     keyIssuer
+}
+
+// keyIssuer issues API keys to users.
+type keyIssuer interface {
+	IssueKey(ctx context.Context, owner, name string) (string, trade.APIKey, error)
+}
+
+// backend bundles the datastores the services are built on.
+type backend struct {
+	users        user.Repository
+	accounts     account.Repository
+	transactions transaction.Repository
+	settler      *trade.Settler
+	graph        account.Graph
+	searcher     user.Searcher
+	reports      report.Repository
+	apiKeys      auth.KeyRepository
+	idempotency  idempotency.Repository
 }
 
 // New instantiates a new application according to cnf and options and returns
@@ -43,24 +108,189 @@ func New(cnf Config, options ...func(*application)) *application {
 		option(a)
 	}
 
-	arangoClient, err := trade.NewArangoClient([]string{a.cnf.DB_ADDRESS})
+	switch a.cnf.DB_BACKEND {
+	case BACKEND_MEMORY:
+		a.backend = a.memoryBackend()
+	case BACKEND_BOLT:
+		a.backend = a.boltBackend()
+	case BACKEND_ARANGO, "":
+		a.backend = a.arangoBackend()
+	default:
+		log.Fatalf("unknown database backend %q", a.cnf.DB_BACKEND)
+	}
+
+	// Every response carries the ID of its request, as do error bodies
+	a.router.Use(trade.RequestID)
+	// No handler reads more than MAX_REQUEST_BODY_SIZE bytes of a body
+	a.router.Use(trade.LimitRequestBody)
+	a.router.Get("/ping", a.Ping())
+
+	// Instantiate and register services
+	b := a.backend
+	tokens, err := a.tokens()
+	if err != nil {
+		log.Fatalf("error configuring authentication %v", err)
+	}
+	authService := auth.New(a.router, "/auth", b.apiKeys, b.users, &trade.RenderService{},
+		auth.WithJWT(tokens))
+	a.keys = authService
+
+	// Every other route requires authentication and replays retried requests.
+	// Responses of the auth routes are not stored, as they hold credentials.
+	idempotencyService := idempotency.New(b.idempotency, &trade.RenderService{},
+		idempotency.WithTTL(a.cnf.IDEMPOTENCY_TTL))
+	a.router.Group(func(r chi.Router) {
+		if !a.cnf.AUTH_DISABLED {
+			r.Use(authService.Authenticate)
+		}
+		r.Use(idempotencyService.Idempotent)
+		user.New(r, "/users", b.users, &trade.RenderService{},
+			user.WithAccountRepository(b.accounts),
+			user.WithTransactionRepository(b.transactions),
+			user.WithSearcher(b.searcher))
+		account.New(r, "/accounts", b.accounts, &trade.RenderService{},
+			account.WithUserRepository(b.users),
+			account.WithGraph(b.graph))
+		transaction.New(r, "/transactions", b.transactions, &trade.RenderService{},
+			transaction.WithSettler(b.settler),
+			transaction.WithAccountRepository(b.accounts))
+		// Reports aggregate the transactions of every user
+		report.New(r.With(authService.Require(auth.PERMISSION_READ_ANY)), "/reports", b.reports, &trade.RenderService{})
+	})
+
+	return a
+}
+
+// tokens returns the JWTs configured by a.cnf, or nil if none are.
+func (a *application) tokens() (*auth.JWT, error) {
+	switch a.cnf.AUTH_JWT_ALGORITHM {
+	case auth.ALG_HS256, "":
+		if a.cnf.AUTH_JWT_SECRET == "" {
+			return nil, nil
+		}
+		return auth.NewHS256([]byte(a.cnf.AUTH_JWT_SECRET), a.cnf.AUTH_JWT_ISSUER, a.cnf.AUTH_TOKEN_TTL), nil
+	case auth.ALG_RS256:
+		if a.cnf.AUTH_JWT_PRIVATE_KEY_FILE == "" && a.cnf.AUTH_JWT_PUBLIC_KEY_FILE == "" {
+			return nil, fmt.Errorf("%s requires a private or public key file", auth.ALG_RS256)
+		}
+		var err error
+		var privateKey *rsa.PrivateKey
+		var publicKey *rsa.PublicKey
+		if a.cnf.AUTH_JWT_PRIVATE_KEY_FILE != "" {
+			if privateKey, err = auth.LoadRSAPrivateKey(a.cnf.AUTH_JWT_PRIVATE_KEY_FILE); err != nil {
+				return nil, err
+			}
+		}
+		if a.cnf.AUTH_JWT_PUBLIC_KEY_FILE != "" {
+			if publicKey, err = auth.LoadRSAPublicKey(a.cnf.AUTH_JWT_PUBLIC_KEY_FILE); err != nil {
+				return nil, err
+			}
+		}
+		return auth.NewRS256(privateKey, publicKey, a.cnf.AUTH_JWT_ISSUER, a.cnf.AUTH_TOKEN_TTL), nil
+	default:
+		return nil, fmt.Errorf("unknown JWT algorithm %q", a.cnf.AUTH_JWT_ALGORITHM)
+	}
+}
+
+// arangoBackend connects to the ArangoDB database a.cnf.DB_NAME and returns
+// datastores backed by it. The server is retried with backoff at startup so
+// that the application can start alongside it.
+func (a *application) arangoBackend() backend {
+	options, err := a.arangoClientOptions()
+	if err != nil {
+		log.Fatalf("error configuring arangodb client %v", err)
+	}
+	arangoClient, err := trade.NewArangoClient(a.cnf.DB_ADDRESS, options...)
 	if err != nil {
 		log.Fatalf("error instantiating arangodb client %v", err)
 	}
 
-	a.dbClient, err = arangoClient.Database(context.TODO(), a.cnf.DB_NAME, true, "./db/arango_schema.json")
+	ctx := context.TODO()
+	if err = arangoClient.WaitReady(ctx, a.cnf.DB_CONNECT_ATTEMPTS, a.cnf.DB_CONNECT_BACKOFF); err != nil {
+		log.Fatalf("error reaching arangodb at %s %v", strings.Join(a.cnf.DB_ADDRESS, ","), err)
+	}
+	a.dbClient, err = arangoClient.Database(ctx, a.cnf.DB_NAME, a.cnf.createOnNotExist, SCHEMA_PATH)
 	if err != nil {
 		log.Fatalf("error connecting to database %v", err)
 	}
 
-	a.router.Get("/ping", a.Ping())
+	return backend{
+		users:        trade.NewArangoRepository[trade.User](a.dbClient, "users"),
+		accounts:     trade.NewArangoRepository[trade.Account](a.dbClient, "accounts"),
+		transactions: trade.NewArangoRepository[trade.Transaction](a.dbClient, "transactions"),
+		settler:      trade.NewArangoSettler(a.dbClient, "accounts", "transactions"),
+		graph:        trade.NewArangoGraph[trade.Account](a.dbClient, trade.TRADING_GRAPH_NAME),
+		searcher:     trade.NewArangoSearchView[trade.User](a.dbClient, trade.USER_SEARCH_VIEW_NAME, trade.USER_SEARCH_FIELDS),
+		reports:      report.NewRepository(a.dbClient, "transactions", "accounts"),
+		apiKeys:      trade.NewArangoRepository[trade.APIKey](a.dbClient, "apikeys"),
+		idempotency:  trade.NewArangoRepository[trade.IdempotencyRecord](a.dbClient, "idempotency"),
+	}
+}
 
-	// Instantiate and register services
-	user.New(a.router, "/users", trade.NewArangoRepository[trade.User](a.dbClient, "users"), &trade.RenderService{})
-	account.New(a.router, "/accounts", trade.NewArangoRepository[trade.Account](a.dbClient, "accounts"), &trade.RenderService{})
-	transaction.New(a.router, "/transactions", trade.NewArangoRepository[trade.Transaction](a.dbClient, "transactions"), &trade.RenderService{})
+// arangoClientOptions returns the options of the ArangoDB client configured
+// by a.cnf.
+func (a *application) arangoClientOptions() ([]trade.ArangoClientOption, error) {
+	options := []trade.ArangoClientOption{
+		trade.WithArangoConnLimit(a.cnf.DB_CONN_LIMIT),
+		trade.WithArangoTimeout(a.cnf.DB_TIMEOUT),
+	}
+	switch {
+	case a.cnf.DB_JWT != "":
+		options = append(options, trade.WithArangoJWT(a.cnf.DB_JWT))
+	case a.cnf.DB_USERNAME == "":
+	case a.cnf.DB_AUTH == DB_AUTH_BASIC || a.cnf.DB_AUTH == "":
+		options = append(options, trade.WithArangoBasicAuth(a.cnf.DB_USERNAME, a.cnf.DB_PASSWORD))
+	case a.cnf.DB_AUTH == DB_AUTH_JWT:
+		options = append(options, trade.WithArangoJWTAuth(a.cnf.DB_USERNAME, a.cnf.DB_PASSWORD))
+	default:
+		return nil, fmt.Errorf("unknown authentication %q", a.cnf.DB_AUTH)
+	}
+	if a.cnf.DB_CA_FILE != "" {
+		options = append(options, trade.WithArangoCAFile(a.cnf.DB_CA_FILE))
+	}
+	return options, nil
+}
 
-	return a
+// memoryBackend returns datastores backed by a new in-memory database.
+func (a *application) memoryBackend() backend {
+	a.memoryDB = trade.NewMemoryDatabase()
+	accounts := trade.NewMemoryRepository[trade.Account](a.memoryDB, "accounts")
+	transactions := trade.NewMemoryRepository[trade.Transaction](a.memoryDB, "transactions")
+	return backend{
+		users:        trade.NewMemoryRepository[trade.User](a.memoryDB, "users"),
+		accounts:     accounts,
+		transactions: transactions,
+		settler:      trade.NewSettler(accounts, transactions),
+		graph:        trade.NewMemoryGraph[trade.Account](a.memoryDB, "transactions"),
+		searcher:     trade.NewMemorySearchView[trade.User](a.memoryDB, "users", trade.USER_SEARCH_FIELDS),
+		reports:      report.NewMemoryRepository(a.memoryDB, "transactions", "accounts"),
+		apiKeys:      trade.NewMemoryRepository[trade.APIKey](a.memoryDB, "apikeys"),
+		idempotency:  trade.NewMemoryRepository[trade.IdempotencyRecord](a.memoryDB, "idempotency"),
+	}
+}
+
+// boltBackend opens the embedded database file a.cnf.DB_PATH and returns
+// datastores backed by it.
+func (a *application) boltBackend() backend {
+	var err error
+	a.boltDB, err = trade.OpenBoltDatabase(a.cnf.DB_PATH, a.cnf.createOnNotExist, SCHEMA_PATH)
+	if err != nil {
+		log.Fatalf("error opening database %v", err)
+	}
+
+	accounts := trade.NewBoltRepository[trade.Account](a.boltDB, "accounts")
+	transactions := trade.NewBoltRepository[trade.Transaction](a.boltDB, "transactions")
+	return backend{
+		users:        trade.NewBoltRepository[trade.User](a.boltDB, "users"),
+		accounts:     accounts,
+		transactions: transactions,
+		settler:      trade.NewSettler(accounts, transactions),
+		graph:        trade.NewMemoryGraph[trade.Account](a.boltDB, "transactions"),
+		searcher:     trade.NewMemorySearchView[trade.User](a.boltDB, "users", trade.USER_SEARCH_FIELDS),
+		reports:      report.NewMemoryRepository(a.boltDB, "transactions", "accounts"),
+		apiKeys:      trade.NewBoltRepository[trade.APIKey](a.boltDB, "apikeys"),
+		idempotency:  trade.NewBoltRepository[trade.IdempotencyRecord](a.boltDB, "idempotency"),
+	}
 }
 
 // WithCreateOnNotExist is an application functional option. If set to true
@@ -73,12 +303,81 @@ func WithCreateOnNotExist(flag bool) func(*application) {
 	}
 }
 
+// WithMemoryDatabase is an application functional option. If set to true the
+// application stores its data in memory instead of connecting to ArangoDB.
+// Data is lost when the application exits.
+func WithMemoryDatabase(flag bool) func(*application) {
+	return func(a *application) {
+		if flag {
+			a.cnf.DB_BACKEND = BACKEND_MEMORY
+		}
+	}
+}
+
+// Seed loads f into the application's database. If reset is set all users,
+// accounts and transactions are deleted first.
+func (a *application) Seed(ctx context.Context, f seed.File, reset bool) (seed.Result, error) {
+	loader := seed.New(a.backend.users, a.backend.accounts, a.backend.transactions, a.backend.settler)
+	if reset {
+		if _, err := loader.Reset(ctx); err != nil {
+			return seed.Result{}, err
+		}
+	}
+	return loader.Load(ctx, f)
+}
+
+// ExportTransactions writes the transactions selected by f to w, oldest
+// first, and returns the number of transactions written.
+func (a *application) ExportTransactions(ctx context.Context, w *ledger.Writer, f ledger.Filter) (int, error) {
+	return ledger.Export(ctx, a.backend.transactions, f.Query(), w)
+}
+
+// ImportTransactions imports the transactions read from r in mode, mapping
+// the account references of refs, and returns a summary listing the
+// transactions that failed.
+func (a *application) ImportTransactions(ctx context.Context, r *ledger.Reader, mode string, refs map[string]string) (ledger.Result, error) {
+	importer := ledger.NewImporter(a.backend.accounts, a.backend.transactions, a.backend.settler, ledger.WithRefs(refs))
+	return importer.Import(ctx, r, mode)
+}
+
+// IssueAPIKey issues a new API key named name to the user with _id owner and
+// returns the key, which cannot be recovered later, and its stored record.
+func (a *application) IssueAPIKey(ctx context.Context, owner, name string) (string, trade.APIKey, error) {
+	return a.keys.IssueKey(ctx, owner, name)
+}
+
+// SetRoles replaces the roles of the user with _id id and returns the updated
+// user.
+func (a *application) SetRoles(ctx context.Context, id string, roles []string) (trade.User, error) {
+	if err := auth.ValidateRoles(roles); err != nil {
+		return trade.User{}, err
+	}
+	u, err := a.backend.users.Get(ctx, id)
+	if err != nil {
+		return trade.User{}, err
+	}
+	u.Roles = append([]string{}, roles...)
+	return a.backend.users.Update(ctx, id, u)
+}
+
+// SimulationTarget returns a target that drives the application's datastores
+// and settler directly, bypassing HTTP.
+func (a *application) SimulationTarget() simulate.Target {
+	return simulate.NewRepositoryTarget(a.backend.users, a.backend.accounts, a.backend.settler)
+}
+
 // Run runs the application on a.cnf.PORT
 func (a *application) Run() error {
 	fmt.Println("application running on port ", a.cnf.PORT)
 	return http.ListenAndServe(":"+a.cnf.PORT, a.router)
 }
 
+// ServeHTTP serves r with the application's routes, so that an application
+// can be mounted in another server or tested with httptest.
+func (a *application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.router.ServeHTTP(w, r)
+}
+
 func (a *application) Ping() http.HandlerFunc {
 	resp := fmt.Sprintf("server is healthy and running at port %s", a.cnf.PORT)
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,1042 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/arangotest"
+	"github.com/gabriel-ross/trade/report"
+	"github.com/gabriel-ross/trade/seed"
+)
+
+// TestArangoEndToEnd drives the full application, backed by a fake ArangoDB
+// server, through its HTTP API.
+func TestArangoEndToEnd(t *testing.T) {
+	db := arangotest.NewServer()
+	defer db.Close()
+
+	schemaPath := SCHEMA_PATH
+	SCHEMA_PATH = "../db/arango_schema.json"
+	defer func() { SCHEMA_PATH = schemaPath }()
+
+	a := New(Config{
+		DB_BACKEND: BACKEND_ARANGO,
+		DB_ADDRESS: db.Endpoints(),
+		DB_NAME:    "trade",
+	}, WithCreateOnNotExist(true))
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+	authorize(t, a, srv)
+
+	var user struct {
+		Data struct {
+			ID string `json:"_id"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/users", `{"name": "Ada", "email": "ada@example.com"}`, http.StatusCreated, &user)
+	userKey := strings.TrimPrefix(user.Data.ID, "users/")
+
+	accounts := make([]string, 2)
+	for i := range accounts {
+		var account struct {
+			Data struct {
+				ID string `json:"_id"`
+			} `json:"data"`
+		}
+		do(t, srv, http.MethodPost, "/accounts", fmt.Sprintf(`{"owner": %q}`, user.Data.ID), http.StatusCreated, &account)
+		accounts[i] = account.Data.ID
+	}
+	do(t, srv, http.MethodPost, "/accounts", `{"owner": "users/missing"}`, http.StatusUnprocessableEntity, nil)
+
+	var expanded struct {
+		Data []struct {
+			Owner struct {
+				Name string `json:"name"`
+			} `json:"owner"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodGet, "/accounts?expand=owner&fields=owner", "", http.StatusOK, &expanded)
+	if len(expanded.Data) != 2 || expanded.Data[0].Owner.Name != "Ada" {
+		t.Errorf("GET /accounts?expand=owner returned %+v", expanded.Data)
+	}
+
+	var page struct {
+		Data []json.RawMessage `json:"data"`
+		Next string            `json:"next"`
+	}
+	do(t, srv, http.MethodGet, "/users/"+userKey+"/accounts?limit=1", "", http.StatusOK, &page)
+	if len(page.Data) != 1 || page.Next == "" {
+		t.Fatalf("first page of accounts returned %d accounts and cursor %q", len(page.Data), page.Next)
+	}
+	do(t, srv, http.MethodGet, "/users/"+userKey+"/accounts?limit=1&cursor="+page.Next, "", http.StatusOK, &page)
+	if len(page.Data) != 1 {
+		t.Fatalf("second page of accounts returned %d accounts", len(page.Data))
+	}
+	do(t, srv, http.MethodGet, "/users/"+userKey+"/accounts?limit=abc", "", http.StatusBadRequest, nil)
+	do(t, srv, http.MethodGet, "/users/"+userKey+"/accounts?offset=-1", "", http.StatusBadRequest, nil)
+
+	// Accounts are opened empty, so fund one by seeding it
+	transfer := fmt.Sprintf(`{"sender": %q, "recipient": %q, "quantities": {"dollars": 10}}`, accounts[0], accounts[1])
+	do(t, srv, http.MethodPost, "/transactions", transfer, http.StatusUnprocessableEntity, nil)
+	seeded, err := a.Seed(context.Background(), seed.File{
+		Accounts: []seed.Account{{Ref: "funded", Owner: user.Data.ID, Balances: map[string]float64{"dollars": 15}}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funded := seeded.Refs["funded"]
+
+	transfer = fmt.Sprintf(`{"sender": %q, "recipient": %q, "quantities": {"dollars": 10}}`, funded, accounts[1])
+	do(t, srv, http.MethodPost, "/transactions", transfer, http.StatusCreated, nil)
+	do(t, srv, http.MethodPost, "/transactions", transfer, http.StatusUnprocessableEntity, nil)
+	var transactions struct {
+		Data []struct {
+			ID     string `json:"_id"`
+			Sender string `json:"_from"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodGet, "/transactions?_from="+funded, "", http.StatusOK, &transactions)
+	if len(transactions.Data) != 1 || transactions.Data[0].Sender != funded {
+		t.Errorf("GET /transactions?_from= returned %+v", transactions.Data)
+	}
+	var sparse struct {
+		Data []map[string]json.RawMessage `json:"data"`
+	}
+	do(t, srv, http.MethodGet, "/transactions?fields=quantities&expand=sender,recipient&sort=timestamp+desc,_key", "", http.StatusOK, &sparse)
+	if len(sparse.Data) != 1 || len(sparse.Data[0]) != 3 || !strings.Contains(string(sparse.Data[0]["_from"]), `"balances"`) ||
+		!strings.Contains(string(sparse.Data[0]["_to"]), accounts[1]) {
+		t.Errorf("GET /transactions?fields=quantities&expand=sender,recipient returned %+v", sparse.Data)
+	}
+	do(t, srv, http.MethodGet, "/transactions?fields=quantity", "", http.StatusBadRequest, nil)
+	do(t, srv, http.MethodGet, "/accounts?sort=name", "", http.StatusBadRequest, nil)
+	var recipient struct {
+		Data struct {
+			Balances map[string]float64 `json:"balances"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodGet, "/accounts/"+strings.TrimPrefix(accounts[1], "accounts/"), "", http.StatusOK, &recipient)
+	if recipient.Data.Balances["dollars"] != 10 {
+		t.Errorf("recipient balances after settlement are %v", recipient.Data.Balances)
+	}
+
+	do(t, srv, http.MethodDelete, "/users/"+userKey, "", http.StatusConflict, nil)
+
+	// Neither accounts with transactions nor a stale revision delete any
+	// account
+	do(t, srv, http.MethodDelete, "/users/"+userKey+"?cascade=true", "", http.StatusConflict, nil)
+	do(t, srv, http.MethodDelete, "/transactions/"+strings.TrimPrefix(transactions.Data[0].ID, "transactions/"), "", http.StatusNoContent, nil)
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/users/"+userKey+"?cascade=true", nil)
+	req.Header.Set("If-Match", `"stale"`)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("cascading DELETE with a stale If-Match returned %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	do(t, srv, http.MethodGet, "/accounts", "", http.StatusOK, &page)
+	if len(page.Data) != 3 {
+		t.Errorf("refused cascading deletes left %d accounts, want 3", len(page.Data))
+	}
+
+	do(t, srv, http.MethodDelete, "/users/"+userKey+"?cascade=true", "", http.StatusNoContent, nil)
+	do(t, srv, http.MethodGet, "/users/"+userKey+"/accounts", "", http.StatusNotFound, nil)
+	do(t, srv, http.MethodGet, "/accounts", "", http.StatusOK, &page)
+	if len(page.Data) != 0 {
+		t.Errorf("cascading delete left %d accounts", len(page.Data))
+	}
+}
+
+// authorize makes the requests of srv's client authenticate with the API key
+// of a new admin user of a.
+func authorize(t *testing.T, a *application, srv *httptest.Server) {
+	t.Helper()
+	ctx := context.Background()
+	seeded, err := a.Seed(ctx, seed.File{Users: []seed.User{{Ref: "operator", Name: "Operator", Roles: []string{"admin"}}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, err := a.IssueAPIKey(ctx, seeded.Refs["operator"], "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Client().Transport = apiKeyTransport{key: key, base: srv.Client().Transport}
+}
+
+// apiKeyTransport sets the X-API-Key header of every request it sends.
+type apiKeyTransport struct {
+	key  string
+	base http.RoundTripper
+}
+
+func (t apiKeyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("X-API-Key", t.key)
+	return t.base.RoundTrip(r)
+}
+
+// do sends a request to srv, checks it is answered with code and decodes the
+// response body into v if it is not nil.
+func do(t *testing.T, srv *httptest.Server, method, path, body string, code int, v interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s returned %d, want %d: %s", method, path, resp.StatusCode, code, raw)
+	}
+	if v != nil {
+		if err = json.Unmarshal(raw, v); err != nil {
+			t.Fatalf("%s %s returned invalid JSON: %v", method, path, err)
+		}
+	}
+}
+
+// TestAuthentication exercises API keys and the JWTs exchanged for them.
+func TestAuthentication(t *testing.T) {
+	a := New(Config{
+		DB_BACKEND:      BACKEND_MEMORY,
+		AUTH_JWT_SECRET: "secret",
+		AUTH_JWT_ISSUER: "trade",
+		AUTH_TOKEN_TTL:  time.Minute,
+	})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	do(t, srv, http.MethodGet, "/ping", "", http.StatusOK, nil)
+	do(t, srv, http.MethodGet, "/users", "", http.StatusUnauthorized, nil)
+	authorize(t, a, srv)
+	do(t, srv, http.MethodGet, "/users", "", http.StatusOK, nil)
+
+	var issued struct {
+		Data struct {
+			ID  string `json:"_id"`
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/auth/keys", `{"name": "ci"}`, http.StatusCreated, &issued)
+	var token struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/auth/token", "", http.StatusCreated, &token)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		code   int
+	}{
+		{"api key header", "X-API-Key", issued.Data.Key, http.StatusOK},
+		{"api key bearer", "Authorization", "Bearer " + issued.Data.Key, http.StatusOK},
+		{"jwt", "Authorization", "Bearer " + token.Data.Token, http.StatusOK},
+		{"unknown key", "X-API-Key", "trade_000000000000_secret", http.StatusUnauthorized},
+		{"tampered jwt", "Authorization", "Bearer " + token.Data.Token + "x", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/users", nil)
+		req.Header.Set(tt.header, tt.value)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s: GET /users returned %d, want %d", tt.name, resp.StatusCode, tt.code)
+		}
+	}
+
+	// A JWT cannot be exchanged for another
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/auth/token", nil)
+	req.Header.Set("Authorization", "Bearer "+token.Data.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("POST /auth/token with a JWT returned %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	// Revoked keys are refused
+	var keys struct {
+		Data []struct {
+			ID string `json:"_id"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodGet, "/auth/keys", "", http.StatusOK, &keys)
+	if len(keys.Data) != 2 {
+		t.Errorf("GET /auth/keys returned %d keys, want 2", len(keys.Data))
+	}
+	do(t, srv, http.MethodDelete, "/auth/keys/"+strings.TrimPrefix(issued.Data.ID, "apikeys/"), "", http.StatusNoContent, nil)
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/users", nil)
+	req.Header.Set("X-API-Key", issued.Data.Key)
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET /users with a revoked key returned %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+// serveAs returns a server of a whose client authenticates as the user with
+// _id id.
+func serveAs(t *testing.T, a *application, id string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(a)
+	t.Cleanup(srv.Close)
+	key, _, err := a.IssueAPIKey(context.Background(), id, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Client().Transport = apiKeyTransport{key: key, base: srv.Client().Transport}
+	return srv
+}
+
+// TestAuthorization checks that users only reach their own resources unless
+// their roles allow more.
+func TestAuthorization(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY})
+	seeded, err := a.Seed(context.Background(), seed.File{
+		Users: []seed.User{
+			{Ref: "admin", Name: "Admin", Roles: []string{"admin"}},
+			{Ref: "auditor", Name: "Auditor", Roles: []string{"auditor"}},
+			{Ref: "ada", Name: "Ada"},
+			{Ref: "bob", Name: "Bob"},
+		},
+		Accounts: []seed.Account{
+			{Ref: "ada-usd", Owner: "@ada", Balances: map[string]float64{"dollars": 20}},
+			{Ref: "bob-usd", Owner: "@bob", Balances: map[string]float64{"dollars": 20}},
+			{Ref: "auditor-usd", Owner: "@auditor"},
+		},
+		Transactions: []seed.Transaction{
+			{Sender: "@bob-usd", Recipient: "@auditor-usd", Quantities: map[string]float64{"dollars": 5}},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(ref string) string { return strings.SplitN(seeded.Refs[ref], "/", 2)[1] }
+	admin, auditor, ada := serveAs(t, a, seeded.Refs["admin"]), serveAs(t, a, seeded.Refs["auditor"]), serveAs(t, a, seeded.Refs["ada"])
+
+	var page struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	do(t, ada, http.MethodGet, "/users", "", http.StatusOK, &page)
+	if len(page.Data) != 1 {
+		t.Errorf("GET /users as a user returned %d users, want 1", len(page.Data))
+	}
+	do(t, ada, http.MethodGet, "/accounts", "", http.StatusOK, &page)
+	if len(page.Data) != 1 {
+		t.Errorf("GET /accounts as a user returned %d accounts, want 1", len(page.Data))
+	}
+	do(t, ada, http.MethodGet, "/users/"+key("ada"), "", http.StatusOK, nil)
+	do(t, ada, http.MethodGet, "/users/"+key("bob"), "", http.StatusForbidden, nil)
+	do(t, ada, http.MethodPut, "/users/"+key("bob"), `{"name": "Mallory"}`, http.StatusForbidden, nil)
+	do(t, ada, http.MethodGet, "/accounts/"+key("bob-usd"), "", http.StatusForbidden, nil)
+	do(t, ada, http.MethodDelete, "/accounts/"+key("bob-usd"), "", http.StatusForbidden, nil)
+	do(t, ada, http.MethodPost, "/users", `{"name": "Eve"}`, http.StatusForbidden, nil)
+	do(t, ada, http.MethodPost, "/accounts", fmt.Sprintf(`{"owner": %q}`, seeded.Refs["ada"]), http.StatusCreated, nil)
+	do(t, ada, http.MethodPost, "/accounts", fmt.Sprintf(`{"owner": %q}`, seeded.Refs["bob"]), http.StatusForbidden, nil)
+	do(t, ada, http.MethodPost, "/accounts", fmt.Sprintf(`{"owner": %q, "balances": {"dollars": 100}}`, seeded.Refs["ada"]), http.StatusForbidden, nil)
+	do(t, ada, http.MethodGet, "/reports/volume", "", http.StatusForbidden, nil)
+	do(t, ada, http.MethodPut, "/users/"+key("ada")+"/roles", `{"roles": ["admin"]}`, http.StatusForbidden, nil)
+
+	transfer := `{"sender": %q, "recipient": %q, "quantities": {"dollars": 1}}`
+	do(t, ada, http.MethodPost, "/transactions", fmt.Sprintf(transfer, seeded.Refs["bob-usd"], seeded.Refs["ada-usd"]), http.StatusForbidden, nil)
+	do(t, ada, http.MethodPost, "/transactions", fmt.Sprintf(transfer, seeded.Refs["ada-usd"], seeded.Refs["bob-usd"]), http.StatusCreated, nil)
+	do(t, ada, http.MethodGet, "/transactions", "", http.StatusOK, &page)
+	if len(page.Data) != 1 {
+		t.Errorf("GET /transactions as a user returned %d transactions, want 1", len(page.Data))
+	}
+
+	do(t, auditor, http.MethodGet, "/transactions", "", http.StatusOK, &page)
+	if len(page.Data) != 2 {
+		t.Errorf("GET /transactions as an auditor returned %d transactions, want 2", len(page.Data))
+	}
+	do(t, auditor, http.MethodGet, "/accounts/"+key("bob-usd"), "", http.StatusOK, nil)
+	do(t, auditor, http.MethodGet, "/reports/volume", "", http.StatusOK, nil)
+	do(t, auditor, http.MethodPost, "/transactions", fmt.Sprintf(transfer, seeded.Refs["bob-usd"], seeded.Refs["ada-usd"]), http.StatusForbidden, nil)
+
+	// Roles are only changed by admins, and apply to existing keys at once
+	do(t, ada, http.MethodPut, "/users/"+key("ada"), `{"name": "Ada Lovelace"}`, http.StatusNoContent, nil)
+	do(t, admin, http.MethodPut, "/users/"+key("ada")+"/roles", `{"roles": ["root"]}`, http.StatusBadRequest, nil)
+	do(t, admin, http.MethodPut, "/users/"+key("ada")+"/roles", `{"roles": ["auditor"]}`, http.StatusNoContent, nil)
+	do(t, ada, http.MethodGet, "/users/"+key("bob"), "", http.StatusOK, nil)
+	var user struct {
+		Data struct {
+			Roles []string `json:"roles"`
+		} `json:"data"`
+	}
+	do(t, ada, http.MethodPut, "/users/"+key("ada"), `{"name": "Ada"}`, http.StatusNoContent, nil)
+	do(t, ada, http.MethodGet, "/users/"+key("ada"), "", http.StatusOK, &user)
+	if len(user.Data.Roles) != 1 || user.Data.Roles[0] != "auditor" {
+		t.Errorf("roles after updating a user are %v, want [auditor]", user.Data.Roles)
+	}
+}
+
+// TestIdempotency checks that requests retried with the same Idempotency-Key
+// are applied once.
+func TestIdempotency(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+	seeded, err := a.Seed(context.Background(), seed.File{
+		Users: []seed.User{{Ref: "ada", Name: "Ada"}},
+		Accounts: []seed.Account{
+			{Ref: "from", Owner: "@ada", Balances: map[string]float64{"dollars": 100}},
+			{Ref: "to", Owner: "@ada"},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// post is also called from other goroutines, so it cannot stop the test
+	post := func(key, body string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/transactions", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Error(err)
+			return &http.Response{Header: http.Header{}}, ""
+		}
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		return resp, string(raw)
+	}
+	transfer := func(quantity int) string {
+		return fmt.Sprintf(`{"sender": %q, "recipient": %q, "quantities": {"dollars": %d}}`, seeded.Refs["from"], seeded.Refs["to"], quantity)
+	}
+
+	first, firstBody := post("retry", transfer(10))
+	retry, retryBody := post("retry", transfer(10))
+	if first.StatusCode != http.StatusCreated || retry.StatusCode != http.StatusCreated || retryBody != firstBody {
+		t.Errorf("retry returned %d %s, want %d %s", retry.StatusCode, retryBody, first.StatusCode, firstBody)
+	}
+	if first.Header.Get("Idempotent-Replayed") != "" || retry.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Idempotent-Replayed headers are %q and %q", first.Header.Get("Idempotent-Replayed"), retry.Header.Get("Idempotent-Replayed"))
+	}
+	if id := retry.Header.Get("X-Request-ID"); id == "" || id == first.Header.Get("X-Request-ID") {
+		t.Errorf("retry has request ID %q, want another than the first request's", id)
+	}
+	if resp, body := post("retry", transfer(20)); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("reusing a key for another request returned %d %s, want %d", resp.StatusCode, body, http.StatusUnprocessableEntity)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, body := post("concurrent", transfer(5)); resp.StatusCode != http.StatusCreated {
+				t.Errorf("concurrent request returned %d %s", resp.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var account struct {
+		Data struct {
+			Balances map[string]float64 `json:"balances"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodGet, "/accounts/"+strings.TrimPrefix(seeded.Refs["from"], "accounts/"), "", http.StatusOK, &account)
+	if account.Data.Balances["dollars"] != 85 {
+		t.Errorf("balance after retried transfers is %v, want 85", account.Data.Balances["dollars"])
+	}
+}
+
+// TestGraph checks that traversals from accounts that do not exist are not
+// found rather than empty.
+func TestGraph(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+	seeded, err := a.Seed(context.Background(), seed.File{
+		Users: []seed.User{{Ref: "ada", Name: "Ada"}},
+		Accounts: []seed.Account{
+			{Ref: "from", Owner: "@ada", Balances: map[string]float64{"dollars": 10}},
+			{Ref: "to", Owner: "@ada"},
+		},
+		Transactions: []seed.Transaction{{Sender: "@from", Recipient: "@to", Quantities: map[string]float64{"dollars": 1}}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := "/accounts/" + strings.TrimPrefix(seeded.Refs["from"], "accounts/")
+	to := strings.TrimPrefix(seeded.Refs["to"], "accounts/")
+
+	var counterparties struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	do(t, srv, http.MethodGet, from+"/counterparties", "", http.StatusOK, &counterparties)
+	if len(counterparties.Data) != 1 {
+		t.Errorf("GET counterparties returned %d accounts, want 1", len(counterparties.Data))
+	}
+	do(t, srv, http.MethodGet, from+"/path/"+to, "", http.StatusOK, nil)
+	do(t, srv, http.MethodGet, from+"/community", "", http.StatusOK, nil)
+
+	do(t, srv, http.MethodGet, "/accounts/missing/counterparties", "", http.StatusNotFound, nil)
+	do(t, srv, http.MethodGet, "/accounts/missing/path/"+to, "", http.StatusNotFound, nil)
+	do(t, srv, http.MethodGet, from+"/path/missing", "", http.StatusNotFound, nil)
+	do(t, srv, http.MethodGet, "/accounts/missing/community", "", http.StatusNotFound, nil)
+}
+
+// TestConditionalRequests checks that writes conditional on a stale ETag are
+// refused and that unchanged documents are not sent again.
+func TestConditionalRequests(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	send := func(method, path, body, header, etag string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, etag)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	var user struct {
+		Data struct {
+			ID string `json:"_id"`
+		} `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/users", `{"name": "Ada"}`, http.StatusCreated, &user)
+	path := "/users/" + strings.TrimPrefix(user.Data.ID, "users/")
+
+	etag := send(http.MethodGet, path, "", "", "").Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("GET %s returned no ETag", path)
+	}
+	if resp := send(http.MethodGet, path, "", "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET with a current If-None-Match returned %d, want %d", resp.StatusCode, http.StatusNotModified)
+	}
+
+	resp := send(http.MethodPut, path, `{"name": "Ada Lovelace"}`, "If-Match", etag)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("ETag") == etag {
+		t.Fatalf("PUT with a current If-Match returned %d and ETag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	updated := resp.Header.Get("ETag")
+
+	if resp = send(http.MethodPut, path, `{"name": "Ada Byron"}`, "If-Match", etag); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale If-Match returned %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	if resp = send(http.MethodGet, path, "", "If-None-Match", etag); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET with a stale If-None-Match returned %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp = send(http.MethodDelete, path, "", "If-Match", etag); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale If-Match returned %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+	if resp = send(http.MethodDelete, path, "", "If-Match", updated); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE with a current If-Match returned %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+}
+
+// TestPatch exercises merge patches and JSON Patches of accounts.
+func TestPatch(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	patch := func(path, contentType, body, etag string, code int, v interface{}) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPatch, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			raw, _ := io.ReadAll(resp.Body)
+			t.Fatalf("PATCH %s %s returned %d, want %d: %s", path, body, resp.StatusCode, code, raw)
+		}
+		if v != nil {
+			if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp
+	}
+
+	var ada, bob struct {
+		Data trade.User `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/users", `{"name": "Ada", "email": "ada@example.com"}`, http.StatusCreated, &ada)
+	do(t, srv, http.MethodPost, "/users", `{"name": "Bob"}`, http.StatusCreated, &bob)
+
+	userPath := "/users/" + trade.DocumentKey(ada.Data.ID)
+	var user struct {
+		Data trade.User `json:"data"`
+	}
+	patch(userPath, trade.MEDIA_TYPE_MERGE_PATCH, `{"name": "Ada Lovelace"}`, "", http.StatusOK, &user)
+	if user.Data.Name != "Ada Lovelace" || user.Data.Email != "ada@example.com" {
+		t.Errorf("merge patch of the name returned %+v", user.Data)
+	}
+	patch(userPath, trade.MEDIA_TYPE_MERGE_PATCH, `{"roles": ["admin"]}`, "", http.StatusUnprocessableEntity, nil)
+
+	var account struct {
+		Data trade.Account `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/accounts", fmt.Sprintf(`{"owner": %q, "balances": {"dollars": 50}}`, ada.Data.ID), http.StatusCreated, &account)
+	path := "/accounts/" + trade.DocumentKey(account.Data.ID)
+
+	resp := patch(path, trade.MEDIA_TYPE_JSON_PATCH, fmt.Sprintf(`[
+		{"op": "test", "path": "/owner", "value": %q},
+		{"op": "replace", "path": "/owner", "value": %q}
+	]`, ada.Data.ID, bob.Data.ID), "", http.StatusOK, &account)
+	if account.Data.Owner != bob.Data.ID || account.Data.Balances["dollars"] != 50 || account.Data.Reputation != 100 {
+		t.Errorf("JSON Patch of the owner returned %+v", account.Data)
+	}
+	etag := resp.Header.Get("ETag")
+
+	patch(path, trade.MEDIA_TYPE_MERGE_PATCH, `{"balances": {"dollars": 1000}}`, "", http.StatusUnprocessableEntity, nil)
+	patch(path, trade.MEDIA_TYPE_MERGE_PATCH, `{"_id": "accounts/other"}`, "", http.StatusUnprocessableEntity, nil)
+	patch(path, trade.MEDIA_TYPE_MERGE_PATCH, `{"owner": "nobody"}`, "", http.StatusUnprocessableEntity, nil)
+	patch(path, "application/json", `{"owner": "nobody"}`, "", http.StatusUnsupportedMediaType, nil)
+	patch(path, trade.MEDIA_TYPE_JSON_PATCH, `[{"op": "test", "path": "/owner", "value": "users/nobody"}]`, "", http.StatusConflict, nil)
+	patch(path, trade.MEDIA_TYPE_JSON_PATCH, `{"op": "remove"}`, "", http.StatusBadRequest, nil)
+
+	patch(path, trade.MEDIA_TYPE_MERGE_PATCH, fmt.Sprintf(`{"owner": %q}`, ada.Data.ID), `"stale"`, http.StatusPreconditionFailed, nil)
+	patch(path, trade.MEDIA_TYPE_MERGE_PATCH, fmt.Sprintf(`{"owner": %q}`, ada.Data.ID), etag, http.StatusOK, &account)
+	if account.Data.Owner != ada.Data.ID {
+		t.Errorf("conditional merge patch returned %+v", account.Data)
+	}
+
+	// Settled transactions cannot be patched into disagreeing with balances
+	var recipient struct {
+		Data trade.Account `json:"data"`
+	}
+	var transaction struct {
+		Data trade.Transaction `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/accounts", fmt.Sprintf(`{"owner": %q}`, bob.Data.ID), http.StatusCreated, &recipient)
+	do(t, srv, http.MethodPost, "/transactions", fmt.Sprintf(`{"sender": %q, "recipient": %q, "quantities": {"dollars": 5}}`, account.Data.ID, recipient.Data.ID), http.StatusCreated, &transaction)
+	transactionPath := "/transactions/" + trade.DocumentKey(transaction.Data.ID)
+	patch(transactionPath, trade.MEDIA_TYPE_MERGE_PATCH, `{"quantities": {"dollars": 500}}`, "", http.StatusUnprocessableEntity, nil)
+	patch(transactionPath, trade.MEDIA_TYPE_JSON_PATCH, fmt.Sprintf(`[{"op": "replace", "path": "/_to", "value": %q}]`, account.Data.ID), "", http.StatusUnprocessableEntity, nil)
+	do(t, srv, http.MethodGet, transactionPath, "", http.StatusOK, &transaction)
+	do(t, srv, http.MethodGet, path, "", http.StatusOK, &account)
+	if transaction.Data.Quantities["dollars"] != 5 || transaction.Data.Recipient != recipient.Data.ID || account.Data.Balances["dollars"] != 45 {
+		t.Errorf("after refused patches the transaction is %+v and the sender holds %v", transaction.Data, account.Data.Balances)
+	}
+
+	// Nor replaced, and deleting one reverses it
+	replacement := `{"sender": %q, "recipient": %q, "quantities": {"dollars": %d}}`
+	timestamp := transaction.Data.Timestamp
+	do(t, srv, http.MethodPut, transactionPath, fmt.Sprintf(replacement, account.Data.ID, recipient.Data.ID, 500), http.StatusUnprocessableEntity, nil)
+	do(t, srv, http.MethodPut, transactionPath, fmt.Sprintf(replacement, recipient.Data.ID, account.Data.ID, 5), http.StatusUnprocessableEntity, nil)
+	do(t, srv, http.MethodPut, transactionPath, fmt.Sprintf(replacement, trade.DocumentKey(account.Data.ID), recipient.Data.ID, 5), http.StatusNoContent, nil)
+	do(t, srv, http.MethodGet, transactionPath, "", http.StatusOK, &transaction)
+	if !transaction.Data.Timestamp.Equal(timestamp) || transaction.Data.Quantities["dollars"] != 5 {
+		t.Errorf("after replacing it unchanged the transaction is %+v", transaction.Data)
+	}
+	do(t, srv, http.MethodDelete, transactionPath, "", http.StatusNoContent, nil)
+	do(t, srv, http.MethodGet, transactionPath, "", http.StatusNotFound, nil)
+	do(t, srv, http.MethodGet, path, "", http.StatusOK, &account)
+	do(t, srv, http.MethodGet, "/accounts/"+trade.DocumentKey(recipient.Data.ID), "", http.StatusOK, &recipient)
+	if account.Data.Balances["dollars"] != 50 || recipient.Data.Balances["dollars"] != 0 {
+		t.Errorf("after reversing the transaction the sender holds %v and the recipient %v", account.Data.Balances, recipient.Data.Balances)
+	}
+}
+
+// TestValidation checks that invalid requests are answered with every failing
+// field.
+func TestValidation(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	type failure struct {
+		Field string `json:"field"`
+		Code  string `json:"code"`
+	}
+	var resp struct {
+		Fields []failure `json:"fields"`
+	}
+	do(t, srv, http.MethodPost, "/users", `{"email": "ada", "phoneNumber": "555-0100", "admin": true}`, http.StatusBadRequest, &resp)
+	want := []failure{
+		{"admin", trade.CODE_UNKNOWN_FIELD},
+		{"email", trade.CODE_INVALID_EMAIL},
+		{"name", trade.CODE_REQUIRED},
+		{"phoneNumber", trade.CODE_INVALID_PHONE},
+	}
+	if !reflect.DeepEqual(resp.Fields, want) {
+		t.Errorf("POST /users returned failures %v, want %v", resp.Fields, want)
+	}
+
+	do(t, srv, http.MethodPost, "/users", `not json`, http.StatusBadRequest, nil)
+	do(t, srv, http.MethodPost, "/accounts", `{"owner": "users/1", "balances": {"doubloons": 1}}`, http.StatusBadRequest, nil)
+
+	resp.Fields = nil
+	do(t, srv, http.MethodPost, "/transactions", `{"sender": "1", "recipient": "accounts/1", "quantities": {"dollars": 0}}`, http.StatusBadRequest, &resp)
+	want = []failure{
+		{"quantities.dollars", trade.CODE_NOT_POSITIVE},
+		{"recipient", trade.CODE_SAME_ACCOUNT},
+	}
+	if !reflect.DeepEqual(resp.Fields, want) {
+		t.Errorf("POST /transactions returned failures %v, want %v", resp.Fields, want)
+	}
+
+	// Bodies are refused past their size limit, whichever route reads them
+	var ada struct {
+		Data trade.User `json:"data"`
+	}
+	do(t, srv, http.MethodPost, "/users", `{"name": "Ada"}`, http.StatusCreated, &ada)
+	defer func(size int64) { trade.MAX_REQUEST_BODY_SIZE = size }(trade.MAX_REQUEST_BODY_SIZE)
+	trade.MAX_REQUEST_BODY_SIZE = 64
+	name := strings.Repeat("a", 64)
+	var tooLarge struct {
+		Code string `json:"code"`
+	}
+	do(t, srv, http.MethodPost, "/users", `{"name": "`+name+`"}`, http.StatusRequestEntityTooLarge, &tooLarge)
+	if tooLarge.Code != trade.ERROR_PAYLOAD_TOO_LARGE.Code {
+		t.Errorf("POST /users with an oversized body returned code %q, want %q", tooLarge.Code, trade.ERROR_PAYLOAD_TOO_LARGE.Code)
+	}
+	do(t, srv, http.MethodPost, "/users:batch", `[{"data": {"name": "`+name+`"}}]`, http.StatusRequestEntityTooLarge, nil)
+	req, _ := http.NewRequest(http.MethodPatch, srv.URL+"/users/"+trade.DocumentKey(ada.Data.ID), strings.NewReader(`{"name": "`+name+`"}`))
+	req.Header.Set("Content-Type", trade.MEDIA_TYPE_MERGE_PATCH)
+	patched, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched.Body.Close()
+	if patched.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("PATCH with an oversized body returned %d, want %d", patched.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// TestProblemDetails checks that errors are answered with problem details
+// carrying the ID of the request.
+func TestProblemDetails(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/users/missing", nil)
+	req.Header.Set(trade.REQUEST_ID_HEADER, "trace-42")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var p struct {
+		Status    int    `json:"status"`
+		Code      string `json:"code"`
+		RequestID string `json:"requestId"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound || p.Status != http.StatusNotFound || p.Code != trade.ERROR_NOT_FOUND.Code {
+		t.Errorf("GET of a missing user returned %d %+v", resp.StatusCode, p)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != trade.MEDIA_TYPE_PROBLEM {
+		t.Errorf("Content-Type is %q, want %q", ct, trade.MEDIA_TYPE_PROBLEM)
+	}
+	if p.RequestID != "trace-42" || resp.Header.Get(trade.REQUEST_ID_HEADER) != "trace-42" {
+		t.Errorf("request ID is %q in the body and %q in the header, want trace-42", p.RequestID, resp.Header.Get(trade.REQUEST_ID_HEADER))
+	}
+
+	do(t, srv, http.MethodPost, "/transactions", `{"sender": "1", "recipient": "2", "quantities": {"dollars": 1}}`, http.StatusUnprocessableEntity, &p)
+	if p.Code != trade.ERROR_UNKNOWN_ACCOUNT.Code {
+		t.Errorf("transfer between missing accounts returned code %q, want %q", p.Code, trade.ERROR_UNKNOWN_ACCOUNT.Code)
+	}
+}
+
+// TestContentNegotiation checks that lists are answered in the media type the
+// client accepts.
+func TestContentNegotiation(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	if _, err := a.Seed(context.Background(), seed.File{
+		Users: []seed.User{{Ref: "ada", Name: "Ada"}, {Ref: "bob", Name: "Bob"}, {Ref: "cy", Name: "Cy"}},
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	get := func(path, accept string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(raw)
+	}
+
+	resp, body := get("/users", trade.MEDIA_TYPE_NDJSON)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if resp.Header.Get("Content-Type") != trade.MEDIA_TYPE_NDJSON || len(lines) != 3 {
+		t.Errorf("GET /users as NDJSON returned %s with %d lines: %s", resp.Header.Get("Content-Type"), len(lines), body)
+	}
+	for _, line := range lines {
+		var u trade.User
+		if err := json.Unmarshal([]byte(line), &u); err != nil || u.Name == "" {
+			t.Errorf("GET /users as NDJSON returned line %q: %v", line, err)
+		}
+	}
+
+	resp, body = get("/users?limit=2", trade.MEDIA_TYPE_CSV)
+	records := strings.Split(strings.TrimSpace(body), "\n")
+	if resp.Header.Get("Content-Type") != trade.MEDIA_TYPE_CSV || len(records) != 3 || !strings.Contains(records[0], "name") {
+		t.Errorf("GET /users as CSV returned %s: %s", resp.Header.Get("Content-Type"), body)
+	}
+	if !strings.Contains(resp.Header.Get("Link"), `rel="next"`) {
+		t.Errorf("GET /users as CSV of a first page has Link header %q", resp.Header.Get("Link"))
+	}
+
+	resp, _ = get("/users", "application/json;q=0.1, "+trade.MEDIA_TYPE_MSGPACK)
+	if resp.Header.Get("Content-Type") != trade.MEDIA_TYPE_MSGPACK {
+		t.Errorf("GET /users preferring MessagePack returned %s", resp.Header.Get("Content-Type"))
+	}
+
+	resp, _ = get("/users", "application/xml")
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("GET /users as XML returned %d, want %d", resp.StatusCode, http.StatusNotAcceptable)
+	}
+}
+
+// TestBatch checks that batches report the result of each operation, that
+// atomic batches are written entirely or not at all, and that the
+// transactions of a batch are settled in order.
+func TestBatch(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+	seeded, err := a.Seed(context.Background(), seed.File{
+		Users: []seed.User{{Ref: "ada", Name: "Ada"}, {Ref: "bob", Name: "Bob"}},
+		Accounts: []seed.Account{
+			{Ref: "ada-usd", Owner: "@ada", Balances: map[string]float64{"dollars": 10}},
+			{Ref: "bob-usd", Owner: "@bob"},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ada, bob := seeded.Refs["ada"], seeded.Refs["bob"]
+
+	type response struct {
+		Data []struct {
+			Status int    `json:"status"`
+			ID     string `json:"id"`
+			Error  struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		} `json:"data"`
+	}
+	statuses := func(resp response) []int {
+		out := make([]int, len(resp.Data))
+		for i, item := range resp.Data {
+			out[i] = item.Status
+		}
+		return out
+	}
+	countUsers := func() int {
+		var users struct {
+			Data []trade.User `json:"data"`
+		}
+		do(t, srv, http.MethodGet, "/users", "", http.StatusOK, &users)
+		return len(users.Data)
+	}
+
+	var resp response
+	do(t, srv, http.MethodPost, "/users:batch", fmt.Sprintf(`[
+		{"data": {"name": "Cy"}},
+		{"op": "update", "id": %q, "data": {"name": "Robert"}},
+		{"op": "delete", "id": %q},
+		{"op": "update", "id": "missing", "data": {"name": "Nobody"}},
+		{"data": {"email": "nobody"}}
+	]`, bob, ada), http.StatusMultiStatus, &resp)
+	if got, want := statuses(resp), []int{201, 200, 409, 404, 400}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batch returned statuses %v, want %v", got, want)
+	}
+	if resp.Data[4].Error.Code != trade.ERROR_VALIDATION.Code {
+		t.Errorf("invalid user of a batch failed with %q", resp.Data[4].Error.Code)
+	}
+	if n := countUsers(); n != 3 {
+		t.Errorf("%d users after a batch, want 3", n)
+	}
+
+	do(t, srv, http.MethodPost, "/users:batch?atomic=true", `[{"data": {"name": "Dee"}}, {"data": {"name": ""}}]`, http.StatusBadRequest, &resp)
+	if got, want := statuses(resp), []int{424, 400}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failed atomic batch returned statuses %v, want %v", got, want)
+	}
+	if n := countUsers(); n != 3 {
+		t.Errorf("%d users after a failed atomic batch, want 3", n)
+	}
+
+	// The second transfer spends what the first one received
+	transfers := fmt.Sprintf(`[
+		{"data": {"sender": %[1]q, "recipient": %[2]q, "quantities": {"dollars": 10}}},
+		{"data": {"sender": %[2]q, "recipient": %[1]q, "quantities": {"dollars": 4}}}
+	]`, seeded.Refs["ada-usd"], seeded.Refs["bob-usd"])
+	do(t, srv, http.MethodPost, "/transactions:batch?atomic=true", transfers, http.StatusOK, &resp)
+	if got, want := statuses(resp), []int{201, 201}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transaction batch returned statuses %v, want %v", got, want)
+	}
+	do(t, srv, http.MethodPost, "/transactions:batch?atomic=true", transfers, http.StatusUnprocessableEntity, &resp)
+	if resp.Data[0].Error.Code != trade.ERROR_INSUFFICIENT_FUNDS.Code || resp.Data[1].Error.Code != trade.ERROR_BATCH_ABORTED.Code {
+		t.Errorf("failed transaction batch returned %+v", resp.Data)
+	}
+
+	var account struct {
+		Data trade.Account `json:"data"`
+	}
+	do(t, srv, http.MethodGet, "/accounts/"+trade.DocumentKey(seeded.Refs["bob-usd"]), "", http.StatusOK, &account)
+	if account.Data.Balances["dollars"] != 6 {
+		t.Errorf("balance after the transaction batches is %v, want 6", account.Data.Balances["dollars"])
+	}
+}
+
+// TestExport checks that transaction history is exported for a date range and
+// account, and that users only export the transactions of their own accounts.
+func TestExport(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY})
+	seeded, err := a.Seed(context.Background(), seed.File{
+		Users: []seed.User{{Ref: "admin", Name: "Admin", Roles: []string{"admin"}}, {Ref: "ada", Name: "Ada"}, {Ref: "bob", Name: "Bob"}},
+		Accounts: []seed.Account{
+			{Ref: "ada-usd", Owner: "@ada", Balances: map[string]float64{"dollars": 100}},
+			{Ref: "bob-usd", Owner: "@bob", Balances: map[string]float64{"dollars": 100, "apples": 5}},
+			{Ref: "bob-savings", Owner: "@bob"},
+		},
+		Transactions: []seed.Transaction{
+			{Sender: "@ada-usd", Recipient: "@bob-usd", Quantities: map[string]float64{"dollars": 1}, Timestamp: time.Date(2022, 12, 31, 23, 0, 0, 0, time.UTC)},
+			{Sender: "@bob-usd", Recipient: "@ada-usd", Quantities: map[string]float64{"dollars": 2, "apples": 1}, Timestamp: time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC)},
+			{Sender: "@bob-usd", Recipient: "@bob-savings", Quantities: map[string]float64{"dollars": 3}, Timestamp: time.Date(2023, 2, 1, 8, 0, 0, 0, time.FixedZone("AEST", 10*60*60))},
+			{Sender: "@ada-usd", Recipient: "@bob-usd", Quantities: map[string]float64{"dollars": 4}, Timestamp: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := serveAs(t, a, seeded.Refs["admin"])
+	ada := serveAs(t, a, seeded.Refs["ada"])
+
+	get := func(srv *httptest.Server, path string, code int) (*http.Response, string) {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != code {
+			t.Fatalf("GET %s returned %d, want %d: %s", path, resp.StatusCode, code, raw)
+		}
+		return resp, string(raw)
+	}
+
+	resp, body := get(admin, "/transactions:export?format=csv&account="+seeded.Refs["ada-usd"]+"&from=2023-01-01&to=2023-02-01", http.StatusOK)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if resp.Header.Get("Content-Type") != trade.MEDIA_TYPE_CSV || len(lines) != 3 || lines[0] != "id,timestamp,sender,recipient,currency,quantity" ||
+		!strings.Contains(lines[1], ",2023-01-15T00:00:00Z,"+seeded.Refs["bob-usd"]+","+seeded.Refs["ada-usd"]+",apples,1") {
+		t.Errorf("CSV export returned %s:\n%s", resp.Header.Get("Content-Type"), body)
+	}
+
+	_, body = get(admin, "/transactions:export?from=2023-01-01", http.StatusOK)
+	if n := strings.Count(body, "\n"); n != 3 {
+		t.Errorf("JSON Lines export of every account returned %d transactions, want 3:\n%s", n, body)
+	}
+	get(admin, "/transactions:export?from=yesterday&format=xml", http.StatusBadRequest)
+
+	// Timestamps seeded in another time zone are bounded by their time in UTC
+	_, body = get(admin, "/transactions:export?format=csv&account="+seeded.Refs["bob-savings"]+"&to=2023-02-01", http.StatusOK)
+	if !strings.Contains(body, ",2023-01-31T22:00:00Z,") {
+		t.Errorf("export before 2023-02-01 UTC returned\n%s", body)
+	}
+
+	// Ada only sees the transactions of her account
+	_, body = get(ada, "/transactions:export", http.StatusOK)
+	if n := strings.Count(body, "\n"); n != 3 || strings.Contains(body, seeded.Refs["bob-savings"]) {
+		t.Errorf("export of a user returned %d transactions:\n%s", n, body)
+	}
+	get(ada, "/transactions:export?account="+seeded.Refs["bob-usd"], http.StatusForbidden)
+}
+
+func TestReports(t *testing.T) {
+	a := New(Config{DB_BACKEND: BACKEND_MEMORY})
+	seeded, err := a.Seed(context.Background(), seed.File{
+		Users:    []seed.User{{Ref: "auditor", Name: "Auditor", Roles: []string{"auditor"}}, {Ref: "ada", Name: "Ada"}},
+		Accounts: []seed.Account{{Ref: "ada-usd", Owner: "@ada", Balances: map[string]float64{"dollars": 100}}, {Ref: "ada-savings", Owner: "@ada"}},
+		Transactions: []seed.Transaction{
+			{Sender: "@ada-usd", Recipient: "@ada-savings", Quantities: map[string]float64{"dollars": 1}, Timestamp: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)},
+			{Sender: "@ada-usd", Recipient: "@ada-savings", Quantities: map[string]float64{"dollars": 2}, Timestamp: time.Date(2024, 12, 30, 12, 0, 0, 0, time.UTC)},
+		},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditor := serveAs(t, a, seeded.Refs["auditor"])
+
+	// Weeks that straddle a new year are named by their ISO week-year
+	var volume struct {
+		Data []report.Volume `json:"data"`
+	}
+	do(t, auditor, http.MethodGet, "/reports/volume?interval=week", "", http.StatusOK, &volume)
+	if len(volume.Data) != 2 || volume.Data[0].Period != "2022-W52" || volume.Data[1].Period != "2025-W01" {
+		t.Errorf("GET /reports/volume?interval=week returned %+v, want periods 2022-W52 and 2025-W01", volume.Data)
+	}
+	do(t, auditor, http.MethodGet, "/reports/volume?interval=year", "", http.StatusBadRequest, nil)
+
+	// Reports are negotiated like any other response
+	req, err := http.NewRequest(http.MethodGet, auditor.URL+"/reports/volume?interval=week", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", trade.MEDIA_TYPE_CSV)
+	resp, err := auditor.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	records := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if resp.Header.Get("Content-Type") != trade.MEDIA_TYPE_CSV || len(records) != 3 || records[0] != "currency,period,transactions,volume" {
+		t.Errorf("GET /reports/volume as CSV returned %s:\n%s", resp.Header.Get("Content-Type"), raw)
+	}
+}
@@ -2,47 +2,177 @@ package trade
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	arangodriver "github.com/arangodb/go-driver"
+	arangocluster "github.com/arangodb/go-driver/cluster"
 	arangohttp "github.com/arangodb/go-driver/http"
-	"github.com/tidwall/gjson"
 )
 
-type Schema struct {
-	DocumentCollections []string `json:"documentCollections"`
-	EdgeCollections     []string `json:"edgeCollections"`
-}
-
 type ArangoClient struct {
 	DriverConnection arangodriver.Connection
 	DriverClient     arangodriver.Client
 }
 
-func NewArangoClient(addrs []string) (*ArangoClient, error) {
+// arangoClientConfig holds the connection settings set by the functional
+// options of NewArangoClient.
+type arangoClientConfig struct {
+	auth      arangodriver.Authentication
+	tlsConfig *tls.Config
+	caFile    string
+	connLimit int
+	timeout   time.Duration
+}
+
+// ArangoClientOption is a functional option of NewArangoClient.
+type ArangoClientOption func(*arangoClientConfig)
+
+// NewArangoClient returns a client of the ArangoDB deployment at addrs. With
+// more than one address requests fail over to the next coordinator when one
+// cannot be reached. No request is made until the client is used.
+func NewArangoClient(addrs []string, options ...ArangoClientOption) (*ArangoClient, error) {
+	cnf := arangoClientConfig{}
+	for _, option := range options {
+		option(&cnf)
+	}
+
+	tlsConfig := cnf.tlsConfig
+	if cnf.caFile != "" {
+		pem, err := os.ReadFile(cnf.caFile)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		if tlsConfig.RootCAs == nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		}
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cnf.caFile)
+		}
+	}
+
 	conn, err := arangohttp.NewConnection(arangohttp.ConnectionConfig{
-		Endpoints: addrs,
+		Endpoints:        addrs,
+		TLSConfig:        tlsConfig,
+		ConnLimit:        cnf.connLimit,
+		ConnectionConfig: arangocluster.ConnectionConfig{DefaultTimeout: cnf.timeout},
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	cl, err := arangodriver.NewClient(arangodriver.ClientConfig{
-		Connection: conn,
+		Connection:     conn,
+		Authentication: cnf.auth,
 	})
 	if err != nil {
 		return nil, err
 	}
 	return &ArangoClient{
-		DriverClient: cl,
+		DriverConnection: cl.Connection(),
+		DriverClient:     cl,
 	}, nil
 }
 
+// WithArangoBasicAuth is a functional option for authenticating every request
+// of an ArangoDB client with HTTP basic authentication.
+func WithArangoBasicAuth(username, password string) ArangoClientOption {
+	return func(cnf *arangoClientConfig) {
+		cnf.auth = arangodriver.BasicAuthentication(username, password)
+	}
+}
+
+// WithArangoJWTAuth is a functional option for authenticating an ArangoDB
+// client with a JWT the driver obtains from the server with username and
+// password and renews when it expires.
+func WithArangoJWTAuth(username, password string) ArangoClientOption {
+	return func(cnf *arangoClientConfig) {
+		cnf.auth = arangodriver.JWTAuthentication(username, password)
+	}
+}
+
+// WithArangoJWT is a functional option for authenticating an ArangoDB client
+// with a JWT issued out of band, for example a superuser token signed with the
+// server's JWT secret.
+func WithArangoJWT(token string) ArangoClientOption {
+	return func(cnf *arangoClientConfig) {
+		cnf.auth = arangodriver.RawAuthentication("bearer " + token)
+	}
+}
+
+// WithArangoTLSConfig is a functional option for configuring the TLS settings
+// of connections to https endpoints.
+func WithArangoTLSConfig(tlsConfig *tls.Config) ArangoClientOption {
+	return func(cnf *arangoClientConfig) {
+		cnf.tlsConfig = tlsConfig
+	}
+}
+
+// WithArangoCAFile is a functional option for trusting the PEM encoded
+// certificates in path, in addition to any of the TLS settings, when
+// verifying https endpoints.
+func WithArangoCAFile(path string) ArangoClientOption {
+	return func(cnf *arangoClientConfig) {
+		cnf.caFile = path
+	}
+}
+
+// WithArangoConnLimit is a functional option for limiting the number of
+// connections an ArangoDB client opens to each endpoint. Defaults to 32.
+func WithArangoConnLimit(n int) ArangoClientOption {
+	return func(cnf *arangoClientConfig) {
+		cnf.connLimit = n
+	}
+}
+
+// WithArangoTimeout is a functional option for bounding requests whose
+// context has no deadline. The timeout is shared by every endpoint a request
+// fails over to.
+func WithArangoTimeout(d time.Duration) ArangoClientOption {
+	return func(cnf *arangoClientConfig) {
+		cnf.timeout = d
+	}
+}
+
+// WaitReady waits until the server answers a version request, trying up to
+// attempts times and doubling the wait between attempts from backoff up to
+// ARANGO_MAX_BACKOFF. Authentication failures are returned immediately since
+// retrying cannot fix them.
+func (cl *ArangoClient) WaitReady(ctx context.Context, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if _, err = cl.DriverClient.Version(ctx); err == nil {
+			return nil
+		}
+		if arangodriver.IsUnauthorized(err) || attempt >= attempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+		if backoff *= 2; backoff > ARANGO_MAX_BACKOFF {
+			backoff = ARANGO_MAX_BACKOFF
+		}
+	}
+}
+
+// ARANGO_MAX_BACKOFF caps the wait between the attempts of WaitReady.
+var ARANGO_MAX_BACKOFF = 30 * time.Second
+
 func (cl *ArangoClient) Database(ctx context.Context, name string, createOnNotExist bool, schemaPath string) (arangodriver.Database, error) {
 	var err error
 	var dbClient arangodriver.Database
@@ -60,47 +190,20 @@ func (cl *ArangoClient) Database(ctx context.Context, name string, createOnNotEx
 		}
 	}
 
-	// Check if collections exist and create them if they don't
+	// Bring the database up to the latest schema version
 	if createOnNotExist {
-		schemaF, err := os.Open(schemaPath)
+		schema, err := LoadSchema(schemaPath)
 		if err != nil {
 			return nil, err
 		}
-
-		schemaRaw, err := io.ReadAll(schemaF)
-		if err != nil {
+		if _, err = Migrate(ctx, dbClient, schema); err != nil {
 			return nil, err
 		}
 
-		var schema Schema
-		err = json.Unmarshal(schemaRaw, &schema)
-		if err != nil {
+		// Create search views
+		if err = ensureSearchView(ctx, dbClient, USER_SEARCH_VIEW_NAME, "users", USER_SEARCH_FIELDS); err != nil {
 			return nil, err
 		}
-
-		// Create document collections
-		for _, e := range schema.DocumentCollections {
-			_, err = dbClient.Collection(ctx, gjson.Get(e, "collectionName").Str)
-			if err != nil && arangodriver.IsNotFoundGeneral(err) {
-				if _, err = dbClient.CreateCollection(ctx, gjson.Get(e, "collectionName").Str, nil); err != nil {
-					return nil, err
-				}
-			} else if err != nil {
-				return nil, err
-			}
-		}
-
-		// Create edge collections
-		for _, e := range schema.EdgeCollections {
-			_, err = dbClient.Collection(ctx, gjson.Get(e, "collectionName").Str)
-			if err != nil && arangodriver.IsNotFoundGeneral(err) {
-				if _, err = dbClient.CreateCollection(ctx, gjson.Get(e, "collectionName").Str, &arangodriver.CreateCollectionOptions{Type: arangodriver.CollectionTypeEdge}); err != nil {
-					return nil, err
-				}
-			} else if err != nil {
-				return nil, err
-			}
-		}
 	}
 
 	return dbClient, nil
@@ -118,6 +221,7 @@ func NewArangoRepository[T any](db arangodriver.Database, collectionName string)
 	}
 }
 
+// Create creates a new document from data and returns its _id.
 func (r *ArangoRepository[T]) Create(ctx context.Context, data T) (string, T, error) {
 	var err error
 	var t T
@@ -131,21 +235,61 @@ func (r *ArangoRepository[T]) Create(ctx context.Context, data T) (string, T, er
 		return "", t, err
 	}
 
-	return meta.Key, data, nil
+	return meta.ID.String(), data, nil
 }
 
-func (r *ArangoRepository[T]) Query(ctx context.Context, query string) ([]T, error) {
-	var err error
+// Query runs q over the collection.
+func (r *ArangoRepository[T]) Query(ctx context.Context, q Query) ([]T, error) {
 	results := []T{}
-
-	cur, err := r.database.Query(ctx, query, map[string]interface{}{})
+	err := r.QueryEach(ctx, q, func(data T) error {
+		results = append(results, data)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return results, nil
+}
+
+// QueryEach runs q over the collection and calls fn with each result as it is
+// read from the cursor, stopping at the first error fn returns.
+func (r *ArangoRepository[T]) QueryEach(ctx context.Context, q Query, fn func(data T) error) error {
+	var err error
+
+	query, bindVars := q.AQL(r.collectionName)
+	cur, err := r.database.Query(ctx, query, bindVars)
+	if err != nil {
+		return err
+	}
 	defer cur.Close()
 
 	for cur.HasMore() {
 		var data T
+		if _, err = cur.ReadDocument(ctx, &data); err != nil {
+			return err
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryRaw runs q and returns the resulting documents without binding them to
+// T. Used for queries that project or expand documents.
+func (r *ArangoRepository[T]) QueryRaw(ctx context.Context, q Query) ([]map[string]interface{}, error) {
+	var err error
+	results := []map[string]interface{}{}
+
+	query, bindVars := q.AQL(r.collectionName)
+	cur, err := r.database.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	for cur.HasMore() {
+		var data map[string]interface{}
 		if _, err = cur.ReadDocument(ctx, &data); err != nil {
 			return nil, err
 		}
@@ -154,6 +298,8 @@ func (r *ArangoRepository[T]) Query(ctx context.Context, query string) ([]T, err
 	return results, nil
 }
 
+// Get returns the document identified by id, which may be either a _key or an
+// _id. If no document is found returns NotFoundError.
 func (r *ArangoRepository[T]) Get(ctx context.Context, id string) (T, error) {
 	var err error
 	var t T
@@ -164,7 +310,7 @@ func (r *ArangoRepository[T]) Get(ctx context.Context, id string) (T, error) {
 	}
 
 	var result T
-	_, err = col.ReadDocument(ctx, id, &result)
+	_, err = col.ReadDocument(ctx, DocumentKey(id), &result)
 	if err != nil {
 		return t, err
 	}
@@ -173,7 +319,8 @@ func (r *ArangoRepository[T]) Get(ctx context.Context, id string) (T, error) {
 }
 
 // Update updates the document identified by id with values user and returns
-// the new document. If no document with id is found returns NotFoundError.
+// the new document. If no document with id is found returns NotFoundError, and
+// if ctx is conditional on another revision a precondition failed error.
 func (r *ArangoRepository[T]) Update(ctx context.Context, id string, data T) (T, error) {
 	var err error
 	var t T
@@ -181,9 +328,35 @@ func (r *ArangoRepository[T]) Update(ctx context.Context, id string, data T) (T,
 	if err != nil {
 		return t, err
 	}
+	if rev, ok := revisionFromContext(ctx); ok {
+		ctx = arangodriver.WithRevision(ctx, rev)
+	}
+
+	var result T
+	_, err = col.UpdateDocument(arangodriver.WithReturnNew(ctx, &result), DocumentKey(id), data)
+	if err != nil {
+		return t, err
+	}
+
+	return result, nil
+}
+
+// Replace replaces the document identified by id with data and returns the
+// new document. If no document with id is found returns NotFoundError, and if
+// ctx is conditional on another revision a precondition failed error.
+func (r *ArangoRepository[T]) Replace(ctx context.Context, id string, data T) (T, error) {
+	var err error
+	var t T
+	col, err := r.database.Collection(ctx, r.collectionName)
+	if err != nil {
+		return t, err
+	}
+	if rev, ok := revisionFromContext(ctx); ok {
+		ctx = arangodriver.WithRevision(ctx, rev)
+	}
 
 	var result T
-	_, err = col.UpdateDocument(arangodriver.WithReturnNew(ctx, &result), id, data)
+	_, err = col.ReplaceDocument(arangodriver.WithReturnNew(ctx, &result), DocumentKey(id), data)
 	if err != nil {
 		return t, err
 	}
@@ -192,15 +365,19 @@ func (r *ArangoRepository[T]) Update(ctx context.Context, id string, data T) (T,
 }
 
 // Delete deletes the document with given id from the collection. If no match
-// is found returns NotFoundError.
+// is found returns NotFoundError, and if ctx is conditional on another
+// revision a precondition failed error.
 func (r *ArangoRepository[T]) Delete(ctx context.Context, id string) error {
 	var err error
 	col, err := r.database.Collection(ctx, r.collectionName)
 	if err != nil {
 		return err
 	}
+	if rev, ok := revisionFromContext(ctx); ok {
+		ctx = arangodriver.WithRevision(ctx, rev)
+	}
 
-	_, err = col.RemoveDocument(ctx, id)
+	_, err = col.RemoveDocument(ctx, DocumentKey(id))
 	if err != nil {
 		return err
 	}
@@ -208,173 +385,215 @@ func (r *ArangoRepository[T]) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-// sort query will be formatted ?sort=key1+dir,key2+dir
-// Operator, value separated by + which decodes to a space. If there is no operator default to equality
-func BuildFilterQueryFromURLParams(aqb ArangoQueryBuilder, r *http.Request, queryParams []string, paginate Paginate) (ArangoQueryBuilder, error) {
-	if len(queryParams) < 1 {
-		return aqb, nil
+// Batch applies ops in order, writing each run of consecutive operations of
+// the same kind in a single request. An atomic batch is applied in a stream
+// transaction that is aborted if any operation fails, otherwise each operation
+// is applied if it can be.
+func (r *ArangoRepository[T]) Batch(ctx context.Context, ops []BatchOperation[T], atomic bool) ([]BatchResult[T], error) {
+	var err error
+	col, err := r.database.Collection(ctx, r.collectionName)
+	if err != nil {
+		return nil, err
 	}
 
-	i := 0
-	fqb := NewFilterQueryBuilder(aqb)
-	for idx, param := range queryParams {
-		if val := r.URL.Query().Get(param); val != "" {
-			fqb = aqb.Filter(FilterKeyFromURLElement(param, val))
-			i = idx
-			break
+	results := make([]BatchResult[T], len(ops))
+	if !atomic {
+		if err = r.batch(ctx, col, ops, results, false); err != nil {
+			return nil, err
 		}
+		return results, nil
 	}
 
-	if i+1 < len(queryParams) {
-		for _, param := range queryParams[i+1:] {
-			if val := r.URL.Query().Get(param); val != "" {
-				if r.URL.Query().Get("inclusive") == "true" {
-					fqb = fqb.Or(FilterKeyFromURLElement(param, val))
-				} else {
-					fqb = fqb.And(FilterKeyFromURLElement(param, val))
-				}
-			}
+	trx, err := r.database.BeginTransaction(ctx, arangodriver.TransactionCollections{Write: []string{r.collectionName}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.batch(arangodriver.WithTransactionID(ctx, trx), col, ops, results, true); err != nil || batchFailed(results) {
+		r.database.AbortTransaction(ctx, trx, nil)
+		if err != nil {
+			return nil, err
 		}
+		return AbortBatch(results), nil
 	}
-
-	aqb = fqb.Sort(paginate.SortFields...)
-	aqb = aqb.Limit(paginate.Limit)
-
-	// extract sort and limit values. Move ExtractURLQueryParams code from request to here
-	return fqb.Sort(paginate.SortFields...).Limit(paginate.Limit).Done(), nil
+	if err = r.database.CommitTransaction(ctx, trx, nil); err != nil {
+		return nil, err
+	}
+	return results, nil
 }
 
-type SortDirection string
-
-type FilterOperator string
-
-var (
-	SORT_ASC     = SortDirection("ASC")
-	SORT_DESC    = SortDirection("DESC")
-	Eq           = FilterOperator("==")
-	Neq          = FilterOperator("!=")
-	Gt           = FilterOperator(">")
-	Lt           = FilterOperator("<")
-	Geq          = FilterOperator(">=")
-	Leq          = FilterOperator("<=")
-	OPERATOR_MAP = map[string]FilterOperator{
-		"eq":  Eq,
-		"neq": Neq,
-		"gt":  Gt,
-		"lt":  Lt,
-		"geq": Geq,
-		"leq": Leq,
-	}
-)
+// batch writes ops into results, stopping after the first run with a failed
+// operation if atomic.
+func (r *ArangoRepository[T]) batch(ctx context.Context, col arangodriver.Collection, ops []BatchOperation[T], results []BatchResult[T], atomic bool) error {
+	for start := 0; start < len(ops); {
+		end := start + 1
+		for end < len(ops) && ops[end].Op == ops[start].Op {
+			end++
+		}
 
-type FilterKey struct {
-	FieldName string
-	Operator  FilterOperator
-	Value     interface{}
-}
+		run := ops[start:end]
+		keys, docs, news := make([]string, len(run)), make([]T, len(run)), make([]T, len(run))
+		for i, op := range run {
+			keys[i], docs[i] = DocumentKey(op.ID), op.Data
+		}
+
+		var metas arangodriver.DocumentMetaSlice
+		var errs arangodriver.ErrorSlice
+		var err error
+		switch run[0].Op {
+		case BATCH_CREATE:
+			metas, errs, err = col.CreateDocuments(arangodriver.WithReturnNew(ctx, news), docs)
+		case BATCH_UPDATE:
+			metas, errs, err = col.UpdateDocuments(arangodriver.WithReturnNew(ctx, news), keys, docs)
+		case BATCH_DELETE:
+			metas, errs, err = col.RemoveDocuments(ctx, keys)
+		default:
+			err = fmt.Errorf("unknown batch operation %q", run[0].Op)
+		}
+		if err != nil {
+			return err
+		}
 
-func NewFilterKey(fieldName string, op FilterOperator, val interface{}) FilterKey {
-	return FilterKey{
-		FieldName: fieldName,
-		Operator:  op,
-		Value:     val,
+		for i := range run {
+			if errs[i] != nil {
+				results[start+i].Err = batchError(errs[i])
+			} else {
+				results[start+i] = BatchResult[T]{ID: metas[i].ID.String(), Data: news[i]}
+			}
+		}
+		if atomic && batchFailed(results[start:end]) {
+			return nil
+		}
+		start = end
 	}
+	return nil
 }
 
-func (fk FilterKey) FormatValue() interface{} {
-	switch v := fk.Value.(type) {
-	case string:
-		return fmt.Sprintf("\"%s\"", v)
+// batchError returns the error of an operation of a multi-document request.
+// Unlike the errors of single document requests they carry no HTTP status, so
+// it is set from the error number to let callers handle both alike.
+func batchError(err error) error {
+	var arangoErr arangodriver.ArangoError
+	if !errors.As(err, &arangoErr) || (arangoErr.Code != 0 && arangoErr.Code != http.StatusInternalServerError) {
+		return err
+	}
+	switch arangoErr.ErrorNum {
+	case arangodriver.ErrArangoDocumentNotFound, arangodriver.ErrArangoDataSourceNotFound:
+		arangoErr.Code = http.StatusNotFound
+	case arangodriver.ErrArangoConflict:
+		arangoErr.Code = http.StatusPreconditionFailed
+	case arangodriver.ErrArangoUniqueConstraintViolated:
+		arangoErr.Code = http.StatusConflict
 	default:
-		return fk.Value
+		return err
 	}
+	return arangoErr
 }
 
-func FilterKeyFromURLElement(key, val string) FilterKey {
-	eles := strings.Split(val, " ")
-	if len(eles) < 2 {
-		return FilterKey{
-			FieldName: key,
-			Operator:  Eq,
-			Value:     eles[0],
-		}
-	} else {
-		return FilterKey{
-			FieldName: key,
-			Operator:  OPERATOR_MAP[eles[0]],
-			Value:     eles[1],
-		}
-	}
-}
+// AQL compiles q over collectionName to an AQL query. Every value, attribute
+// name and collection name is passed as a bind parameter so none of them are
+// ever interpolated into the query string.
+func (q Query) AQL(collectionName string) (string, map[string]interface{}) {
+	bindVars := map[string]interface{}{"@collection": collectionName}
 
-type ArangoQueryBuilder struct {
-	QueryString *strings.Builder
-	loopVar     string
-}
+	var sb strings.Builder
+	sb.WriteString("FOR x IN @@collection")
 
-func NewArangoQueryBuilder(collectionName string) ArangoQueryBuilder {
-	aqb := ArangoQueryBuilder{
-		QueryString: &strings.Builder{},
-		loopVar:     "x",
+	if filter := q.FilterAQL("x", bindVars); filter != "" {
+		sb.WriteString(" FILTER " + filter)
 	}
-	aqb.QueryString.WriteString(fmt.Sprintf("FOR %s IN %s", aqb.loopVar, collectionName))
-	return aqb
-}
 
-func (aqb ArangoQueryBuilder) Sort(sortFields ...SortField) ArangoQueryBuilder {
-	if len(sortFields) > 0 {
-		aqb.QueryString.WriteString(fmt.Sprintf("\n\tSORT %s.%s %s", aqb.loopVar, sortFields[0].Field, sortFields[0].Direction))
-	}
-	if len(sortFields) > 1 {
-		for _, sortField := range sortFields {
-			aqb.QueryString.WriteString(fmt.Sprintf(", %s.%s %s", aqb.loopVar, sortField.Field, sortField.Direction))
+	if len(q.SortFields) > 0 {
+		sorts := make([]string, len(q.SortFields))
+		for i, sf := range q.SortFields {
+			sorts[i] = attributeAQL("x", sf.Field, bindVars) + " " + string(sf.Direction)
 		}
+		sb.WriteString(" SORT " + strings.Join(sorts, ", "))
 	}
-	return aqb
-}
-
-func (aqb ArangoQueryBuilder) Limit(limit int) ArangoQueryBuilder {
-	aqb.QueryString.WriteString(fmt.Sprintf("\n\tLIMIT %d", limit))
-	return aqb
-}
-
-func (aqb ArangoQueryBuilder) Paginate(p Paginate) ArangoQueryBuilder {
-	return aqb.Sort(p.SortFields...).Limit(p.Limit)
-}
 
-func (aqb ArangoQueryBuilder) Filter(filter FilterKey) FilterQueryBuilder {
-	aqb.QueryString.WriteString(fmt.Sprintf("\n\tFILTER %s.%s %s %v", aqb.loopVar, filter.FieldName, filter.Operator, filter.FormatValue()))
-	return FilterQueryBuilder{aqb}
-}
+	offset := max(q.Offset, 0)
+	if q.Limit >= 0 {
+		bindVars["offset"] = offset
+		bindVars["limit"] = q.Limit
+		sb.WriteString(" LIMIT @offset, @limit")
+	} else if offset > 0 {
+		// AQL has no offset without a count so use the largest one it accepts
+		bindVars["offset"] = offset
+		bindVars["limit"] = AQL_MAX_LIMIT
+		sb.WriteString(" LIMIT @offset, @limit")
+	}
 
-func (aqb ArangoQueryBuilder) Done() ArangoQueryBuilder {
-	aqb.QueryString.WriteString(fmt.Sprintf("\n\tRETURN %s", aqb.loopVar))
-	return aqb
+	sb.WriteString(" RETURN " + q.returnAQL("x", bindVars))
+	return sb.String(), bindVars
 }
 
-func (aqb ArangoQueryBuilder) String() string {
-	return aqb.QueryString.String()
+// AQL_MAX_LIMIT is the largest count a LIMIT operation accepts.
+var AQL_MAX_LIMIT int64 = 1<<53 - 1
+
+// FilterAQL compiles the filters of q to an AQL condition over the documents
+// bound to loopVar, adding their values to bindVars. Returns an empty string
+// if q has no filters.
+func (q Query) FilterAQL(loopVar string, bindVars map[string]interface{}) string {
+	disjunction := []string{}
+	for _, conjunction := range q.Filters {
+		conditions := []string{}
+		for _, fk := range conjunction {
+			name := bindName("v", bindVars)
+			bindVars[name] = fk.Value
+			conditions = append(conditions, fmt.Sprintf("%s %s @%s", attributeAQL(loopVar, fk.FieldName, bindVars), fk.Operator, name))
+		}
+		if len(conditions) > 0 {
+			disjunction = append(disjunction, "("+strings.Join(conditions, " && ")+")")
+		}
+	}
+	return strings.Join(disjunction, " || ")
 }
 
-type FilterQueryBuilder struct {
-	ArangoQueryBuilder
-}
+// returnAQL compiles the projection and expansions of q to the expression
+// returned for each document.
+func (q Query) returnAQL(loopVar string, bindVars map[string]interface{}) string {
+	doc := loopVar
+	if len(q.Fields) > 0 {
+		name := bindName("f", bindVars)
+		bindVars[name] = q.Fields
+		doc = fmt.Sprintf("KEEP(%s, @%s)", loopVar, name)
+	}
 
-func NewFilterQueryBuilder(aqb ArangoQueryBuilder) FilterQueryBuilder {
-	return FilterQueryBuilder{aqb}
-}
+	if len(q.Relations) < 1 {
+		return doc
+	}
 
-func (fqb FilterQueryBuilder) And(filter FilterKey) FilterQueryBuilder {
-	fqb.QueryString.WriteString(fmt.Sprintf(" && %s.%s %s %v", fqb.loopVar, filter.FieldName, filter.Operator, filter.FormatValue()))
-	return fqb
+	expansions := make([]string, len(q.Relations))
+	for i, relation := range q.Relations {
+		field := bindName("r", bindVars)
+		bindVars[field] = relation.Field
+		col := bindName("c", bindVars)
+		bindVars["@"+col] = relation.CollectionName
+		expansions[i] = fmt.Sprintf("[@%s]: FIRST(FOR e IN @@%s FILTER e._id == %s[@%s] RETURN e)", field, col, loopVar, field)
+	}
+	return fmt.Sprintf("MERGE(%s, {%s})", doc, strings.Join(expansions, ", "))
 }
 
-func (fqb FilterQueryBuilder) Or(filter FilterKey) FilterQueryBuilder {
-	fqb.QueryString.WriteString(fmt.Sprintf(" || %s.%s %s %v", fqb.loopVar, filter.FieldName, filter.Operator, filter.FormatValue()))
-	return fqb
+// attributeAQL returns the AQL expression accessing the possibly nested field of
+// the documents bound to loopVar, binding each attribute name.
+func attributeAQL(loopVar, field string, bindVars map[string]interface{}) string {
+	expr := loopVar
+	for _, attr := range strings.Split(field, ".") {
+		name := bindName("a", bindVars)
+		bindVars[name] = attr
+		expr += ".@" + name
+	}
+	return expr
 }
 
-func (fqb FilterQueryBuilder) Not(filter FilterKey) FilterQueryBuilder {
-	fqb.QueryString.WriteString(fmt.Sprintf(" NOT %s.%s %s %v", fqb.loopVar, filter.FieldName, filter.Operator, filter.FormatValue()))
-	return fqb
+// bindName returns the first bind parameter name made of prefix and a counter
+// that is not yet in bindVars.
+func bindName(prefix string, bindVars map[string]interface{}) string {
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		if _, ok := bindVars[name]; !ok {
+			if _, ok := bindVars["@"+name]; !ok {
+				return name
+			}
+		}
+	}
 }
@@ -0,0 +1,232 @@
+package trade_test
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	arangodriver "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/arangotest"
+	"github.com/gabriel-ross/trade/repotest"
+)
+
+// TestArangoRepository runs against the ArangoDB server listed in
+// TRADE_TEST_ARANGO_ADDRS, a comma separated list of endpoints, or against a
+// fake server if it is unset.
+func TestArangoRepository(t *testing.T) {
+	ctx := context.Background()
+	cl, err := trade.NewArangoClient(arangoEndpoints(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := cl.DriverClient.Database(ctx, "trade_test")
+	if arangodriver.IsNotFoundGeneral(err) {
+		db, err = cl.DriverClient.CreateDatabase(ctx, "trade_test", nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	repotest.Run(t, func(t *testing.T) repotest.Repository[repotest.Document] {
+		n++
+		name := fmt.Sprintf("documents_%d", n)
+		col, err := db.CreateCollection(ctx, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { col.Remove(ctx) })
+
+		return trade.NewArangoRepository[repotest.Document](db, name)
+	})
+}
+
+// arangoEndpoints returns the endpoints listed in TRADE_TEST_ARANGO_ADDRS or,
+// if it is unset, those of a fake server that is closed when t completes.
+func arangoEndpoints(t *testing.T) []string {
+	if addrs := os.Getenv("TRADE_TEST_ARANGO_ADDRS"); addrs != "" {
+		return strings.Split(addrs, ",")
+	}
+	srv := arangotest.NewServer()
+	t.Cleanup(srv.Close)
+	return srv.Endpoints()
+}
+
+func TestArangoClientOptions(t *testing.T) {
+	ctx := context.Background()
+	srv := arangotest.NewServer()
+	defer srv.Close()
+	srv.RequireAuth("root", "secret")
+
+	// An unreachable coordinator is failed over
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	endpoints := []string{down.URL, srv.URL}
+
+	tests := []struct {
+		name    string
+		options []trade.ArangoClientOption
+		ok      bool
+	}{
+		{"no auth", nil, false},
+		{"basic", []trade.ArangoClientOption{trade.WithArangoBasicAuth("root", "secret")}, true},
+		{"wrong password", []trade.ArangoClientOption{trade.WithArangoBasicAuth("root", "guess")}, false},
+		{"jwt", []trade.ArangoClientOption{trade.WithArangoJWTAuth("root", "secret")}, true},
+		{"token", []trade.ArangoClientOption{trade.WithArangoJWT(srv.IssueToken())}, true},
+	}
+	for _, tt := range tests {
+		cl, err := trade.NewArangoClient(endpoints, append(tt.options, trade.WithArangoTimeout(5*time.Second))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cl.WaitReady(ctx, 3, time.Millisecond)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: WaitReady() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if !tt.ok && !arangodriver.IsUnauthorized(err) {
+			t.Errorf("%s: WaitReady() = %v, want unauthorized", tt.name, err)
+		}
+	}
+}
+
+func TestArangoClientTLS(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewTLSServer(arangotest.NewServer())
+	defer srv.Close()
+
+	cl, err := trade.NewArangoClient([]string{srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.WaitReady(ctx, 1, 0); err == nil {
+		t.Error("WaitReady() with an untrusted certificate succeeded")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err = os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if cl, err = trade.NewArangoClient([]string{srv.URL}, trade.WithArangoCAFile(caFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.WaitReady(ctx, 1, 0); err != nil {
+		t.Errorf("WaitReady() with the CA trusted = %v", err)
+	}
+}
+
+func TestArangoClientWaitReady(t *testing.T) {
+	srv := arangotest.NewServer()
+	defer srv.Close()
+
+	// The server becomes available after two failed attempts
+	var calls int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	cl, err := trade.NewArangoClient([]string{flaky.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.WaitReady(context.Background(), 2, time.Millisecond); err == nil {
+		t.Error("WaitReady() succeeded before the server was available")
+	}
+	if err = cl.WaitReady(context.Background(), 2, time.Millisecond); err != nil {
+		t.Errorf("WaitReady() = %v, want the second attempt to succeed", err)
+	}
+}
+
+// TestArangoQueryRaw checks the AQL projections, expansions and sorts that
+// ?fields=, ?expand= and ?sort= compile to.
+func TestArangoQueryRaw(t *testing.T) {
+	ctx := context.Background()
+	cl, err := trade.NewArangoClient(arangoEndpoints(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := cl.DriverClient.Database(ctx, "trade_test")
+	if arangodriver.IsNotFoundGeneral(err) {
+		db, err = cl.DriverClient.CreateDatabase(ctx, "trade_test", nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"users", "accounts", "transactions"} {
+		options := &arangodriver.CreateCollectionOptions{}
+		if name == "transactions" {
+			options.Type = arangodriver.CollectionTypeEdge
+		}
+		col, err := db.CreateCollection(ctx, name, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { col.Remove(ctx) })
+	}
+	users := trade.NewArangoRepository[trade.User](db, "users")
+	accounts := trade.NewArangoRepository[trade.Account](db, "accounts")
+	transactions := trade.NewArangoRepository[trade.Transaction](db, "transactions")
+
+	ada, _, err := users.Create(ctx, trade.User{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 3)
+	for i, reputation := range []int{50, 100, 50} {
+		if ids[i], _, err = accounts.Create(ctx, trade.Account{Owner: ada, Reputation: reputation, Balances: map[string]float64{"dollars": float64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err = transactions.Create(ctx, trade.Transaction{Sender: ids[0], Recipient: ids[1], Quantities: map[string]float64{"dollars": 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sorted by reputation, then by balance in reverse
+	got, err := accounts.QueryRaw(ctx, trade.NewQuery().
+		Sort(trade.SortField{Field: "reputation", Direction: trade.SORT_ASC}, trade.SortField{Field: "balances.dollars", Direction: trade.SORT_DESC}).
+		Keep("_id", "reputation").
+		Expand(trade.Relation{Field: "owner", CollectionName: "users"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIDs := []string{ids[2], ids[0], ids[1]}
+	if len(got) != len(wantIDs) {
+		t.Fatalf("QueryRaw returned %d accounts, want %d", len(got), len(wantIDs))
+	}
+	for i, doc := range got {
+		owner, _ := doc["owner"].(map[string]interface{})
+		if doc["_id"] != wantIDs[i] || len(doc) != 3 || owner["name"] != "Ada" || doc["balances"] != nil {
+			t.Errorf("account %d is %v, want %s with its reputation and owner", i, doc, wantIDs[i])
+		}
+	}
+
+	got, err = transactions.QueryRaw(ctx, trade.NewQuery().
+		Keep("quantities").
+		Expand(trade.Relation{Field: "_from", CollectionName: "accounts"}).
+		Expand(trade.Relation{Field: "_to", CollectionName: "accounts"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("QueryRaw returned %d transactions, want 1", len(got))
+	}
+	sender, _ := got[0]["_from"].(map[string]interface{})
+	recipient, _ := got[0]["_to"].(map[string]interface{})
+	if sender["_id"] != ids[0] || recipient["_id"] != ids[1] || got[0]["quantities"] == nil || got[0]["_id"] != nil {
+		t.Errorf("transaction is %v, want its quantities with its sender and recipient expanded", got[0])
+	}
+}
@@ -0,0 +1,809 @@
+package arangotest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// The server only runs the AQL statements that package trade generates:
+//
+//	FOR x IN @@collection
+//	[FILTER (x.@a0 == @v0 && ...) || ...]
+//	[SORT x.@a1 ASC, ...]
+//	[LIMIT @offset, @limit]
+//	RETURN x | x.attribute | KEEP(x, @f0) |
+//		MERGE(<either of those>, {[@r0]: FIRST(FOR e IN @@c0 FILTER e._id == x[@r0] RETURN e), ...})
+//
+// where the comparisons are ==, !=, <, <=, >, >= and IN. Any other statement
+// is rejected with a parse error, so that a test exercising new AQL fails
+// loudly instead of running against a fake that silently diverges from
+// ArangoDB.
+
+// queryError is an error produced while parsing or running a query.
+type queryError struct {
+	code     int
+	errorNum int
+	message  string
+}
+
+func (e queryError) Error() string {
+	return e.message
+}
+
+func parseError(format string, args ...interface{}) error {
+	return queryError{code: 400, errorNum: ERROR_QUERY_PARSE, message: "AQL: syntax error, " + fmt.Sprintf(format, args...)}
+}
+
+func runtimeError(format string, args ...interface{}) error {
+	return queryError{code: 400, errorNum: ERROR_QUERY_RUNTIME, message: "AQL: " + fmt.Sprintf(format, args...)}
+}
+
+// query is a parsed AQL statement.
+type query struct {
+	variable   string
+	collection string
+	// filter is a disjunction of conjunctions of comparisons.
+	filter [][]comparison
+	sort   []sortKey
+	// offset and count are set if the query has a LIMIT.
+	limited       bool
+	offset, count int
+	// attribute is the path of the attribute returned of each document, or
+	// empty to return the document.
+	attribute []string
+	// keep lists the attributes KEEP projects documents down to, or is nil
+	// if they are not projected.
+	keep       map[string]bool
+	expansions []expansion
+}
+
+type comparison struct {
+	path []string
+	op   string
+	val  interface{}
+}
+
+type sortKey struct {
+	path []string
+	desc bool
+}
+
+// expansion replaces the attribute field of each result with the document of
+// collection whose _id it holds.
+type expansion struct {
+	field      string
+	collection string
+}
+
+// run runs q over the collections of v and returns its results.
+func (q *query) run(v view) ([]interface{}, error) {
+	all, err := v.collection(q.collection)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []map[string]interface{}{}
+	for _, doc := range all {
+		if q.matches(doc) {
+			docs = append(docs, doc)
+		}
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range q.sort {
+			cmp := compare(lookup(docs[i], key.path), lookup(docs[j], key.path))
+			if cmp == 0 {
+				continue
+			}
+			if key.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+
+	if q.limited {
+		start := min(q.offset, len(docs))
+		docs = docs[start:min(start+q.count, len(docs))]
+	}
+
+	results := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		if results[i], err = q.result(v, doc); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (q *query) matches(doc map[string]interface{}) bool {
+	if len(q.filter) == 0 {
+		return true
+	}
+	for _, conjunction := range q.filter {
+		matched := true
+		for _, c := range conjunction {
+			if !c.matches(doc) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparison) matches(doc map[string]interface{}) bool {
+	a := lookup(doc, c.path)
+	if c.op == "IN" {
+		arr, _ := c.val.([]interface{})
+		for _, elem := range arr {
+			if compare(a, elem) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp := compare(a, c.val)
+	switch c.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// result returns what q returns for doc.
+func (q *query) result(v view, doc map[string]interface{}) (interface{}, error) {
+	if len(q.attribute) > 0 {
+		return lookup(doc, q.attribute), nil
+	}
+	if q.keep == nil && len(q.expansions) == 0 {
+		return doc, nil
+	}
+
+	out := map[string]interface{}{}
+	for k, val := range doc {
+		if q.keep == nil || q.keep[k] {
+			out[k] = val
+		}
+	}
+	for _, e := range q.expansions {
+		related, err := v.collection(e.collection)
+		if err != nil {
+			return nil, err
+		}
+		out[e.field] = nil
+		for _, r := range related {
+			if compare(r["_id"], doc[e.field]) == 0 {
+				out[e.field] = r
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// lookup returns the attribute of doc at path, or nil if there is none.
+func lookup(doc map[string]interface{}, path []string) interface{} {
+	var val interface{} = doc
+	for _, name := range path {
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		val = obj[name]
+	}
+	return val
+}
+
+// parseQuery parses text with the bind parameters bindVars. Like ArangoDB it
+// rejects queries that use undeclared bind parameters or declare unused ones.
+func parseQuery(text string, bindVars map[string]interface{}) (*query, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, bindVars: bindVars, used: map[string]bool{}}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, parseError("unexpected %q", p.peek().text)
+	}
+
+	for name := range bindVars {
+		if !p.used[name] {
+			return nil, queryError{code: 400, errorNum: ERROR_QUERY_BIND_UNUSED, message: fmt.Sprintf("bind parameter '%s' was not declared in the query", name)}
+		}
+	}
+	return q, nil
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenBind
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var punctuation = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", ".", ",", ":", "(", ")", "[", "]", "{", "}"}
+
+func tokenize(text string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '@':
+			j := i + 1
+			if j < len(text) && text[j] == '@' {
+				j++
+			}
+			start := j
+			for j < len(text) && isIdentChar(rune(text[j])) {
+				j++
+			}
+			if j == start {
+				return nil, parseError("invalid bind parameter at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenBind, text: text[i:j]})
+			i = j
+
+		case isIdentChar(c):
+			j := i
+			for j < len(text) && isIdentChar(rune(text[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: text[i:j]})
+			i = j
+
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(text[i:], p) {
+					tokens = append(tokens, token{kind: tokenPunct, text: p})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, parseError("unsupported character %q", c)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type parser struct {
+	tokens   []token
+	pos      int
+	bindVars map[string]interface{}
+	used     map[string]bool
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokenPunct, text: "end of query"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword reports whether the next token is the keyword kw and consumes it
+// if it is.
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.keyword(kw) {
+		return parseError("expected %s near %q", kw, p.peek().text)
+	}
+	return nil
+}
+
+// punct reports whether the next token is s and consumes it if it is.
+func (p *parser) punct(s string) bool {
+	t := p.peek()
+	if t.kind == tokenPunct && t.text == s && !p.done() {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.punct(s) {
+		return parseError("expected %q near %q", s, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return "", parseError("expected a name near %q", t.text)
+	}
+	return t.text, nil
+}
+
+// variable consumes the variable name.
+func (p *parser) variable(name string) error {
+	if p.calls() {
+		fn := p.peek().text
+		return queryError{code: 404, errorNum: ERROR_QUERY_FUNCTION_UNKNOWN, message: fmt.Sprintf("usage of unknown or unsupported function '%s()'", fn)}
+	}
+	if t := p.next(); t.kind != tokenIdent || t.text != name {
+		return parseError("expected variable %s near %q", name, t.text)
+	}
+	return nil
+}
+
+// bind consumes a bind parameter and returns its value.
+func (p *parser) bind() (interface{}, error) {
+	t := p.next()
+	if t.kind != tokenBind {
+		return nil, parseError("expected a bind parameter near %q", t.text)
+	}
+	name := strings.TrimPrefix(t.text, "@")
+	val, ok := p.bindVars[name]
+	if !ok {
+		return nil, queryError{code: 400, errorNum: ERROR_QUERY_BIND_MISSING, message: fmt.Sprintf("no value specified for declared bind parameter '%s'", name)}
+	}
+	p.used[name] = true
+	return val, nil
+}
+
+// name consumes a bind parameter holding a string.
+func (p *parser) name() (string, error) {
+	val, err := p.bind()
+	if err != nil {
+		return "", err
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", runtimeError("bind parameter %s must be a string", p.tokens[p.pos-1].text)
+	}
+	return s, nil
+}
+
+// collection consumes a collection bind parameter or collection name.
+func (p *parser) collection() (string, error) {
+	if t := p.peek(); t.kind == tokenBind && strings.HasPrefix(t.text, "@@") {
+		return p.name()
+	}
+	return p.ident()
+}
+
+func (p *parser) query() (*query, error) {
+	var err error
+	q := &query{}
+	if err = p.expectKeyword("FOR"); err != nil {
+		return nil, err
+	}
+	if q.variable, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("IN"); err != nil {
+		return nil, err
+	}
+	if q.collection, err = p.collection(); err != nil {
+		return nil, err
+	}
+
+	if p.keyword("FILTER") {
+		if q.filter, err = p.filter(q.variable); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.keyword("SORT") {
+		for {
+			key := sortKey{}
+			if key.path, err = p.path(q.variable); err != nil {
+				return nil, err
+			}
+			if p.keyword("DESC") {
+				key.desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			q.sort = append(q.sort, key)
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		q.limited = true
+		if q.offset, err = p.int(); err != nil {
+			return nil, err
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+		if q.count, err = p.int(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = p.expectKeyword("RETURN"); err != nil {
+		return nil, err
+	}
+	if err = p.result(q); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// filter parses the disjunction of conjunctions of a FILTER.
+func (p *parser) filter(variable string) ([][]comparison, error) {
+	disjunction := [][]comparison{}
+	for {
+		parenthesized := p.punct("(")
+		conjunction := []comparison{}
+		for {
+			c, err := p.comparison(variable)
+			if err != nil {
+				return nil, err
+			}
+			conjunction = append(conjunction, c)
+			if !p.punct("&&") {
+				break
+			}
+		}
+		if parenthesized {
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+		disjunction = append(disjunction, conjunction)
+		if !p.punct("||") {
+			return disjunction, nil
+		}
+	}
+}
+
+func (p *parser) comparison(variable string) (comparison, error) {
+	var err error
+	c := comparison{}
+	if c.path, err = p.path(variable); err != nil {
+		return c, err
+	}
+	switch t := p.next(); {
+	case t.kind == tokenPunct && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		c.op = t.text
+	case t.kind == tokenIdent && strings.EqualFold(t.text, "IN"):
+		c.op = "IN"
+	default:
+		return c, parseError("unsupported comparison %q", t.text)
+	}
+	c.val, err = p.bind()
+	return c, err
+}
+
+// path parses an attribute of variable, such as x.@a0.@a1 or x.version.
+func (p *parser) path(variable string) ([]string, error) {
+	if err := p.variable(variable); err != nil {
+		return nil, err
+	}
+	path := []string{}
+	for p.punct(".") {
+		var name string
+		var err error
+		if p.peek().kind == tokenBind {
+			name, err = p.name()
+		} else {
+			name, err = p.ident()
+		}
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, name)
+	}
+	return path, nil
+}
+
+// int consumes a bind parameter holding a LIMIT operand.
+func (p *parser) int() (int, error) {
+	val, err := p.bind()
+	if err != nil {
+		return 0, err
+	}
+	f, ok := val.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return 0, runtimeError("LIMIT value must be a non-negative integer, got %v", val)
+	}
+	return int(min(f, math.MaxInt32)), nil
+}
+
+// result parses the expression of RETURN into q.
+func (p *parser) result(q *query) error {
+	merge := p.call("MERGE")
+	if err := p.document(q); err != nil {
+		return err
+	}
+	if !merge {
+		return nil
+	}
+	if len(q.attribute) > 0 {
+		return parseError("MERGE of an attribute is not supported")
+	}
+
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		e, err := p.expansion(q.variable)
+		if err != nil {
+			return err
+		}
+		q.expansions = append(q.expansions, e)
+		if !p.punct(",") {
+			break
+		}
+	}
+	if err := p.expect("}"); err != nil {
+		return err
+	}
+	return p.expect(")")
+}
+
+// document parses the document returned, which is an attribute of the
+// variable or a projection of it.
+func (p *parser) document(q *query) error {
+	var err error
+	if !p.call("KEEP") {
+		q.attribute, err = p.path(q.variable)
+		return err
+	}
+
+	if err = p.variable(q.variable); err != nil {
+		return err
+	}
+	if err = p.expect(","); err != nil {
+		return err
+	}
+	val, err := p.bind()
+	if err != nil {
+		return err
+	}
+	q.keep = map[string]bool{}
+	fields, _ := val.([]interface{})
+	for _, field := range fields {
+		if s, ok := field.(string); ok {
+			q.keep[s] = true
+		}
+	}
+	return p.expect(")")
+}
+
+// expansion parses [@r0]: FIRST(FOR e IN @@c0 FILTER e._id == x[@r0] RETURN e).
+func (p *parser) expansion(variable string) (expansion, error) {
+	var err error
+	e := expansion{}
+	if err = p.expect("["); err != nil {
+		return e, err
+	}
+	if e.field, err = p.name(); err != nil {
+		return e, err
+	}
+	if err = p.expect("]"); err != nil {
+		return e, err
+	}
+	if err = p.expect(":"); err != nil {
+		return e, err
+	}
+	if !p.call("FIRST") {
+		return e, parseError("expected FIRST near %q", p.peek().text)
+	}
+
+	if err = p.expectKeyword("FOR"); err != nil {
+		return e, err
+	}
+	related, err := p.ident()
+	if err != nil {
+		return e, err
+	}
+	if err = p.expectKeyword("IN"); err != nil {
+		return e, err
+	}
+	if e.collection, err = p.collection(); err != nil {
+		return e, err
+	}
+	if err = p.expectKeyword("FILTER"); err != nil {
+		return e, err
+	}
+	if path, err := p.path(related); err != nil || len(path) != 1 || path[0] != "_id" {
+		if err == nil {
+			err = parseError("expected %s._id near %q", related, p.peek().text)
+		}
+		return e, err
+	}
+	if err = p.expect("=="); err != nil {
+		return e, err
+	}
+	if err = p.variable(variable); err != nil {
+		return e, err
+	}
+	if err = p.expect("["); err != nil {
+		return e, err
+	}
+	field, err := p.name()
+	if err != nil {
+		return e, err
+	}
+	if field != e.field {
+		return e, parseError("expansion of %s filters on %s", e.field, field)
+	}
+	if err = p.expect("]"); err != nil {
+		return e, err
+	}
+	if err = p.expectKeyword("RETURN"); err != nil {
+		return e, err
+	}
+	if err = p.variable(related); err != nil {
+		return e, err
+	}
+	return e, p.expect(")")
+}
+
+// call reports whether the next tokens call the function fn and consumes them
+// if they do.
+func (p *parser) call(fn string) bool {
+	if !p.calls() || !strings.EqualFold(p.tokens[p.pos].text, fn) {
+		return false
+	}
+	p.pos += 2
+	return true
+}
+
+// calls reports whether the next tokens call a function.
+func (p *parser) calls() bool {
+	return p.pos+1 < len(p.tokens) && p.tokens[p.pos].kind == tokenIdent && p.tokens[p.pos+1].text == "("
+}
+
+// typeRank orders the AQL types null < bool < number < string < array <
+// object.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	}
+	return 5
+}
+
+// compare compares a and b using AQL's ordering of values.
+func compare(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+
+	case float64:
+		bv := b.(float64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+
+	case string:
+		return strings.Compare(av, b.(string))
+
+	case []interface{}:
+		bv := b.([]interface{})
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			var x, y interface{}
+			if i < len(av) {
+				x = av[i]
+			}
+			if i < len(bv) {
+				y = bv[i]
+			}
+			if cmp := compare(x, y); cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+
+	case map[string]interface{}:
+		bv := b.(map[string]interface{})
+		keys := map[string]bool{}
+		for k := range av {
+			keys[k] = true
+		}
+		for k := range bv {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			if cmp := compare(av[k], bv[k]); cmp != 0 {
+				return cmp
+			}
+		}
+		return 0
+	}
+	return 0
+}
+
+// collectionNotFound returns the error ArangoDB responds with when a
+// collection does not exist.
+func collectionNotFound(name string) error {
+	return queryError{code: 404, errorNum: arangodriver.ErrArangoDataSourceNotFound, message: fmt.Sprintf("collection or view not found: %s", name)}
+}
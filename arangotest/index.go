@@ -0,0 +1,180 @@
+package arangotest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// index is a persistent or ttl index of a collection. The server enforces the
+// uniqueness of unique indexes but never uses an index to answer a query, and
+// ttl indexes do not expire documents.
+type index struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Fields      []string `json:"fields"`
+	Unique      bool     `json:"unique"`
+	Sparse      bool     `json:"sparse"`
+	ExpireAfter int      `json:"expireAfter,omitempty"`
+}
+
+// sameDefinition reports whether idx and other index the same fields in the
+// same way, in which case ensuring other returns idx.
+func (idx *index) sameDefinition(other *index) bool {
+	if idx.Type != other.Type || idx.Unique != other.Unique || idx.Sparse != other.Sparse ||
+		idx.ExpireAfter != other.ExpireAfter || len(idx.Fields) != len(other.Fields) {
+		return false
+	}
+	for i := range idx.Fields {
+		if idx.Fields[i] != other.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// values returns the values doc holds for the fields of idx and whether doc
+// is indexed, which a sparse index does not do if any of them is null.
+func (idx *index) values(doc map[string]interface{}) ([]interface{}, bool) {
+	values := make([]interface{}, len(idx.Fields))
+	for i, field := range idx.Fields {
+		var v interface{} = doc
+		for _, name := range strings.Split(field, ".") {
+			obj, _ := v.(map[string]interface{})
+			v = obj[name]
+		}
+		if v == nil && idx.Sparse {
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+// checkUnique returns an error if storing doc under key would give two
+// documents of c the same values for a unique index.
+func (c *collection) checkUnique(doc map[string]interface{}, key string) error {
+	for _, idx := range c.indexes {
+		if !idx.Unique {
+			continue
+		}
+		values, ok := idx.values(doc)
+		if !ok {
+			continue
+		}
+		for k, other := range c.docs {
+			if k == key {
+				continue
+			}
+			if otherValues, ok := idx.values(other); ok && compare(values, otherValues) == 0 {
+				return uniqueConstraintViolated(idx, k)
+			}
+		}
+	}
+	return nil
+}
+
+func uniqueConstraintViolated(idx *index, conflictingKey string) error {
+	return queryError{
+		code:     http.StatusConflict,
+		errorNum: arangodriver.ErrArangoUniqueConstraintViolated,
+		message: fmt.Sprintf("unique constraint violated - in index %s of type %s over '%s'; conflicting key: %s",
+			idx.Name, idx.Type, strings.Join(idx.Fields, ", "), conflictingKey),
+	}
+}
+
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request, v view, args []string) {
+	switch {
+	case r.Method == http.MethodGet && len(args) == 0:
+		c, err := v.collectionByName(r.URL.Query().Get("collection"))
+		if err != nil {
+			writeArangoError(w, err)
+			return
+		}
+		indexes := []interface{}{map[string]interface{}{
+			"id": c.name + "/0", "name": "primary", "type": "primary", "fields": []string{"_key"}, "unique": true, "sparse": false,
+		}}
+		for _, idx := range c.indexes {
+			indexes = append(indexes, idx)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"indexes": indexes})
+
+	case r.Method == http.MethodPost && len(args) == 0:
+		c, err := v.collectionByName(r.URL.Query().Get("collection"))
+		if err != nil {
+			writeArangoError(w, err)
+			return
+		}
+		idx := &index{}
+		if !readJSON(w, r, idx) {
+			return
+		}
+		if idx.Type != "persistent" && idx.Type != "ttl" || len(idx.Fields) == 0 {
+			writeError(w, http.StatusBadRequest, ERROR_HTTP_BAD_PARAMETER, "only persistent and ttl indexes over at least one field are supported")
+			return
+		}
+
+		for _, existing := range c.indexes {
+			if existing.sameDefinition(idx) && (idx.Name == "" || idx.Name == existing.Name) {
+				writeIndex(w, http.StatusOK, existing, false)
+				return
+			}
+			if idx.Name != "" && idx.Name == existing.Name {
+				writeError(w, http.StatusConflict, ERROR_DUPLICATE_NAME, "duplicate value: index name "+idx.Name)
+				return
+			}
+		}
+
+		id := s.newID()
+		idx.ID = c.name + "/" + id
+		if idx.Name == "" {
+			idx.Name = "idx_" + id
+		}
+		if idx.Unique {
+			seen := &collection{indexes: []*index{idx}, docs: map[string]map[string]interface{}{}}
+			for _, key := range c.keys {
+				if err := seen.checkUnique(c.docs[key], key); err != nil {
+					writeArangoError(w, err)
+					return
+				}
+				seen.docs[key] = c.docs[key]
+			}
+		}
+		c.indexes = append(c.indexes, idx)
+		writeIndex(w, http.StatusCreated, idx, true)
+
+	case (r.Method == http.MethodGet || r.Method == http.MethodDelete) && len(args) == 2:
+		c, err := v.collectionByName(args[0])
+		if err != nil {
+			writeArangoError(w, err)
+			return
+		}
+		for i, idx := range c.indexes {
+			if idx.ID != args[0]+"/"+args[1] && idx.Name != args[1] {
+				continue
+			}
+			if r.Method == http.MethodGet {
+				writeIndex(w, http.StatusOK, idx, false)
+				return
+			}
+			c.indexes = append(c.indexes[:i:i], c.indexes[i+1:]...)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": idx.ID})
+			return
+		}
+		writeError(w, http.StatusNotFound, ERROR_INDEX_NOT_FOUND, "index not found")
+
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func writeIndex(w http.ResponseWriter, code int, idx *index, created bool) {
+	writeJSON(w, code, map[string]interface{}{
+		"id": idx.ID, "name": idx.Name, "type": idx.Type, "fields": idx.Fields,
+		"unique": idx.Unique, "sparse": idx.Sparse, "expireAfter": idx.ExpireAfter,
+		"isNewlyCreated": created,
+	})
+}
@@ -0,0 +1,982 @@
+// Package arangotest provides an in-process stand-in for an ArangoDB server so
+// that code built on the ArangoDB driver can be tested without one.
+//
+// The server implements the subset of the ArangoDB HTTP API used by this
+// project: creating databases and collections, document CRUD, AQL cursors for
+// the queries package trade compiles, stream transactions, unique persistent
+// indexes, basic and JWT authentication, and enough of the graph, analyzer and
+// view APIs for databases to be provisioned. Any other query, such as graph
+// traversals, COLLECT or ArangoSearch queries, fails with a parse error.
+package arangotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// Error numbers of the ArangoDB errors the server responds with that the
+// driver has no constant for.
+var (
+	ERROR_QUERY_PARSE            = 1501
+	ERROR_QUERY_RUNTIME          = 1562
+	ERROR_QUERY_FUNCTION_UNKNOWN = 1540
+	ERROR_QUERY_BIND_MISSING     = 1551
+	ERROR_QUERY_BIND_UNUSED      = 1552
+	ERROR_CURSOR_NOT_FOUND       = 1600
+	ERROR_TRANSACTION_NOT_FOUND  = 1655
+	ERROR_DATABASE_NOT_FOUND     = 1228
+	ERROR_DUPLICATE_NAME         = 1207
+	ERROR_DOCUMENT_KEY_BAD       = 1221
+	ERROR_INDEX_NOT_FOUND        = 1212
+	ERROR_EDGE_ATTRIBUTE_MISSING = 1233
+	ERROR_GRAPH_NOT_FOUND        = 1924
+	ERROR_GRAPH_DUPLICATE        = 1925
+	ERROR_HTTP_BAD_PARAMETER     = 400
+	ERROR_HTTP_UNAUTHORIZED      = 401
+	ERROR_HTTP_NOT_FOUND         = 404
+)
+
+// DEFAULT_BATCH_SIZE is the number of results a cursor returns per batch when
+// the query does not set one.
+var DEFAULT_BATCH_SIZE = 1000
+
+// Server is a fake ArangoDB server listening on a local address.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	databases    map[string]*database
+	cursors      map[string]*cursor
+	transactions map[string]*transaction
+	lastID       int64
+
+	// users maps the name of each user to its password. If it is not empty
+	// every request must authenticate.
+	users  map[string]string
+	tokens map[string]bool
+}
+
+// NewServer starts and returns a new Server with an empty _system database.
+// The caller should call Close when finished to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		databases:    map[string]*database{"_system": newDatabase("_system")},
+		cursors:      map[string]*cursor{},
+		transactions: map[string]*transaction{},
+		users:        map[string]string{},
+		tokens:       map[string]bool{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// RequireAuth makes the server refuse requests that do not authenticate as
+// username with password, either with HTTP basic authentication or with a JWT
+// obtained from POST /_open/auth or IssueToken.
+func (s *Server) RequireAuth(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = password
+}
+
+// IssueToken returns a new JWT the server accepts.
+func (s *Server) IssueToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueToken()
+}
+
+func (s *Server) issueToken() string {
+	token := "jwt" + s.newID()
+	s.tokens[token] = true
+	return token
+}
+
+// authorized reports whether r authenticates as a user of the server.
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.users) == 0 {
+		return true
+	}
+	if username, password, ok := r.BasicAuth(); ok {
+		want, exists := s.users[username]
+		return exists && want == password
+	}
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "bearer") && s.tokens[token]
+}
+
+// serveAuth serves POST /_open/auth, which exchanges a username and password
+// for a JWT.
+func (s *Server) serveAuth(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if !readJSON(w, r, &credentials) {
+		return
+	}
+	if want, ok := s.users[credentials.Username]; !ok || want != credentials.Password {
+		writeError(w, http.StatusUnauthorized, ERROR_HTTP_UNAUTHORIZED, "Wrong credentials")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"jwt": s.issueToken()})
+}
+
+// Endpoints returns the endpoints to configure an ArangoDB client with.
+func (s *Server) Endpoints() []string {
+	return []string{s.URL}
+}
+
+type database struct {
+	name        string
+	collections map[string]*collection
+	graphs      map[string]map[string]interface{}
+	analyzers   map[string]map[string]interface{}
+	views       map[string]map[string]interface{}
+}
+
+func newDatabase(name string) *database {
+	return &database{
+		name:        name,
+		collections: map[string]*collection{},
+		graphs:      map[string]map[string]interface{}{},
+		analyzers:   map[string]map[string]interface{}{},
+		views:       map[string]map[string]interface{}{},
+	}
+}
+
+type collection struct {
+	id   string
+	name string
+	// kind is the ArangoDB collection type, 2 for documents and 3 for edges.
+	kind int
+	docs map[string]map[string]interface{}
+	// keys lists the keys of docs in insertion order.
+	keys []string
+	// schema is the collection's validation rule. It is stored but not
+	// enforced.
+	schema  interface{}
+	indexes []*index
+}
+
+func (c *collection) clone() *collection {
+	out := &collection{
+		id:   c.id,
+		name: c.name,
+		kind: c.kind,
+		docs: make(map[string]map[string]interface{}, len(c.docs)),
+		keys: append([]string{}, c.keys...),
+
+		schema:  c.schema,
+		indexes: c.indexes,
+	}
+	for k, v := range c.docs {
+		out.docs[k] = v
+	}
+	return out
+}
+
+func (c *collection) info() map[string]interface{} {
+	return map[string]interface{}{
+		"id":               c.id,
+		"name":             c.name,
+		"type":             c.kind,
+		"status":           3,
+		"isSystem":         strings.HasPrefix(c.name, "_"),
+		"globallyUniqueId": c.id,
+		"schema":           c.schema,
+	}
+}
+
+func (c *collection) remove(key string) {
+	delete(c.docs, key)
+	for i, k := range c.keys {
+		if k == key {
+			c.keys = append(c.keys[:i:i], c.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// cursor holds the results of a query that did not fit in one batch.
+type cursor struct {
+	id        string
+	results   []interface{}
+	batchSize int
+	count     *int
+}
+
+// transaction is a stream transaction. Collections are copied into it the
+// first time the transaction touches them, so it reads a snapshot of them and
+// its writes are only visible to other requests once it commits. Committing
+// replaces the collections it touched, discarding writes made outside the
+// transaction in the meantime, which is enough for tests that do not race
+// transactions against each other.
+type transaction struct {
+	id          string
+	db          *database
+	status      string
+	collections map[string]*collection
+}
+
+// view is the state a request reads and writes: a database, seen through a
+// transaction if the request is part of one.
+type view struct {
+	db  *database
+	trx *transaction
+}
+
+func (v view) collectionByName(name string) (*collection, error) {
+	if v.trx != nil {
+		if c, ok := v.trx.collections[name]; ok {
+			return c, nil
+		}
+	}
+	c, ok := v.db.collections[name]
+	if !ok {
+		return nil, collectionNotFound(name)
+	}
+	if v.trx != nil {
+		c = c.clone()
+		v.trx.collections[name] = c
+	}
+	return c, nil
+}
+
+func (v view) collection(name string) ([]map[string]interface{}, error) {
+	c, err := v.collectionByName(name)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]map[string]interface{}, len(c.keys))
+	for i, key := range c.keys {
+		docs[i] = c.docs[key]
+	}
+	return docs, nil
+}
+
+func (s *Server) newID() string {
+	s.lastID++
+	return strconv.FormatInt(s.lastID, 10)
+}
+
+func (s *Server) newRev() string {
+	s.lastID++
+	return "_" + strconv.FormatInt(s.lastID, 36)
+}
+
+// ServeHTTP routes a request to the handler of the API it calls.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbName, segments := "_system", splitPath(r.URL.EscapedPath())
+	if r.Method == http.MethodPost && len(segments) == 2 && segments[0] == "_open" && segments[1] == "auth" {
+		s.serveAuth(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, ERROR_HTTP_UNAUTHORIZED, "not authorized to execute this request")
+		return
+	}
+	if len(segments) >= 2 && segments[0] == "_db" {
+		dbName, segments = segments[1], segments[2:]
+	}
+	if len(segments) < 2 || segments[0] != "_api" {
+		writeError(w, http.StatusNotFound, ERROR_HTTP_NOT_FOUND, "unknown path "+r.URL.Path)
+		return
+	}
+	api, args := segments[1], segments[2:]
+
+	if api == "database" && r.Method == http.MethodPost && len(args) == 0 {
+		s.createDatabase(w, r)
+		return
+	}
+	if api == "database" && r.Method == http.MethodDelete && len(args) == 1 {
+		s.dropDatabase(w, args[0])
+		return
+	}
+
+	db, ok := s.databases[dbName]
+	if !ok {
+		writeError(w, http.StatusNotFound, ERROR_DATABASE_NOT_FOUND, "database not found")
+		return
+	}
+
+	v := view{db: db}
+	if id := r.Header.Get("x-arango-trx-id"); id != "" && api != "transaction" {
+		trx, ok := s.transactions[id]
+		if !ok || trx.db != db || trx.status != "running" {
+			writeError(w, http.StatusNotFound, ERROR_TRANSACTION_NOT_FOUND, "transaction '"+id+"' not found")
+			return
+		}
+		v.trx = trx
+	}
+
+	switch api {
+	case "version":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"server": "arango", "version": "3.11.0", "license": "community"})
+	case "collection":
+		s.serveCollection(w, r, v, args)
+	case "document":
+		s.serveDocument(w, r, v, args)
+	case "index":
+		s.serveIndex(w, r, v, args)
+	case "cursor":
+		s.serveCursor(w, r, v, args)
+	case "transaction":
+		s.serveTransaction(w, r, db, args)
+	case "gharial":
+		s.serveGraph(w, r, db, args)
+	case "analyzer":
+		s.serveNamed(w, r, db.analyzers, args, "analyzer")
+	case "view":
+		s.serveNamed(w, r, db.views, args, "view")
+	default:
+		writeError(w, http.StatusNotFound, ERROR_HTTP_NOT_FOUND, "unsupported API "+api)
+	}
+}
+
+func splitPath(p string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+func (s *Server) createDatabase(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, ERROR_HTTP_BAD_PARAMETER, "database name is missing")
+		return
+	}
+	if _, ok := s.databases[body.Name]; ok {
+		writeError(w, http.StatusConflict, ERROR_DUPLICATE_NAME, "duplicate database name '"+body.Name+"'")
+		return
+	}
+	s.databases[body.Name] = newDatabase(body.Name)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"result": true})
+}
+
+func (s *Server) dropDatabase(w http.ResponseWriter, name string) {
+	if _, ok := s.databases[name]; !ok || name == "_system" {
+		writeError(w, http.StatusNotFound, ERROR_DATABASE_NOT_FOUND, "database not found")
+		return
+	}
+	delete(s.databases, name)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": true})
+}
+
+func (s *Server) serveCollection(w http.ResponseWriter, r *http.Request, v view, args []string) {
+	switch {
+	case r.Method == http.MethodPost && len(args) == 0:
+		var body struct {
+			Name   string      `json:"name"`
+			Type   int         `json:"type"`
+			Schema interface{} `json:"schema"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+		if body.Name == "" {
+			writeError(w, http.StatusBadRequest, ERROR_HTTP_BAD_PARAMETER, "collection name is missing")
+			return
+		}
+		if _, ok := v.db.collections[body.Name]; ok {
+			writeError(w, http.StatusConflict, ERROR_DUPLICATE_NAME, "duplicate name: "+body.Name)
+			return
+		}
+		c := s.createCollection(v.db, body.Name, body.Type)
+		c.schema = body.Schema
+		writeJSON(w, http.StatusOK, c.info())
+
+	case len(args) >= 1:
+		c, ok := v.db.collections[args[0]]
+		if !ok {
+			writeArangoError(w, collectionNotFound(args[0]))
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && len(args) == 1:
+			writeJSON(w, http.StatusOK, c.info())
+		case r.Method == http.MethodPut && len(args) == 2 && args[1] == "properties":
+			var body struct {
+				Schema interface{} `json:"schema"`
+			}
+			if !readJSON(w, r, &body) {
+				return
+			}
+			if body.Schema != nil {
+				c.schema = body.Schema
+			}
+			writeJSON(w, http.StatusOK, c.info())
+		case r.Method == http.MethodDelete && len(args) == 1:
+			delete(v.db.collections, c.name)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"id": c.id})
+		default:
+			writeMethodNotAllowed(w)
+		}
+
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (s *Server) createCollection(db *database, name string, kind int) *collection {
+	if kind != int(arangodriver.CollectionTypeEdge) {
+		kind = int(arangodriver.CollectionTypeDocument)
+	}
+	c := &collection{
+		id:   s.newID(),
+		name: name,
+		kind: kind,
+		docs: map[string]map[string]interface{}{},
+	}
+	db.collections[name] = c
+	return c
+}
+
+func (s *Server) serveDocument(w http.ResponseWriter, r *http.Request, v view, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		writeError(w, http.StatusNotFound, ERROR_HTTP_NOT_FOUND, "expected a collection and an optional key")
+		return
+	}
+	c, err := v.collectionByName(args[0])
+	if err != nil {
+		writeArangoError(w, err)
+		return
+	}
+
+	if len(args) == 1 {
+		switch r.Method {
+		case http.MethodPost, http.MethodPatch, http.MethodPut, http.MethodDelete:
+			s.serveDocuments(w, r, c)
+		default:
+			writeMethodNotAllowed(w)
+		}
+		return
+	}
+
+	key := args[1]
+	doc, ok := c.docs[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, arangodriver.ErrArangoDocumentNotFound, "document not found")
+		return
+	}
+	if rev := strings.Trim(r.Header.Get("If-Match"), `"`); rev != "" && rev != doc["_rev"] {
+		writeJSON(w, http.StatusPreconditionFailed, map[string]interface{}{
+			"error": true, "code": http.StatusPreconditionFailed, "errorNum": arangodriver.ErrArangoConflict,
+			"errorMessage": "conflict, _rev values do not match",
+			"_id":          doc["_id"], "_key": doc["_key"], "_rev": doc["_rev"],
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, doc)
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch, http.MethodPut:
+		patch := map[string]interface{}{}
+		if !readJSON(w, r, &patch) {
+			return
+		}
+		resp, err := s.updateDocument(r, c, doc, patch)
+		if err != nil {
+			writeArangoError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, resp)
+	case http.MethodDelete:
+		writeJSON(w, http.StatusAccepted, s.removeDocument(r, c, doc))
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// serveDocuments creates, updates, replaces or removes each document of the
+// array in the request body. Like ArangoDB it responds with an array holding
+// the result of each, where the failed ones hold an error number but no HTTP
+// code.
+func (s *Server) serveDocuments(w http.ResponseWriter, r *http.Request, c *collection) {
+	var body json.RawMessage
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		// A single document is created by a POST of an object
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal(body, &doc); err == nil {
+			resp, err := s.createDocument(r, c, doc)
+			if err != nil {
+				writeArangoError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, resp)
+			return
+		}
+	}
+	docs := []map[string]interface{}{}
+	if err := json.Unmarshal(body, &docs); err != nil {
+		writeError(w, http.StatusBadRequest, ERROR_HTTP_BAD_PARAMETER, "expected an array of documents")
+		return
+	}
+
+	results := make([]map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		var resp map[string]interface{}
+		var err error
+		if r.Method == http.MethodPost {
+			resp, err = s.createDocument(r, c, doc)
+		} else {
+			key, _ := doc["_key"].(string)
+			old, ok := c.docs[key]
+			switch {
+			case !ok:
+				err = queryError{code: http.StatusNotFound, errorNum: arangodriver.ErrArangoDocumentNotFound, message: "document not found"}
+			case doc["_rev"] != nil && doc["_rev"] != old["_rev"] && r.URL.Query().Get("ignoreRevs") == "false":
+				err = queryError{code: http.StatusPreconditionFailed, errorNum: arangodriver.ErrArangoConflict, message: "conflict, _rev values do not match"}
+			case r.Method == http.MethodDelete:
+				resp = s.removeDocument(r, c, old)
+			default:
+				resp, err = s.updateDocument(r, c, old, doc)
+			}
+		}
+		if err != nil {
+			resp = map[string]interface{}{"error": true, "errorNum": ERROR_HTTP_BAD_PARAMETER, "errorMessage": err.Error()}
+			if qerr, ok := err.(queryError); ok {
+				resp["errorNum"] = qerr.errorNum
+			}
+		}
+		results[i] = resp
+	}
+	writeJSON(w, http.StatusAccepted, results)
+}
+
+// createDocument creates doc in c and returns its metadata, along with the
+// document if the request asks for it.
+func (s *Server) createDocument(r *http.Request, c *collection, doc map[string]interface{}) (map[string]interface{}, error) {
+	key, _ := doc["_key"].(string)
+	if key == "" {
+		key = s.newID()
+	} else if strings.ContainsAny(key, "/ ") {
+		return nil, queryError{code: http.StatusBadRequest, errorNum: ERROR_DOCUMENT_KEY_BAD, message: "illegal document key"}
+	}
+	if _, ok := c.docs[key]; ok {
+		return nil, queryError{code: http.StatusConflict, errorNum: arangodriver.ErrArangoUniqueConstraintViolated, message: "unique constraint violated - in index primary of type primary over '_key'"}
+	}
+	if c.kind == int(arangodriver.CollectionTypeEdge) {
+		from, _ := doc["_from"].(string)
+		to, _ := doc["_to"].(string)
+		if !strings.Contains(from, "/") || !strings.Contains(to, "/") {
+			return nil, queryError{code: http.StatusBadRequest, errorNum: ERROR_EDGE_ATTRIBUTE_MISSING, message: "edge attribute missing or invalid"}
+		}
+	}
+
+	doc["_key"] = key
+	doc["_id"] = c.name + "/" + key
+	if err := c.checkUnique(doc, key); err != nil {
+		return nil, err
+	}
+	doc["_rev"] = s.newRev()
+	c.docs[key] = doc
+	c.keys = append(c.keys, key)
+
+	resp := meta(doc)
+	if r.URL.Query().Get("returnNew") == "true" {
+		resp["new"] = doc
+	}
+	return resp, nil
+}
+
+// updateDocument patches old with patch or, for a PUT, replaces it, and
+// returns the metadata of the new document.
+func (s *Server) updateDocument(r *http.Request, c *collection, old, patch map[string]interface{}) (map[string]interface{}, error) {
+	for _, system := range []string{"_id", "_key", "_rev"} {
+		delete(patch, system)
+	}
+
+	doc := map[string]interface{}{}
+	if r.Method == http.MethodPatch {
+		for k, v := range old {
+			doc[k] = v
+		}
+		mergeObjects := r.URL.Query().Get("mergeObjects") != "false"
+		keepNull := r.URL.Query().Get("keepNull") != "false"
+		merge(doc, patch, mergeObjects, keepNull)
+	} else {
+		for k, v := range patch {
+			doc[k] = v
+		}
+		if c.kind == int(arangodriver.CollectionTypeEdge) {
+			for _, edge := range []string{"_from", "_to"} {
+				if _, ok := doc[edge]; !ok {
+					doc[edge] = old[edge]
+				}
+			}
+		}
+	}
+	doc["_key"] = old["_key"]
+	doc["_id"] = old["_id"]
+	if err := c.checkUnique(doc, old["_key"].(string)); err != nil {
+		return nil, err
+	}
+	doc["_rev"] = s.newRev()
+	c.docs[old["_key"].(string)] = doc
+
+	resp := meta(doc)
+	resp["_oldRev"] = old["_rev"]
+	if r.URL.Query().Get("returnNew") == "true" {
+		resp["new"] = doc
+	}
+	if r.URL.Query().Get("returnOld") == "true" {
+		resp["old"] = old
+	}
+	return resp, nil
+}
+
+// removeDocument removes doc from c and returns its metadata.
+func (s *Server) removeDocument(r *http.Request, c *collection, doc map[string]interface{}) map[string]interface{} {
+	c.remove(doc["_key"].(string))
+	resp := meta(doc)
+	if r.URL.Query().Get("returnOld") == "true" {
+		resp["old"] = doc
+	}
+	return resp
+}
+
+// merge merges patch into doc. Nested objects are merged if mergeObjects is
+// set and attributes set to null are removed unless keepNull is set. Nested
+// objects of doc are copied rather than modified.
+func merge(doc, patch map[string]interface{}, mergeObjects, keepNull bool) {
+	for k, v := range patch {
+		if v == nil && !keepNull {
+			delete(doc, k)
+			continue
+		}
+		if pv, ok := v.(map[string]interface{}); ok && mergeObjects {
+			if dv, ok := doc[k].(map[string]interface{}); ok {
+				merged := make(map[string]interface{}, len(dv))
+				for dk, dvv := range dv {
+					merged[dk] = dvv
+				}
+				merge(merged, pv, mergeObjects, keepNull)
+				doc[k] = merged
+				continue
+			}
+		}
+		doc[k] = v
+	}
+}
+
+func meta(doc map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"_id": doc["_id"], "_key": doc["_key"], "_rev": doc["_rev"]}
+}
+
+func (s *Server) serveCursor(w http.ResponseWriter, r *http.Request, v view, args []string) {
+	switch {
+	case r.Method == http.MethodPost && len(args) == 0:
+		var body struct {
+			Query     string                 `json:"query"`
+			BindVars  map[string]interface{} `json:"bindVars"`
+			BatchSize int                    `json:"batchSize"`
+			Count     bool                   `json:"count"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+
+		q, err := parseQuery(body.Query, body.BindVars)
+		if err != nil {
+			writeArangoError(w, err)
+			return
+		}
+		results, err := q.run(v)
+		if err != nil {
+			writeArangoError(w, err)
+			return
+		}
+
+		c := &cursor{results: results, batchSize: body.BatchSize}
+		if c.batchSize <= 0 {
+			c.batchSize = DEFAULT_BATCH_SIZE
+		}
+		if body.Count {
+			n := len(results)
+			c.count = &n
+		}
+		writeJSON(w, http.StatusCreated, s.nextBatch(c))
+
+	case r.Method == http.MethodPut && len(args) == 1:
+		c, ok := s.cursors[args[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, ERROR_CURSOR_NOT_FOUND, "cursor not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.nextBatch(c))
+
+	case r.Method == http.MethodDelete && len(args) == 1:
+		if _, ok := s.cursors[args[0]]; !ok {
+			writeError(w, http.StatusNotFound, ERROR_CURSOR_NOT_FOUND, "cursor not found")
+			return
+		}
+		delete(s.cursors, args[0])
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"id": args[0]})
+
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// nextBatch removes the next batch of results from c and returns the response
+// carrying it, registering c to be continued if results remain.
+func (s *Server) nextBatch(c *cursor) map[string]interface{} {
+	n := c.batchSize
+	if n > len(c.results) {
+		n = len(c.results)
+	}
+	batch := c.results[:n]
+	c.results = c.results[n:]
+
+	resp := map[string]interface{}{
+		"result":  batch,
+		"hasMore": len(c.results) > 0,
+		"cached":  false,
+		"extra":   map[string]interface{}{"warnings": []interface{}{}, "stats": map[string]interface{}{}},
+	}
+	if c.count != nil {
+		resp["count"] = *c.count
+	}
+
+	if len(c.results) > 0 {
+		if c.id == "" {
+			c.id = s.newID()
+			s.cursors[c.id] = c
+		}
+		resp["id"] = c.id
+	} else if c.id != "" {
+		delete(s.cursors, c.id)
+	}
+	return resp
+}
+
+func (s *Server) serveTransaction(w http.ResponseWriter, r *http.Request, db *database, args []string) {
+	if r.Method == http.MethodPost && len(args) == 1 && args[0] == "begin" {
+		var body struct {
+			Collections struct {
+				Read      interface{} `json:"read"`
+				Write     interface{} `json:"write"`
+				Exclusive interface{} `json:"exclusive"`
+			} `json:"collections"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+
+		trx := &transaction{id: s.newID(), db: db, status: "running", collections: map[string]*collection{}}
+		v := view{db: db, trx: trx}
+		for _, names := range []interface{}{body.Collections.Read, body.Collections.Write, body.Collections.Exclusive} {
+			for _, name := range collectionNames(names) {
+				if _, err := v.collectionByName(name); err != nil {
+					writeArangoError(w, err)
+					return
+				}
+			}
+		}
+		s.transactions[trx.id] = trx
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"result": map[string]interface{}{"id": trx.id, "status": trx.status}})
+		return
+	}
+
+	if len(args) != 1 {
+		writeMethodNotAllowed(w)
+		return
+	}
+	trx, ok := s.transactions[args[0]]
+	if !ok || trx.db != db {
+		writeError(w, http.StatusNotFound, ERROR_TRANSACTION_NOT_FOUND, "transaction '"+args[0]+"' not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if trx.status == "running" {
+			for name, c := range trx.collections {
+				if _, ok := db.collections[name]; ok {
+					db.collections[name] = c
+				}
+			}
+			trx.status = "committed"
+		}
+	case http.MethodDelete:
+		if trx.status == "running" {
+			trx.status = "aborted"
+		}
+	default:
+		writeMethodNotAllowed(w)
+		return
+	}
+	if trx.status != "running" {
+		trx.collections = nil
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": map[string]interface{}{"id": trx.id, "status": trx.status}})
+}
+
+// collectionNames returns the collection names of the read, write or exclusive
+// attribute of a transaction, which may be a name or an array of names.
+func collectionNames(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		names := []string{}
+		for _, elem := range v {
+			if name, ok := elem.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func (s *Server) serveGraph(w http.ResponseWriter, r *http.Request, db *database, args []string) {
+	switch {
+	case r.Method == http.MethodGet && len(args) == 1:
+		g, ok := db.graphs[args[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, ERROR_GRAPH_NOT_FOUND, "graph '"+args[0]+"' not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"graph": g})
+
+	case r.Method == http.MethodPost && len(args) == 0:
+		g := map[string]interface{}{}
+		if !readJSON(w, r, &g) {
+			return
+		}
+		name, _ := g["name"].(string)
+		if _, ok := db.graphs[name]; ok {
+			writeError(w, http.StatusConflict, ERROR_GRAPH_DUPLICATE, "graph already exists")
+			return
+		}
+
+		// Like ArangoDB, create the collections of the graph that are missing
+		defs, _ := g["edgeDefinitions"].([]interface{})
+		for _, d := range defs {
+			def, _ := d.(map[string]interface{})
+			if name, ok := def["collection"].(string); ok {
+				if _, ok := db.collections[name]; !ok {
+					s.createCollection(db, name, int(arangodriver.CollectionTypeEdge))
+				}
+			}
+			for _, vertices := range []interface{}{def["from"], def["to"]} {
+				for _, name := range collectionNames(vertices) {
+					if _, ok := db.collections[name]; !ok {
+						s.createCollection(db, name, int(arangodriver.CollectionTypeDocument))
+					}
+				}
+			}
+		}
+
+		g["_id"] = "_graphs/" + name
+		g["_key"] = name
+		db.graphs[name] = g
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"graph": g})
+
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// serveNamed serves an API of named definitions that are stored but have no
+// effect, like analyzers and views.
+func (s *Server) serveNamed(w http.ResponseWriter, r *http.Request, defs map[string]map[string]interface{}, args []string, kind string) {
+	switch {
+	case r.Method == http.MethodGet && (len(args) == 1 || len(args) == 2 && args[1] == "properties"):
+		def, ok := defs[args[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, arangodriver.ErrArangoDataSourceNotFound, kind+" not found: "+args[0])
+			return
+		}
+		writeJSON(w, http.StatusOK, def)
+
+	case r.Method == http.MethodPost && len(args) == 0:
+		def := map[string]interface{}{}
+		if !readJSON(w, r, &def) {
+			return
+		}
+		name, _ := def["name"].(string)
+		if existing, ok := defs[name]; ok {
+			if kind == "analyzer" {
+				writeJSON(w, http.StatusOK, existing)
+				return
+			}
+			writeError(w, http.StatusConflict, ERROR_DUPLICATE_NAME, "duplicate name: "+name)
+			return
+		}
+		def["id"] = s.newID()
+		defs[name] = def
+		writeJSON(w, http.StatusCreated, def)
+
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, ERROR_HTTP_BAD_PARAMETER, fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code, errorNum int, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"error":        true,
+		"code":         code,
+		"errorNum":     errorNum,
+		"errorMessage": message,
+	})
+}
+
+func writeArangoError(w http.ResponseWriter, err error) {
+	if qerr, ok := err.(queryError); ok {
+		writeError(w, qerr.code, qerr.errorNum, qerr.message)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, ERROR_HTTP_BAD_PARAMETER, err.Error())
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, "method not supported")
+}
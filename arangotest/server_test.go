@@ -0,0 +1,193 @@
+package arangotest
+
+import (
+	"context"
+	"testing"
+
+	arangodriver "github.com/arangodb/go-driver"
+	arangohttp "github.com/arangodb/go-driver/http"
+)
+
+func newDatabaseClient(t *testing.T) arangodriver.Database {
+	t.Helper()
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+
+	conn, err := arangohttp.NewConnection(arangohttp.ConnectionConfig{Endpoints: srv.Endpoints()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := arangodriver.NewClient(arangodriver.ClientConfig{Connection: conn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := cl.CreateDatabase(context.Background(), "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestTransactionIsolation(t *testing.T) {
+	ctx := context.Background()
+	db := newDatabaseClient(t)
+	col, err := db.CreateCollection(ctx, "docs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, commit := range []bool{false, true} {
+		trx, err := db.BeginTransaction(ctx, arangodriver.TransactionCollections{Write: []string{"docs"}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trxCtx := arangodriver.WithTransactionID(ctx, trx)
+
+		meta, err := col.CreateDocument(trxCtx, map[string]interface{}{"commit": commit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := col.DocumentExists(trxCtx, meta.Key); !exists {
+			t.Errorf("document created in a transaction is not visible inside it")
+		}
+		if exists, _ := col.DocumentExists(ctx, meta.Key); exists {
+			t.Errorf("document created in a running transaction is visible outside it")
+		}
+
+		if commit {
+			err = db.CommitTransaction(ctx, trx, nil)
+		} else {
+			err = db.AbortTransaction(ctx, trx, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := col.DocumentExists(ctx, meta.Key); exists != commit {
+			t.Errorf("after commit=%v document exists=%v", commit, exists)
+		}
+
+		if _, err = col.CreateDocument(trxCtx, map[string]interface{}{}); !arangodriver.IsNotFoundGeneral(err) {
+			t.Errorf("write to a finished transaction returned %v, want not found", err)
+		}
+	}
+}
+
+func TestCursorBatches(t *testing.T) {
+	ctx := context.Background()
+	db := newDatabaseClient(t)
+	col, err := db.CreateCollection(ctx, "docs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err = col.CreateDocument(ctx, map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := "FOR d IN @@col FILTER d.n >= @min SORT d.n DESC RETURN d.n"
+	cur, err := db.Query(arangodriver.WithQueryBatchSize(ctx, 2), query, map[string]interface{}{"@col": "docs", "min": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	got := []int{}
+	for cur.HasMore() {
+		var n int
+		if _, err = cur.ReadDocument(ctx, &n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	want := []int{4, 3, 2, 1}
+	if len(got) != len(want) {
+		t.Fatalf("query returned %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("query returned %v, want %v", got, want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	ctx := context.Background()
+	db := newDatabaseClient(t)
+	if _, err := db.CreateCollection(ctx, "docs", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query    string
+		bindVars map[string]interface{}
+		errorNum int
+	}{
+		{"FOR d IN docs RETURN", nil, ERROR_QUERY_PARSE},
+		{"FOR d IN docs COLLECT g = d.g RETURN g", nil, ERROR_QUERY_PARSE},
+		{"FOR d IN docs FILTER d.n == 1 RETURN d", nil, ERROR_QUERY_PARSE},
+		{"FOR d IN docs FILTER e.n == @n RETURN d", map[string]interface{}{"n": 1}, ERROR_QUERY_PARSE},
+		{"FOR d IN docs LIMIT @n RETURN d", map[string]interface{}{"n": 1}, ERROR_QUERY_PARSE},
+		{"FOR d IN docs RETURN DISTINCT d", nil, ERROR_QUERY_PARSE},
+		{"LET n = 1 RETURN n", nil, ERROR_QUERY_PARSE},
+		{"FOR v IN 1..2 ANY @start GRAPH @graph RETURN v", map[string]interface{}{"start": "users/1", "graph": "g"}, ERROR_QUERY_PARSE},
+		{"FOR d IN docs FILTER d.n == @n RETURN d", nil, ERROR_QUERY_BIND_MISSING},
+		{"FOR d IN docs RETURN d", map[string]interface{}{"n": 1}, ERROR_QUERY_BIND_UNUSED},
+		{"FOR d IN docs RETURN NOPE(d)", nil, ERROR_QUERY_FUNCTION_UNKNOWN},
+		{"FOR d IN missing RETURN d", nil, arangodriver.ErrArangoDataSourceNotFound},
+	}
+	for _, tt := range tests {
+		_, err := db.Query(ctx, tt.query, tt.bindVars)
+		aerr, ok := arangodriver.AsArangoError(err)
+		if !ok || aerr.ErrorNum != tt.errorNum {
+			t.Errorf("Query(%q) returned %v, want error %d", tt.query, err, tt.errorNum)
+		}
+	}
+}
+
+func TestQueryProjection(t *testing.T) {
+	ctx := context.Background()
+	db := newDatabaseClient(t)
+	users, err := db.CreateCollection(ctx, "users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := db.CreateCollection(ctx, "accounts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = users.CreateDocument(ctx, map[string]interface{}{"_key": "ada", "name": "Ada"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, owner := range []string{"users/ada", "users/bob"} {
+		if _, err = accounts.CreateDocument(ctx, map[string]interface{}{"owner": owner, "balances": map[string]interface{}{"dollars": 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := "FOR x IN @@collection FILTER (x.@a0 IN @v0) || (x.@a1.@a2 < @v1) SORT x.@a3 DESC LIMIT @offset, @limit " +
+		"RETURN MERGE(KEEP(x, @f0), {[@r0]: FIRST(FOR e IN @@c0 FILTER e._id == x[@r0] RETURN e)})"
+	cur, err := db.Query(ctx, query, map[string]interface{}{
+		"@collection": "accounts", "@c0": "users",
+		"a0": "owner", "a1": "balances", "a2": "dollars", "a3": "owner", "r0": "owner",
+		"v0": []string{"users/ada"}, "v1": 0, "f0": []string{"owner"},
+		"offset": 0, "limit": 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	got := []map[string]interface{}{}
+	for cur.HasMore() {
+		var doc map[string]interface{}
+		if _, err = cur.ReadDocument(ctx, &doc); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, doc)
+	}
+	owner, _ := got[0]["owner"].(map[string]interface{})
+	if len(got) != 1 || len(got[0]) != 1 || owner["name"] != "Ada" {
+		t.Errorf("query returned %v", got)
+	}
+}
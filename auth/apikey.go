@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// KEY_PREFIX starts every API key, so that keys are recognizable in headers
+// and leaked credentials.
+var KEY_PREFIX = "trade_"
+
+// ErrInvalidKey is returned when an API key is malformed, unknown or revoked.
+var ErrInvalidKey = errors.New("invalid API key")
+
+// IssueKey issues a new API key named name to the user with _id owner and
+// returns the key and its stored record. The key cannot be recovered later.
+func (s *service) IssueKey(ctx context.Context, owner, name string) (string, trade.APIKey, error) {
+	if _, err := s.users.Get(ctx, owner); err != nil {
+		return "", trade.APIKey{}, err
+	}
+
+	prefix, secret := make([]byte, 6), make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", trade.APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", trade.APIKey{}, err
+	}
+	k := trade.APIKey{
+		Owner:             trade.DocumentID("users", owner),
+		Name:              name,
+		Prefix:            hex.EncodeToString(prefix),
+		CreationTimestamp: time.Now(),
+	}
+	key := KEY_PREFIX + k.Prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = hashKey(key)
+
+	id, k, err := s.keys.Create(ctx, k)
+	if err != nil {
+		return "", trade.APIKey{}, err
+	}
+	k.ID = id
+	return key, k, nil
+}
+
+// authenticateKey returns the principal key authenticates as.
+func (s *service) authenticateKey(ctx context.Context, key string) (Principal, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(key, KEY_PREFIX), "_")
+	if !strings.HasPrefix(key, KEY_PREFIX) || !ok {
+		return Principal{}, ErrInvalidKey
+	}
+
+	matches, err := s.keys.Query(ctx, trade.NewQuery().Where(trade.NewFilterKey("prefix", trade.Eq, prefix)).Page(0, 1))
+	if err != nil {
+		return Principal{}, err
+	}
+	if len(matches) < 1 || matches[0].Revoked ||
+		subtle.ConstantTimeCompare([]byte(matches[0].Hash), []byte(hashKey(key))) != 1 {
+		return Principal{}, ErrInvalidKey
+	}
+
+	// Keys of deleted users are no longer valid
+	k := matches[0]
+	owner, err := s.users.Get(ctx, k.Owner)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: owner %s: %v", ErrInvalidKey, k.Owner, err)
+	}
+	return Principal{UserID: k.Owner, KeyID: k.ID, Method: METHOD_API_KEY, Roles: owner.Roles}, nil
+}
+
+// hashKey returns the hex encoded SHA-256 hash of key. Keys are random so a
+// fast hash is enough to keep them secret at rest.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+	"github.com/go-chi/chi"
+)
+
+// keyRequest represents a request body naming a new API key.
+type keyRequest struct {
+	Name string `json:"name"`
+}
+
+// key is an API key as shown to its owner. Key holds the key itself and is only
+// set in the response to the request that issued it.
+type key struct {
+	ID                string    `json:"_id"`
+	Owner             string    `json:"owner"`
+	Name              string    `json:"name"`
+	Prefix            string    `json:"prefix"`
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+	Revoked           bool      `json:"revoked"`
+	Key               string    `json:"key,omitempty"`
+}
+
+func newKey(k trade.APIKey) key {
+	return key{
+		ID:                k.ID,
+		Owner:             k.Owner,
+		Name:              k.Name,
+		Prefix:            k.Prefix,
+		CreationTimestamp: k.CreationTimestamp,
+		Revoked:           k.Revoked,
+	}
+}
+
+// token is a JWT issued in exchange for an API key.
+type token struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type response[T key | []key | token] struct {
+	Data T `json:"data"`
+}
+
+func newResponse[T key | []key | token](data T) response[T] {
+	return response[T]{Data: data}
+}
+
+// Authenticate is chi middleware that authenticates requests with an API key
+// or a JWT, putting the principal in the request context. Requests without
+// valid credentials are refused with 401 Unauthorized.
+func (s *service) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		var p Principal
+		ctx := context.TODO()
+
+		credential := r.Header.Get("X-API-Key")
+		if scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "bearer") && credential == "" {
+			credential = strings.TrimSpace(value)
+		}
+
+		switch {
+		case credential == "":
+			s.renderUnauthorized(w, r, nil, "authentication required")
+			return
+		case strings.HasPrefix(credential, KEY_PREFIX):
+			p, err = s.authenticateKey(ctx, credential)
+		case s.tokens != nil:
+			var claims Claims
+			claims, err = s.tokens.Verify(credential)
+			p = Principal{UserID: claims.Subject, Method: METHOD_JWT, Roles: claims.Roles}
+		default:
+			err = ErrInvalidToken
+		}
+		if errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrInvalidToken) {
+			s.renderUnauthorized(w, r, err, err.Error())
+			return
+		} else if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+	})
+}
+
+func (s *service) renderUnauthorized(w http.ResponseWriter, r *http.Request, err error, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="trade"`)
+	s.renderer.RenderError(w, r, err, http.StatusUnauthorized, "%s", message)
+}
+
+// handleToken exchanges the API key a request authenticated with for a JWT
+// holding the roles of its user.
+func (s *service) handleToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, _ := FromContext(r.Context())
+		if p.Method != METHOD_API_KEY {
+			s.renderUnauthorized(w, r, nil, "tokens are only issued in exchange for an API key")
+			return
+		}
+		if s.tokens == nil {
+			s.renderer.RenderError(w, r, nil, http.StatusNotImplemented, "token issuance is not configured")
+			return
+		}
+
+		signed, claims, err := s.tokens.Sign(p.UserID, p.Roles)
+		if errors.Is(err, ErrCannotSign) {
+			s.renderer.RenderError(w, r, err, http.StatusNotImplemented, "token issuance is not configured")
+			return
+		} else if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusCreated, newResponse(token{
+			Token:     signed,
+			TokenType: "Bearer",
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		}))
+	}
+}
+
+// handleCreateKey issues a new API key to the authenticated user.
+func (s *service) handleCreateKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		p, _ := FromContext(r.Context())
+
+		reqData := keyRequest{}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		if len(body) > 0 {
+			if err = json.Unmarshal(body, &reqData); err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+				return
+			}
+		}
+
+		issued, k, err := s.IssueKey(ctx, p.UserID, reqData.Name)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		resp := newKey(k)
+		resp.Key = issued
+		s.renderer.RenderJSON(w, r, http.StatusCreated, newResponse(resp))
+	}
+}
+
+// handleListKeys lists the API keys of the authenticated user.
+func (s *service) handleListKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		p, _ := FromContext(r.Context())
+
+		owned, err := s.keys.Query(ctx, trade.NewQuery().
+			Where(trade.NewFilterKey("owner", trade.Eq, p.UserID)).
+			Sort(trade.SortField{Field: "creationTimestamp", Direction: trade.SORT_ASC}))
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		resp := make([]key, len(owned))
+		for i, k := range owned {
+			resp[i] = newKey(k)
+		}
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+// handleRevokeKey revokes an API key of the authenticated user. Keys of other
+// users are reported as not found.
+func (s *service) handleRevokeKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		p, _ := FromContext(r.Context())
+		id := chi.URLParam(r, "id")
+
+		k, err := s.keys.Get(ctx, id)
+		if arango.IsNotFoundGeneral(err) || (err == nil && k.Owner != p.UserID) {
+			s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s not found", id)
+			return
+		} else if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		k.Revoked = true
+		if _, err = s.keys.Update(ctx, id, k); err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
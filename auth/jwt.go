@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Signing algorithms of JWTs.
+var (
+	ALG_HS256 = "HS256"
+	ALG_RS256 = "RS256"
+)
+
+var (
+	// ErrInvalidToken is returned when a JWT is malformed, is signed with
+	// another key or algorithm, or is outside its validity period.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrCannotSign is returned when tokens are requested from a JWT that only
+	// holds a key to verify them.
+	ErrCannotSign = errors.New("no key to sign tokens with")
+)
+
+// Claims are the claims of the JWTs the service issues. Subject holds the _id
+// of the user the token was issued to and Roles their roles when it was
+// issued. Times are seconds since the epoch.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub"`
+	ID        string   `json:"jti,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// JWT issues and verifies JSON Web Tokens signed with a single algorithm.
+// Tokens signed with any other algorithm are rejected.
+type JWT struct {
+	alg        string
+	secret     []byte
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+	issuer     string
+	ttl        time.Duration
+}
+
+// NewHS256 returns a JWT that signs and verifies tokens valid for ttl with
+// HMAC-SHA256 and secret.
+func NewHS256(secret []byte, issuer string, ttl time.Duration) *JWT {
+	return &JWT{
+		alg:    ALG_HS256,
+		secret: secret,
+		issuer: issuer,
+		ttl:    ttl,
+	}
+}
+
+// NewRS256 returns a JWT that signs tokens valid for ttl with privateKey and
+// verifies them with publicKey using RSASSA-PKCS1-v1_5 and SHA-256. If
+// privateKey is nil tokens are only verified, for example when they are
+// issued by another service. If publicKey is nil that of privateKey is used.
+func NewRS256(privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey, issuer string, ttl time.Duration) *JWT {
+	if publicKey == nil && privateKey != nil {
+		publicKey = &privateKey.PublicKey
+	}
+	return &JWT{
+		alg:        ALG_RS256,
+		privateKey: privateKey,
+		publicKey:  publicKey,
+		issuer:     issuer,
+		ttl:        ttl,
+	}
+}
+
+// TTL returns how long the tokens j signs are valid for.
+func (j *JWT) TTL() time.Duration {
+	return j.ttl
+}
+
+// Sign returns a token issued to subject holding roles and its claims.
+func (j *JWT) Sign(subject string, roles []string) (string, Claims, error) {
+	if j.alg == ALG_RS256 && j.privateKey == nil {
+		return "", Claims{}, ErrCannotSign
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", Claims{}, err
+	}
+	now := time.Now()
+	claims := Claims{
+		Issuer:    j.issuer,
+		Subject:   subject,
+		ID:        hex.EncodeToString(id),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(j.ttl).Unix(),
+		Roles:     roles,
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": j.alg, "typ": "JWT"})
+	if err != nil {
+		return "", Claims{}, err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+
+	signature, err := j.sign([]byte(signingInput))
+	if err != nil {
+		return "", Claims{}, err
+	}
+	return signingInput + "." + encodeSegment(signature), claims, nil
+}
+
+// Verify checks the signature, issuer and validity period of token and
+// returns its claims.
+func (j *JWT) Verify(token string) (Claims, error) {
+	var claims Claims
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return claims, fmt.Errorf("%w: want three segments", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(segments[0], &header); err != nil {
+		return claims, err
+	}
+	if header.Alg != j.alg {
+		return claims, fmt.Errorf("%w: algorithm %q is not accepted", ErrInvalidToken, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return claims, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !j.verify([]byte(segments[0]+"."+segments[1]), signature) {
+		return claims, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	if err = decodeSegment(segments[1], &claims); err != nil {
+		return claims, err
+	}
+	now := time.Now().Unix()
+	switch {
+	case claims.Subject == "":
+		return claims, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case j.issuer != "" && claims.Issuer != j.issuer:
+		return claims, fmt.Errorf("%w: issued by %q", ErrInvalidToken, claims.Issuer)
+	case claims.ExpiresAt == 0 || now >= claims.ExpiresAt:
+		return claims, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case now < claims.NotBefore:
+		return claims, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+func (j *JWT) sign(signingInput []byte) ([]byte, error) {
+	if j.alg == ALG_HS256 {
+		mac := hmac.New(sha256.New, j.secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	}
+	digest := sha256.Sum256(signingInput)
+	return rsa.SignPKCS1v15(rand.Reader, j.privateKey, crypto.SHA256, digest[:])
+}
+
+func (j *JWT) verify(signingInput, signature []byte) bool {
+	if j.alg == ALG_HS256 {
+		mac := hmac.New(sha256.New, j.secret)
+		mac.Write(signingInput)
+		return hmac.Equal(signature, mac.Sum(nil))
+	}
+	if j.publicKey == nil {
+		return false
+	}
+	digest := sha256.Sum256(signingInput)
+	return rsa.VerifyPKCS1v15(j.publicKey, crypto.SHA256, digest[:], signature) == nil
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err = json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// LoadRSAPrivateKey reads a PEM encoded PKCS #1 or PKCS #8 RSA private key
+// from path.
+func LoadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", path)
+	}
+	return rsaKey, nil
+}
+
+// LoadRSAPublicKey reads a PEM encoded PKIX or PKCS #1 RSA public key from
+// path.
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	return rsaKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
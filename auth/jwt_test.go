@@ -0,0 +1,66 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabriel-ross/trade/auth"
+)
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		signer   *auth.JWT
+		verifier *auth.JWT
+		valid    bool
+	}{
+		{"hs256", auth.NewHS256([]byte("secret"), "trade", time.Minute), auth.NewHS256([]byte("secret"), "trade", time.Minute), true},
+		{"hs256 other secret", auth.NewHS256([]byte("secret"), "trade", time.Minute), auth.NewHS256([]byte("other"), "trade", time.Minute), false},
+		{"expired", auth.NewHS256([]byte("secret"), "trade", -time.Minute), auth.NewHS256([]byte("secret"), "trade", time.Minute), false},
+		{"other issuer", auth.NewHS256([]byte("secret"), "elsewhere", time.Minute), auth.NewHS256([]byte("secret"), "trade", time.Minute), false},
+		{"rs256", auth.NewRS256(rsaKey, nil, "trade", time.Minute), auth.NewRS256(nil, &rsaKey.PublicKey, "trade", time.Minute), true},
+		{"rs256 other key", auth.NewRS256(otherKey, nil, "trade", time.Minute), auth.NewRS256(nil, &rsaKey.PublicKey, "trade", time.Minute), false},
+		// An HS256 token must not verify against an RS256 key
+		{"algorithm mismatch", auth.NewHS256([]byte("secret"), "trade", time.Minute), auth.NewRS256(rsaKey, nil, "trade", time.Minute), false},
+	}
+	for _, tt := range tests {
+		token, _, err := tt.signer.Sign("users/1", nil)
+		if err != nil {
+			t.Fatalf("%s: Sign() = %v", tt.name, err)
+		}
+		claims, err := tt.verifier.Verify(token)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: Verify() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+		if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+			t.Errorf("%s: Verify() = %v, want ErrInvalidToken", tt.name, err)
+		}
+		if tt.valid && claims.Subject != "users/1" {
+			t.Errorf("%s: subject = %q", tt.name, claims.Subject)
+		}
+	}
+
+	if _, _, err = auth.NewRS256(nil, &rsaKey.PublicKey, "trade", time.Minute).Sign("users/1", nil); !errors.Is(err, auth.ErrCannotSign) {
+		t.Errorf("Sign() without a private key = %v, want ErrCannotSign", err)
+	}
+
+	// Tokens that are not three segments are refused
+	hs := auth.NewHS256([]byte("secret"), "trade", time.Minute)
+	token, _, _ := hs.Sign("users/1", nil)
+	if _, err = hs.Verify(strings.Join(strings.Split(token, ".")[:2], ".")); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("Verify() of a truncated token = %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Roles a user can be granted. A user with no role may only act on their own
+// resources.
+var (
+	ROLE_ADMIN   = "admin"
+	ROLE_AUDITOR = "auditor"
+)
+
+// Permission allows acting on the resources of every user rather than only on
+// one's own.
+type Permission string
+
+var (
+	// PERMISSION_READ_ANY allows reading every user, account, transaction and
+	// report.
+	PERMISSION_READ_ANY = Permission("read:any")
+	// PERMISSION_WRITE_ANY allows creating, changing and deleting the
+	// resources of every user, funding accounts and amending transactions.
+	PERMISSION_WRITE_ANY = Permission("write:any")
+	// PERMISSION_MANAGE_ROLES allows granting and revoking roles.
+	PERMISSION_MANAGE_ROLES = Permission("manage:roles")
+)
+
+// ROLE_PERMISSIONS maps each role to the permissions it grants.
+var ROLE_PERMISSIONS = map[string][]Permission{
+	ROLE_ADMIN:   {PERMISSION_READ_ANY, PERMISSION_WRITE_ANY, PERMISSION_MANAGE_ROLES},
+	ROLE_AUDITOR: {PERMISSION_READ_ANY},
+}
+
+// ErrForbidden is returned when the caller of a request is not allowed to
+// act on a resource.
+var ErrForbidden = errors.New("forbidden")
+
+// ValidateRoles returns an error if any of roles is unknown.
+func ValidateRoles(roles []string) error {
+	for _, role := range roles {
+		if _, ok := ROLE_PERMISSIONS[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// Can reports whether p holds perm through one of its roles.
+func (p Principal) Can(perm Permission) bool {
+	for _, role := range p.Roles {
+		for _, granted := range ROLE_PERMISSIONS[role] {
+			if granted == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Can reports whether the caller of ctx holds perm. Requests without a
+// principal, which are only served when authentication is disabled, hold
+// every permission.
+func Can(ctx context.Context, perm Permission) bool {
+	p, ok := FromContext(ctx)
+	return !ok || p.Can(perm)
+}
+
+// Authorize returns nil if the caller of ctx is the user with _id owner or
+// holds perm, and ErrForbidden otherwise. An empty owner requires perm.
+func Authorize(ctx context.Context, perm Permission, owner string) error {
+	if Can(ctx, perm) {
+		return nil
+	}
+	if p, _ := FromContext(ctx); owner != "" && p.UserID == owner {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is required to act on resources of other users", ErrForbidden, perm)
+}
+
+// Restrict returns the _id of the user whose resources the caller of ctx is
+// restricted to, and false if the caller holds perm and is not restricted.
+func Restrict(ctx context.Context, perm Permission) (string, bool) {
+	if Can(ctx, perm) {
+		return "", false
+	}
+	p, _ := FromContext(ctx)
+	return p.UserID, true
+}
+
+// Require is chi middleware that refuses requests whose caller does not hold
+// perm with 403 Forbidden.
+func (s *service) Require(perm Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := Authorize(r.Context(), perm, ""); err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
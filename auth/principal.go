@@ -0,0 +1,33 @@
+package auth
+
+import "context"
+
+// Methods a principal can authenticate with.
+var (
+	METHOD_API_KEY = "apikey"
+	METHOD_JWT     = "jwt"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// UserID is the _id of the user the request is made on behalf of.
+	UserID string
+	// KeyID is the _id of the API key the request authenticated with, if any.
+	KeyID  string
+	Method string
+	// Roles are the roles of the user when the request authenticated.
+	Roles []string
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
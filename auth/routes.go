@@ -0,0 +1,19 @@
+package auth
+
+import "github.com/go-chi/chi"
+
+// Routes returns a new chi router with all auth routes mounted to it. Every
+// route requires authentication.
+func (s *service) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(s.Authenticate)
+
+	r.Post("/token", s.handleToken())
+	r.Route("/keys", func(r chi.Router) {
+		r.Post("/", s.handleCreateKey())
+		r.Get("/", s.handleListKeys())
+		r.Delete("/{id}", s.handleRevokeKey())
+	})
+
+	return r
+}
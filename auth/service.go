@@ -0,0 +1,69 @@
+// Package auth authenticates requests with API keys issued to users and with
+// JWT bearer tokens exchanged for them.
+//
+// API keys are sent in the X-API-Key header or as a bearer token, and JWTs as
+// a bearer token. The Authenticate middleware puts the Principal of an
+// authenticated request in its context.
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gabriel-ross/trade"
+	"github.com/go-chi/chi"
+)
+
+// KeyRepository is the API for the APIKey datastore.
+type KeyRepository interface {
+	Create(ctx context.Context, k trade.APIKey) (string, trade.APIKey, error)
+	Query(ctx context.Context, q trade.Query) ([]trade.APIKey, error)
+	Get(ctx context.Context, id string) (trade.APIKey, error)
+	Update(ctx context.Context, id string, k trade.APIKey) (trade.APIKey, error)
+}
+
+// UserRepository is the API for the datastore of the users keys are issued
+// to.
+type UserRepository interface {
+	Get(ctx context.Context, id string) (trade.User, error)
+}
+
+type Renderer interface {
+	RenderJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, body interface{})
+	RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any)
+}
+
+// Service houses the API and necessary dependencies for authenticating
+// requests and issuing credentials.
+type service struct {
+	router   chi.Router
+	keys     KeyRepository
+	users    UserRepository
+	tokens   *JWT
+	renderer Renderer
+}
+
+// New mounts the auth routes on r at endpoint and returns a new auth service.
+func New(r chi.Router, endpoint string, keys KeyRepository, users UserRepository, renderer Renderer, options ...func(*service)) *service {
+	svc := &service{
+		router:   r,
+		keys:     keys,
+		users:    users,
+		renderer: renderer,
+	}
+
+	for _, option := range options {
+		option(svc)
+	}
+	r.Mount(endpoint, svc.Routes())
+
+	return svc
+}
+
+// WithJWT is a functional option for configuring the JWTs an auth service
+// issues and accepts. Without it only API keys are accepted.
+func WithJWT(tokens *JWT) func(*service) {
+	return func(s *service) {
+		s.tokens = tokens
+	}
+}
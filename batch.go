@@ -0,0 +1,287 @@
+package trade
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Operations of a batch.
+var (
+	BATCH_CREATE = "create"
+	BATCH_UPDATE = "update"
+	BATCH_DELETE = "delete"
+)
+
+// BATCH_MAX_OPERATIONS is the most operations a batch request may hold.
+var BATCH_MAX_OPERATIONS = 1000
+
+var (
+	// ErrBatchAborted is the error of the operations of an atomic batch that
+	// were not applied because another operation of the batch failed.
+	ErrBatchAborted = errors.New("batch aborted")
+	// ErrBatchTooLarge is returned by DecodeBatch for a batch of more than
+	// BATCH_MAX_OPERATIONS operations.
+	ErrBatchTooLarge = errors.New("batch too large")
+)
+
+// BatchOperation is a write of a batch: the creation of a document from Data,
+// or the update with Data or deletion of the document identified by ID.
+type BatchOperation[T any] struct {
+	Op   string
+	ID   string
+	Data T
+}
+
+// BatchResult is the outcome of a BatchOperation. Err is set if the operation
+// failed, otherwise ID is the _id of the document written and Data the new
+// document, unless it was deleted.
+type BatchResult[T any] struct {
+	ID   string
+	Data T
+	Err  error
+}
+
+// BatchItem is an operation of a batch request. Op defaults to create, and
+// Data holds the same body as a request creating or updating a single
+// document.
+type BatchItem struct {
+	Op   string          `json:"op"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// DecodeBatch decodes the JSON array of operations in the body of r. Each
+// operation is decoded by Decode, so that the failures of one operation do not
+// fail the others.
+func DecodeBatch(r *http.Request) ([]BatchItem, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	malformed := func(msg string) error {
+		return &ValidationError{Errors: []FieldError{{Code: CODE_MALFORMED, Message: msg}}}
+	}
+
+	var raw []json.RawMessage
+	if err = json.Unmarshal(body, &raw); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) || len(bytes.TrimSpace(body)) == 0 {
+			return nil, malformed("request body must be a JSON array of operations")
+		}
+		return nil, malformed("request body is not valid JSON: " + err.Error())
+	}
+	if len(raw) > BATCH_MAX_OPERATIONS {
+		return nil, fmt.Errorf("%w: %d operations, at most %d are allowed", ErrBatchTooLarge, len(raw), BATCH_MAX_OPERATIONS)
+	}
+
+	items := make([]BatchItem, len(raw))
+	for i, item := range raw {
+		// Operations that are not objects are reported by Decode
+		if err = json.Unmarshal(item, &items[i]); err != nil {
+			items[i] = BatchItem{Op: BATCH_CREATE, Data: item}
+		}
+		if items[i].Op == "" {
+			items[i].Op = BATCH_CREATE
+		}
+	}
+	return items, nil
+}
+
+// Decode validates the item and decodes its data into the struct v, as
+// DecodeJSON does for the body of a single request. The data of deletions is
+// ignored. Failures are reported in a single ValidationError, with the fields
+// of the data prefixed with "data.".
+func (item BatchItem) Decode(v interface{}) error {
+	var errs []FieldError
+	switch item.Op {
+	case BATCH_CREATE:
+	case BATCH_UPDATE, BATCH_DELETE:
+		if item.ID == "" {
+			errs = append(errs, FieldError{Field: "id", Code: CODE_REQUIRED, Message: "is required to " + item.Op + " a document"})
+		}
+	default:
+		errs = append(errs, FieldError{Field: "op", Code: CODE_UNKNOWN_OPERATION, Message: fmt.Sprintf("must be one of %s, %s or %s", BATCH_CREATE, BATCH_UPDATE, BATCH_DELETE)})
+	}
+
+	if item.Op == BATCH_CREATE || item.Op == BATCH_UPDATE {
+		var validationErr *ValidationError
+		if err := DecodeJSON(item.Data, v); errors.As(err, &validationErr) {
+			for _, fe := range validationErr.Errors {
+				fe.Field = dataField(fe.Field)
+				errs = append(errs, fe)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func dataField(field string) string {
+	if field == "" {
+		return "data"
+	}
+	return "data." + field
+}
+
+// ApplyBatch applies ops with write, except for those that already failed,
+// whose errors are given by failed. An atomic batch is only written if none
+// of its operations failed, otherwise the others are aborted.
+func ApplyBatch[T any](ctx context.Context, ops []BatchOperation[T], failed []error, atomic bool, write func(ctx context.Context, ops []BatchOperation[T], atomic bool) ([]BatchResult[T], error)) ([]BatchResult[T], error) {
+	results := make([]BatchResult[T], len(ops))
+	pending := []BatchOperation[T]{}
+	indexes := []int{}
+	for i, err := range failed {
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		pending = append(pending, ops[i])
+		indexes = append(indexes, i)
+	}
+	if atomic && len(pending) < len(ops) {
+		return AbortBatch(results), nil
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	written, err := write(ctx, pending, atomic)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range indexes {
+		results[i] = written[j]
+	}
+	return results, nil
+}
+
+// AbortBatch marks each operation of results that did not fail as aborted,
+// after an atomic batch failed.
+func AbortBatch[T any](results []BatchResult[T]) []BatchResult[T] {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BatchResult[T]{Err: ErrBatchAborted}
+		}
+	}
+	return results
+}
+
+// BatchRenderer is the API HandleBatch renders responses with.
+type BatchRenderer interface {
+	RenderJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, body interface{})
+	RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any)
+}
+
+// HandleBatch returns a handler for batch requests of operations on documents
+// of type T. decode turns each item of the request into an operation, or the
+// error failing it, and write applies the operations that did not fail. With
+// ?atomic=true either every operation is applied or none is.
+func HandleBatch[T any](renderer BatchRenderer, decode func(ctx context.Context, r *http.Request, item BatchItem) (BatchOperation[T], error), write func(ctx context.Context, ops []BatchOperation[T], atomic bool) ([]BatchResult[T], error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		atomic := r.URL.Query().Get("atomic") == "true"
+
+		items, err := DecodeBatch(r)
+		if errors.Is(err, ErrBatchTooLarge) {
+			renderer.RenderError(w, r, err, http.StatusRequestEntityTooLarge, "%s", err.Error())
+			return
+		} else if err != nil {
+			renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		ops := make([]BatchOperation[T], len(items))
+		failed := make([]error, len(items))
+		for i, item := range items {
+			ops[i], failed[i] = decode(ctx, r, item)
+		}
+
+		results, err := ApplyBatch(ctx, ops, failed, atomic, write)
+		if err != nil {
+			renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		status, resp := NewBatchResponse(r, ops, results, atomic)
+		renderer.RenderJSON(w, r, status, resp)
+	}
+}
+
+// batchFailed reports whether any operation of results failed.
+func batchFailed[T any](results []BatchResult[T]) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// batchResponse is the response to a batch, with the result of each operation
+// in the order of the request.
+type batchResponse struct {
+	Data []batchItemResponse `json:"data"`
+}
+
+// batchItemResponse is the result of an operation of a batch. Failed
+// operations hold a problem like those of error responses.
+type batchItemResponse struct {
+	Status int         `json:"status"`
+	ID     string      `json:"id,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  *problem    `json:"error,omitempty"`
+}
+
+// NewBatchResponse returns the status and body of the response to a batch
+// from the results of its operations. A batch of which every operation
+// succeeded is answered with 200 OK and one that partly succeeded with 207
+// Multi-Status. A failed atomic batch is answered with the status of the
+// operation that failed it.
+func NewBatchResponse[T any](r *http.Request, ops []BatchOperation[T], results []BatchResult[T], atomic bool) (int, interface{}) {
+	status := http.StatusOK
+	resp := batchResponse{Data: make([]batchItemResponse, len(results))}
+	for i, result := range results {
+		item := &resp.Data[i]
+		if result.Err != nil {
+			kind, ok := ErrorKindOf(result.Err)
+			if !ok {
+				kind = ERROR_INTERNAL
+			}
+			p := newProblem(r, kind, result.Err, result.Err.Error())
+			if kind == ERROR_INTERNAL {
+				hideInternalError(r, &p)
+			}
+			item.Status, item.Error = kind.Status, &p
+
+			switch {
+			case !atomic:
+				status = http.StatusMultiStatus
+			case status == http.StatusOK && kind != ERROR_BATCH_ABORTED:
+				status = kind.Status
+			}
+			continue
+		}
+
+		item.ID = result.ID
+		switch ops[i].Op {
+		case BATCH_CREATE:
+			item.Status, item.Data = http.StatusCreated, result.Data
+		case BATCH_DELETE:
+			item.Status = http.StatusNoContent
+		default:
+			item.Status, item.Data = http.StatusOK, result.Data
+		}
+	}
+	return status, resp
+}
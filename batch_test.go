@@ -0,0 +1,119 @@
+package trade_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gabriel-ross/trade"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/transactions:batch", strings.NewReader(`[
+		{"data": {"sender": "1", "recipient": "2", "quantities": {"apples": 2}}},
+		{"op": "update", "data": {"sender": "1", "recipient": 2, "quantities": {"apples": 2}}},
+		{"op": "delete", "id": "3", "data": "ignored"},
+		{"op": "move", "id": "3"},
+		42
+	]`))
+	items, err := trade.DecodeBatch(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]trade.FieldError{
+		nil,
+		{{Field: "id", Code: trade.CODE_REQUIRED}, {Field: "data.recipient", Code: trade.CODE_INVALID_TYPE}},
+		nil,
+		{{Field: "op", Code: trade.CODE_UNKNOWN_OPERATION}},
+		{{Field: "data", Code: trade.CODE_MALFORMED}},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("DecodeBatch returned %d items, want %d", len(items), len(want))
+	}
+	for i, item := range items {
+		var v transfer
+		if got := codes(item.Decode(&v)); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("item %d: Decode returned %v, want %v", i, got, want[i])
+		}
+	}
+
+	for body, want := range map[string][]trade.FieldError{
+		`{"op": "create"}`: {{Code: trade.CODE_MALFORMED}},
+		`[`:                {{Code: trade.CODE_MALFORMED}},
+		``:                 {{Code: trade.CODE_MALFORMED}},
+	} {
+		_, err := trade.DecodeBatch(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		if got := codes(err); !reflect.DeepEqual(got, want) {
+			t.Errorf("DecodeBatch(%q) returned %v, want %v", body, got, want)
+		}
+	}
+
+	tooLarge := "[" + strings.Repeat("{},", trade.BATCH_MAX_OPERATIONS) + "{}]"
+	if _, err = trade.DecodeBatch(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tooLarge))); !errors.Is(err, trade.ErrBatchTooLarge) {
+		t.Errorf("DecodeBatch of %d operations returned %v, want %v", trade.BATCH_MAX_OPERATIONS+1, err, trade.ErrBatchTooLarge)
+	}
+}
+
+func TestNewBatchResponse(t *testing.T) {
+	ops := []trade.BatchOperation[trade.User]{
+		{Op: trade.BATCH_CREATE},
+		{Op: trade.BATCH_UPDATE, ID: "users/1"},
+		{Op: trade.BATCH_DELETE, ID: "users/2"},
+	}
+	ok := trade.BatchResult[trade.User]{ID: "users/1", Data: trade.User{ID: "users/1", Name: "Ada"}}
+	tests := []struct {
+		name         string
+		results      []trade.BatchResult[trade.User]
+		atomic       bool
+		wantStatus   int
+		wantStatuses []int
+		wantCodes    []string
+	}{
+		{"succeeded", []trade.BatchResult[trade.User]{ok, ok, {ID: "users/2"}}, false,
+			http.StatusOK, []int{201, 200, 204}, []string{"", "", ""}},
+		{"partly failed", []trade.BatchResult[trade.User]{ok, {Err: trade.ErrInvalidTransaction}, {Err: errors.New("disk full")}}, false,
+			http.StatusMultiStatus, []int{201, 422, 500}, []string{"", "invalid_transaction", "internal"}},
+		{"atomic", []trade.BatchResult[trade.User]{{Err: trade.ErrBatchAborted}, {Err: trade.WithErrorKind(errors.New("not yours"), trade.ERROR_FORBIDDEN)}, {Err: trade.ErrBatchAborted}}, true,
+			http.StatusForbidden, []int{424, 403, 424}, []string{"batch_aborted", "forbidden", "batch_aborted"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/users:batch", nil)
+		status, body := trade.NewBatchResponse(r, ops, tt.results, tt.atomic)
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			Data []struct {
+				Status int             `json:"status"`
+				Data   json.RawMessage `json:"data"`
+				Error  struct {
+					Code   string `json:"code"`
+					Detail string `json:"detail"`
+				} `json:"error"`
+			} `json:"data"`
+		}
+		if err = json.Unmarshal(raw, &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		statuses := make([]int, len(resp.Data))
+		errCodes := make([]string, len(resp.Data))
+		for i, item := range resp.Data {
+			statuses[i], errCodes[i] = item.Status, item.Error.Code
+			if strings.Contains(item.Error.Detail, "disk full") {
+				t.Errorf("%s: the internal error of item %d is exposed: %s", tt.name, i, item.Error.Detail)
+			}
+		}
+		if status != tt.wantStatus || !reflect.DeepEqual(statuses, tt.wantStatuses) || !reflect.DeepEqual(errCodes, tt.wantCodes) {
+			t.Errorf("%s: responded %d %v %v, want %d %v %v", tt.name, status, statuses, errCodes, tt.wantStatus, tt.wantStatuses, tt.wantCodes)
+		}
+		if tt.name == "succeeded" && (resp.Data[2].Data != nil || !strings.Contains(string(resp.Data[0].Data), "Ada")) {
+			t.Errorf("%s: responded %s", tt.name, raw)
+		}
+	}
+}
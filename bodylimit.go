@@ -0,0 +1,19 @@
+package trade
+
+import (
+	"net/http"
+)
+
+// MAX_REQUEST_BODY_SIZE is the size in bytes of the largest request body
+// LimitRequestBody lets handlers read.
+var MAX_REQUEST_BODY_SIZE int64 = 10 << 20
+
+// LimitRequestBody is chi middleware that caps the body of each request at
+// MAX_REQUEST_BODY_SIZE bytes. Reading past the cap fails with an
+// *http.MaxBytesError, which RenderError reports as payload_too_large.
+func LimitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_REQUEST_BODY_SIZE)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,422 @@
+package trade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrCollectionNotFound is returned by requests to a collection that has no
+// bucket, such as of a database created from another schema.
+var ErrCollectionNotFound = errors.New("collection does not exist")
+
+// BoltDatabase is an embedded, file backed store of JSON documents with one
+// bbolt bucket per collection. It lets small deployments and CI run without an
+// ArangoDB server.
+type BoltDatabase struct {
+	db *bolt.DB
+}
+
+// OpenBoltDatabase opens the database file at path. If createOnNotExist is set
+// a missing file is created along with a bucket for every collection in the
+// schema at schemaPath. Indexes and validation rules are not enforced.
+func OpenBoltDatabase(path string, createOnNotExist bool, schemaPath string) (*BoltDatabase, error) {
+	if _, err := os.Stat(path); err != nil && !(os.IsNotExist(err) && createOnNotExist) {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if buckets exist and create them if they don't
+	if createOnNotExist {
+		schema, err := LoadSchema(schemaPath)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			documentCollections, edgeCollections := schema.Collections()
+			for _, name := range append(documentCollections, edgeCollections...) {
+				if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &BoltDatabase{db: db}, nil
+}
+
+// Close releases the database file.
+func (db *BoltDatabase) Close() error {
+	return db.db.Close()
+}
+
+// Documents returns every document in collectionName in the order they were
+// created.
+func (db *BoltDatabase) Documents(collectionName string) ([]map[string]interface{}, error) {
+	docs := []map[string]interface{}{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collectionName))
+		if b == nil {
+			return newCollectionNotFoundError(collectionName)
+		}
+
+		byKey := map[string]map[string]interface{}{}
+		keys := []string{}
+		err := b.ForEach(func(k, v []byte) error {
+			doc := map[string]interface{}{}
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return err
+			}
+			byKey[string(k)] = doc
+			keys = append(keys, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		sortKeys(keys)
+		for _, key := range keys {
+			docs = append(docs, byKey[key])
+		}
+		return nil
+	})
+	return docs, err
+}
+
+// Document returns the document with _id id. If no document is found returns
+// NotFoundError.
+func (db *BoltDatabase) Document(id string) (map[string]interface{}, error) {
+	i := strings.Index(id, "/")
+	if i < 0 {
+		return nil, newNotFoundError()
+	}
+
+	doc := map[string]interface{}{}
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(id[:i]))
+		if b == nil {
+			return newCollectionNotFoundError(id[:i])
+		}
+		v := b.Get([]byte(id[i+1:]))
+		if v == nil {
+			return newNotFoundError()
+		}
+		return json.Unmarshal(v, &doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// BoltRepository is a Repository of documents of type T stored in a
+// BoltDatabase bucket.
+type BoltRepository[T any] struct {
+	database       *BoltDatabase
+	collectionName string
+}
+
+func NewBoltRepository[T any](db *BoltDatabase, collectionName string) *BoltRepository[T] {
+	return &BoltRepository[T]{
+		database:       db,
+		collectionName: collectionName,
+	}
+}
+
+// Create creates a new document from data and returns its _id.
+func (r *BoltRepository[T]) Create(ctx context.Context, data T) (string, T, error) {
+	var t T
+	doc, err := toDocument(data)
+	if err != nil {
+		return "", t, err
+	}
+
+	err = r.database.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(r.collectionName))
+		if b == nil {
+			return newCollectionNotFoundError(r.collectionName)
+		}
+		return r.create(b, doc)
+	})
+	if err != nil {
+		return "", t, err
+	}
+
+	return doc["_id"].(string), data, nil
+}
+
+// create stores doc as a new document of bucket b.
+func (r *BoltRepository[T]) create(b *bolt.Bucket, doc map[string]interface{}) error {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := strconv.FormatUint(seq, 10)
+	doc["_key"] = key
+	doc["_id"] = DocumentID(r.collectionName, key)
+	doc["_rev"] = newRevision()
+
+	return putDocument(b, key, doc)
+}
+
+// Query runs q over the collection.
+func (r *BoltRepository[T]) Query(ctx context.Context, q Query) ([]T, error) {
+	docs, err := r.QueryRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return fromDocuments[T](docs)
+}
+
+// QueryEach runs q over the collection and calls fn with each result,
+// stopping at the first error fn returns.
+func (r *BoltRepository[T]) QueryEach(ctx context.Context, q Query, fn func(data T) error) error {
+	docs, err := r.QueryRaw(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		data, err := fromDocument[T](doc)
+		if err != nil {
+			return err
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryRaw runs q and returns the resulting documents without binding them to
+// T.
+func (r *BoltRepository[T]) QueryRaw(ctx context.Context, q Query) ([]map[string]interface{}, error) {
+	return q.Evaluate(r.database, r.collectionName)
+}
+
+// Get returns the document identified by id, which may be either a _key or an
+// _id. If no document is found returns NotFoundError.
+func (r *BoltRepository[T]) Get(ctx context.Context, id string) (T, error) {
+	var t T
+	doc, err := r.database.Document(DocumentID(r.collectionName, DocumentKey(id)))
+	if err != nil {
+		return t, err
+	}
+	return fromDocument[T](doc)
+}
+
+// Update merges data into the document identified by id and returns the new
+// document. Like an ArangoDB update, nested objects are merged rather than
+// replaced. If no document with id is found returns NotFoundError, and if ctx
+// is conditional on another revision a precondition failed error.
+func (r *BoltRepository[T]) Update(ctx context.Context, id string, data T) (T, error) {
+	var t T
+	patch, err := toDocument(data)
+	if err != nil {
+		return t, err
+	}
+
+	var doc map[string]interface{}
+	err = r.database.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(r.collectionName))
+		if b == nil {
+			return newCollectionNotFoundError(r.collectionName)
+		}
+		doc, err = r.update(ctx, b, id, patch)
+		return err
+	})
+	if err != nil {
+		return t, err
+	}
+
+	return fromDocument[T](doc)
+}
+
+// update merges patch into the document of bucket b identified by id and
+// returns the new document.
+func (r *BoltRepository[T]) update(ctx context.Context, b *bolt.Bucket, id string, patch map[string]interface{}) (map[string]interface{}, error) {
+	delete(patch, "_id")
+	delete(patch, "_key")
+	delete(patch, "_rev")
+
+	key := DocumentKey(id)
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil, newNotFoundError()
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(v, &doc); err != nil {
+		return nil, err
+	}
+	if err := checkRevision(ctx, doc); err != nil {
+		return nil, err
+	}
+	mergeDocuments(doc, patch)
+	doc["_rev"] = newRevision()
+
+	return doc, putDocument(b, key, doc)
+}
+
+// Replace replaces the document identified by id with data and returns the
+// new document. If no document with id is found returns NotFoundError, and if
+// ctx is conditional on another revision a precondition failed error.
+func (r *BoltRepository[T]) Replace(ctx context.Context, id string, data T) (T, error) {
+	var t T
+	doc, err := toDocument(data)
+	if err != nil {
+		return t, err
+	}
+
+	err = r.database.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(r.collectionName))
+		if b == nil {
+			return newCollectionNotFoundError(r.collectionName)
+		}
+
+		key := DocumentKey(id)
+		v := b.Get([]byte(key))
+		if v == nil {
+			return newNotFoundError()
+		}
+		old := map[string]interface{}{}
+		if err := json.Unmarshal(v, &old); err != nil {
+			return err
+		}
+		if err := checkRevision(ctx, old); err != nil {
+			return err
+		}
+		doc["_key"] = key
+		doc["_id"] = old["_id"]
+		doc["_rev"] = newRevision()
+
+		return putDocument(b, key, doc)
+	})
+	if err != nil {
+		return t, err
+	}
+
+	return fromDocument[T](doc)
+}
+
+// Delete deletes the document with given id from the collection. If no match
+// is found returns NotFoundError, and if ctx is conditional on another revision
+// a precondition failed error.
+func (r *BoltRepository[T]) Delete(ctx context.Context, id string) error {
+	return r.database.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(r.collectionName))
+		if b == nil {
+			return newCollectionNotFoundError(r.collectionName)
+		}
+		return r.delete(ctx, b, id)
+	})
+}
+
+// delete deletes the document of bucket b identified by id.
+func (r *BoltRepository[T]) delete(ctx context.Context, b *bolt.Bucket, id string) error {
+	key := []byte(DocumentKey(id))
+	v := b.Get(key)
+	if v == nil {
+		return newNotFoundError()
+	}
+	if _, ok := revisionFromContext(ctx); ok {
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal(v, &doc); err != nil {
+			return err
+		}
+		if err := checkRevision(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return b.Delete(key)
+}
+
+// Batch applies ops in order in a single bbolt transaction, which an atomic
+// batch rolls back if any operation fails.
+func (r *BoltRepository[T]) Batch(ctx context.Context, ops []BatchOperation[T], atomic bool) ([]BatchResult[T], error) {
+	results := make([]BatchResult[T], len(ops))
+	err := r.database.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(r.collectionName))
+		if b == nil {
+			return newCollectionNotFoundError(r.collectionName)
+		}
+
+		for i, op := range ops {
+			results[i] = r.apply(ctx, b, op)
+			if atomic && results[i].Err != nil {
+				return ErrBatchAborted
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrBatchAborted) {
+		return AbortBatch(results), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// apply applies op to bucket b.
+func (r *BoltRepository[T]) apply(ctx context.Context, b *bolt.Bucket, op BatchOperation[T]) BatchResult[T] {
+	var result BatchResult[T]
+	doc, err := toDocument(op.Data)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	switch op.Op {
+	case BATCH_CREATE:
+		err = r.create(b, doc)
+	case BATCH_UPDATE:
+		doc, err = r.update(ctx, b, op.ID, doc)
+	case BATCH_DELETE:
+		result.ID = DocumentID(r.collectionName, DocumentKey(op.ID))
+		result.Err = r.delete(ctx, b, op.ID)
+		return result
+	default:
+		err = fmt.Errorf("unknown batch operation %q", op.Op)
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.ID = doc["_id"].(string)
+	result.Data, result.Err = fromDocument[T](doc)
+	return result
+}
+
+func putDocument(b *bolt.Bucket, key string, doc map[string]interface{}) error {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), raw)
+}
+
+// newCollectionNotFoundError returns the error of a request to a collection
+// without a bucket, which is a fault of the database rather than a missing
+// document, so that it is reported as an internal error.
+func newCollectionNotFoundError(collectionName string) error {
+	return fmt.Errorf("%w: %s", ErrCollectionNotFound, collectionName)
+}
@@ -0,0 +1,56 @@
+package trade_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	arangodriver "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/repotest"
+)
+
+func TestBoltRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository[repotest.Document] {
+		dir := t.TempDir()
+		schemaPath := filepath.Join(dir, "schema.json")
+		err := os.WriteFile(schemaPath, []byte(`{"versions": [{"version": 1, "documentCollections": [{"collectionName": "documents"}]}]}`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db, err := trade.OpenBoltDatabase(filepath.Join(dir, "trade.db"), true, schemaPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		return trade.NewBoltRepository[repotest.Document](db, "documents")
+	})
+}
+
+func TestBoltMissingCollection(t *testing.T) {
+	dir := t.TempDir()
+	schemaPath := filepath.Join(dir, "schema.json")
+	err := os.WriteFile(schemaPath, []byte(`{"versions": [{"version": 1, "documentCollections": [{"collectionName": "documents"}]}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := trade.OpenBoltDatabase(filepath.Join(dir, "trade.db"), true, schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A collection without a bucket is a fault of the database, not a missing
+	// document
+	_, err = trade.NewBoltRepository[repotest.Document](db, "missing").Get(context.Background(), "1")
+	if !errors.Is(err, trade.ErrCollectionNotFound) || arangodriver.IsNotFoundGeneral(err) {
+		t.Errorf("Get from a missing collection returned %v", err)
+	}
+	if kind, ok := trade.ErrorKindOf(err); ok {
+		t.Errorf("error of a missing collection has kind %s, want none", kind.Code)
+	}
+}
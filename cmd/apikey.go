@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gabriel-ross/trade/app"
+)
+
+// runAPIKey runs the apikey subcommand, which issues an API key to a user of
+// the configured database and prints it. It is how the first key of a
+// deployment is issued; later keys can be issued over HTTP.
+//
+//	trade apikey -user users/123 [-name ci] [-config config.yaml] ...
+func runAPIKey(args []string) error {
+	flags := flag.NewFlagSet("apikey", flag.ExitOnError)
+	owner := flags.String("user", "", "_id of the user to issue the key to")
+	name := flags.String("name", "", "name of the key")
+	loadConfig := registerConfig(flags)
+	flags.Parse(args)
+
+	cnf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *owner == "" {
+		return fmt.Errorf("-user is required")
+	}
+	if cnf.DB_BACKEND == app.BACKEND_MEMORY {
+		return fmt.Errorf("the %s backend does not keep data after the command exits", app.BACKEND_MEMORY)
+	}
+
+	a := app.New(cnf, app.WithCreateOnNotExist(true))
+	key, k, err := a.IssueAPIKey(context.Background(), *owner, *name)
+	if err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(map[string]string{"_id": k.ID, "owner": k.Owner, "key": key})
+}
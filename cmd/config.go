@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/gabriel-ross/trade/app"
+	"github.com/gabriel-ross/trade/config"
+)
+
+// registerConfig registers -config and a flag for every field of app.Config on
+// fs and returns a function that loads the configuration once fs is parsed.
+// The file named by -config, or by CONFIG_FILE if the flag is not set, is read
+// between the defaults and the environment.
+func registerConfig(fs *flag.FlagSet) func() (app.Config, error) {
+	cnf := app.Config{}
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file (env CONFIG_FILE)")
+	config.RegisterFlags(fs, &cnf)
+	return func() (app.Config, error) {
+		err := config.Load(&cnf, *file, fs)
+		return cnf, err
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-ross/trade/app"
+	"github.com/gabriel-ross/trade/ledger"
+)
+
+// runExport runs the export subcommand, which writes the transactions of the
+// configured database, oldest first, as CSV or JSON Lines:
+//
+//	trade export [-out transactions.csv] [-format csv|jsonl] [-account accounts/123] [-from 2023-01-01] [-to 2023-02-01] ...
+//
+// The format defaults to that named by the extension of -out, or else JSON
+// Lines.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "file to write, instead of stdout")
+	format := flags.String("format", "", "format to write, csv or jsonl")
+	account := flags.String("account", "", "_id of the account to export the transactions of, instead of every account")
+	from := flags.String("from", "", "date or RFC 3339 timestamp of the first transaction to export")
+	to := flags.String("to", "", "date or RFC 3339 timestamp to export transactions until, exclusive")
+	loadConfig := registerConfig(flags)
+	flags.Parse(args)
+
+	cnf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cnf.DB_BACKEND == app.BACKEND_MEMORY {
+		return fmt.Errorf("the %s backend does not keep data after the command exits", app.BACKEND_MEMORY)
+	}
+	if *format, err = fileFormat(*format, *out); err != nil {
+		return err
+	}
+	filter := ledger.Filter{Account: *account}
+	if *from != "" {
+		if filter.From, err = ledger.ParseTime(*from); err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+	}
+	if *to != "" {
+		if filter.To, err = ledger.ParseTime(*to); err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+	}
+
+	var dst io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst = f
+	}
+	buf := bufio.NewWriter(dst)
+	w, err := ledger.NewWriter(buf, *format)
+	if err != nil {
+		return err
+	}
+
+	a := app.New(cnf, app.WithCreateOnNotExist(true))
+	n, err := a.ExportTransactions(context.Background(), w, filter)
+	if err != nil {
+		return err
+	}
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d transactions\n", n)
+	return nil
+}
+
+// fileFormat returns format, or if it is empty the format named by the
+// extension of the file name, defaulting to JSON Lines.
+func fileFormat(format, name string) (string, error) {
+	if format != "" {
+		return ledger.ParseFormat(format)
+	}
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		return ledger.FORMAT_CSV, nil
+	}
+	return ledger.FORMAT_JSONL, nil
+}
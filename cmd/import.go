@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-ross/trade/app"
+	"github.com/gabriel-ross/trade/ledger"
+)
+
+// runImport runs the import subcommand, which imports historical transactions
+// from a CSV or JSON Lines file into the configured database:
+//
+//	trade import -file history.csv [-format csv|jsonl] [-mode settle|backfill] [-refs refs.csv] [-report history.errors.csv] ...
+//
+// Every transaction of the file is validated and imported on its own, and
+// those that fail are listed in the report file along with why. The command
+// fails if any transaction does.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "", "CSV or JSON Lines file of transactions to import")
+	format := flags.String("format", "", "format of -file, csv or jsonl; defaults to that named by its extension")
+	mode := flags.String("mode", ledger.MODE_SETTLE, "settle to post transactions and move balances, or backfill to record them as history only")
+	refsFile := flags.String("refs", "", "CSV file with a reference,account header mapping account references of -file to account _ids")
+	report := flags.String("report", "", "CSV file to list failed transactions in; defaults to -file with an .errors.csv extension")
+	loadConfig := registerConfig(flags)
+	flags.Parse(args)
+
+	cnf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if cnf.DB_BACKEND == app.BACKEND_MEMORY {
+		return fmt.Errorf("the %s backend does not keep data after the command exits", app.BACKEND_MEMORY)
+	}
+	if *format, err = fileFormat(*format, *file); err != nil {
+		return err
+	}
+	if *report == "" {
+		*report = strings.TrimSuffix(*file, filepath.Ext(*file)) + ".errors.csv"
+	}
+
+	refs := map[string]string{}
+	if *refsFile != "" {
+		f, err := os.Open(*refsFile)
+		if err != nil {
+			return err
+		}
+		refs, err = ledger.ReadRefs(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", *refsFile, err)
+		}
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := ledger.NewReader(f, *format)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *file, err)
+	}
+
+	a := app.New(cnf, app.WithCreateOnNotExist(true))
+	res, importErr := a.ImportTransactions(context.Background(), r, *mode, refs)
+
+	// Failures before an error stopping the import are reported all the same
+	out, err := os.Create(*report)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err = ledger.WriteReport(out, res.Failures); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(map[string]interface{}{"imported": res.Imported, "failed": res.Failed, "report": *report}); err != nil {
+		return err
+	}
+	if importErr != nil {
+		return fmt.Errorf("importing %s: %w", *file, importErr)
+	}
+	if res.Failed > 0 {
+		return fmt.Errorf("%d of %d transactions were not imported, see %s", res.Failed, res.Imported+res.Failed, *report)
+	}
+	return nil
+}
@@ -1,23 +1,53 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
 
 	"github.com/gabriel-ross/trade/app"
-)
-
-var (
-	// ARANGODB_ADDRESS = "http://" + os.Getenv("ARANGODB_ADDRESS") + ":" + os.Getenv("ARANGODB_PORT")
-	ARANGODB_ADDRESS = "http://localhost:8529"
-	PORT             = "80"
+	"github.com/gabriel-ross/trade/config"
 )
 
 func main() {
-	app := app.New(app.Config{
-		PORT:       PORT,
-		DB_ADDRESS: ARANGODB_ADDRESS,
-		DB_NAME:    "trade",
-	}, app.WithCreateOnNotExist(true))
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "seed":
+			run = runSeed
+		case "simulate":
+			run = runSimulate
+		case "apikey":
+			run = runAPIKey
+		case "roles":
+			run = runRoles
+		case "export":
+			run = runExport
+		case "import":
+			run = runImport
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	memory := flag.Bool("memory", false, "store data in memory instead of ArangoDB")
+	loadConfig := registerConfig(flag.CommandLine)
+	flag.Parse()
+
+	cnf, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Fprintln(os.Stderr, "configuration:")
+	config.Write(os.Stderr, cnf)
+
+	app := app.New(cnf, app.WithCreateOnNotExist(true), app.WithMemoryDatabase(*memory))
 
 	fmt.Printf("%v", app.Run())
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gabriel-ross/trade/app"
+)
+
+// runRoles runs the roles subcommand, which replaces the roles of a user of the
+// configured database. It is how the first admin of a deployment is granted;
+// admins can change roles over HTTP.
+//
+//	trade roles -user users/123 -set admin[,auditor] [-config config.yaml] ...
+func runRoles(args []string) error {
+	flags := flag.NewFlagSet("roles", flag.ExitOnError)
+	id := flags.String("user", "", "_id of the user whose roles to set")
+	set := flags.String("set", "", "comma separated roles to grant, replacing the current ones")
+	loadConfig := registerConfig(flags)
+	flags.Parse(args)
+
+	cnf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *id == "" {
+		return fmt.Errorf("-user is required")
+	}
+	if cnf.DB_BACKEND == app.BACKEND_MEMORY {
+		return fmt.Errorf("the %s backend does not keep data after the command exits", app.BACKEND_MEMORY)
+	}
+
+	roles := []string{}
+	for _, role := range strings.Split(*set, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	a := app.New(cnf, app.WithCreateOnNotExist(true))
+	u, err := a.SetRoles(context.Background(), *id, roles)
+	if err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(map[string]interface{}{"_id": *id, "roles": u.Roles})
+}
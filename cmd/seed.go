@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gabriel-ross/trade/app"
+	"github.com/gabriel-ross/trade/seed"
+)
+
+// runSeed runs the seed subcommand, which loads a seed file into the
+// configured database:
+//
+//	trade seed [-file db/seed.json] [-reset] [-config config.yaml] [-backend arango|bolt] ...
+//
+// The database is configured like the server's.
+func runSeed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	file := flags.String("file", "./db/seed.json", "seed file to load")
+	reset := flags.Bool("reset", false, "delete all users, accounts and transactions before loading; for local environments only")
+	loadConfig := registerConfig(flags)
+	flags.Parse(args)
+
+	cnf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cnf.DB_BACKEND == app.BACKEND_MEMORY {
+		return fmt.Errorf("the %s backend does not keep data after the command exits", app.BACKEND_MEMORY)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := seed.Decode(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *file, err)
+	}
+
+	a := app.New(cnf, app.WithCreateOnNotExist(true))
+
+	res, err := a.Seed(context.Background(), data, *reset)
+	if err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(res)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/gabriel-ross/trade/app"
+	"github.com/gabriel-ross/trade/simulate"
+)
+
+// runSimulate runs the simulate subcommand, which generates synthetic users,
+// accounts and transactions, drives them through the application and reports
+// throughput and latency:
+//
+//	trade simulate [-http http://localhost:80] [-backend memory|bolt|arango] [-transactions 10000] [-rate 0] ...
+//
+// Without -http the application is run in process on the database configured
+// like the server's.
+func runSimulate(args []string) error {
+	opts := simulate.DefaultOptions()
+	currencies := opts.Currencies.String()
+
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	baseURL := flags.String("http", "", "base URL of a running server to drive over HTTP instead of an in process application")
+	apiKey := flags.String("api-key", os.Getenv("TRADE_API_KEY"), "API key of an admin to authenticate to the server at -http with (env TRADE_API_KEY)")
+	loadConfig := registerConfig(flags)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.IntVar(&opts.Users, "users", opts.Users, "number of users to create")
+	flags.IntVar(&opts.Accounts, "accounts", opts.Accounts, "number of accounts to create")
+	flags.IntVar(&opts.Transactions, "transactions", opts.Transactions, "number of transactions to settle")
+	flags.Float64Var(&opts.Rate, "rate", opts.Rate, "transactions started per second, or 0 for as fast as possible")
+	flags.IntVar(&opts.Concurrency, "concurrency", opts.Concurrency, "number of transactions in flight at once")
+	flags.StringVar(&currencies, "currencies", currencies, "share of transactions in each currency as currency=share pairs")
+	flags.Float64Var(&opts.Skew, "skew", opts.Skew, "power law exponent counterparties are drawn with, greater than 1")
+	flags.Float64Var(&opts.OpeningBalance, "opening-balance", opts.OpeningBalance, "opening balance of every account in every currency")
+	flags.Float64Var(&opts.MeanQuantity, "mean-quantity", opts.MeanQuantity, "mean quantity of a transaction")
+	flags.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed, the same seed generates the same data")
+	flags.Parse(args)
+
+	cnf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if opts.Currencies, err = simulate.ParseCurrencyMix(currencies); err != nil {
+		return err
+	}
+	if err = opts.Validate(); err != nil {
+		return err
+	}
+
+	var target simulate.Target
+	if *baseURL != "" {
+		client := &http.Client{Transport: apiKeyTransport{key: *apiKey, base: http.DefaultTransport}}
+		target = simulate.NewHTTPTarget(*baseURL, client)
+	} else {
+		target = app.New(cnf, app.WithCreateOnNotExist(true)).SimulationTarget()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report, err := simulate.Run(ctx, target, opts)
+	if *asJSON {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		if encErr := out.Encode(report); encErr != nil {
+			return encErr
+		}
+	} else if writeErr := report.Write(os.Stdout); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("simulation stopped: %w", err)
+	}
+	return nil
+}
+
+// apiKeyTransport sets the X-API-Key header of every request it sends, if it
+// has a key.
+type apiKeyTransport struct {
+	key  string
+	base http.RoundTripper
+}
+
+func (t apiKeyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.key == "" {
+		return t.base.RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("X-API-Key", t.key)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,304 @@
+// Package config loads typed configuration structs from, in increasing order
+// of precedence, field defaults, a YAML file, environment variables and
+// command line flags.
+//
+// Fields are described by struct tags:
+//
+//	env       name of the environment variable the field is read from
+//	default   value of the field if no other source sets it
+//	required  "true" if the loaded value must not be the zero value
+//	yaml      key of the field in a YAML file
+//	flag      name of the command line flag, by default the env name in
+//	          lower case with underscores replaced by dashes
+//	usage     description of the flag
+//	secret    "true" if the value must be redacted when printed
+//
+// Exported fields of type string, bool, int, int64, float64, time.Duration
+// and []string, the last written as a comma separated list, are supported.
+// Unexported fields and fields without an env or yaml tag are left alone.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrRequired is returned by Load when a required field is not set by any
+// source.
+var ErrRequired = errors.New("required configuration is missing")
+
+// Loader loads configuration structs.
+type Loader struct {
+	// File is the path of the YAML file to read. No file is read if empty.
+	File string
+	// LookupEnv looks up environment variables. Defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+	// Flags are the parsed command line flags. Only flags registered with
+	// RegisterFlags and set on the command line are applied.
+	Flags *flag.FlagSet
+}
+
+// Load populates cnf, a pointer to a struct, from its defaults, l.File, the
+// environment and l.Flags, then checks that every required field is set.
+func (l Loader) Load(cnf interface{}) error {
+	v, err := structValue(cnf)
+	if err != nil {
+		return err
+	}
+	fields := fieldsOf(v)
+
+	for _, f := range fields {
+		if def, ok := f.tag.Lookup("default"); ok {
+			if err = f.set(def); err != nil {
+				return fmt.Errorf("default of %s: %w", f.name(), err)
+			}
+		}
+	}
+
+	if l.File != "" {
+		raw, err := os.ReadFile(l.File)
+		if err != nil {
+			return err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(raw))
+		dec.KnownFields(true)
+		if err = dec.Decode(cnf); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", l.File, err)
+		}
+	}
+
+	lookupEnv := l.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	for _, f := range fields {
+		env := f.tag.Get("env")
+		if env == "" {
+			continue
+		}
+		if s, ok := lookupEnv(env); ok {
+			if err = f.set(s); err != nil {
+				return fmt.Errorf("environment variable %s: %w", env, err)
+			}
+		}
+	}
+
+	if l.Flags != nil {
+		byFlag := map[string]field{}
+		for _, f := range fields {
+			byFlag[f.flagName()] = f
+		}
+		l.Flags.Visit(func(fl *flag.Flag) {
+			f, ok := byFlag[fl.Name]
+			if !ok || err != nil {
+				return
+			}
+			if setErr := f.set(fl.Value.String()); setErr != nil {
+				err = fmt.Errorf("flag -%s: %w", fl.Name, setErr)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	missing := []string{}
+	for _, f := range fields {
+		if f.tag.Get("required") == "true" && f.value.IsZero() {
+			missing = append(missing, f.name())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrRequired, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Load loads cnf from file, which may be empty, the environment and flags.
+func Load(cnf interface{}, file string, flags *flag.FlagSet) error {
+	return Loader{File: file, Flags: flags}.Load(cnf)
+}
+
+// RegisterFlags registers a flag on fs for every configurable field of cnf, a
+// pointer to a struct. The flags hold strings that Load parses into the
+// fields, so an unset flag never overrides another source.
+func RegisterFlags(fs *flag.FlagSet, cnf interface{}) {
+	v, err := structValue(cnf)
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range fieldsOf(v) {
+		usage := f.tag.Get("usage")
+		if env := f.tag.Get("env"); env != "" {
+			usage = strings.TrimSpace(usage + " (env " + env + ")")
+		}
+		fs.Var(&stringFlag{def: f.tag.Get("default"), isBool: f.value.Kind() == reflect.Bool}, f.flagName(), usage)
+	}
+}
+
+// Write writes the value of every configurable field of cnf, a struct or a
+// pointer to one, to w, one NAME=value line each, redacting secrets.
+func Write(w io.Writer, cnf interface{}) error {
+	v, err := structValue(cnf)
+	if err != nil {
+		return err
+	}
+	for _, f := range fieldsOf(v) {
+		value := f.String()
+		if f.tag.Get("secret") == "true" && value != "" {
+			value = "[redacted]"
+		}
+		if _, err = fmt.Fprintf(w, "%s=%s\n", f.name(), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func structValue(cnf interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(cnf)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return v, fmt.Errorf("config must be a struct or a pointer to one, got %T", cnf)
+	}
+	return v, nil
+}
+
+// field is a configurable field of a struct.
+type field struct {
+	sf    reflect.StructField
+	tag   reflect.StructTag
+	value reflect.Value
+}
+
+func fieldsOf(v reflect.Value) []field {
+	fields := []field{}
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		_, hasEnv := sf.Tag.Lookup("env")
+		_, hasYAML := sf.Tag.Lookup("yaml")
+		if !sf.IsExported() || !(hasEnv || hasYAML) {
+			continue
+		}
+		fields = append(fields, field{sf: sf, tag: sf.Tag, value: v.Field(i)})
+	}
+	return fields
+}
+
+// name returns the name the field is reported by, its environment variable if
+// it has one.
+func (f field) name() string {
+	if env := f.tag.Get("env"); env != "" {
+		return env
+	}
+	return f.sf.Name
+}
+
+func (f field) flagName() string {
+	if name := f.tag.Get("flag"); name != "" {
+		return name
+	}
+	return strings.ReplaceAll(strings.ToLower(f.name()), "_", "-")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses s into the field.
+func (f field) set(s string) error {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		elems := []string{}
+		for _, elem := range strings.Split(s, ",") {
+			if elem = strings.TrimSpace(elem); elem != "" {
+				elems = append(elems, elem)
+			}
+		}
+		v.Set(reflect.ValueOf(elems))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// String formats the field in the form set parses.
+func (f field) String() string {
+	v := f.value
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = v.Index(i).String()
+		}
+		return strings.Join(elems, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// stringFlag is a flag.Value holding the unparsed value of a field.
+type stringFlag struct {
+	value  string
+	def    string
+	set    bool
+	isBool bool
+}
+
+func (s *stringFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	if !s.set {
+		return s.def
+	}
+	return s.value
+}
+
+func (s *stringFlag) Set(value string) error {
+	s.value, s.set = value, true
+	return nil
+}
+
+// IsBoolFlag lets boolean fields be set with -name as well as -name=true.
+func (s *stringFlag) IsBoolFlag() bool {
+	return s.isBool
+}
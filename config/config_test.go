@@ -0,0 +1,119 @@
+package config_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabriel-ross/trade/config"
+)
+
+type testConfig struct {
+	PORT     string        `env:"PORT" yaml:"port" default:"80"`
+	ADDRESS  string        `env:"ADDRESS" yaml:"address" required:"true"`
+	BACKEND  string        `env:"DB_BACKEND" yaml:"backend" flag:"backend" default:"arango"`
+	TIMEOUT  time.Duration `env:"TIMEOUT" yaml:"timeout" default:"1s"`
+	VERBOSE  bool          `env:"VERBOSE" yaml:"verbose"`
+	HOSTS    []string      `env:"HOSTS" yaml:"hosts"`
+	PASSWORD string        `env:"PASSWORD" yaml:"password" secret:"true"`
+	ignored  string
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "port: \"81\"\naddress: file\ntimeout: 2s\nhosts: [a, b]\n")
+
+	cnf := testConfig{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	config.RegisterFlags(fs, &cnf)
+	if err := fs.Parse([]string{"-address", "flag", "-verbose", "-backend=bolt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := config.Loader{
+		File:      file,
+		LookupEnv: env(map[string]string{"PORT": "82", "ADDRESS": "env", "DB_BACKEND": "memory"}),
+		Flags:     fs,
+	}.Load(&cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testConfig{
+		PORT:    "82",
+		ADDRESS: "flag",
+		BACKEND: "bolt",
+		TIMEOUT: 2 * time.Second,
+		VERBOSE: true,
+		HOSTS:   []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(cnf, want) {
+		t.Errorf("Load() = %+v, want %+v", cnf, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		env    map[string]string
+		target error
+	}{
+		{"missing required", "", nil, config.ErrRequired},
+		{"unknown key", "address: a\nadress: b\n", nil, nil},
+		{"invalid env", "", map[string]string{"ADDRESS": "a", "TIMEOUT": "soon"}, nil},
+	}
+	for _, tt := range tests {
+		l := config.Loader{LookupEnv: env(tt.env)}
+		if tt.file != "" {
+			l.File = writeFile(t, tt.file)
+		}
+		err := l.Load(&testConfig{})
+		if err == nil || (tt.target != nil && !errors.Is(err, tt.target)) {
+			t.Errorf("%s: Load() = %v, want %v", tt.name, err, tt.target)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := config.Write(&buf, testConfig{
+		PORT:     "80",
+		TIMEOUT:  time.Minute,
+		HOSTS:    []string{"a", "b"},
+		PASSWORD: "hunter2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{"PORT=80\n", "TIMEOUT=1m0s\n", "HOSTS=a,b\n", "PASSWORD=[redacted]\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Write() output %q does not contain %q", out, line)
+		}
+	}
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("Write() output %q leaks a secret", out)
+	}
+}
@@ -1,5 +1,7 @@
 package trade
 
+import "fmt"
+
 type Currency int
 
 const (
@@ -7,6 +9,9 @@ const (
 	Apples
 )
 
+// CURRENCIES are the currencies balances and quantities are held in.
+var CURRENCIES = []Currency{Dollars, Apples}
+
 func (c Currency) String() string {
 	switch c {
 	case Dollars:
@@ -17,3 +22,13 @@ func (c Currency) String() string {
 
 	return "unknown"
 }
+
+// ParseCurrency returns the currency named s, as returned by String.
+func ParseCurrency(s string) (Currency, error) {
+	for _, c := range CURRENCIES {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown currency %q, want one of %v", s, CURRENCIES)
+}
@@ -0,0 +1,88 @@
+package trade
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// DocumentStore is the read API shared by the embedded backends, which store
+// documents as JSON objects and evaluate queries in process.
+type DocumentStore interface {
+	// Documents returns a copy of every document in collectionName in the
+	// order they were created.
+	Documents(collectionName string) ([]map[string]interface{}, error)
+
+	// Document returns a copy of the document with _id id. If no document is
+	// found returns NotFoundError.
+	Document(id string) (map[string]interface{}, error)
+}
+
+// sortKeys orders generated document keys by creation.
+func sortKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// mergeDocuments merges patch into doc, recursively merging nested objects.
+func mergeDocuments(doc, patch map[string]interface{}) {
+	for k, v := range patch {
+		if pv, ok := v.(map[string]interface{}); ok {
+			if dv, ok := doc[k].(map[string]interface{}); ok {
+				mergeDocuments(dv, pv)
+				continue
+			}
+		}
+		doc[k] = v
+	}
+}
+
+// toDocument converts v to its JSON document representation.
+func toDocument(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if err = json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// fromDocument binds doc to a T.
+func fromDocument[T any](doc map[string]interface{}) (T, error) {
+	var t T
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(raw, &t)
+	return t, err
+}
+
+// copyDocuments returns deep copies of docs so callers cannot modify stored
+// documents.
+func copyDocuments(docs []map[string]interface{}) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		out[i], _ = toDocument(doc)
+	}
+	return out
+}
+
+// fromDocuments binds each of docs to a T.
+func fromDocuments[T any](docs []map[string]interface{}) ([]T, error) {
+	results := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		data, err := fromDocument[T](doc)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
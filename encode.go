@@ -0,0 +1,259 @@
+package trade
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types the RenderService encodes responses in by default.
+var (
+	MEDIA_TYPE_JSON    = "application/json"
+	MEDIA_TYPE_CSV     = "text/csv"
+	MEDIA_TYPE_NDJSON  = "application/x-ndjson"
+	MEDIA_TYPE_MSGPACK = "application/msgpack"
+)
+
+// Encoder writes response bodies in a media type.
+type Encoder interface {
+	Encode(w io.Writer, r *http.Request, body interface{}) error
+}
+
+// EncoderFunc adapts a function to an Encoder.
+type EncoderFunc func(w io.Writer, r *http.Request, body interface{}) error
+
+func (f EncoderFunc) Encode(w io.Writer, r *http.Request, body interface{}) error {
+	return f(w, r, body)
+}
+
+// mediaEncoder is an Encoder registered for a media type.
+type mediaEncoder struct {
+	mediaType string
+	encoder   Encoder
+}
+
+// defaultEncoders returns the encoders of a RenderService without any of its
+// own, in order of preference.
+func defaultEncoders() []mediaEncoder {
+	return []mediaEncoder{
+		{MEDIA_TYPE_JSON, EncoderFunc(encodeJSON)},
+		{MEDIA_TYPE_CSV, EncoderFunc(encodeCSV)},
+		{MEDIA_TYPE_NDJSON, EncoderFunc(encodeNDJSON)},
+		{MEDIA_TYPE_MSGPACK, EncoderFunc(encodeMsgpack)},
+	}
+}
+
+// encodeJSON writes body as compact JSON, or indented if the request has
+// ?pretty=true.
+func encodeJSON(w io.Writer, r *http.Request, body interface{}) error {
+	enc := json.NewEncoder(w)
+	if r.URL.Query().Get("pretty") == "true" {
+		enc.SetIndent("", "	")
+	}
+	return enc.Encode(body)
+}
+
+// encodeNDJSON writes each row of body, as returned by rows, as a line of
+// JSON.
+func encodeNDJSON(w io.Writer, r *http.Request, body interface{}) error {
+	rows, err := rows(body)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		if err = enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeCSV writes the rows of body, as returned by rows, as CSV with a header
+// row. Nested objects are flattened into columns named by their path, such as
+// balances.dollars, and arrays are written as JSON.
+func encodeCSV(w io.Writer, r *http.Request, body interface{}) error {
+	rows, err := rows(body)
+	if err != nil {
+		return err
+	}
+
+	flat := make([]map[string]string, len(rows))
+	columns := map[string]bool{}
+	for i, row := range rows {
+		flat[i] = map[string]string{}
+		flatten("", row, flat[i])
+		for column := range flat[i] {
+			columns[column] = true
+		}
+	}
+	header := make([]string, 0, len(columns))
+	for column := range columns {
+		header = append(header, column)
+	}
+	sort.Strings(header)
+
+	cw := csv.NewWriter(w)
+	if len(header) > 0 {
+		cw.Write(header)
+	}
+	for _, row := range flat {
+		record := make([]string, len(header))
+		for i, column := range header {
+			record[i] = row[column]
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// encodeMsgpack writes body as MessagePack.
+func encodeMsgpack(w io.Writer, r *http.Request, body interface{}) error {
+	v, err := toValue(body)
+	if err != nil {
+		return err
+	}
+	return NewMsgpackEncoder(w).Encode(v)
+}
+
+// rows returns the rows of a tabular encoding of body. Responses wrap their
+// data in an envelope, whose data is a list of rows or a single row.
+func rows(body interface{}) ([]interface{}, error) {
+	v, err := toValue(body)
+	if err != nil {
+		return nil, err
+	}
+	if envelope, ok := v.(map[string]interface{}); ok {
+		if data, ok := envelope["data"]; ok {
+			v = data
+		}
+	}
+	if list, ok := v.([]interface{}); ok {
+		return list, nil
+	}
+	return []interface{}{v}, nil
+}
+
+// flatten adds the scalar members of v to out, keyed by their path below
+// prefix.
+func flatten(prefix string, v interface{}, out map[string]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, member := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flatten(key, member, out)
+		}
+		return
+	case nil:
+		out[prefix] = ""
+	case string:
+		out[prefix] = v
+	case bool:
+		out[prefix] = strconv.FormatBool(v)
+	case json.Number:
+		out[prefix] = v.String()
+	default:
+		raw, _ := json.Marshal(v)
+		out[prefix] = string(raw)
+	}
+	if prefix == "" {
+		// A row that is not an object is a column of its own
+		out["value"] = out[""]
+		delete(out, "")
+	}
+}
+
+// toValue returns body as the value decoding its JSON encoding returns, with
+// numbers as json.Number so that integers stay integers.
+func toValue(body interface{}) (interface{}, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	return v, err
+}
+
+// acceptedRange is a media range of an Accept header.
+type acceptedRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiate returns the first of encoders in the most preferred media range
+// of the Accept header of r. Requests without an Accept header get the first
+// encoder. Returns false if none of encoders is acceptable.
+func negotiate(r *http.Request, encoders []mediaEncoder) (mediaEncoder, bool) {
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return encoders[0], true
+	}
+
+	ranges := []acceptedRange{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		ar := acceptedRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(name) == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					ar.q = q
+				}
+			}
+		}
+		if ar.mediaType != "" {
+			ranges = append(ranges, ar)
+		}
+	}
+	// More specific ranges come first among those of equal preference
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+
+	for _, ar := range ranges {
+		if ar.q <= 0 {
+			continue
+		}
+		for _, me := range encoders {
+			if matchesRange(ar.mediaType, me.mediaType) && !excluded(ranges, me.mediaType) {
+				return me, true
+			}
+		}
+	}
+	return mediaEncoder{}, false
+}
+
+// matchesRange reports whether mediaType is in the media range mediaRange,
+// such as text/* or */*.
+func matchesRange(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// excluded reports whether ranges refuse mediaType with an exact range of
+// q=0.
+func excluded(ranges []acceptedRange, mediaType string) bool {
+	for _, ar := range ranges {
+		if ar.mediaType == mediaType && ar.q <= 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package trade_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabriel-ross/trade"
+)
+
+type page struct {
+	Data []trade.Account `json:"data"`
+	Next string          `json:"next,omitempty"`
+}
+
+var accounts = page{
+	Data: []trade.Account{
+		{ID: "accounts/1", Owner: "users/1", Balances: map[string]float64{"dollars": 10.5}, Reputation: 100},
+		{ID: "accounts/2", Owner: "users/2", Balances: map[string]float64{"apples": 3}, Reputation: 90},
+	},
+	Next: "abc",
+}
+
+func TestRenderJSON(t *testing.T) {
+	tests := []struct {
+		name, target, accept string
+		wantStatus           int
+		wantType             string
+		wantBody             string
+	}{
+		{"default", "/accounts", "", http.StatusOK, trade.MEDIA_TYPE_JSON, ""},
+		{"any", "/accounts", "*/*", http.StatusOK, trade.MEDIA_TYPE_JSON, ""},
+		{"csv", "/accounts", "text/csv", http.StatusOK, trade.MEDIA_TYPE_CSV,
+			"_id,balances.apples,balances.dollars,creationTimestamp,owner,reputation\n" +
+				"accounts/1,,10.5,0001-01-01T00:00:00Z,users/1,100\n" +
+				"accounts/2,3,,0001-01-01T00:00:00Z,users/2,90\n"},
+		{"ndjson", "/accounts", "application/x-ndjson", http.StatusOK, trade.MEDIA_TYPE_NDJSON,
+			`{"_id":"accounts/1","balances":{"dollars":10.5},"creationTimestamp":"0001-01-01T00:00:00Z","owner":"users/1","reputation":100}` + "\n" +
+				`{"_id":"accounts/2","balances":{"apples":3},"creationTimestamp":"0001-01-01T00:00:00Z","owner":"users/2","reputation":90}` + "\n"},
+		{"preference", "/accounts", "application/json;q=0.5, text/csv;q=0.9", http.StatusOK, trade.MEDIA_TYPE_CSV, ""},
+		{"wildcard subtype", "/accounts", "text/*", http.StatusOK, trade.MEDIA_TYPE_CSV, ""},
+		{"excluded", "/accounts", "*/*, application/json;q=0", http.StatusOK, trade.MEDIA_TYPE_CSV, ""},
+		{"not acceptable", "/accounts", "application/xml", http.StatusNotAcceptable, trade.MEDIA_TYPE_PROBLEM, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		(&trade.RenderService{}).RenderJSON(w, r, http.StatusOK, accounts)
+
+		if w.Code != tt.wantStatus || w.Header().Get("Content-Type") != tt.wantType {
+			t.Errorf("%s: rendered %d %s, want %d %s", tt.name, w.Code, w.Header().Get("Content-Type"), tt.wantStatus, tt.wantType)
+			continue
+		}
+		if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: rendered\n%s\nwant\n%s", tt.name, w.Body.String(), tt.wantBody)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("Link") != `</accounts?cursor=abc>; rel="next"` {
+			t.Errorf("%s: Link header is %q", tt.name, w.Header().Get("Link"))
+		}
+	}
+}
+
+func TestRenderJSONPretty(t *testing.T) {
+	body := map[string]int{"a": 1}
+	for target, want := range map[string]string{
+		"/":             "{\"a\":1}\n",
+		"/?pretty=true": "{\n\t\"a\": 1\n}\n",
+	} {
+		w := httptest.NewRecorder()
+		(&trade.RenderService{}).RenderJSON(w, httptest.NewRequest(http.MethodGet, target, nil), http.StatusOK, body)
+		if w.Body.String() != want {
+			t.Errorf("GET %s rendered %q, want %q", target, w.Body.String(), want)
+		}
+	}
+}
+
+func TestWithEncoder(t *testing.T) {
+	rs := trade.NewRenderService(trade.WithEncoder("text/plain", trade.EncoderFunc(func(w io.Writer, r *http.Request, body interface{}) error {
+		_, err := io.WriteString(w, "plain")
+		return err
+	})))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	rs.RenderJSON(w, r, http.StatusOK, accounts)
+	if w.Body.String() != "plain" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("rendered %s %q with a registered encoder", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+func TestRenderStream(t *testing.T) {
+	rs := &trade.RenderService{}
+	r := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+	r.Header.Set("Accept", trade.MEDIA_TYPE_NDJSON)
+	if !rs.Streams(r) {
+		t.Fatalf("a request accepting %s is not streamed", trade.MEDIA_TYPE_NDJSON)
+	}
+
+	w := httptest.NewRecorder()
+	rs.RenderStream(w, r, http.StatusOK, func(emit func(row interface{}) error) error {
+		for _, a := range accounts.Data {
+			if err := emit(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var got []trade.Account
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		var a trade.Account
+		if err := dec.Decode(&a); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, a)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != trade.MEDIA_TYPE_NDJSON || len(got) != 2 || !w.Flushed {
+		t.Errorf("streamed %d %s %v", w.Code, w.Header().Get("Content-Type"), got)
+	}
+
+	w = httptest.NewRecorder()
+	rs.RenderStream(w, r, http.StatusOK, func(emit func(row interface{}) error) error { return nil })
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("empty stream rendered %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	rs.RenderStream(w, r, http.StatusOK, func(emit func(row interface{}) error) error { return errors.New("cursor lost") })
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != trade.MEDIA_TYPE_PROBLEM {
+		t.Errorf("stream failing before its first row rendered %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	r.Header.Set("Accept", trade.MEDIA_TYPE_JSON)
+	if rs.Streams(r) {
+		t.Errorf("a request accepting %s is streamed", trade.MEDIA_TYPE_JSON)
+	}
+}
+
+func TestMsgpackEncoder(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{json.Number("1"), "01"},
+		{json.Number("-1"), "ff"},
+		{json.Number("-33"), "d0df"},
+		{json.Number("300"), "d1012c"},
+		{json.Number("70000"), "d200011170"},
+		{json.Number("5000000000"), "d3000000012a05f200"},
+		{json.Number("1.5"), "cb3ff8000000000000"},
+		{"abc", "a3616263"},
+		{string(bytes.Repeat([]byte("x"), 32)), "d920" + hex.EncodeToString(bytes.Repeat([]byte("x"), 32))},
+		{[]interface{}{json.Number("1"), "a"}, "9201a161"},
+		{map[string]interface{}{"b": false, "a": nil}, "82a161c0a162c2"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := trade.NewMsgpackEncoder(&buf).Encode(tt.value); err != nil {
+			t.Errorf("Encode(%v): %v", tt.value, err)
+			continue
+		}
+		if got := hex.EncodeToString(buf.Bytes()); got != tt.want {
+			t.Errorf("Encode(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
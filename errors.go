@@ -0,0 +1,156 @@
+package trade
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// ErrorKind is a kind of error the API responds with. Code is stable and
+// machine-readable, and Status is the HTTP status of responses with the error.
+type ErrorKind struct {
+	Code   string
+	Status int
+	Title  string
+}
+
+// Kinds of errors, as documented in docs/errors.md.
+var (
+	ERROR_BAD_REQUEST            = ErrorKind{"bad_request", http.StatusBadRequest, "Bad request"}
+	ERROR_VALIDATION             = ErrorKind{"validation_failed", http.StatusBadRequest, "Request failed validation"}
+	ERROR_INVALID_PATCH          = ErrorKind{"invalid_patch", http.StatusBadRequest, "Invalid patch"}
+	ERROR_UNAUTHORIZED           = ErrorKind{"unauthorized", http.StatusUnauthorized, "Authentication required"}
+	ERROR_FORBIDDEN              = ErrorKind{"forbidden", http.StatusForbidden, "Forbidden"}
+	ERROR_NOT_FOUND              = ErrorKind{"not_found", http.StatusNotFound, "Resource not found"}
+	ERROR_NOT_ACCEPTABLE         = ErrorKind{"not_acceptable", http.StatusNotAcceptable, "Not acceptable"}
+	ERROR_CONFLICT               = ErrorKind{"conflict", http.StatusConflict, "Conflict"}
+	ERROR_PATCH_TEST_FAILED      = ErrorKind{"patch_test_failed", http.StatusConflict, "Patch test failed"}
+	ERROR_PRECONDITION_FAILED    = ErrorKind{"precondition_failed", http.StatusPreconditionFailed, "Precondition failed"}
+	ERROR_BATCH_TOO_LARGE        = ErrorKind{"batch_too_large", http.StatusRequestEntityTooLarge, "Batch too large"}
+	ERROR_PAYLOAD_TOO_LARGE      = ErrorKind{"payload_too_large", http.StatusRequestEntityTooLarge, "Payload too large"}
+	ERROR_UNSUPPORTED_MEDIA_TYPE = ErrorKind{"unsupported_media_type", http.StatusUnsupportedMediaType, "Unsupported media type"}
+	ERROR_UNPROCESSABLE          = ErrorKind{"unprocessable", http.StatusUnprocessableEntity, "Request cannot be processed"}
+	ERROR_IMMUTABLE_FIELD        = ErrorKind{"immutable_field", http.StatusUnprocessableEntity, "Field cannot be changed"}
+	ERROR_INVALID_TRANSACTION    = ErrorKind{"invalid_transaction", http.StatusUnprocessableEntity, "Invalid transaction"}
+	ERROR_UNKNOWN_ACCOUNT        = ErrorKind{"unknown_account", http.StatusUnprocessableEntity, "Account does not exist"}
+	ERROR_INSUFFICIENT_FUNDS     = ErrorKind{"insufficient_funds", http.StatusUnprocessableEntity, "Insufficient funds"}
+	ERROR_BATCH_ABORTED          = ErrorKind{"batch_aborted", http.StatusFailedDependency, "Batch aborted"}
+	ERROR_INTERNAL               = ErrorKind{"internal", http.StatusInternalServerError, "Internal server error"}
+	ERROR_NOT_IMPLEMENTED        = ErrorKind{"not_implemented", http.StatusNotImplemented, "Not implemented"}
+	ERROR_UNAVAILABLE            = ErrorKind{"unavailable", http.StatusServiceUnavailable, "Service unavailable"}
+	ERROR_TIMEOUT                = ErrorKind{"timeout", http.StatusGatewayTimeout, "Timed out"}
+)
+
+// ERROR_KINDS are all kinds of errors.
+var ERROR_KINDS = []ErrorKind{
+	ERROR_BAD_REQUEST, ERROR_VALIDATION, ERROR_INVALID_PATCH, ERROR_UNAUTHORIZED,
+	ERROR_FORBIDDEN, ERROR_NOT_FOUND, ERROR_NOT_ACCEPTABLE, ERROR_CONFLICT,
+	ERROR_PATCH_TEST_FAILED, ERROR_PRECONDITION_FAILED, ERROR_BATCH_TOO_LARGE,
+	ERROR_PAYLOAD_TOO_LARGE, ERROR_UNSUPPORTED_MEDIA_TYPE, ERROR_UNPROCESSABLE, ERROR_IMMUTABLE_FIELD,
+	ERROR_INVALID_TRANSACTION, ERROR_UNKNOWN_ACCOUNT, ERROR_INSUFFICIENT_FUNDS,
+	ERROR_BATCH_ABORTED, ERROR_INTERNAL,
+	ERROR_NOT_IMPLEMENTED, ERROR_UNAVAILABLE, ERROR_TIMEOUT,
+}
+
+// kindError is an error of a kind set by WithErrorKind.
+type kindError struct {
+	error
+	kind ErrorKind
+}
+
+func (err kindError) Unwrap() error {
+	return err.error
+}
+
+// WithErrorKind returns err as an error of kind, for errors of other packages
+// that ErrorKindOf does not know the kind of.
+func WithErrorKind(err error, kind ErrorKind) error {
+	return kindError{error: err, kind: kind}
+}
+
+// ErrorKindOf returns the kind of err, if it is one of the errors of this
+// package or of the ArangoDB driver with a kind of its own, or has a kind set
+// by WithErrorKind.
+func ErrorKindOf(err error) (ErrorKind, bool) {
+	var validationErr *ValidationError
+	var maxBytesErr *http.MaxBytesError
+	var kindErr kindError
+	switch {
+	case err == nil:
+		return ErrorKind{}, false
+	case errors.As(err, &kindErr):
+		return kindErr.kind, true
+	case errors.As(err, &validationErr):
+		return ERROR_VALIDATION, true
+	case errors.Is(err, ErrInvalidPatch):
+		return ERROR_INVALID_PATCH, true
+	case errors.Is(err, ErrPatchTestFailed):
+		return ERROR_PATCH_TEST_FAILED, true
+	case errors.Is(err, ErrUnsupportedPatch):
+		return ERROR_UNSUPPORTED_MEDIA_TYPE, true
+	case errors.Is(err, ErrImmutableField):
+		return ERROR_IMMUTABLE_FIELD, true
+	case errors.Is(err, ErrInvalidTransaction):
+		return ERROR_INVALID_TRANSACTION, true
+	case errors.Is(err, ErrUnknownAccount):
+		return ERROR_UNKNOWN_ACCOUNT, true
+	case errors.Is(err, ErrInsufficientFunds):
+		return ERROR_INSUFFICIENT_FUNDS, true
+	case errors.Is(err, ErrBatchAborted):
+		return ERROR_BATCH_ABORTED, true
+	case errors.Is(err, ErrBatchTooLarge):
+		return ERROR_BATCH_TOO_LARGE, true
+	case errors.As(err, &maxBytesErr):
+		return ERROR_PAYLOAD_TOO_LARGE, true
+	case errors.Is(err, context.DeadlineExceeded), arangodriver.IsTimeout(err):
+		return ERROR_TIMEOUT, true
+	case arangodriver.IsNotFoundGeneral(err):
+		return ERROR_NOT_FOUND, true
+	case arangodriver.IsPreconditionFailed(err):
+		return ERROR_PRECONDITION_FAILED, true
+	case arangodriver.IsConflict(err):
+		return ERROR_CONFLICT, true
+	case arangodriver.IsNoLeaderOrOngoing(err), arangodriver.IsArangoErrorWithCode(err, http.StatusServiceUnavailable):
+		return ERROR_UNAVAILABLE, true
+	}
+	return ErrorKind{}, false
+}
+
+// ErrorKindForStatus returns the generic kind of errors with HTTP status
+// status.
+func ErrorKindForStatus(status int) ErrorKind {
+	switch status {
+	case http.StatusBadRequest:
+		return ERROR_BAD_REQUEST
+	case http.StatusUnauthorized:
+		return ERROR_UNAUTHORIZED
+	case http.StatusForbidden:
+		return ERROR_FORBIDDEN
+	case http.StatusNotFound:
+		return ERROR_NOT_FOUND
+	case http.StatusNotAcceptable:
+		return ERROR_NOT_ACCEPTABLE
+	case http.StatusConflict:
+		return ERROR_CONFLICT
+	case http.StatusPreconditionFailed:
+		return ERROR_PRECONDITION_FAILED
+	case http.StatusRequestEntityTooLarge:
+		return ERROR_PAYLOAD_TOO_LARGE
+	case http.StatusUnsupportedMediaType:
+		return ERROR_UNSUPPORTED_MEDIA_TYPE
+	case http.StatusUnprocessableEntity:
+		return ERROR_UNPROCESSABLE
+	case http.StatusNotImplemented:
+		return ERROR_NOT_IMPLEMENTED
+	case http.StatusServiceUnavailable:
+		return ERROR_UNAVAILABLE
+	case http.StatusGatewayTimeout:
+		return ERROR_TIMEOUT
+	}
+	if status >= 500 {
+		return ERROR_INTERNAL
+	}
+	return ErrorKind{Code: ERROR_BAD_REQUEST.Code, Status: status, Title: http.StatusText(status)}
+}
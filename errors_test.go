@@ -0,0 +1,107 @@
+package trade_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	arangodriver "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+)
+
+func TestRenderError(t *testing.T) {
+	notFound := arangodriver.ArangoError{HasError: true, Code: http.StatusNotFound, ErrorNum: 1202, ErrorMessage: "document not found"}
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{"driver not found as 500", notFound, http.StatusInternalServerError, http.StatusNotFound, "not_found", "document not found"},
+		{"wrapped domain error", fmt.Errorf("settling: %w", trade.ErrInsufficientFunds), http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, "insufficient_funds", "settling: insufficient funds"},
+		{"status of the handler", trade.ErrInsufficientFunds, http.StatusConflict, http.StatusConflict, "conflict", "insufficient funds"},
+		{"oversized body", fmt.Errorf("reading body: %w", &http.MaxBytesError{Limit: 64}), http.StatusBadRequest, http.StatusRequestEntityTooLarge, "payload_too_large", ""},
+		{"unclassified error", errors.New("forbidden"), http.StatusForbidden, http.StatusForbidden, "forbidden", "forbidden"},
+		{"no error", nil, http.StatusNotFound, http.StatusNotFound, "not_found", "no error"},
+		{"internal error", errors.New("connection refused by 10.0.0.1"), http.StatusInternalServerError, http.StatusInternalServerError, "internal", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/accounts/1", nil)
+		detail := "no error"
+		if tt.err != nil {
+			detail = tt.err.Error()
+		}
+		(&trade.RenderService{}).RenderError(w, r, tt.err, tt.status, "%s", detail)
+
+		var p struct {
+			Type     string `json:"type"`
+			Status   int    `json:"status"`
+			Code     string `json:"code"`
+			Detail   string `json:"detail"`
+			Instance string `json:"instance"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if w.Code != tt.wantStatus || p.Status != tt.wantStatus || p.Code != tt.wantCode {
+			t.Errorf("%s: rendered %d %+v, want %d %s", tt.name, w.Code, p, tt.wantStatus, tt.wantCode)
+		}
+		if tt.wantDetail != "" && p.Detail != tt.wantDetail {
+			t.Errorf("%s: detail is %q, want %q", tt.name, p.Detail, tt.wantDetail)
+		}
+		if strings.Contains(p.Detail, "10.0.0.1") {
+			t.Errorf("%s: detail of an internal error was sent: %q", tt.name, p.Detail)
+		}
+		if p.Type != trade.ERROR_DOCS_URL+"#"+tt.wantCode || p.Instance != "/accounts/1" {
+			t.Errorf("%s: type %q and instance %q", tt.name, p.Type, p.Instance)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != trade.MEDIA_TYPE_PROBLEM {
+			t.Errorf("%s: Content-Type is %q", tt.name, ct)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := trade.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = trade.RequestIDFromContext(r.Context())
+		(&trade.RenderService{}).RenderError(w, r, nil, http.StatusNotFound, "not found")
+	}))
+
+	tests := []struct {
+		sent string
+		kept bool
+	}{
+		{"", false},
+		{"trace-1234.abc:5", true},
+		{"not a valid id\n", false},
+		{strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.sent != "" {
+			r.Header.Set(trade.REQUEST_ID_HEADER, tt.sent)
+		}
+		h.ServeHTTP(w, r)
+
+		var p struct {
+			RequestID string `json:"requestId"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &p)
+		got := w.Header().Get(trade.REQUEST_ID_HEADER)
+		if got == "" || got != seen || got != p.RequestID {
+			t.Errorf("request ID %q in the header, %q in the context and %q in the body", got, seen, p.RequestID)
+		}
+		if (got == tt.sent) != tt.kept {
+			t.Errorf("request ID %q was replaced by %q: %v, want %v", tt.sent, got, got != tt.sent, !tt.kept)
+		}
+	}
+}
@@ -1,16 +1,16 @@
 module github.com/gabriel-ross/trade
 
-go 1.20
+go 1.21
 
 require (
 	github.com/arangodb/go-driver v1.5.2
 	github.com/go-chi/chi v1.5.4
-	github.com/tidwall/gjson v1.14.4
+	go.etcd.io/bbolt v1.3.10
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/arangodb/go-velocypack v0.0.0-20200318135517-5af53c29c67e // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/tidwall/match v1.1.1 // indirect
-	github.com/tidwall/pretty v1.2.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 )
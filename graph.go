@@ -0,0 +1,198 @@
+package trade
+
+import (
+	"context"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+var (
+	// TRADING_GRAPH_NAME is the name of the graph linking accounts through the
+	// transactions edge collection. It is declared by the schema manifest.
+	TRADING_GRAPH_NAME = "trading"
+	// DEFAULT_COMMUNITY_DEPTH bounds how far a community traversal walks from
+	// its starting vertex.
+	DEFAULT_COMMUNITY_DEPTH = 1000
+)
+
+// ArangoGraph runs traversals over a named graph whose vertices are of type T.
+type ArangoGraph[T any] struct {
+	database  arangodriver.Database
+	graphName string
+}
+
+func NewArangoGraph[T any](db arangodriver.Database, graphName string) *ArangoGraph[T] {
+	return &ArangoGraph[T]{
+		database:  db,
+		graphName: graphName,
+	}
+}
+
+// Neighbors returns the distinct vertices reachable from start by following at
+// most depth edges in either direction. start is not included.
+func (g *ArangoGraph[T]) Neighbors(ctx context.Context, start string, depth int) ([]T, error) {
+	query := `FOR v IN 1..@depth ANY @start GRAPH @graph
+	OPTIONS {order: "bfs", uniqueVertices: "global"}
+	RETURN v`
+	return g.query(ctx, query, map[string]interface{}{
+		"depth": depth,
+		"start": start,
+		"graph": g.graphName,
+	})
+}
+
+// ShortestPath returns the vertices on the shortest path between from and to,
+// inclusive. If the vertices are not connected returns an empty slice.
+func (g *ArangoGraph[T]) ShortestPath(ctx context.Context, from, to string) ([]T, error) {
+	query := `FOR v IN ANY SHORTEST_PATH @from TO @to GRAPH @graph
+	RETURN v`
+	return g.query(ctx, query, map[string]interface{}{
+		"from":  from,
+		"to":    to,
+		"graph": g.graphName,
+	})
+}
+
+// Component returns every vertex connected to start, including start itself.
+func (g *ArangoGraph[T]) Component(ctx context.Context, start string) ([]T, error) {
+	query := `FOR v IN 0..@depth ANY @start GRAPH @graph
+	OPTIONS {order: "bfs", uniqueVertices: "global"}
+	RETURN v`
+	return g.query(ctx, query, map[string]interface{}{
+		"depth": DEFAULT_COMMUNITY_DEPTH,
+		"start": start,
+		"graph": g.graphName,
+	})
+}
+
+func (g *ArangoGraph[T]) query(ctx context.Context, query string, bindVars map[string]interface{}) ([]T, error) {
+	var err error
+	results := []T{}
+
+	cur, err := g.database.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	for cur.HasMore() {
+		var data T
+		if _, err = cur.ReadDocument(ctx, &data); err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
+
+// MemoryGraph runs traversals in process over the edges of a DocumentStore
+// edge collection whose vertices are of type T.
+type MemoryGraph[T any] struct {
+	database           DocumentStore
+	edgeCollectionName string
+}
+
+func NewMemoryGraph[T any](db DocumentStore, edgeCollectionName string) *MemoryGraph[T] {
+	return &MemoryGraph[T]{
+		database:           db,
+		edgeCollectionName: edgeCollectionName,
+	}
+}
+
+// Neighbors returns the distinct vertices reachable from start by following at
+// most depth edges in either direction. start is not included.
+func (g *MemoryGraph[T]) Neighbors(ctx context.Context, start string, depth int) ([]T, error) {
+	order, _, err := g.walk(start, "", depth)
+	if err != nil {
+		return nil, err
+	}
+	if len(order) > 0 {
+		order = order[1:]
+	}
+	return fromDocuments[T](order)
+}
+
+// ShortestPath returns the vertices on the shortest path between from and to,
+// inclusive. If the vertices are not connected returns an empty slice.
+func (g *MemoryGraph[T]) ShortestPath(ctx context.Context, from, to string) ([]T, error) {
+	order, parents, err := g.walk(from, to, -1)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := parents[to]; !ok {
+		return []T{}, nil
+	}
+
+	vertices := map[string]map[string]interface{}{}
+	for _, vertex := range order {
+		vertices[vertex["_id"].(string)] = vertex
+	}
+	path := []map[string]interface{}{}
+	for id := to; id != ""; id = parents[id] {
+		path = append([]map[string]interface{}{vertices[id]}, path...)
+	}
+	return fromDocuments[T](path)
+}
+
+// Component returns every vertex connected to start, including start itself.
+func (g *MemoryGraph[T]) Component(ctx context.Context, start string) ([]T, error) {
+	order, _, err := g.walk(start, "", DEFAULT_COMMUNITY_DEPTH)
+	if err != nil {
+		return nil, err
+	}
+	return fromDocuments[T](order)
+}
+
+// walk visits the vertices reachable from start breadth first, following at
+// most depth edges or unbounded if depth is negative, stopping early once
+// target is reached. Returns the visited vertices in order and the _id of the
+// vertex each was reached from.
+func (g *MemoryGraph[T]) walk(start, target string, depth int) ([]map[string]interface{}, map[string]string, error) {
+	first, err := g.database.Document(start)
+	if err != nil {
+		if arangodriver.IsNotFoundGeneral(err) {
+			return []map[string]interface{}{}, map[string]string{}, nil
+		}
+		return nil, nil, err
+	}
+
+	edges, err := g.database.Documents(g.edgeCollectionName)
+	if err != nil {
+		return nil, nil, err
+	}
+	adjacent := map[string][]string{}
+	for _, edge := range edges {
+		from, _ := edge["_from"].(string)
+		to, _ := edge["_to"].(string)
+		adjacent[from] = append(adjacent[from], to)
+		adjacent[to] = append(adjacent[to], from)
+	}
+
+	order := []map[string]interface{}{first}
+	parents := map[string]string{start: ""}
+	frontier := []string{start}
+	for level := 0; len(frontier) > 0 && (depth < 0 || level < depth); level++ {
+		next := []string{}
+		for _, id := range frontier {
+			for _, neighbor := range adjacent[id] {
+				if _, seen := parents[neighbor]; seen {
+					continue
+				}
+				vertex, err := g.database.Document(neighbor)
+				if arangodriver.IsNotFoundGeneral(err) {
+					continue
+				} else if err != nil {
+					return nil, nil, err
+				}
+				parents[neighbor] = id
+				order = append(order, vertex)
+				next = append(next, neighbor)
+				if neighbor == target {
+					return order, parents, nil
+				}
+			}
+		}
+		frontier = next
+	}
+	return order, parents, nil
+}
@@ -0,0 +1,21 @@
+package trade
+
+import "strings"
+
+// DocumentID returns the _id of the document with key in collectionName. If key
+// is already an _id it is returned unchanged.
+func DocumentID(collectionName, key string) string {
+	if strings.Contains(key, "/") {
+		return key
+	}
+	return collectionName + "/" + key
+}
+
+// DocumentKey returns the _key portion of id. If id has no collection prefix it
+// is returned unchanged.
+func DocumentKey(id string) string {
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		return id[i+1:]
+	}
+	return id
+}
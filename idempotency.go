@@ -0,0 +1,19 @@
+package trade
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key, replayed when the request is retried with the same key.
+// Owner is the _id of the user that made the request, so keys of different
+// users never collide, and Fingerprint identifies its method, URL and body.
+// A record is pending until the request completes. ExpiresAt is in seconds
+// since the epoch, the format ArangoDB ttl indexes expire documents by.
+type IdempotencyRecord struct {
+	ID          string              `json:"_id"`
+	Owner       string              `json:"owner"`
+	Key         string              `json:"key"`
+	Fingerprint string              `json:"fingerprint"`
+	Completed   bool                `json:"completed"`
+	StatusCode  int                 `json:"statusCode"`
+	Header      map[string][]string `json:"header"`
+	Body        []byte              `json:"body"`
+	ExpiresAt   int64               `json:"expiresAt"`
+}
@@ -0,0 +1,221 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	arango "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/auth"
+)
+
+var (
+	// HEADER is the request header carrying idempotency keys.
+	HEADER = "Idempotency-Key"
+	// REPLAYED_HEADER is set on responses replayed from an earlier request.
+	REPLAYED_HEADER = "Idempotent-Replayed"
+	// MAX_KEY_LENGTH is the length of the longest key accepted.
+	MAX_KEY_LENGTH = 255
+	// MAX_BODY_SIZE is the size in bytes of the largest request body read
+	// to fingerprint a request.
+	MAX_BODY_SIZE int64 = 10 << 20
+)
+
+var (
+	errKeyTooLong  = fmt.Errorf("%s must be at most %d characters", HEADER, MAX_KEY_LENGTH)
+	errKeyReused   = fmt.Errorf("%s was already used for a different request", HEADER)
+	errKeyInFlight = fmt.Errorf("a request with this %s is still being processed", HEADER)
+)
+
+// Idempotent is chi middleware that replays the stored response of mutating
+// requests retried with the same Idempotency-Key. It must run after
+// authentication, as keys are scoped to the authenticated user.
+//
+// A key reused with a different method, URL or body is refused with 422
+// Unprocessable Entity. Server errors are not stored, so that the request can
+// be retried once the error is resolved.
+func (s *service) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		key := r.Header.Get(HEADER)
+		if key == "" || !mutates(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > MAX_KEY_LENGTH {
+			s.renderer.RenderError(w, r, errKeyTooLong, http.StatusBadRequest, "%s", errKeyTooLong.Error())
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_BODY_SIZE))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.renderer.RenderError(w, r, err, http.StatusRequestEntityTooLarge, "request body must be at most %d bytes", maxBytesErr.Limit)
+			return
+		} else if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		p, _ := auth.FromContext(r.Context())
+
+		unlock := s.lock(p.UserID + "\x00" + key)
+		defer unlock()
+
+		rec, found, err := s.lookup(ctx, p.UserID, key)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+		fingerprint := fingerprint(r, body)
+		switch {
+		case found && rec.Fingerprint != fingerprint:
+			s.renderer.RenderError(w, r, errKeyReused, http.StatusUnprocessableEntity, "%s", errKeyReused.Error())
+			return
+		case found && !rec.Completed:
+			s.renderer.RenderError(w, r, errKeyInFlight, http.StatusConflict, "%s", errKeyInFlight.Error())
+			return
+		case found:
+			replay(w, rec)
+			return
+		}
+
+		// Store the record before serving the request so that other instances
+		// sharing the datastore see it in flight
+		rec = trade.IdempotencyRecord{
+			Owner:       p.UserID,
+			Key:         key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(s.ttl).Unix(),
+		}
+		rec.ID, _, err = s.database.Create(ctx, rec)
+		if arango.IsConflict(err) {
+			s.renderer.RenderError(w, r, errKeyInFlight, http.StatusConflict, "%s", errKeyInFlight.Error())
+			return
+		} else if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		// A handler that panics, such as to abort a stream, never completes the
+		// record, which would refuse every retry until it expires
+		defer func() {
+			if v := recover(); v != nil {
+				if err := s.database.Delete(ctx, rec.ID); err != nil {
+					log.Printf("error deleting response for %s %q %v", HEADER, key, err)
+				}
+				panic(v)
+			}
+		}()
+
+		rw := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		if rw.status() >= http.StatusInternalServerError {
+			err = s.database.Delete(ctx, rec.ID)
+		} else {
+			rec.Completed = true
+			rec.StatusCode = rw.status()
+			rec.Header = w.Header().Clone()
+			// Replays are new requests with IDs of their own
+			delete(rec.Header, http.CanonicalHeaderKey(trade.REQUEST_ID_HEADER))
+			rec.Body = rw.body.Bytes()
+			_, err = s.database.Update(ctx, rec.ID, rec)
+		}
+		if err != nil {
+			// The response is already sent; a retry will be refused or served again
+			log.Printf("error storing response for %s %q %v", HEADER, key, err)
+		}
+	})
+}
+
+// lookup returns the unexpired record of the request made by owner with key,
+// if any. Expired records are deleted, as not every datastore removes them on
+// its own.
+func (s *service) lookup(ctx context.Context, owner, key string) (trade.IdempotencyRecord, bool, error) {
+	matches, err := s.database.Query(ctx, trade.NewQuery().Where(
+		trade.NewFilterKey("owner", trade.Eq, owner),
+		trade.NewFilterKey("key", trade.Eq, key),
+	).Page(0, 1))
+	if err != nil || len(matches) < 1 {
+		return trade.IdempotencyRecord{}, false, err
+	}
+
+	rec := matches[0]
+	if time.Now().Unix() < rec.ExpiresAt {
+		return rec, true, nil
+	}
+	if err = s.database.Delete(ctx, rec.ID); err != nil && !arango.IsNotFoundGeneral(err) {
+		return trade.IdempotencyRecord{}, false, err
+	}
+	return trade.IdempotencyRecord{}, false, nil
+}
+
+// mutates reports whether requests with method change resources.
+func mutates(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// fingerprint returns the hex encoded SHA-256 hash of the method, URL and body
+// of r.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes the response stored in rec to w.
+func replay(w http.ResponseWriter, rec trade.IdempotencyRecord) {
+	for name, values := range rec.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(REPLAYED_HEADER, "true")
+	w.WriteHeader(rec.StatusCode)
+	w.Write(rec.Body)
+}
+
+// recorder is an http.ResponseWriter that keeps a copy of the status code and
+// body written through it.
+type recorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rw *recorder) WriteHeader(code int) {
+	if rw.code == 0 {
+		rw.code = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	if rw.code == 0 {
+		rw.code = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// status returns the status code written through rw.
+func (rw *recorder) status() int {
+	if rw.code == 0 {
+		return http.StatusOK
+	}
+	return rw.code
+}
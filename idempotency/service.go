@@ -0,0 +1,101 @@
+// Package idempotency makes retried requests safe. A client that sends a
+// mutating request with an Idempotency-Key header gets the response of the
+// first request made with that key replayed on every retry, instead of the
+// request being applied again.
+//
+// Responses are stored per key and authenticated user for a limited time.
+// Requests with the same key are serialized, so a retry sent while the first
+// request is still running waits for its response.
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// Repository is the API for the IdempotencyRecord datastore.
+type Repository interface {
+	Create(ctx context.Context, rec trade.IdempotencyRecord) (string, trade.IdempotencyRecord, error)
+	Query(ctx context.Context, q trade.Query) ([]trade.IdempotencyRecord, error)
+	Update(ctx context.Context, id string, rec trade.IdempotencyRecord) (trade.IdempotencyRecord, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type Renderer interface {
+	RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any)
+}
+
+// DEFAULT_TTL is how long responses are replayed for unless WithTTL is given.
+var DEFAULT_TTL = 24 * time.Hour
+
+// Service houses the API and necessary dependencies for replaying the
+// responses of retried requests.
+type service struct {
+	database Repository
+	renderer Renderer
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// New returns a new idempotency service storing responses in database.
+func New(database Repository, renderer Renderer, options ...func(*service)) *service {
+	svc := &service{
+		database: database,
+		renderer: renderer,
+		ttl:      DEFAULT_TTL,
+		locks:    map[string]*keyLock{},
+	}
+
+	for _, option := range options {
+		option(svc)
+	}
+
+	return svc
+}
+
+// WithTTL is a functional option for configuring how long an idempotency
+// service replays responses for. A ttl that is not positive is ignored.
+func WithTTL(ttl time.Duration) func(*service) {
+	return func(s *service) {
+		if ttl > 0 {
+			s.ttl = ttl
+		}
+	}
+}
+
+// keyLock serializes the requests made with one key. waiters counts the
+// requests holding or waiting for it, so that it is forgotten when the last
+// one is done.
+type keyLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock blocks until no other request made with scope is running and returns
+// the function releasing it.
+func (s *service) lock(scope string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[scope]
+	if !ok {
+		l = &keyLock{}
+		s.locks[scope] = l
+	}
+	l.waiters++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(s.locks, scope)
+		}
+		s.mu.Unlock()
+	}
+}
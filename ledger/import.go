@@ -0,0 +1,191 @@
+package ledger
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	arango "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+)
+
+// Modes of an import.
+var (
+	// MODE_SETTLE posts imported transactions through settlement, moving
+	// their quantities between the balances of their accounts.
+	MODE_SETTLE = "settle"
+	// MODE_BACKFILL records imported transactions as history, leaving the
+	// balances of their accounts as they are. Every transaction must have a
+	// timestamp.
+	MODE_BACKFILL = "backfill"
+)
+
+var ErrUnknownMode = errors.New("unknown import mode")
+
+// AccountRepository is the API for looking up the accounts imported
+// transactions move quantities between.
+type AccountRepository interface {
+	Get(ctx context.Context, id string) (trade.Account, error)
+}
+
+// TransactionRepository is the API for the datastore backfilled transactions
+// are recorded in.
+type TransactionRepository interface {
+	Create(ctx context.Context, t trade.Transaction) (string, trade.Transaction, error)
+}
+
+// Settler is the API for posting transactions, which moves their quantities
+// between the balances of the accounts involved.
+type Settler interface {
+	Settle(ctx context.Context, t trade.Transaction) (string, trade.Transaction, error)
+}
+
+// Result summarizes an import.
+type Result struct {
+	Imported int `json:"imported"`
+	Failed   int `json:"failed"`
+	// Failures lists the transactions that were not imported, in the order of
+	// the file.
+	Failures []Failure `json:"failures,omitempty"`
+}
+
+// Failure is a transaction of a file that was not imported. Code is the code
+// of the kind of its error, as documented in docs/errors.md.
+type Failure struct {
+	Line  int    `json:"line"`
+	ID    string `json:"id,omitempty"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// REPORT_HEADER names the columns of the report written by WriteReport.
+var REPORT_HEADER = []string{"line", "id", "code", "error"}
+
+// Importer imports transactions into a set of repositories.
+type Importer struct {
+	accounts     AccountRepository
+	transactions TransactionRepository
+	settler      Settler
+	refs         map[string]string
+}
+
+// NewImporter returns an Importer that posts transactions through settler or
+// backfills them in transactions, between accounts of accounts.
+func NewImporter(accounts AccountRepository, transactions TransactionRepository, settler Settler, options ...func(*Importer)) *Importer {
+	imp := &Importer{
+		accounts:     accounts,
+		transactions: transactions,
+		settler:      settler,
+		refs:         map[string]string{},
+	}
+	for _, option := range options {
+		option(imp)
+	}
+	return imp
+}
+
+// WithRefs is a functional option for configuring the account references an
+// Importer maps to the _ids or _keys of accounts, as read by ReadRefs. Senders
+// and recipients that are not mapped are used as _ids or _keys as they are.
+func WithRefs(refs map[string]string) func(*Importer) {
+	return func(imp *Importer) {
+		imp.refs = refs
+	}
+}
+
+// Import imports the records of r in mode, in the order of the file. Records
+// that fail are reported in the result and do not stop the import, which only
+// stops at the first error reading the file or that has no kind of its own,
+// such as the database being unreachable.
+func (imp *Importer) Import(ctx context.Context, r *Reader, mode string) (Result, error) {
+	res := Result{Failures: []Failure{}}
+	if mode != MODE_SETTLE && mode != MODE_BACKFILL {
+		return res, fmt.Errorf("%w %q, must be %s or %s", ErrUnknownMode, mode, MODE_SETTLE, MODE_BACKFILL)
+	}
+
+	for {
+		rec, line, err := r.Read()
+		if err == io.EOF {
+			return res, nil
+		}
+		var validationErr *trade.ValidationError
+		if err != nil && !errors.As(err, &validationErr) {
+			return res, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err == nil {
+			err = imp.importRecord(ctx, rec, mode)
+		}
+		if err == nil {
+			res.Imported++
+			continue
+		}
+
+		kind, ok := trade.ErrorKindOf(err)
+		if !ok {
+			return res, fmt.Errorf("line %d: %w", line, err)
+		}
+		res.Failed++
+		res.Failures = append(res.Failures, Failure{Line: line, ID: rec.ID, Code: kind.Code, Error: err.Error()})
+	}
+}
+
+// importRecord posts or backfills the valid record rec.
+func (imp *Importer) importRecord(ctx context.Context, rec Record, mode string) error {
+	t := trade.Transaction{
+		Sender:     imp.resolve(rec.Sender),
+		Recipient:  imp.resolve(rec.Recipient),
+		Quantities: rec.Quantities,
+		Timestamp:  rec.Timestamp.UTC(),
+	}
+	// Settled transactions without a timestamp are timestamped when posted
+	if mode == MODE_SETTLE {
+		_, _, err := imp.settler.Settle(ctx, t)
+		return err
+	}
+
+	if t.Timestamp.IsZero() {
+		return &trade.ValidationError{Errors: []trade.FieldError{
+			{Field: "timestamp", Code: trade.CODE_REQUIRED, Message: "is required to backfill a transaction"},
+		}}
+	}
+	if t.Sender == t.Recipient {
+		return &trade.ValidationError{Errors: []trade.FieldError{
+			{Field: "recipient", Code: trade.CODE_SAME_ACCOUNT, Message: "must be another account than the sender"},
+		}}
+	}
+	for _, id := range []string{t.Sender, t.Recipient} {
+		if _, err := imp.accounts.Get(ctx, id); arango.IsNotFoundGeneral(err) {
+			return fmt.Errorf("%w: %s", trade.ErrUnknownAccount, id)
+		} else if err != nil {
+			return err
+		}
+	}
+	_, _, err := imp.transactions.Create(ctx, t)
+	return err
+}
+
+// resolve returns the _id of the account referenced by ref.
+func (imp *Importer) resolve(ref string) string {
+	if id, ok := imp.refs[ref]; ok {
+		ref = id
+	}
+	return trade.DocumentID("accounts", ref)
+}
+
+// WriteReport writes failures to w as CSV, with a REPORT_HEADER header.
+func WriteReport(w io.Writer, failures []Failure) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(REPORT_HEADER); err != nil {
+		return err
+	}
+	for _, f := range failures {
+		if err := cw.Write([]string{strconv.Itoa(f.Line), f.ID, f.Code, f.Error}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
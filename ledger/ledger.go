@@ -0,0 +1,203 @@
+// Package ledger exports transaction history as CSV or JSON Lines, and imports
+// historical transactions in the same formats, such as those exported by a
+// legacy system.
+//
+// A JSON Lines file holds a Record per line. A CSV file has a header row
+// naming the columns of CSV_HEADER, in any order, and a row per currency a
+// transaction moves; consecutive rows with the same non-empty id are a single
+// transaction:
+//
+//	id,timestamp,sender,recipient,currency,quantity
+//	transactions/1,2023-01-02T15:04:05Z,accounts/1,accounts/2,dollars,10
+//	transactions/1,2023-01-02T15:04:05Z,accounts/1,accounts/2,apples,3
+package ledger
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// Formats of files.
+var (
+	FORMAT_CSV   = "csv"
+	FORMAT_JSONL = "jsonl"
+	// MEDIA_TYPES maps each format to the media type it is served as.
+	MEDIA_TYPES = map[string]string{
+		FORMAT_CSV:   trade.MEDIA_TYPE_CSV,
+		FORMAT_JSONL: trade.MEDIA_TYPE_NDJSON,
+	}
+)
+
+// CSV_HEADER names the columns of CSV files.
+var CSV_HEADER = []string{"id", "timestamp", "sender", "recipient", "currency", "quantity"}
+
+var ErrUnknownFormat = errors.New("unknown format")
+
+// Record is a transaction of a file. ID is the _id of an exported transaction,
+// or the reference of an imported one in the system it comes from. Sender and
+// Recipient are account _ids, _keys or references mapped by an Importer.
+type Record struct {
+	ID         string             `json:"id,omitempty"`
+	Timestamp  time.Time          `json:"timestamp"`
+	Sender     string             `json:"sender" validate:"required"`
+	Recipient  string             `json:"recipient" validate:"required"`
+	Quantities map[string]float64 `json:"quantities" validate:"required,currencies,positive"`
+}
+
+// Validate checks that rec moves quantities between two different accounts.
+func (rec Record) Validate() []trade.FieldError {
+	return trade.ValidateTransfer("recipient", rec.Sender, rec.Recipient)
+}
+
+// NewRecord returns the record of the transaction t.
+func NewRecord(t trade.Transaction) Record {
+	return Record{ID: t.ID, Timestamp: t.Timestamp, Sender: t.Sender, Recipient: t.Recipient, Quantities: t.Quantities}
+}
+
+// ParseFormat returns the format named s, which may also be a media type.
+func ParseFormat(s string) (string, error) {
+	for format, mediaType := range MEDIA_TYPES {
+		if s == format || s == mediaType {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q, must be %s or %s", ErrUnknownFormat, s, FORMAT_CSV, FORMAT_JSONL)
+}
+
+// ParseTime parses s as either a date, meaning midnight UTC, or an RFC 3339
+// timestamp.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// Filter selects the transactions to export.
+type Filter struct {
+	// Account is the _id or _key of the account that sent or received the
+	// transactions, or empty for the transactions of every account.
+	Account string
+	// From and To bound the timestamps of the transactions. From is
+	// inclusive, To exclusive, and either is unbounded if zero.
+	From, To time.Time
+}
+
+// timestampLayout formats the bounds of a Filter as prefixes of the timestamps
+// they are compared with, which settlement and backfills store in UTC.
+const timestampLayout = "2006-01-02T15:04:05"
+
+// Query returns the query for the transactions f selects, oldest first.
+func (f Filter) Query() trade.Query {
+	q := trade.NewQuery().Sort(
+		trade.SortField{Field: "timestamp", Direction: trade.SORT_ASC},
+		trade.SortField{Field: "_key", Direction: trade.SORT_ASC},
+	)
+	if !f.From.IsZero() {
+		q = q.Where(trade.NewFilterKey("timestamp", trade.Geq, f.From.UTC().Format(timestampLayout)))
+	}
+	if !f.To.IsZero() {
+		q = q.Where(trade.NewFilterKey("timestamp", trade.Lt, f.To.UTC().Format(timestampLayout)))
+	}
+	if f.Account != "" {
+		id := trade.DocumentID("accounts", f.Account)
+		q = q.WhereAny(trade.NewFilterKey("_from", trade.Eq, id), trade.NewFilterKey("_to", trade.Eq, id))
+	}
+	return q
+}
+
+// Repository is the API for the datastore transactions are exported from.
+type Repository interface {
+	QueryEach(ctx context.Context, q trade.Query, fn func(t trade.Transaction) error) error
+}
+
+// Export writes the transactions of repo matching q to w as they are read, so
+// that large exports are never held in memory, and returns the number of
+// transactions written. w is flushed once every transaction is written.
+func Export(ctx context.Context, repo Repository, q trade.Query, w *Writer) (int, error) {
+	n := 0
+	err := repo.QueryEach(ctx, q, func(t trade.Transaction) error {
+		if err := w.Write(NewRecord(t)); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
+
+// Writer writes records to a file.
+type Writer struct {
+	csv         *csv.Writer
+	json        *json.Encoder
+	wroteHeader bool
+}
+
+// NewWriter returns a Writer writing records to w in format.
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	switch format {
+	case FORMAT_CSV:
+		return &Writer{csv: csv.NewWriter(w)}, nil
+	case FORMAT_JSONL:
+		return &Writer{json: json.NewEncoder(w)}, nil
+	}
+	_, err := ParseFormat(format)
+	return nil, err
+}
+
+// Write writes rec. CSV records are buffered until Flush.
+func (w *Writer) Write(rec Record) error {
+	if w.json != nil {
+		return w.json.Encode(rec)
+	}
+	if err := w.header(); err != nil {
+		return err
+	}
+
+	currencies := make([]string, 0, len(rec.Quantities))
+	for currency := range rec.Quantities {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	timestamp := rec.Timestamp.Format(time.RFC3339Nano)
+	for _, currency := range currencies {
+		quantity := strconv.FormatFloat(rec.Quantities[currency], 'f', -1, 64)
+		if err := w.csv.Write([]string{rec.ID, timestamp, rec.Sender, rec.Recipient, currency, quantity}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered records, and the header of a CSV file without
+// records.
+func (w *Writer) Flush() error {
+	if w.json != nil {
+		return nil
+	}
+	if err := w.header(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+func (w *Writer) header() error {
+	if w.wroteHeader {
+		return nil
+	}
+	w.wroteHeader = true
+	return w.csv.Write(CSV_HEADER)
+}
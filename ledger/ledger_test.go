@@ -0,0 +1,222 @@
+package ledger_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/ledger"
+)
+
+var history = []ledger.Record{
+	{ID: "transactions/1", Timestamp: time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC), Sender: "accounts/1", Recipient: "accounts/2", Quantities: map[string]float64{"dollars": 10.5, "apples": 3}},
+	{ID: "transactions/2", Timestamp: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC), Sender: "accounts/2", Recipient: "accounts/1", Quantities: map[string]float64{"dollars": 1}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{ledger.FORMAT_CSV, ledger.FORMAT_JSONL} {
+		var buf bytes.Buffer
+		w, err := ledger.NewWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range history {
+			if err = w.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if format == ledger.FORMAT_CSV && !strings.HasPrefix(buf.String(), "id,timestamp,sender,recipient,currency,quantity\n"+
+			"transactions/1,2023-01-02T15:04:05Z,accounts/1,accounts/2,apples,3\n") {
+			t.Errorf("wrote CSV\n%s", buf.String())
+		}
+
+		got := readAll(t, &buf, format)
+		if !reflect.DeepEqual(got, history) {
+			t.Errorf("%s: read back %+v, want %+v", format, got, history)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name, format, file string
+		want               [][]trade.FieldError
+	}{
+		{"csv", ledger.FORMAT_CSV, "sender,recipient,currency,quantity,timestamp,id\n" +
+			"1,2,dollars,5,2023-01-01,\n" +
+			"1,1,dollars,x,yesterday,\n" +
+			"1,2,,,,a\n" +
+			"1,2,dollars,1,,b\n" +
+			"1,2,dollars,1,,b\n" +
+			"1,2,\"euros,-1,,\n", [][]trade.FieldError{
+			nil,
+			{{Field: "timestamp", Code: trade.CODE_INVALID_TYPE}, {Field: "quantities.dollars", Code: trade.CODE_INVALID_TYPE}, {Field: "recipient", Code: trade.CODE_SAME_ACCOUNT}},
+			{{Field: "currency", Code: trade.CODE_REQUIRED}, {Field: "quantities", Code: trade.CODE_REQUIRED}},
+			{{Field: "quantities.dollars", Code: trade.CODE_MALFORMED}},
+			{{Code: trade.CODE_MALFORMED}},
+		}},
+		{"jsonl", ledger.FORMAT_JSONL, `{"sender": "1", "recipient": "2", "quantities": {"dollars": 5}}` + "\n\n" +
+			`{"sender": "1", "recipient": "2", "quantities": {"euros": -1}, "timestamp": 5, "memo": "x"}` + "\n" +
+			`{"sender": ` + "\n", [][]trade.FieldError{
+			nil,
+			{{Field: "memo", Code: trade.CODE_UNKNOWN_FIELD}, {Field: "timestamp", Code: trade.CODE_INVALID_TYPE}, {Field: "quantities.euros", Code: trade.CODE_UNKNOWN_CURRENCY}, {Field: "quantities.euros", Code: trade.CODE_NOT_POSITIVE}},
+			{{Code: trade.CODE_MALFORMED}},
+		}},
+	}
+	for _, tt := range tests {
+		r, err := ledger.NewReader(strings.NewReader(tt.file), tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]trade.FieldError
+		for {
+			_, _, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			got = append(got, codes(t, err))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Read returned\n%v\nwant\n%v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := ledger.NewReader(strings.NewReader("id,sender\n"), ledger.FORMAT_CSV); err == nil {
+		t.Errorf("NewReader accepted a CSV file without every column")
+	}
+	if _, err := ledger.NewReader(strings.NewReader(""), "xml"); !errors.Is(err, ledger.ErrUnknownFormat) {
+		t.Errorf("NewReader of an unknown format returned %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	db := trade.NewMemoryDatabase()
+	accounts := trade.NewMemoryRepository[trade.Account](db, "accounts")
+	transactions := trade.NewMemoryRepository[trade.Transaction](db, "transactions")
+	alice, _, _ := accounts.Create(ctx, trade.Account{Owner: "users/1", Balances: map[string]float64{"dollars": 10}})
+	bob, _, _ := accounts.Create(ctx, trade.Account{Owner: "users/2", Balances: map[string]float64{}})
+
+	refs, err := ledger.ReadRefs(strings.NewReader("reference,account\nLEGACY-A," + alice + "\nLEGACY-B," + bob + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer := ledger.NewImporter(accounts, transactions, trade.NewSettler(accounts, transactions), ledger.WithRefs(refs))
+	file := "id,timestamp,sender,recipient,currency,quantity\n" +
+		"1,2020-01-01,LEGACY-A,LEGACY-B,dollars,4\n" +
+		"2,2020-01-02,LEGACY-B,LEGACY-A,dollars,9\n" +
+		"3,,LEGACY-A,LEGACY-C,dollars,1\n" +
+		"4,2020-01-03,LEGACY-A,LEGACY-B,dollars,-1\n"
+
+	tests := []struct {
+		mode         string
+		wantImported int
+		wantFailures []ledger.Failure
+		wantBalance  float64
+	}{
+		{ledger.MODE_SETTLE, 1, []ledger.Failure{
+			{Line: 3, ID: "2", Code: trade.ERROR_INSUFFICIENT_FUNDS.Code},
+			{Line: 4, ID: "3", Code: trade.ERROR_UNKNOWN_ACCOUNT.Code},
+			{Line: 5, ID: "4", Code: trade.ERROR_VALIDATION.Code},
+		}, 6},
+		// Backfilled transactions leave balances as they are
+		{ledger.MODE_BACKFILL, 2, []ledger.Failure{
+			{Line: 4, ID: "3", Code: trade.ERROR_VALIDATION.Code},
+			{Line: 5, ID: "4", Code: trade.ERROR_VALIDATION.Code},
+		}, 6},
+	}
+	for _, tt := range tests {
+		r, err := ledger.NewReader(strings.NewReader(file), ledger.FORMAT_CSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := importer.Import(ctx, r, tt.mode)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.mode, err)
+		}
+		for i := range res.Failures {
+			if res.Failures[i].Error == "" {
+				t.Errorf("%s: failure %d has no error", tt.mode, i)
+			}
+			res.Failures[i].Error = ""
+		}
+		if res.Imported != tt.wantImported || res.Failed != len(tt.wantFailures) || !reflect.DeepEqual(res.Failures, tt.wantFailures) {
+			t.Errorf("%s: Import returned %+v, want %d imported and failures %+v", tt.mode, res, tt.wantImported, tt.wantFailures)
+		}
+		a, err := accounts.Get(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Balances["dollars"] != tt.wantBalance {
+			t.Errorf("%s: balance after import is %v, want %v", tt.mode, a.Balances["dollars"], tt.wantBalance)
+		}
+	}
+
+	// Exporting the first week of 2020 finds the imported transactions with
+	// their original timestamps
+	var buf bytes.Buffer
+	w, _ := ledger.NewWriter(&buf, ledger.FORMAT_JSONL)
+	filter := ledger.Filter{Account: bob, From: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2020, 1, 7, 0, 0, 0, 0, time.UTC)}
+	n, err := ledger.Export(ctx, transactions, filter.Query(), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := readAll(t, &buf, ledger.FORMAT_JSONL)
+	if n != 3 || len(exported) != 3 || exported[1].Timestamp.After(exported[2].Timestamp) || exported[2].Sender != bob {
+		t.Errorf("exported %d transactions %+v", n, exported)
+	}
+
+	var report bytes.Buffer
+	if err = ledger.WriteReport(&report, []ledger.Failure{{Line: 3, ID: "2", Code: "insufficient_funds", Error: "short, by 5"}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "line,id,code,error\n3,2,insufficient_funds,\"short, by 5\"\n"; report.String() != want {
+		t.Errorf("WriteReport wrote %q, want %q", report.String(), want)
+	}
+}
+
+func readAll(t *testing.T, r io.Reader, format string) []ledger.Record {
+	t.Helper()
+	lr, err := ledger.NewReader(r, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []ledger.Record
+	for {
+		rec, _, err := lr.Read()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rec)
+	}
+}
+
+// codes returns the fields and codes of the failures of the ValidationError
+// err, without their messages.
+func codes(t *testing.T, err error) []trade.FieldError {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var validationErr *trade.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Read returned %v, want a validation error", err)
+	}
+	out := make([]trade.FieldError, len(validationErr.Errors))
+	for i, fe := range validationErr.Errors {
+		out[i] = trade.FieldError{Field: fe.Field, Code: fe.Code}
+	}
+	return out
+}
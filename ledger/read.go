@@ -0,0 +1,264 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// MAX_LINE_SIZE is the size in bytes of the longest line of a JSON Lines file.
+var MAX_LINE_SIZE = 1 << 20
+
+// Reader reads the records of a file.
+type Reader struct {
+	next func() (Record, int, []trade.FieldError, error)
+}
+
+// NewReader returns a Reader reading records in format from r. The header of
+// a CSV file is read, and must name every column of CSV_HEADER.
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	switch format {
+	case FORMAT_CSV:
+		return newCSVReader(r)
+	case FORMAT_JSONL:
+		return newJSONLReader(r), nil
+	}
+	_, err := ParseFormat(format)
+	return nil, err
+}
+
+// Read returns the next record and the line of the file it starts on, or
+// io.EOF once every record is read. A record that cannot be decoded or fails
+// validation is returned with a trade.ValidationError listing its failures,
+// and reading may carry on with the next record. Any other error ends the
+// file.
+func (r *Reader) Read() (Record, int, error) {
+	rec, line, errs, err := r.next()
+	if err != nil {
+		return rec, line, err
+	}
+
+	// Fields that could not be decoded are not validated again, nor are
+	// records that could not be decoded at all
+	reported := map[string]bool{}
+	for _, fe := range errs {
+		name, _, _ := strings.Cut(fe.Field, ".")
+		reported[fe.Field], reported[name] = true, true
+	}
+	var validationErr *trade.ValidationError
+	if !reported[""] && errors.As(trade.Validate(rec), &validationErr) {
+		for _, fe := range validationErr.Errors {
+			if name, _, _ := strings.Cut(fe.Field, "."); !reported[name] {
+				errs = append(errs, fe)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return rec, line, &trade.ValidationError{Errors: errs}
+	}
+	return rec, line, nil
+}
+
+// jsonRecord is a line of a JSON Lines file. Its timestamp is parsed with
+// ParseTime rather than decoded, so that it may be a date.
+type jsonRecord struct {
+	ID         string             `json:"id"`
+	Timestamp  string             `json:"timestamp"`
+	Sender     string             `json:"sender"`
+	Recipient  string             `json:"recipient"`
+	Quantities map[string]float64 `json:"quantities"`
+}
+
+func newJSONLReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MAX_LINE_SIZE)
+	line := 0
+	return &Reader{next: func() (Record, int, []trade.FieldError, error) {
+		for scanner.Scan() {
+			line++
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+
+			var raw jsonRecord
+			var errs []trade.FieldError
+			var validationErr *trade.ValidationError
+			if err := trade.DecodeJSON(scanner.Bytes(), &raw); errors.As(err, &validationErr) {
+				errs = validationErr.Errors
+			} else if err != nil {
+				return Record{}, line, nil, err
+			}
+
+			rec := Record{ID: raw.ID, Sender: raw.Sender, Recipient: raw.Recipient, Quantities: raw.Quantities}
+			errs = append(errs, parseTimestamp(&rec, raw.Timestamp)...)
+			return rec, line, errs, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return Record{}, line + 1, nil, err
+		}
+		return Record{}, line, nil, io.EOF
+	}}
+}
+
+// csvRow is a row of a CSV file, with the failures of its columns.
+type csvRow struct {
+	rec  Record
+	line int
+	errs []trade.FieldError
+}
+
+func newCSVReader(r io.Reader) (*Reader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV file has no header, want %s", strings.Join(CSV_HEADER, ","))
+	} else if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range CSV_HEADER {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header has no %s column, want %s", name, strings.Join(CSV_HEADER, ","))
+		}
+	}
+
+	// A transaction is only complete once a row of another one is read
+	var pending *csvRow
+	done := false
+	readRow := func() (*csvRow, error) {
+		fields, err := cr.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return &csvRow{line: parseErr.StartLine, errs: []trade.FieldError{{Code: trade.CODE_MALFORMED, Message: parseErr.Err.Error()}}}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		return parseCSVRow(fields, columns, line), nil
+	}
+
+	return &Reader{next: func() (Record, int, []trade.FieldError, error) {
+		for !done {
+			row, err := readRow()
+			if err == io.EOF {
+				done = true
+				break
+			} else if err != nil {
+				return Record{}, 0, nil, err
+			}
+
+			switch {
+			case pending == nil:
+				pending = row
+			case row.rec.ID != "" && row.rec.ID == pending.rec.ID:
+				pending.merge(row)
+			default:
+				tx := pending
+				pending = row
+				return tx.rec, tx.line, tx.errs, nil
+			}
+		}
+		if pending == nil {
+			return Record{}, 0, nil, io.EOF
+		}
+		tx := pending
+		pending = nil
+		return tx.rec, tx.line, tx.errs, nil
+	}}, nil
+}
+
+// parseCSVRow parses the fields of a row of a CSV file with the given columns.
+func parseCSVRow(fields []string, columns map[string]int, line int) *csvRow {
+	get := func(name string) string {
+		if i := columns[name]; i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+
+	row := &csvRow{
+		rec:  Record{ID: get("id"), Sender: get("sender"), Recipient: get("recipient"), Quantities: map[string]float64{}},
+		line: line,
+	}
+	row.errs = parseTimestamp(&row.rec, get("timestamp"))
+
+	currency := get("currency")
+	if currency == "" {
+		row.errs = append(row.errs, trade.FieldError{Field: "currency", Code: trade.CODE_REQUIRED, Message: "is required"})
+		return row
+	}
+	quantity, err := strconv.ParseFloat(get("quantity"), 64)
+	if err != nil {
+		row.errs = append(row.errs, trade.FieldError{Field: "quantities." + currency, Code: trade.CODE_INVALID_TYPE, Message: "must be a number"})
+		return row
+	}
+	row.rec.Quantities[currency] = quantity
+	return row
+}
+
+// merge adds the currency of next, a later row of the same transaction, to
+// row.
+func (row *csvRow) merge(next *csvRow) {
+	row.errs = append(row.errs, next.errs...)
+	for currency, quantity := range next.rec.Quantities {
+		if _, ok := row.rec.Quantities[currency]; ok {
+			row.errs = append(row.errs, trade.FieldError{Field: "quantities." + currency, Code: trade.CODE_MALFORMED, Message: fmt.Sprintf("is repeated on line %d", next.line)})
+			continue
+		}
+		row.rec.Quantities[currency] = quantity
+	}
+	if next.rec.Sender != row.rec.Sender || next.rec.Recipient != row.rec.Recipient || !next.rec.Timestamp.Equal(row.rec.Timestamp) {
+		row.errs = append(row.errs, trade.FieldError{Code: trade.CODE_MALFORMED, Message: fmt.Sprintf("line %d has the same id but another timestamp, sender or recipient", next.line)})
+	}
+}
+
+// parseTimestamp sets the timestamp of rec to s, parsed with ParseTime, unless
+// s is empty.
+func parseTimestamp(rec *Record, s string) []trade.FieldError {
+	if s == "" {
+		return nil
+	}
+	t, err := ParseTime(s)
+	if err != nil {
+		return []trade.FieldError{{Field: "timestamp", Code: trade.CODE_INVALID_TYPE, Message: "must be a date or an RFC 3339 timestamp"}}
+	}
+	rec.Timestamp = t
+	return nil
+}
+
+// ReadRefs reads a CSV file mapping the account references of another system
+// to the _ids or _keys of accounts, with a reference,account header. Its
+// result is passed to WithRefs.
+func ReadRefs(r io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || records[0][0] != "reference" || records[0][1] != "account" {
+		return nil, errors.New("references file must have a reference,account header")
+	}
+
+	refs := map[string]string{}
+	for i, record := range records[1:] {
+		if _, ok := refs[record[0]]; ok {
+			return nil, fmt.Errorf("line %d: reference %s is mapped twice", i+2, record[0])
+		}
+		refs[record[0]] = record[1]
+	}
+	return refs, nil
+}
@@ -0,0 +1,319 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// MemoryDatabase is a concurrency safe, in-memory store of JSON documents
+// grouped by collection. It stands in for ArangoDB in tests and local
+// development.
+type MemoryDatabase struct {
+	mu          sync.RWMutex
+	collections map[string]map[string]map[string]interface{}
+	lastKey     int64
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{
+		collections: map[string]map[string]map[string]interface{}{},
+	}
+}
+
+// Documents returns a copy of every document in collectionName in the order
+// they were created.
+func (db *MemoryDatabase) Documents(collectionName string) ([]map[string]interface{}, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	col := db.collections[collectionName]
+	keys := make([]string, 0, len(col))
+	for key := range col {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+
+	docs := make([]map[string]interface{}, len(keys))
+	for i, key := range keys {
+		docs[i] = col[key]
+	}
+	return copyDocuments(docs), nil
+}
+
+// Document returns a copy of the document with _id id. If no document is found
+// returns NotFoundError.
+func (db *MemoryDatabase) Document(id string) (map[string]interface{}, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	i := strings.Index(id, "/")
+	if i < 0 {
+		return nil, newNotFoundError()
+	}
+	doc, ok := db.collections[id[:i]][id[i+1:]]
+	if !ok {
+		return nil, newNotFoundError()
+	}
+	return toDocument(doc)
+}
+
+// MemoryRepository is a Repository of documents of type T stored in a
+// MemoryDatabase collection.
+type MemoryRepository[T any] struct {
+	database       *MemoryDatabase
+	collectionName string
+}
+
+func NewMemoryRepository[T any](db *MemoryDatabase, collectionName string) *MemoryRepository[T] {
+	return &MemoryRepository[T]{
+		database:       db,
+		collectionName: collectionName,
+	}
+}
+
+// Create creates a new document from data and returns its _id.
+func (r *MemoryRepository[T]) Create(ctx context.Context, data T) (string, T, error) {
+	var t T
+	doc, err := toDocument(data)
+	if err != nil {
+		return "", t, err
+	}
+
+	r.database.mu.Lock()
+	defer r.database.mu.Unlock()
+
+	return r.create(doc), data, nil
+}
+
+// create stores doc as a new document and returns its _id. The caller must
+// hold the write lock of the database.
+func (r *MemoryRepository[T]) create(doc map[string]interface{}) string {
+	r.database.lastKey++
+	key := strconv.FormatInt(r.database.lastKey, 10)
+	doc["_key"] = key
+	doc["_id"] = DocumentID(r.collectionName, key)
+	doc["_rev"] = newRevision()
+
+	if r.database.collections[r.collectionName] == nil {
+		r.database.collections[r.collectionName] = map[string]map[string]interface{}{}
+	}
+	r.database.collections[r.collectionName][key] = doc
+	return doc["_id"].(string)
+}
+
+// Query runs q over the collection.
+func (r *MemoryRepository[T]) Query(ctx context.Context, q Query) ([]T, error) {
+	docs, err := r.QueryRaw(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return fromDocuments[T](docs)
+}
+
+// QueryEach runs q over the collection and calls fn with each result,
+// stopping at the first error fn returns.
+func (r *MemoryRepository[T]) QueryEach(ctx context.Context, q Query, fn func(data T) error) error {
+	docs, err := r.QueryRaw(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		data, err := fromDocument[T](doc)
+		if err != nil {
+			return err
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryRaw runs q and returns the resulting documents without binding them to
+// T.
+func (r *MemoryRepository[T]) QueryRaw(ctx context.Context, q Query) ([]map[string]interface{}, error) {
+	return q.Evaluate(r.database, r.collectionName)
+}
+
+// Get returns the document identified by id, which may be either a _key or an
+// _id. If no document is found returns NotFoundError.
+func (r *MemoryRepository[T]) Get(ctx context.Context, id string) (T, error) {
+	var t T
+	r.database.mu.RLock()
+	defer r.database.mu.RUnlock()
+
+	doc, ok := r.database.collections[r.collectionName][DocumentKey(id)]
+	if !ok {
+		return t, newNotFoundError()
+	}
+	return fromDocument[T](doc)
+}
+
+// Update merges data into the document identified by id and returns the new
+// document. Like an ArangoDB update, nested objects are merged rather than
+// replaced. If no document with id is found returns NotFoundError, and if ctx
+// is conditional on another revision a precondition failed error.
+func (r *MemoryRepository[T]) Update(ctx context.Context, id string, data T) (T, error) {
+	var t T
+	patch, err := toDocument(data)
+	if err != nil {
+		return t, err
+	}
+
+	r.database.mu.Lock()
+	defer r.database.mu.Unlock()
+
+	doc, err := r.update(ctx, id, patch)
+	if err != nil {
+		return t, err
+	}
+	return fromDocument[T](doc)
+}
+
+// update merges patch into the document identified by id and returns the new
+// document. The caller must hold the write lock of the database.
+func (r *MemoryRepository[T]) update(ctx context.Context, id string, patch map[string]interface{}) (map[string]interface{}, error) {
+	delete(patch, "_id")
+	delete(patch, "_key")
+	delete(patch, "_rev")
+
+	doc, ok := r.database.collections[r.collectionName][DocumentKey(id)]
+	if !ok {
+		return nil, newNotFoundError()
+	}
+	if err := checkRevision(ctx, doc); err != nil {
+		return nil, err
+	}
+	mergeDocuments(doc, patch)
+	doc["_rev"] = newRevision()
+	return doc, nil
+}
+
+// Replace replaces the document identified by id with data and returns the
+// new document. If no document with id is found returns NotFoundError, and if
+// ctx is conditional on another revision a precondition failed error.
+func (r *MemoryRepository[T]) Replace(ctx context.Context, id string, data T) (T, error) {
+	var t T
+	doc, err := toDocument(data)
+	if err != nil {
+		return t, err
+	}
+
+	r.database.mu.Lock()
+	defer r.database.mu.Unlock()
+
+	key := DocumentKey(id)
+	old, ok := r.database.collections[r.collectionName][key]
+	if !ok {
+		return t, newNotFoundError()
+	}
+	if err = checkRevision(ctx, old); err != nil {
+		return t, err
+	}
+	doc["_key"] = key
+	doc["_id"] = old["_id"]
+	doc["_rev"] = newRevision()
+	r.database.collections[r.collectionName][key] = doc
+
+	return fromDocument[T](doc)
+}
+
+// Delete deletes the document with given id from the collection. If no match
+// is found returns NotFoundError, and if ctx is conditional on another revision
+// a precondition failed error.
+func (r *MemoryRepository[T]) Delete(ctx context.Context, id string) error {
+	r.database.mu.Lock()
+	defer r.database.mu.Unlock()
+
+	return r.delete(ctx, id)
+}
+
+// delete deletes the document identified by id. The caller must hold the
+// write lock of the database.
+func (r *MemoryRepository[T]) delete(ctx context.Context, id string) error {
+	key := DocumentKey(id)
+	doc, ok := r.database.collections[r.collectionName][key]
+	if !ok {
+		return newNotFoundError()
+	}
+	if err := checkRevision(ctx, doc); err != nil {
+		return err
+	}
+	delete(r.database.collections[r.collectionName], key)
+
+	return nil
+}
+
+// Batch applies ops in order while holding the write lock of the database, so
+// that no other write interleaves with them. If an operation of an atomic
+// batch fails the collection is restored to what it was before the batch.
+func (r *MemoryRepository[T]) Batch(ctx context.Context, ops []BatchOperation[T], atomic bool) ([]BatchResult[T], error) {
+	r.database.mu.Lock()
+	defer r.database.mu.Unlock()
+
+	var saved map[string]map[string]interface{}
+	if col := r.database.collections[r.collectionName]; atomic && col != nil {
+		saved = make(map[string]map[string]interface{}, len(col))
+		for key, doc := range col {
+			saved[key], _ = toDocument(doc)
+		}
+	}
+
+	results := make([]BatchResult[T], len(ops))
+	for i, op := range ops {
+		results[i] = r.apply(ctx, op)
+		if atomic && results[i].Err != nil {
+			r.database.collections[r.collectionName] = saved
+			return AbortBatch(results), nil
+		}
+	}
+	return results, nil
+}
+
+// apply applies op. The caller must hold the write lock of the database.
+func (r *MemoryRepository[T]) apply(ctx context.Context, op BatchOperation[T]) BatchResult[T] {
+	var result BatchResult[T]
+	doc, err := toDocument(op.Data)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	switch op.Op {
+	case BATCH_CREATE:
+		r.create(doc)
+	case BATCH_UPDATE:
+		doc, err = r.update(ctx, op.ID, doc)
+	case BATCH_DELETE:
+		result.ID = DocumentID(r.collectionName, DocumentKey(op.ID))
+		result.Err = r.delete(ctx, op.ID)
+		return result
+	default:
+		err = fmt.Errorf("unknown batch operation %q", op.Op)
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.ID = doc["_id"].(string)
+	result.Data, result.Err = fromDocument[T](doc)
+	return result
+}
+
+// newNotFoundError returns the error ArangoDB responds with when a document
+// does not exist so that callers can handle every backend alike.
+func newNotFoundError() error {
+	return arangodriver.ArangoError{
+		HasError:     true,
+		Code:         http.StatusNotFound,
+		ErrorNum:     arangodriver.ErrArangoDocumentNotFound,
+		ErrorMessage: "document not found",
+	}
+}
@@ -0,0 +1,14 @@
+package trade_test
+
+import (
+	"testing"
+
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/repotest"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository[repotest.Document] {
+		return trade.NewMemoryRepository[repotest.Document](trade.NewMemoryDatabase(), "documents")
+	})
+}
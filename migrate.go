@@ -0,0 +1,182 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// MIGRATIONS_COLLECTION_NAME is the name of the collection recording which
+// schema versions have been applied to a database.
+var MIGRATIONS_COLLECTION_NAME = "migrations"
+
+// Migration records that a schema version was applied. Its key is the version
+// number, so a version can only be recorded once.
+type Migration struct {
+	Key         string    `json:"_key"`
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"appliedAt"`
+}
+
+// Migrate applies the versions of schema that have not yet been applied to db,
+// oldest first, recording each in the migrations collection once it succeeds.
+// Every step is idempotent, so a version that fails part way is retried in
+// full on the next run. Returns the versions that were applied.
+func Migrate(ctx context.Context, db arangodriver.Database, schema Schema) ([]int, error) {
+	migrations, err := ensureCollection(ctx, db, CollectionSchema{CollectionName: MIGRATIONS_COLLECTION_NAME}, arangodriver.CollectionTypeDocument)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := AppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []int{}
+	for _, v := range schema.Pending(applied) {
+		if err = migrateVersion(ctx, db, v); err != nil {
+			return versions, fmt.Errorf("migrating to schema version %d: %w", v.Version, err)
+		}
+
+		_, err = migrations.CreateDocument(ctx, Migration{
+			Key:         strconv.Itoa(v.Version),
+			Version:     v.Version,
+			Description: v.Description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return versions, err
+		}
+		versions = append(versions, v.Version)
+	}
+	return versions, nil
+}
+
+// AppliedMigrations returns the schema versions recorded in db's migrations
+// collection in ascending order.
+func AppliedMigrations(ctx context.Context, db arangodriver.Database) ([]int, error) {
+	var err error
+	applied := []int{}
+
+	query := "FOR m IN @@migrations SORT m.version RETURN m.version"
+	cur, err := db.Query(ctx, query, map[string]interface{}{"@migrations": MIGRATIONS_COLLECTION_NAME})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	for cur.HasMore() {
+		var version int
+		if _, err = cur.ReadDocument(ctx, &version); err != nil {
+			return nil, err
+		}
+		applied = append(applied, version)
+	}
+	return applied, nil
+}
+
+// migrateVersion creates the collections, indexes, validation rules and graphs
+// declared by v.
+func migrateVersion(ctx context.Context, db arangodriver.Database, v SchemaVersion) error {
+	for _, c := range v.DocumentCollections {
+		if err := migrateCollection(ctx, db, c, arangodriver.CollectionTypeDocument); err != nil {
+			return err
+		}
+	}
+	for _, c := range v.EdgeCollections {
+		if err := migrateCollection(ctx, db, c, arangodriver.CollectionTypeEdge); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range v.Graphs {
+		exists, err := db.GraphExists(ctx, g.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err = db.CreateGraphV2(ctx, g.Name, &arangodriver.CreateGraphOptions{EdgeDefinitions: g.EdgeDefinitions}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCollection creates the collection described by c if it does not
+// exist, replaces its validation rule if c declares one and ensures its
+// indexes.
+func migrateCollection(ctx context.Context, db arangodriver.Database, c CollectionSchema, collectionType arangodriver.CollectionType) error {
+	col, err := ensureCollection(ctx, db, c, collectionType)
+	if err != nil {
+		return err
+	}
+
+	if c.Validation != nil {
+		options, err := c.Validation.options()
+		if err != nil {
+			return err
+		}
+		if err = col.SetProperties(ctx, arangodriver.SetCollectionPropertiesOptions{Schema: options}); err != nil {
+			return err
+		}
+	}
+
+	for _, idx := range c.Indexes {
+		switch idx.Type {
+		case INDEX_PERSISTENT:
+			_, _, err = col.EnsurePersistentIndex(ctx, idx.Fields, &arangodriver.EnsurePersistentIndexOptions{
+				Name:   idx.Name,
+				Unique: idx.Unique,
+				Sparse: idx.Sparse,
+			})
+		case INDEX_TTL:
+			_, _, err = col.EnsureTTLIndex(ctx, idx.Fields[0], idx.ExpireAfter, &arangodriver.EnsureTTLIndexOptions{
+				Name: idx.Name,
+			})
+		default:
+			err = fmt.Errorf("unsupported index type %q", idx.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("index %v of %s: %w", idx.Fields, c.CollectionName, err)
+		}
+	}
+	return nil
+}
+
+// ensureCollection returns the collection described by c, creating it with
+// c's validation rule if it does not exist.
+func ensureCollection(ctx context.Context, db arangodriver.Database, c CollectionSchema, collectionType arangodriver.CollectionType) (arangodriver.Collection, error) {
+	col, err := db.Collection(ctx, c.CollectionName)
+	if err == nil || !arangodriver.IsNotFoundGeneral(err) {
+		return col, err
+	}
+
+	options := &arangodriver.CreateCollectionOptions{Type: collectionType}
+	if c.Validation != nil {
+		if options.Schema, err = c.Validation.options(); err != nil {
+			return nil, err
+		}
+	}
+	return db.CreateCollection(ctx, c.CollectionName, options)
+}
+
+func (v ValidationSchema) options() (*arangodriver.CollectionSchemaOptions, error) {
+	options := &arangodriver.CollectionSchemaOptions{
+		Level:   v.Level,
+		Message: v.Message,
+	}
+	if options.Level == "" {
+		options.Level = arangodriver.CollectionSchemaLevelStrict
+	}
+	if err := options.LoadRule(v.Rule); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
@@ -0,0 +1,113 @@
+package trade_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	arangodriver "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	cl, err := trade.NewArangoClient(arangoEndpoints(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := cl.DriverClient.CreateDatabase(ctx, "trade_migrate_test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Remove(ctx) })
+
+	schema, err := trade.LoadSchema("db/arango_schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := trade.Migrate(ctx, db, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != schema.Latest() {
+		t.Errorf("first migration applied versions %v, want all %d", applied, schema.Latest())
+	}
+	if applied, err = trade.Migrate(ctx, db, schema); err != nil || len(applied) != 0 {
+		t.Errorf("second migration applied versions %v, %v, want none", applied, err)
+	}
+
+	exists, err := db.GraphExists(ctx, trade.TRADING_GRAPH_NAME)
+	if err != nil || !exists {
+		t.Errorf("graph %s exists = %v, %v", trade.TRADING_GRAPH_NAME, exists, err)
+	}
+	accounts, err := db.Collection(ctx, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err = accounts.IndexExists(ctx, "byOwner"); err != nil || !exists {
+		t.Errorf("accounts index byOwner exists = %v, %v", exists, err)
+	}
+
+	// A new version is applied on its own and its unique index is enforced
+	schema.Versions = append(schema.Versions, trade.SchemaVersion{
+		Version:     schema.Latest() + 1,
+		Description: "unique phone numbers",
+		DocumentCollections: []trade.CollectionSchema{{
+			CollectionName: "users",
+			Indexes: []trade.IndexSchema{
+				{Type: trade.INDEX_PERSISTENT, Fields: []string{"phoneNumber"}, Unique: true, Sparse: true},
+			},
+		}},
+	})
+	if applied, err = trade.Migrate(ctx, db, schema); err != nil || !reflect.DeepEqual(applied, []int{schema.Latest()}) {
+		t.Errorf("migration applied versions %v, %v, want [%d]", applied, err, schema.Latest())
+	}
+	recorded, err := trade.AppliedMigrations(ctx, db)
+	if err != nil || len(recorded) != schema.Latest() {
+		t.Errorf("recorded migrations %v, %v", recorded, err)
+	}
+
+	users, err := db.Collection(ctx, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = users.CreateDocument(ctx, trade.User{Name: "a", PhoneNumber: "555"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = users.CreateDocument(ctx, trade.User{Name: "b", PhoneNumber: "555"}); !arangodriver.IsConflict(err) {
+		t.Errorf("creating a user with a duplicate phone number returned %v, want conflict", err)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema trade.Schema
+		valid  bool
+	}{
+		{"empty", trade.Schema{}, false},
+		{"ordered", trade.Schema{Versions: []trade.SchemaVersion{{Version: 1}, {Version: 2}}}, true},
+		{"gap", trade.Schema{Versions: []trade.SchemaVersion{{Version: 1}, {Version: 3}}}, false},
+		{"unnamed collection", trade.Schema{Versions: []trade.SchemaVersion{
+			{Version: 1, DocumentCollections: []trade.CollectionSchema{{}}},
+		}}, false},
+		{"multi field ttl", trade.Schema{Versions: []trade.SchemaVersion{
+			{Version: 1, DocumentCollections: []trade.CollectionSchema{{
+				CollectionName: "c",
+				Indexes:        []trade.IndexSchema{{Type: trade.INDEX_TTL, Fields: []string{"a", "b"}, ExpireAfter: 1}},
+			}}},
+		}}, false},
+		{"unknown index type", trade.Schema{Versions: []trade.SchemaVersion{
+			{Version: 1, DocumentCollections: []trade.CollectionSchema{{
+				CollectionName: "c",
+				Indexes:        []trade.IndexSchema{{Type: "hash", Fields: []string{"a"}}},
+			}}},
+		}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.schema.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
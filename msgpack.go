@@ -0,0 +1,135 @@
+package trade
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// MsgpackEncoder writes values in the MessagePack format. It encodes the
+// values JSON decodes into: nil, bool, float64, json.Number, string,
+// []interface{} and map[string]interface{}. Map keys are written in sorted
+// order, so that equal values encode to equal bytes.
+type MsgpackEncoder struct {
+	w *bufio.Writer
+}
+
+// NewMsgpackEncoder returns an encoder writing to w.
+func NewMsgpackEncoder(w io.Writer) *MsgpackEncoder {
+	return &MsgpackEncoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes v to the encoder's writer.
+func (e *MsgpackEncoder) Encode(v interface{}) error {
+	if err := e.encode(v); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *MsgpackEncoder) encode(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return e.w.WriteByte(0xc0)
+	case bool:
+		if v {
+			return e.w.WriteByte(0xc3)
+		}
+		return e.w.WriteByte(0xc2)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return e.encodeInt(i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return e.encodeFloat(f)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return e.encodeInt(int64(v))
+		}
+		return e.encodeFloat(v)
+	case string:
+		e.encodeHeader(len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		_, err := e.w.WriteString(v)
+		return err
+	case []interface{}:
+		e.encodeHeader(len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, elem := range v {
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.encodeHeader(len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, key := range keys {
+			if err := e.encode(key); err != nil {
+				return err
+			}
+			if err := e.encode(v[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("msgpack: cannot encode %T", v)
+	}
+}
+
+// encodeHeader writes the header of a string, array or map of length n: a
+// fix type holding n if it is at most fixMax, otherwise the type with 8, 16
+// or 32 bit length that fits. Arrays and maps have no 8 bit type, marked by a
+// zero code8.
+func (e *MsgpackEncoder) encodeHeader(n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n <= fixMax:
+		e.w.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		e.w.Write([]byte{code8, byte(n)})
+	case n <= math.MaxUint16:
+		e.w.WriteByte(code16)
+		binary.Write(e.w, binary.BigEndian, uint16(n))
+	default:
+		e.w.WriteByte(code32)
+		binary.Write(e.w, binary.BigEndian, uint32(n))
+	}
+}
+
+// encodeInt writes i in the smallest integer type that holds it.
+func (e *MsgpackEncoder) encodeInt(i int64) error {
+	var err error
+	switch {
+	case i >= 0 && i <= 0x7f:
+		err = e.w.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		err = e.w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		_, err = e.w.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		e.w.WriteByte(0xd1)
+		err = binary.Write(e.w, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		e.w.WriteByte(0xd2)
+		err = binary.Write(e.w, binary.BigEndian, int32(i))
+	default:
+		e.w.WriteByte(0xd3)
+		err = binary.Write(e.w, binary.BigEndian, i)
+	}
+	return err
+}
+
+func (e *MsgpackEncoder) encodeFloat(f float64) error {
+	e.w.WriteByte(0xcb)
+	return binary.Write(e.w, binary.BigEndian, math.Float64bits(f))
+}
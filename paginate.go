@@ -13,19 +13,21 @@ var (
 type Paginate struct {
 	SortFields []SortField
 	Limit      int
-	// something for pagination
+	Offset     int
 }
 
 // NewPaginate creates a new paginate from pagination query values in an http
 // request.
 //
 // NewPaginate expects sort quries in the format ?sort=key1+sortDirection,key2+sortDirection,etc.
-func NewPaginate(r *http.Request) Paginate {
+// and pages in the format ?limit=pageSize&offset=resultsToSkip. A limit that
+// is not a positive integer or an offset that is not a non-negative integer
+// fails validation.
+func NewPaginate(r *http.Request) (Paginate, error) {
 	p := Paginate{
 		SortFields: []SortField{},
 		Limit:      DEFAULT_LIMIT,
 	}
-	sortFields := []SortField{}
 	if sort := r.URL.Query().Get("sort"); sort != "" {
 		keys := strings.Split(sort, ",")
 		for _, key := range keys {
@@ -39,15 +41,39 @@ func NewPaginate(r *http.Request) Paginate {
 			} else {
 				sf.Direction = SORT_ASC
 			}
-			sortFields = append(sortFields, sf)
+			p.SortFields = append(p.SortFields, sf)
 		}
 	}
 
+	var errs []FieldError
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		p.Limit, _ = strconv.Atoi(limitStr)
+		limit, err := strconv.Atoi(limitStr)
+		switch {
+		case err != nil:
+			errs = append(errs, FieldError{Field: "limit", Code: CODE_INVALID_TYPE, Message: "must be an integer"})
+		case limit < 1:
+			errs = append(errs, FieldError{Field: "limit", Code: CODE_NOT_POSITIVE, Message: "must be a positive integer"})
+		default:
+			p.Limit = limit
+		}
 	}
 
-	return p
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		switch {
+		case err != nil:
+			errs = append(errs, FieldError{Field: "offset", Code: CODE_INVALID_TYPE, Message: "must be an integer"})
+		case offset < 0:
+			errs = append(errs, FieldError{Field: "offset", Code: CODE_NEGATIVE, Message: "must be a non-negative integer"})
+		default:
+			p.Offset = offset
+		}
+	}
+
+	if len(errs) > 0 {
+		return p, &ValidationError{Errors: errs}
+	}
+	return p, nil
 }
 
 type SortField struct {
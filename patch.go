@@ -0,0 +1,366 @@
+package trade
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of the patch documents Patch applies.
+var (
+	MEDIA_TYPE_MERGE_PATCH = "application/merge-patch+json"
+	MEDIA_TYPE_JSON_PATCH  = "application/json-patch+json"
+)
+
+var (
+	// ErrUnsupportedPatch is returned when a patch is not sent as one of the
+	// supported media types.
+	ErrUnsupportedPatch = fmt.Errorf("patches must be sent as %s or %s", MEDIA_TYPE_MERGE_PATCH, MEDIA_TYPE_JSON_PATCH)
+	// ErrInvalidPatch is returned when a patch is malformed, cannot be applied
+	// or produces a document that is not a valid resource.
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchTestFailed is returned when a test operation of a JSON Patch
+	// does not hold.
+	ErrPatchTestFailed = errors.New("patch test failed")
+	// ErrImmutableField is returned when a patch changes a field that clients
+	// may not change.
+	ErrImmutableField = errors.New("field cannot be changed")
+)
+
+// Patch applies the patch in the body of r to doc and returns the patched
+// document. The Content-Type of r selects a JSON Merge Patch (RFC 7386) or a
+// JSON Patch (RFC 6902). Patches that change any of the top level fields
+// immutable, named as in JSON, fail with ErrImmutableField. The patched
+// document must pass Validate.
+func Patch[T any](r *http.Request, doc T, immutable ...string) (T, error) {
+	var t T
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != MEDIA_TYPE_MERGE_PATCH && mediaType != MEDIA_TYPE_JSON_PATCH {
+		return t, ErrUnsupportedPatch
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return t, err
+	}
+
+	original, err := toDocument(doc)
+	if err != nil {
+		return t, err
+	}
+	var patched interface{}
+	if mediaType == MEDIA_TYPE_MERGE_PATCH {
+		patched, err = MergePatch(original, body)
+	} else {
+		patched, err = JSONPatch(original, body)
+	}
+	if err != nil {
+		return t, err
+	}
+
+	patchedDoc, ok := patched.(map[string]interface{})
+	if !ok {
+		return t, fmt.Errorf("%w: the patched document is not an object", ErrInvalidPatch)
+	}
+	for _, field := range immutable {
+		if !reflect.DeepEqual(original[field], patchedDoc[field]) {
+			return t, fmt.Errorf("%w: %s", ErrImmutableField, field)
+		}
+	}
+
+	// Bind strictly so that misspelt fields are reported rather than dropped
+	raw, err := json.Marshal(patchedDoc)
+	if err != nil {
+		return t, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&t); err != nil {
+		return t, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	if err = Validate(t); err != nil {
+		return t, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+	return t, nil
+}
+
+// MergePatch applies the JSON Merge Patch patch to doc, as described by
+// RFC 7386, and returns the result. doc is not modified.
+func MergePatch(doc interface{}, patch []byte) (interface{}, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return mergePatch(doc, p), nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	result := make(map[string]interface{}, len(t))
+	for k, v := range t {
+		result[k] = v
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		result[k] = mergePatch(result[k], v)
+	}
+	return result
+}
+
+// patchOperation is an operation of a JSON Patch.
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// JSONPatch applies the JSON Patch patch to doc, as described by RFC 6902, and
+// returns the result. The operations are applied in order and the patch fails
+// as a whole if any of them fails. doc is not modified.
+func JSONPatch(doc interface{}, patch []byte) (interface{}, error) {
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	// Work on a copy, as operations modify the document in place
+	doc, err := copyValue(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		if op.Path == nil {
+			return nil, fmt.Errorf("%w: operation %d has no path", ErrInvalidPatch, i)
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+		}
+
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%w: operation %d has no value", ErrInvalidPatch, i)
+			}
+			if err = json.Unmarshal(*op.Value, &value); err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+			}
+		case "move", "copy":
+			if op.From == nil {
+				return nil, fmt.Errorf("%w: operation %d has no from", ErrInvalidPatch, i)
+			}
+			from, err := parsePointer(*op.From)
+			if err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+			}
+			if op.Op == "move" && len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+				return nil, fmt.Errorf("%w: operation %d moves %s into itself", ErrInvalidPatch, i, *op.From)
+			}
+			if value, err = pointerGet(doc, from); err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+			}
+			if op.Op == "move" {
+				if doc, err = pointerRemove(doc, from); err != nil {
+					return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+				}
+			} else if value, err = copyValue(value); err != nil {
+				return nil, err
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%w: operation %d has unknown op %q", ErrInvalidPatch, i, op.Op)
+		}
+
+		switch op.Op {
+		case "add", "move", "copy":
+			doc, err = pointerAdd(doc, path, value)
+		case "remove":
+			doc, err = pointerRemove(doc, path)
+		case "replace":
+			if len(path) == 0 {
+				doc = value
+			} else if doc, err = pointerRemove(doc, path); err == nil {
+				doc, err = pointerAdd(doc, path, value)
+			}
+		case "test":
+			if current, err := pointerGet(doc, path); err != nil || !reflect.DeepEqual(current, value) {
+				return nil, fmt.Errorf("%w: %s is not %s", ErrPatchTestFailed, *op.Path, *op.Value)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer splits the JSON Pointer pointer (RFC 6901) into its unescaped
+// reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("pointer %q does not start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex returns the index token refers to in an array of length n. If
+// appending is set "-" refers to the end of the array and n is a valid index.
+func arrayIndex(token string, n int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > n || (i == n && !appending) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+// pointerGet returns the value path refers to in doc.
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			v, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("cannot index into %T with %q", doc, token)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd adds value to doc at path and returns the new document. Members
+// of objects are set and values are inserted into arrays.
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[token] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(container), true)
+		if err != nil {
+			return nil, err
+		}
+		grown := append(container[:i:i], append([]interface{}{value}, container[i:]...)...)
+		return replaceAt(doc, path[:len(path)-1], grown)
+	default:
+		return nil, fmt.Errorf("cannot add to %T", parent)
+	}
+}
+
+// pointerRemove removes the value at path from doc and returns the new
+// document.
+func pointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := container[token]; !ok {
+			return nil, fmt.Errorf("member %q not found", token)
+		}
+		delete(container, token)
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		shrunk := append(container[:i:i], container[i+1:]...)
+		return replaceAt(doc, path[:len(path)-1], shrunk)
+	default:
+		return nil, fmt.Errorf("cannot remove from %T", parent)
+	}
+}
+
+// replaceAt sets the value at path, which must exist, to value and returns
+// the new document. Arrays are values rather than references, so growing or
+// shrinking one means storing it again in its parent.
+func replaceAt(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[token] = value
+	case []interface{}:
+		i, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		container[i] = value
+	}
+	return doc, nil
+}
+
+// copyValue returns a deep copy of the JSON value v.
+func copyValue(v interface{}) (interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c interface{}
+	err = json.Unmarshal(raw, &c)
+	return c, err
+}
@@ -0,0 +1,140 @@
+package trade_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gabriel-ross/trade"
+)
+
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7386, appendix A
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := trade.MergePatch(decode(t, tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("MergePatch(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("MergePatch(%s, %s) = %v, want %v", tt.doc, tt.patch, got, want)
+		}
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	// Mostly the examples of RFC 6902, appendix A
+	tests := []struct {
+		doc, patch, want string
+		err              error
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{`{"foo":{"bar":[1]}}`, `[{"op":"copy","from":"/foo/bar","path":"/baz"},{"op":"add","path":"/baz/-","value":2}]`,
+			`{"foo":{"bar":[1]},"baz":[1,2]}`, nil},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``, trade.ErrPatchTestFailed},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``, trade.ErrInvalidPatch},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, ``, trade.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"frob","path":"/foo"}]`, ``, trade.ErrInvalidPatch},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, ``, trade.ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		doc := decode(t, tt.doc)
+		got, err := trade.JSONPatch(doc, []byte(tt.patch))
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("JSONPatch(%s, %s) returned %v, want %v", tt.doc, tt.patch, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("JSONPatch(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("JSONPatch(%s, %s) = %v, want %v", tt.doc, tt.patch, got, want)
+		}
+		if !reflect.DeepEqual(doc, decode(t, tt.doc)) {
+			t.Errorf("JSONPatch(%s, %s) modified its document", tt.doc, tt.patch)
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	account := trade.Account{ID: "accounts/1", Owner: "users/1", Balances: map[string]float64{"dollars": 10}, Reputation: 100}
+	immutable := []string{"_id", "balances", "reputation"}
+
+	tests := []struct {
+		name, contentType, body string
+		wantOwner               string
+		err                     error
+	}{
+		{"merge patch", trade.MEDIA_TYPE_MERGE_PATCH, `{"owner": "users/2"}`, "users/2", nil},
+		{"json patch", trade.MEDIA_TYPE_JSON_PATCH, `[{"op": "replace", "path": "/owner", "value": "users/3"}]`, "users/3", nil},
+		{"plain json", "application/json", `{"owner": "users/2"}`, "", trade.ErrUnsupportedPatch},
+		{"immutable field", trade.MEDIA_TYPE_MERGE_PATCH, `{"balances": {"dollars": 1000}}`, "", trade.ErrImmutableField},
+		{"removed immutable field", trade.MEDIA_TYPE_JSON_PATCH, `[{"op": "remove", "path": "/reputation"}]`, "", trade.ErrImmutableField},
+		{"unknown field", trade.MEDIA_TYPE_MERGE_PATCH, `{"ownr": "users/2"}`, "", trade.ErrInvalidPatch},
+		{"wrong type", trade.MEDIA_TYPE_MERGE_PATCH, `{"owner": 2}`, "", trade.ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodPatch, "/accounts/1", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.contentType)
+		got, err := trade.Patch(r, account, immutable...)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: Patch returned %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Patch: %v", tt.name, err)
+			continue
+		}
+		if got.Owner != tt.wantOwner || got.Balances["dollars"] != 10 || got.ID != account.ID {
+			t.Errorf("%s: Patch returned %+v", tt.name, got)
+		}
+	}
+}
+
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid test JSON %s: %v", s, err)
+	}
+	return v
+}
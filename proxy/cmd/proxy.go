@@ -1,18 +1,27 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
-	"time"
 
+	"github.com/gabriel-ross/trade/config"
 	"github.com/gabriel-ross/trade/proxy"
 )
 
 func main() {
-	p := proxy.New(proxy.Config{
-		NAME:           "Proxy server",
-		PORT:           os.Getenv("PORT"),
-		SERVER_ADDRESS: os.Getenv("SERVER_ADDRESS"),
-		CACHE_TIMEOUT:  time.Hour,
-	})
+	cnf := proxy.Config{}
+	file := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file (env CONFIG_FILE)")
+	config.RegisterFlags(flag.CommandLine, &cnf)
+	flag.Parse()
+
+	if err := config.Load(&cnf, *file, flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Fprintln(os.Stderr, "configuration:")
+	config.Write(os.Stderr, cnf)
+
+	p := proxy.New(cnf)
 	p.Run()
 }
@@ -1,5 +1,12 @@
 module github.com/gabriel-ross/trade/proxy
 
-go 1.20
+go 1.21
 
-require github.com/go-chi/chi v1.5.4
+require (
+	github.com/gabriel-ross/trade v0.0.0
+	github.com/go-chi/chi v1.5.4
+)
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+replace github.com/gabriel-ross/trade => ../
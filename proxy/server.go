@@ -9,11 +9,13 @@ import (
 	"time"
 )
 
+// Config contains the settings of a proxy server. It is loaded with the config
+// package of the trade module.
 type Config struct {
-	NAME           string
-	PORT           string `env:"PORT" default:"8081" required:"false"`
-	SERVER_ADDRESS string `env:"SERVER_ADDRESS" default:"localhost:8080" required:"false"`
-	CACHE_TIMEOUT  time.Duration
+	NAME           string        `env:"NAME" yaml:"name" default:"Proxy server" usage:"name of the proxy"`
+	PORT           string        `env:"PORT" yaml:"port" default:"8081" usage:"port the proxy listens on"`
+	SERVER_ADDRESS string        `env:"SERVER_ADDRESS" yaml:"serverAddress" default:"localhost:8080" required:"true" usage:"address of the server requests are forwarded to"`
+	CACHE_TIMEOUT  time.Duration `env:"CACHE_TIMEOUT" yaml:"cacheTimeout" default:"1h" usage:"how long a cached response stays fresh"`
 }
 
 type Cache interface {
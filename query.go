@@ -0,0 +1,502 @@
+package trade
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// Query is a backend-neutral description of a query over a single collection
+// that every Repository backend knows how to run. Filters holds a disjunction
+// of conjunctions, matching AQL's precedence of && over ||. A negative Limit
+// returns every result.
+type Query struct {
+	Filters    [][]FilterKey
+	SortFields []SortField
+	Offset     int
+	Limit      int
+	Fields     []string
+	Relations  []Relation
+}
+
+// NewQuery returns a query matching every document of a collection.
+func NewQuery() Query {
+	return Query{Limit: -1}
+}
+
+// Where restricts q to documents that also match every one of filters.
+func (q Query) Where(filters ...FilterKey) Query {
+	if len(q.Filters) < 1 {
+		q.Filters = [][]FilterKey{append([]FilterKey{}, filters...)}
+		return q
+	}
+
+	conjunctions := make([][]FilterKey, len(q.Filters))
+	for i, conjunction := range q.Filters {
+		conjunctions[i] = append(append([]FilterKey{}, conjunction...), filters...)
+	}
+	q.Filters = conjunctions
+	return q
+}
+
+// WhereAny restricts q to documents that also match at least one of filters.
+func (q Query) WhereAny(filters ...FilterKey) Query {
+	conjunctions := [][]FilterKey{}
+	for _, filter := range filters {
+		conjunctions = append(conjunctions, q.Where(filter).Filters...)
+	}
+	q.Filters = conjunctions
+	return q
+}
+
+// OrWhere widens q to also match documents that match every one of filters.
+func (q Query) OrWhere(filters ...FilterKey) Query {
+	q.Filters = append(append([][]FilterKey{}, q.Filters...), append([]FilterKey{}, filters...))
+	return q
+}
+
+// Sort orders the results of q by sortFields.
+func (q Query) Sort(sortFields ...SortField) Query {
+	q.SortFields = append(append([]SortField{}, q.SortFields...), sortFields...)
+	return q
+}
+
+// Page skips the first offset results of q and returns at most limit. A
+// negative offset skips none and a negative limit returns every result.
+func (q Query) Page(offset, limit int) Query {
+	q.Offset = max(offset, 0)
+	q.Limit = max(limit, -1)
+	return q
+}
+
+func (q Query) Paginate(p Paginate) Query {
+	return q.Sort(p.SortFields...).Page(p.Offset, p.Limit)
+}
+
+// Keep projects the returned documents down to fields.
+func (q Query) Keep(fields ...string) Query {
+	q.Fields = append(append([]string{}, q.Fields...), fields...)
+	return q
+}
+
+// Expand replaces the _id held in relation.Field with the document it
+// references.
+func (q Query) Expand(relation Relation) Query {
+	q.Relations = append(append([]Relation{}, q.Relations...), relation)
+	return q
+}
+
+// IsProjected reports whether the query returns documents that have been
+// projected or expanded and so no longer match the collection's document type.
+func (q Query) IsProjected() bool {
+	return len(q.Fields) > 0 || len(q.Relations) > 0
+}
+
+// QueryFromURLParams builds a query from the filter, sort, pagination and
+// projection query parameters of r.
+//
+// Each of queryParams filters on the field of the same name with the syntax
+// ?key=operator+value, where + decodes to a space, and the value may hold
+// spaces of its own. If there is no operator default to equality. Filters are combined with AND unless ?inclusive=true.
+// Sort, limit, offset and cursor are read as described by NewPaginate. A comma
+// separated ?fields= projects the returned documents down to the listed fields
+// and a comma separated ?expand= inlines the named relations. Projecting or
+// sorting by a field that documents of type T do not have fails validation.
+func QueryFromURLParams[T any](r *http.Request, queryParams []string, relations map[string]Relation) (Query, error) {
+	q := NewQuery()
+
+	for _, param := range queryParams {
+		val := r.URL.Query().Get(param)
+		if val == "" {
+			continue
+		}
+
+		filter := FilterKeyFromURLElement(param, val)
+		if r.URL.Query().Get("inclusive") == "true" {
+			q = q.OrWhere(filter)
+		} else {
+			q = q.Where(filter)
+		}
+	}
+
+	p, err := NewPaginate(r)
+	if err != nil {
+		return q, err
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		offset, err := DecodeCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		p.Offset = offset
+	}
+	q = q.Paginate(p)
+
+	if fields := FieldsFromURLParams(r); len(fields) > 0 {
+		q = q.Keep(fields...)
+	}
+	if err := checkFields[T](q); err != nil {
+		return q, err
+	}
+
+	for _, name := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		relation, ok := relations[name]
+		if !ok {
+			return q, fmt.Errorf("cannot expand unknown relation %q", name)
+		}
+		q = q.Expand(relation)
+	}
+
+	return q, nil
+}
+
+// checkFields returns a ValidationError if q projects or sorts by fields that
+// documents of type T do not have. Nested fields are checked by the name of
+// their top-level field only.
+func checkFields[T any](q Query) error {
+	known := jsonFields(reflect.TypeOf((*T)(nil)).Elem())
+	var errs []FieldError
+	check := func(param, field string) {
+		name, _, _ := strings.Cut(field, ".")
+		if _, ok := known[name]; !ok && name != "_key" {
+			errs = append(errs, FieldError{Field: param, Code: CODE_UNKNOWN_FIELD, Message: fmt.Sprintf("names unknown field %q", field)})
+		}
+	}
+	for _, field := range q.Fields {
+		check("fields", field)
+	}
+	for _, sf := range q.SortFields {
+		check("sort", sf.Field)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// FieldsFromURLParams returns the fields listed in the comma separated ?fields=
+// query of r.
+func FieldsFromURLParams(r *http.Request) []string {
+	fields := []string{}
+	for _, field := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the decoded form of an opaque pagination cursor.
+type cursor struct {
+	Offset int `json:"o"`
+}
+
+// NextCursor returns the cursor of the page following the results of q, or an
+// empty string if q returned its final page. n is the number of results q
+// returned.
+func NextCursor(q Query, n int) string {
+	if q.Limit < 1 || n < q.Limit {
+		return ""
+	}
+	raw, _ := json.Marshal(cursor{Offset: q.Offset + n})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor returns the offset encoded in a cursor returned by NextCursor.
+func DecodeCursor(s string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	var c cursor
+	if err = json.Unmarshal(raw, &c); err != nil || c.Offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return c.Offset, nil
+}
+
+// Evaluate runs q over the documents of collectionName in store.
+func (q Query) Evaluate(store DocumentStore, collectionName string) ([]map[string]interface{}, error) {
+	all, err := store.Documents(collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []map[string]interface{}{}
+	for _, doc := range all {
+		if q.Match(doc) {
+			docs = append(docs, doc)
+		}
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, sf := range q.SortFields {
+			cmp := compareValues(lookupField(docs[i], sf.Field), lookupField(docs[j], sf.Field))
+			if cmp == 0 {
+				continue
+			}
+			if sf.Direction == SORT_DESC {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+
+	// Queries built without Page may hold any offset
+	docs = docs[min(max(q.Offset, 0), len(docs)):]
+	if q.Limit >= 0 && q.Limit < len(docs) {
+		docs = docs[:q.Limit]
+	}
+
+	if len(q.Fields) < 1 && len(q.Relations) < 1 {
+		return docs, nil
+	}
+
+	projected := make([]map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		out := doc
+		if len(q.Fields) > 0 {
+			out = map[string]interface{}{}
+			for _, field := range q.Fields {
+				if val, ok := doc[field]; ok {
+					out[field] = val
+				}
+			}
+		}
+		if len(q.Relations) > 0 {
+			merged := map[string]interface{}{}
+			for k, v := range out {
+				merged[k] = v
+			}
+			for _, relation := range q.Relations {
+				var related interface{}
+				if id, ok := doc[relation.Field].(string); ok && strings.HasPrefix(id, relation.CollectionName+"/") {
+					relatedDoc, err := store.Document(id)
+					if err == nil {
+						related = relatedDoc
+					} else if !arangodriver.IsNotFoundGeneral(err) {
+						return nil, err
+					}
+				}
+				merged[relation.Field] = related
+			}
+			out = merged
+		}
+		projected[i] = out
+	}
+	return projected, nil
+}
+
+// Match reports whether doc satisfies q's filters.
+func (q Query) Match(doc map[string]interface{}) bool {
+	if len(q.Filters) < 1 {
+		return true
+	}
+	for _, conjunction := range q.Filters {
+		matched := true
+		for _, filter := range conjunction {
+			if !filter.Match(doc) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether doc satisfies the filter using AQL comparison
+// semantics.
+func (fk FilterKey) Match(doc map[string]interface{}) bool {
+	cmp := compareValues(lookupField(doc, fk.FieldName), normalizeValue(fk.Value))
+	switch fk.Operator {
+	case Eq:
+		return cmp == 0
+	case Neq:
+		return cmp != 0
+	case Gt:
+		return cmp > 0
+	case Lt:
+		return cmp < 0
+	case Geq:
+		return cmp >= 0
+	case Leq:
+		return cmp <= 0
+	case In:
+		field := lookupField(doc, fk.FieldName)
+		values, _ := normalizeValue(fk.Value).([]interface{})
+		for _, v := range values {
+			if compareValues(field, v) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type SortDirection string
+
+type FilterOperator string
+
+var (
+	SORT_ASC     = SortDirection("ASC")
+	SORT_DESC    = SortDirection("DESC")
+	Eq           = FilterOperator("==")
+	Neq          = FilterOperator("!=")
+	Gt           = FilterOperator(">")
+	Lt           = FilterOperator("<")
+	Geq          = FilterOperator(">=")
+	Leq          = FilterOperator("<=")
+	In           = FilterOperator("IN")
+	OPERATOR_MAP = map[string]FilterOperator{
+		"eq":  Eq,
+		"neq": Neq,
+		"gt":  Gt,
+		"lt":  Lt,
+		"geq": Geq,
+		"leq": Leq,
+	}
+)
+
+type FilterKey struct {
+	FieldName string
+	Operator  FilterOperator
+	Value     interface{}
+}
+
+func NewFilterKey(fieldName string, op FilterOperator, val interface{}) FilterKey {
+	return FilterKey{
+		FieldName: fieldName,
+		Operator:  op,
+		Value:     val,
+	}
+}
+
+// FilterKeyFromURLElement returns the filter of the query parameter key=val.
+// If val starts with an operator of OPERATOR_MAP and a space the field is
+// compared with the rest of val, kept as it is, otherwise with all of val for
+// equality.
+func FilterKeyFromURLElement(key, val string) FilterKey {
+	if name, rest, ok := strings.Cut(val, " "); ok {
+		if op, ok := OPERATOR_MAP[name]; ok {
+			return NewFilterKey(key, op, rest)
+		}
+	}
+	return NewFilterKey(key, Eq, val)
+}
+
+// Relation describes a field holding the _id of a document in another
+// collection.
+type Relation struct {
+	Field          string
+	CollectionName string
+}
+
+// lookupField returns the value at the dot separated path in doc or nil if
+// there is none.
+func lookupField(doc map[string]interface{}, path string) interface{} {
+	var val interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		val = obj[name]
+	}
+	return val
+}
+
+// normalizeValue converts v to the types produced by decoding JSON so that it
+// can be compared with document values.
+func normalizeValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, bool, float64, string, []interface{}, map[string]interface{}:
+		return v
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err = json.Unmarshal(raw, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// compareValues compares a and b using AQL's ordering, in which values of
+// different types order null < bool < number < string < array < object.
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	case float64:
+		bv := b.(float64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(av, b.(string))
+	case []interface{}:
+		bv := b.([]interface{})
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			if cmp := compareValues(av[i], bv[i]); cmp != 0 {
+				return cmp
+			}
+		}
+		return len(av) - len(bv)
+	case map[string]interface{}:
+		ja, _ := json.Marshal(av)
+		jb, _ := json.Marshal(b)
+		return strings.Compare(string(ja), string(jb))
+	}
+	return 0
+}
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	}
+	return 5
+}
@@ -0,0 +1,106 @@
+package trade_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gabriel-ross/trade"
+)
+
+func TestQueryFromURLParams(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []trade.FilterKey
+	}{
+		{"name=Ada", []trade.FilterKey{trade.NewFilterKey("name", trade.Eq, "Ada")}},
+		{"name=Jane+Doe", []trade.FilterKey{trade.NewFilterKey("name", trade.Eq, "Jane Doe")}},
+		{"name=Jane%20Doe", []trade.FilterKey{trade.NewFilterKey("name", trade.Eq, "Jane Doe")}},
+		{"name=eq+Jane+Doe", []trade.FilterKey{trade.NewFilterKey("name", trade.Eq, "Jane Doe")}},
+		{"name=neq+Jane++Doe+", []trade.FilterKey{trade.NewFilterKey("name", trade.Neq, "Jane  Doe ")}},
+		{"name=between+A+and+B", []trade.FilterKey{trade.NewFilterKey("name", trade.Eq, "between A and B")}},
+		{"name=geq+A&reputation=lt+50", []trade.FilterKey{trade.NewFilterKey("name", trade.Geq, "A"), trade.NewFilterKey("reputation", trade.Lt, "50")}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+		q, err := trade.QueryFromURLParams[trade.User](r, []string{"name", "reputation"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if len(q.Filters) != 1 || !reflect.DeepEqual(q.Filters[0], tt.want) {
+			t.Errorf("%s: filters are %+v, want %+v", tt.query, q.Filters, tt.want)
+		}
+	}
+}
+
+func TestQueryFromURLParamsProjection(t *testing.T) {
+	relations := map[string]trade.Relation{"owner": {Field: "owner", CollectionName: "users"}}
+	r := httptest.NewRequest(http.MethodGet, "/accounts?fields=owner,+reputation&expand=owner&sort=reputation+desc,balances.dollars,_key", nil)
+	q, err := trade.QueryFromURLParams[trade.Account](r, nil, relations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSort := []trade.SortField{
+		{Field: "reputation", Direction: trade.SORT_DESC},
+		{Field: "balances.dollars", Direction: trade.SORT_ASC},
+		{Field: "_key", Direction: trade.SORT_ASC},
+	}
+	if !reflect.DeepEqual(q.Fields, []string{"owner", "reputation"}) || !reflect.DeepEqual(q.Relations, []trade.Relation{relations["owner"]}) ||
+		!reflect.DeepEqual(q.SortFields, wantSort) || !q.IsProjected() {
+		t.Errorf("query is %+v", q)
+	}
+
+	for query, want := range map[string][]trade.FieldError{
+		"fields=owner,ownr":                 {{Field: "fields", Code: trade.CODE_UNKNOWN_FIELD}},
+		"sort=name&fields=balances.x,email": {{Field: "fields", Code: trade.CODE_UNKNOWN_FIELD}, {Field: "sort", Code: trade.CODE_UNKNOWN_FIELD}},
+	} {
+		_, err := trade.QueryFromURLParams[trade.Account](httptest.NewRequest(http.MethodGet, "/accounts?"+query, nil), nil, relations)
+		if got := codes(err); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: returned %v, want %v", query, got, want)
+		}
+	}
+	for query, want := range map[string][]trade.FieldError{
+		"limit=abc":           {{Field: "limit", Code: trade.CODE_INVALID_TYPE}},
+		"limit=0&offset=-1":   {{Field: "limit", Code: trade.CODE_NOT_POSITIVE}, {Field: "offset", Code: trade.CODE_NEGATIVE}},
+		"limit=-1&offset=1.5": {{Field: "limit", Code: trade.CODE_NOT_POSITIVE}, {Field: "offset", Code: trade.CODE_INVALID_TYPE}},
+	} {
+		_, err := trade.QueryFromURLParams[trade.Account](httptest.NewRequest(http.MethodGet, "/accounts?"+query, nil), nil, relations)
+		if got := codes(err); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: returned %v, want %v", query, got, want)
+		}
+	}
+	if _, err = trade.QueryFromURLParams[trade.Account](httptest.NewRequest(http.MethodGet, "/accounts?expand=sender", nil), nil, relations); err == nil {
+		t.Errorf("expanding an unknown relation succeeded")
+	}
+}
+
+func TestQueryNegativePage(t *testing.T) {
+	db := trade.NewMemoryDatabase()
+	repo := trade.NewMemoryRepository[trade.User](db, "users")
+	for _, name := range []string{"Ada", "Bob"} {
+		if _, _, err := repo.Create(context.Background(), trade.User{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, q := range []trade.Query{
+		trade.NewQuery().Page(-5, 10),
+		trade.NewQuery().Page(-5, -10),
+		{Offset: -5, Limit: -10},
+	} {
+		docs, err := q.Evaluate(db, "users")
+		if err != nil || len(docs) != 2 {
+			t.Errorf("%+v: Evaluate returned %d documents and %v, want every document", q, len(docs), err)
+		}
+		if aql, bindVars := q.AQL("users"); strings.Contains(aql, "LIMIT") && bindVars["offset"] != 0 {
+			t.Errorf("%+v: AQL is %s with offset %v", q, aql, bindVars["offset"])
+		}
+	}
+	if q := trade.NewQuery().Page(-5, -10); q.Offset != 0 || q.Limit != -1 {
+		t.Errorf("Page(-5, -10) is offset %d and limit %d, want 0 and -1", q.Offset, q.Limit)
+	}
+}
@@ -1,54 +1,235 @@
 package trade
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"reflect"
+	"strings"
 )
 
-type RenderService struct{}
+// RenderService renders responses in the media type negotiated with the
+// Accept header of each request. The zero value encodes JSON, CSV, NDJSON and
+// MessagePack, preferring JSON.
+type RenderService struct {
+	encoders []mediaEncoder
+}
+
+// NewRenderService returns a RenderService configured by options.
+func NewRenderService(options ...func(*RenderService)) *RenderService {
+	rs := &RenderService{encoders: defaultEncoders()}
+	for _, option := range options {
+		option(rs)
+	}
+	return rs
+}
+
+// WithEncoder is a RenderService functional option that encodes responses in
+// mediaType with enc, replacing the encoder of mediaType if it has one.
+func WithEncoder(mediaType string, enc Encoder) func(*RenderService) {
+	return func(rs *RenderService) {
+		for i, me := range rs.encoders {
+			if me.mediaType == mediaType {
+				rs.encoders[i].encoder = enc
+				return
+			}
+		}
+		rs.encoders = append(rs.encoders, mediaEncoder{mediaType, enc})
+	}
+}
+
+func (rs *RenderService) mediaEncoders() []mediaEncoder {
+	if rs.encoders == nil {
+		return defaultEncoders()
+	}
+	return rs.encoders
+}
 
+// RenderJSON renders body, which is encoded as JSON unless the request
+// accepts another of the media types of rs. Requests accepting none of them
+// are refused with 406 Not Acceptable. If body is an envelope with a next
+// cursor, the URL of the next page is also sent in a Link header, for the
+// media types that have nowhere to hold it.
 func (rs *RenderService) RenderJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, body interface{}) {
-	out, err := json.MarshalIndent(body, "", "	")
-	if err != nil {
+	me, ok := negotiate(r, rs.mediaEncoders())
+	if !ok {
+		rs.renderNotAcceptable(w, r)
+		return
+	}
+
+	var out bytes.Buffer
+	if err := me.encoder.Encode(&out, r, body); err != nil {
 		rs.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 		return
 	}
 
+	w.Header().Set("Content-Type", me.mediaType)
+	w.Header().Add("Vary", "Accept")
+	if next := nextCursor(body); next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL(r, next)))
+	}
 	w.WriteHeader(httpStatusCode)
-	w.Write(out)
+	w.Write(out.Bytes())
+}
+
+// Streams reports whether the response to r is negotiated to NDJSON, which
+// RenderStream writes row by row.
+func (rs *RenderService) Streams(r *http.Request) bool {
+	me, ok := negotiate(r, rs.mediaEncoders())
+	return ok && me.mediaType == MEDIA_TYPE_NDJSON
+}
+
+// RenderStream writes each row stream emits as a line of NDJSON as soon as it
+// is emitted, so that large results are never held in memory. If stream fails
+// before emitting a row the error is rendered as usual; once rows have been
+// sent the response is aborted, so that the client sees it is incomplete.
+func (rs *RenderService) RenderStream(w http.ResponseWriter, r *http.Request, httpStatusCode int, stream func(emit func(row interface{}) error) error) {
+	enc := json.NewEncoder(w)
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", MEDIA_TYPE_NDJSON)
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(httpStatusCode)
+		started = true
+	}
+
+	err := stream(func(row interface{}) error {
+		first := !started
+		if first {
+			start()
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+		// Send the headers and first row without waiting for a full buffer
+		if flusher, ok := w.(http.Flusher); ok && first {
+			flusher.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !started:
+		rs.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+	case err != nil:
+		log.Printf("%s %s: request %s: stream failed: %v", r.Method, r.URL.Path, RequestIDFromContext(r.Context()), err)
+		panic(http.ErrAbortHandler)
+	case !started:
+		start()
+	}
 }
 
+// renderNotAcceptable refuses a request that accepts none of the media types
+// of rs.
+func (rs *RenderService) renderNotAcceptable(w http.ResponseWriter, r *http.Request) {
+	encoders := rs.mediaEncoders()
+	mediaTypes := make([]string, len(encoders))
+	for i, me := range encoders {
+		mediaTypes[i] = me.mediaType
+	}
+	rs.RenderError(w, r, nil, http.StatusNotAcceptable, "responses are available as %s", strings.Join(mediaTypes, ", "))
+}
+
+// nextCursor returns the next cursor of an envelope body, if it has one.
+func nextCursor(body interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(body))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if next := v.FieldByName("Next"); next.IsValid() && next.Kind() == reflect.String {
+		return next.String()
+	}
+	return ""
+}
+
+// nextURL returns the URL of r with its cursor set to next.
+func nextURL(r *http.Request, next string) string {
+	u := *r.URL
+	query := u.Query()
+	query.Set("cursor", next)
+	query.Del("offset")
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+// RenderError writes an RFC 7807 problem details response. The kind of svrErr,
+// as returned by ErrorKindOf, sets the code and status of the problem, unless
+// the handler rendered it with another status than that of the kind. Errors
+// rendered with 500 Internal Server Error, which the handler did not
+// anticipate, are reported by their kind too, so that for example a document
+// that went missing is reported as not found. The detail of internal errors is
+// logged rather than sent. A request body that exceeded its size limit is
+// always reported as payload_too_large, whichever handler read it.
 func (rs *RenderService) RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any) {
 	var err error
-	errResp := rs.newErrorResponse(code, svrErr, format, args...)
-	respBody, err := json.Marshal(errResp)
+	kind, ok := ErrorKindOf(svrErr)
+	if !ok || (code != kind.Status && code != http.StatusInternalServerError && kind != ERROR_PAYLOAD_TOO_LARGE) {
+		kind = ErrorKindForStatus(code)
+	}
+
+	p := newProblem(r, kind, svrErr, fmt.Sprintf(format, args...))
+	if kind == ERROR_INTERNAL {
+		hideInternalError(r, &p)
+	}
+	respBody, err := json.Marshal(p)
 	if err != nil {
 		rs.mustWriteError(w, err)
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(code)
+	w.Header().Set("Content-Type", MEDIA_TYPE_PROBLEM)
+	w.WriteHeader(kind.Status)
 	w.Write(respBody)
 }
 
-func (rs *RenderService) newErrorResponse(code int, err error, format string, args ...any) *errorResponse {
-	return &errorResponse{
-		Err:            err,
-		HTTPStatusCode: code,
-		ErrorText:      fmt.Sprintf(format, args...),
-	}
-}
 func (rs *RenderService) mustWriteError(w http.ResponseWriter, err error) {
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte("error encountered while attempting to write error: " + err.Error()))
 }
 
-type errorResponse struct {
-	Err            error  `json:"-"`
-	HTTPStatusCode int    `json:"-"`
-	StatusText     string `json:"-"`
-	AppCode        int64  `json:"code,omitempty"`
-	ErrorText      string `json:"error,omitempty"`
+// MEDIA_TYPE_PROBLEM is the media type of error responses.
+var MEDIA_TYPE_PROBLEM = "application/problem+json"
+
+// ERROR_DOCS_URL is the documentation of the error codes. The type of each
+// problem links to the section on its code.
+var ERROR_DOCS_URL = "https://github.com/gabriel-ross/trade/blob/main/docs/errors.md"
+
+// problem is an RFC 7807 problem details object, extended with the stable
+// code of the error, the ID of the request and the failing fields of a request
+// that failed validation.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// hideInternalError logs the detail of a problem of kind ERROR_INTERNAL and
+// replaces it with a generic one, so that internals are not sent to clients.
+func hideInternalError(r *http.Request, p *problem) {
+	log.Printf("%s %s: request %s: %s", r.Method, r.URL.Path, p.RequestID, p.Detail)
+	p.Detail = "an unexpected error occurred, quote the request ID when reporting it"
+}
+
+func newProblem(r *http.Request, kind ErrorKind, err error, detail string) problem {
+	p := problem{
+		Type:      ERROR_DOCS_URL + "#" + kind.Code,
+		Title:     kind.Title,
+		Status:    kind.Status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      kind.Code,
+		RequestID: RequestIDFromContext(r.Context()),
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		p.Fields = validationErr.Errors
+	}
+	return p
 }
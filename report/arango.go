@@ -0,0 +1,137 @@
+package report
+
+import (
+	"context"
+	"fmt"
+
+	arango "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+)
+
+type repository struct {
+	database                  arango.Database
+	transactionCollectionName string
+	accountCollectionName     string
+}
+
+func NewRepository(dbClient arango.Database, transactionCollectionName, accountCollectionName string) *repository {
+	return &repository{
+		database:                  dbClient,
+		transactionCollectionName: transactionCollectionName,
+		accountCollectionName:     accountCollectionName,
+	}
+}
+
+// Volume returns the volume of each currency moved per interval.
+func (r *repository) Volume(ctx context.Context, interval Interval, filters []trade.FilterKey) ([]Volume, error) {
+	bindVars := map[string]interface{}{
+		"format": INTERVAL_FORMATS[interval],
+	}
+	period := "DATE_FORMAT(x.timestamp, @format)"
+	if interval == Week {
+		period = "CONCAT(DATE_ISOWEEKYEAR(x.timestamp).year, " + period + ")"
+	}
+	query := r.transactions(filters, bindVars) + `
+	FOR c IN ATTRIBUTES(x.quantities)
+	COLLECT period = ` + period + `, currency = c
+	AGGREGATE volume = SUM(x.quantities[c]), transactions = COUNT(1)
+	SORT period, currency
+	RETURN {period, currency, volume, transactions}`
+
+	return queryAll[Volume](ctx, r.database, query, bindVars)
+}
+
+// TopAccounts returns the limit accounts that moved the greatest quantity of
+// a currency in role. If currency is empty every currency is ranked together.
+func (r *repository) TopAccounts(ctx context.Context, role Role, currency string, limit int, filters []trade.FilterKey) ([]AccountTotal, error) {
+	bindVars := map[string]interface{}{
+		"limit": limit,
+	}
+	currencyFilter := ""
+	if currency != "" {
+		currencyFilter = "\n\tFILTER c == @currency"
+		bindVars["currency"] = currency
+	}
+
+	query := r.transactions(filters, bindVars) + `
+	FOR c IN ATTRIBUTES(x.quantities)` + currencyFilter + fmt.Sprintf(`
+	COLLECT account = x.%s, currency = c
+	AGGREGATE total = SUM(x.quantities[c]), transactions = COUNT(1)
+	SORT total DESC
+	LIMIT @limit
+	RETURN {account, currency, total, transactions}`, role)
+
+	return queryAll[AccountTotal](ctx, r.database, query, bindVars)
+}
+
+// AverageSize returns the mean quantity of each currency per transaction.
+func (r *repository) AverageSize(ctx context.Context, filters []trade.FilterKey) ([]Average, error) {
+	bindVars := map[string]interface{}{}
+	query := r.transactions(filters, bindVars) + `
+	FOR c IN ATTRIBUTES(x.quantities)
+	COLLECT currency = c
+	AGGREGATE average = AVERAGE(x.quantities[c]), transactions = COUNT(1)
+	SORT currency
+	RETURN {currency, average, transactions}`
+
+	return queryAll[Average](ctx, r.database, query, bindVars)
+}
+
+// ActiveAccounts counts the accounts that took part in a transaction.
+func (r *repository) ActiveAccounts(ctx context.Context, filters []trade.FilterKey) (ActiveAccounts, error) {
+	bindVars := map[string]interface{}{
+		"@accounts": r.accountCollectionName,
+	}
+	query := `LET active = (
+	` + r.transactions(filters, bindVars) + `
+	FOR a IN [x._from, x._to]
+	RETURN DISTINCT a
+)
+LET total = FIRST(
+	FOR a IN @@accounts
+	COLLECT AGGREGATE n = COUNT(1)
+	RETURN n
+)
+RETURN {activeAccounts: LENGTH(active), totalAccounts: total}`
+
+	results, err := queryAll[ActiveAccounts](ctx, r.database, query, bindVars)
+	if err != nil {
+		return ActiveAccounts{}, err
+	}
+	if len(results) < 1 {
+		return ActiveAccounts{}, nil
+	}
+	return results[0], nil
+}
+
+// transactions returns the FOR and FILTER clauses selecting the transactions
+// matching filters, adding their bind parameters to bindVars.
+func (r *repository) transactions(filters []trade.FilterKey, bindVars map[string]interface{}) string {
+	bindVars["@transactions"] = r.transactionCollectionName
+	query := "FOR x IN @@transactions"
+	if filter := trade.NewQuery().Where(filters...).FilterAQL("x", bindVars); filter != "" {
+		query += " FILTER " + filter
+	}
+	return query
+}
+
+// queryAll runs query and binds every resulting document to a T.
+func queryAll[T any](ctx context.Context, db arango.Database, query string, bindVars map[string]interface{}) ([]T, error) {
+	var err error
+	results := []T{}
+
+	cur, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	for cur.HasMore() {
+		var data T
+		if _, err = cur.ReadDocument(ctx, &data); err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
@@ -0,0 +1,120 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gabriel-ross/trade"
+)
+
+var (
+	DEFAULT_TOP_LIMIT = 10
+	// FILTER_PARAMS are the transaction fields reports can be filtered on. A
+	// parameter may be repeated to bound a range, e.g.
+	// ?timestamp=geq+2023-01-01&timestamp=lt+2023-02-01.
+	FILTER_PARAMS = []string{"timestamp"}
+)
+
+type response[T []Volume | []AccountTotal | []Average | ActiveAccounts] struct {
+	Data T `json:"data"`
+}
+
+func newResponse[T []Volume | []AccountTotal | []Average | ActiveAccounts](data T) response[T] {
+	return response[T]{Data: data}
+}
+
+func (s *service) handleVolume() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		interval := Day
+		if val := r.URL.Query().Get("interval"); val != "" {
+			interval = Interval(val)
+		}
+		if _, ok := INTERVAL_FORMATS[interval]; !ok {
+			err = fmt.Errorf("unknown interval %q", interval)
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		resp, err := s.database.Volume(ctx, interval, filtersFromURLParams(r))
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+func (s *service) handleTopAccounts(role Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		limit := DEFAULT_TOP_LIMIT
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit < 1 {
+				s.renderer.RenderError(w, r, err, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+		}
+
+		resp, err := s.database.TopAccounts(ctx, role, r.URL.Query().Get("currency"), limit, filtersFromURLParams(r))
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+func (s *service) handleAverageSize() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		resp, err := s.database.AverageSize(ctx, filtersFromURLParams(r))
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+func (s *service) handleActiveAccounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		resp, err := s.database.ActiveAccounts(ctx, filtersFromURLParams(r))
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+	}
+}
+
+// filtersFromURLParams returns a filter for every value of every
+// FILTER_PARAMS parameter in r, using the same key=operator+value syntax as
+// the list endpoints.
+func filtersFromURLParams(r *http.Request) []trade.FilterKey {
+	filters := []trade.FilterKey{}
+	for _, param := range FILTER_PARAMS {
+		for _, val := range r.URL.Query()[param] {
+			if val != "" {
+				filters = append(filters, trade.FilterKeyFromURLElement(param, val))
+			}
+		}
+	}
+	return filters
+}
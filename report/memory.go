@@ -0,0 +1,196 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+)
+
+type memoryRepository struct {
+	database                  trade.DocumentStore
+	transactionCollectionName string
+	accountCollectionName     string
+}
+
+func NewMemoryRepository(db trade.DocumentStore, transactionCollectionName, accountCollectionName string) *memoryRepository {
+	return &memoryRepository{
+		database:                  db,
+		transactionCollectionName: transactionCollectionName,
+		accountCollectionName:     accountCollectionName,
+	}
+}
+
+// Volume returns the volume of each currency moved per interval.
+func (r *memoryRepository) Volume(ctx context.Context, interval Interval, filters []trade.FilterKey) ([]Volume, error) {
+	transactions, err := r.transactions(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[[2]string]*Volume{}
+	for _, t := range transactions {
+		period := formatPeriod(t.Timestamp, interval)
+		for currency, quantity := range t.Quantities {
+			k := [2]string{period, currency}
+			if totals[k] == nil {
+				totals[k] = &Volume{Period: period, Currency: currency}
+			}
+			totals[k].Volume += quantity
+			totals[k].Transactions++
+		}
+	}
+
+	results := []Volume{}
+	for _, v := range totals {
+		results = append(results, *v)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Period != results[j].Period {
+			return results[i].Period < results[j].Period
+		}
+		return results[i].Currency < results[j].Currency
+	})
+	return results, nil
+}
+
+// TopAccounts returns the limit accounts that moved the greatest quantity of
+// a currency in role. If currency is empty every currency is ranked together.
+func (r *memoryRepository) TopAccounts(ctx context.Context, role Role, currency string, limit int, filters []trade.FilterKey) ([]AccountTotal, error) {
+	transactions, err := r.transactions(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[[2]string]*AccountTotal{}
+	for _, t := range transactions {
+		account := t.Sender
+		if role == Recipients {
+			account = t.Recipient
+		}
+		for c, quantity := range t.Quantities {
+			if currency != "" && c != currency {
+				continue
+			}
+			k := [2]string{account, c}
+			if totals[k] == nil {
+				totals[k] = &AccountTotal{Account: account, Currency: c}
+			}
+			totals[k].Total += quantity
+			totals[k].Transactions++
+		}
+	}
+
+	results := []AccountTotal{}
+	for _, total := range totals {
+		results = append(results, *total)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Total != results[j].Total {
+			return results[i].Total > results[j].Total
+		}
+		return results[i].Account < results[j].Account
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// AverageSize returns the mean quantity of each currency per transaction.
+func (r *memoryRepository) AverageSize(ctx context.Context, filters []trade.FilterKey) ([]Average, error) {
+	transactions, err := r.transactions(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := map[string]float64{}
+	averages := map[string]*Average{}
+	for _, t := range transactions {
+		for currency, quantity := range t.Quantities {
+			if averages[currency] == nil {
+				averages[currency] = &Average{Currency: currency}
+			}
+			sums[currency] += quantity
+			averages[currency].Transactions++
+		}
+	}
+
+	results := []Average{}
+	for currency, average := range averages {
+		average.Average = sums[currency] / float64(average.Transactions)
+		results = append(results, *average)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Currency < results[j].Currency
+	})
+	return results, nil
+}
+
+// ActiveAccounts counts the accounts that took part in a transaction.
+func (r *memoryRepository) ActiveAccounts(ctx context.Context, filters []trade.FilterKey) (ActiveAccounts, error) {
+	transactions, err := r.transactions(filters)
+	if err != nil {
+		return ActiveAccounts{}, err
+	}
+
+	active := map[string]bool{}
+	for _, t := range transactions {
+		active[t.Sender] = true
+		active[t.Recipient] = true
+	}
+
+	accounts, err := r.database.Documents(r.accountCollectionName)
+	if err != nil {
+		return ActiveAccounts{}, err
+	}
+
+	return ActiveAccounts{
+		Active: len(active),
+		Total:  len(accounts),
+	}, nil
+}
+
+// transactions returns the transactions matching every filter.
+func (r *memoryRepository) transactions(filters []trade.FilterKey) ([]trade.Transaction, error) {
+	docs, err := r.database.Documents(r.transactionCollectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	q := trade.NewQuery().Where(filters...)
+	results := []trade.Transaction{}
+	for _, doc := range docs {
+		if !q.Match(doc) {
+			continue
+		}
+
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		var t trade.Transaction
+		if err = json.Unmarshal(raw, &t); err != nil {
+			return nil, err
+		}
+		results = append(results, t)
+	}
+	return results, nil
+}
+
+// formatPeriod names the interval t falls in the same way as the
+// INTERVAL_FORMATS passed to AQL's DATE_FORMAT.
+func formatPeriod(t time.Time, interval Interval) string {
+	t = t.UTC()
+	switch interval {
+	case Week:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case Month:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
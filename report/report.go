@@ -0,0 +1,57 @@
+package report
+
+// Interval is the width of the periods volume is bucketed into.
+type Interval string
+
+var (
+	Day   = Interval("day")
+	Week  = Interval("week")
+	Month = Interval("month")
+	// INTERVAL_FORMATS maps each interval to the AQL DATE_FORMAT string that
+	// names its periods. DATE_FORMAT has no placeholder for the ISO
+	// week-year, so Volume prefixes it to the Week format.
+	INTERVAL_FORMATS = map[Interval]string{
+		Day:   "%yyyy-%mm-%dd",
+		Week:  "-W%kk",
+		Month: "%yyyy-%mm",
+	}
+)
+
+// Role selects which side of a transaction an account is ranked by.
+type Role string
+
+var (
+	Senders    = Role("_from")
+	Recipients = Role("_to")
+)
+
+// Volume is the total quantity of a currency moved during a period.
+type Volume struct {
+	Period       string  `json:"period"`
+	Currency     string  `json:"currency"`
+	Volume       float64 `json:"volume"`
+	Transactions int     `json:"transactions"`
+}
+
+// AccountTotal is the total quantity of a currency an account sent or
+// received.
+type AccountTotal struct {
+	Account      string  `json:"account"`
+	Currency     string  `json:"currency"`
+	Total        float64 `json:"total"`
+	Transactions int     `json:"transactions"`
+}
+
+// Average is the mean quantity of a currency moved per transaction.
+type Average struct {
+	Currency     string  `json:"currency"`
+	Average      float64 `json:"average"`
+	Transactions int     `json:"transactions"`
+}
+
+// ActiveAccounts counts the accounts that sent or received at least one
+// transaction against the total number of accounts.
+type ActiveAccounts struct {
+	Active int `json:"activeAccounts"`
+	Total  int `json:"totalAccounts"`
+}
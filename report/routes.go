@@ -0,0 +1,16 @@
+package report
+
+import "github.com/go-chi/chi"
+
+// Routes returns a new chi router with all report routes mounted to it.
+func (s *service) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/volume", s.handleVolume())
+	r.Get("/top-senders", s.handleTopAccounts(Senders))
+	r.Get("/top-recipients", s.handleTopAccounts(Recipients))
+	r.Get("/average-size", s.handleAverageSize())
+	r.Get("/active-accounts", s.handleActiveAccounts())
+
+	return r
+}
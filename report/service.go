@@ -0,0 +1,54 @@
+package report
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gabriel-ross/trade"
+	"github.com/go-chi/chi"
+)
+
+// Repository is the API for computing aggregate reports over transactions and
+// accounts. filters restrict the transactions a report is computed over.
+type Repository interface {
+	Volume(ctx context.Context, interval Interval, filters []trade.FilterKey) ([]Volume, error)
+	TopAccounts(ctx context.Context, role Role, currency string, limit int, filters []trade.FilterKey) ([]AccountTotal, error)
+	AverageSize(ctx context.Context, filters []trade.FilterKey) ([]Average, error)
+	ActiveAccounts(ctx context.Context, filters []trade.FilterKey) (ActiveAccounts, error)
+}
+
+type Renderer interface {
+	RenderJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, body interface{})
+	RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any)
+}
+
+// Service houses the API and necessary dependencies for serving reports.
+type service struct {
+	router   chi.Router
+	database Repository
+	renderer Renderer
+}
+
+// New mounts the report routes on r at endpoint and returns a new report service.
+func New(r chi.Router, endpoint string, database Repository, renderer Renderer, options ...func(*service)) *service {
+	svc := &service{
+		router:   r,
+		database: database,
+		renderer: renderer,
+	}
+	r.Mount(endpoint, svc.Routes())
+
+	for _, option := range options {
+		option(svc)
+	}
+
+	return svc
+}
+
+// WithRepository is a functional option for configuring a report service's
+// repository upon instantiation.
+func WithRepository(repo Repository) func(*service) {
+	return func(s *service) {
+		s.database = repo
+	}
+}
@@ -0,0 +1,551 @@
+// Package repotest is a conformance suite for the repositories of package
+// trade. Every backend runs the same suite so that the services built on top
+// of them behave the same whichever backend an application is configured with.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	arango "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+)
+
+// Repository is the interface shared by the repositories of every backend.
+type Repository[T any] interface {
+	Create(ctx context.Context, data T) (string, T, error)
+	Query(ctx context.Context, q trade.Query) ([]T, error)
+	QueryEach(ctx context.Context, q trade.Query, fn func(data T) error) error
+	QueryRaw(ctx context.Context, q trade.Query) ([]map[string]interface{}, error)
+	Get(ctx context.Context, id string) (T, error)
+	Update(ctx context.Context, id string, data T) (T, error)
+	Replace(ctx context.Context, id string, data T) (T, error)
+	Delete(ctx context.Context, id string) error
+	Batch(ctx context.Context, ops []trade.BatchOperation[T], atomic bool) ([]trade.BatchResult[T], error)
+}
+
+// Document is the document type the suite stores. Every field is omitted when
+// empty so that updates only touch the fields they set.
+type Document struct {
+	ID     string            `json:"_id,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	Group  string            `json:"group,omitempty"`
+	Rank   int               `json:"rank,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Factory returns a repository of an empty collection. It is called once per
+// test and should register any cleanup with t.
+type Factory func(t *testing.T) Repository[Document]
+
+// fixtures are created in order by the tests that need a populated collection.
+var fixtures = []Document{
+	{Name: "ada", Group: "b", Rank: 3, Labels: map[string]string{"tier": "gold"}},
+	{Name: "bob", Group: "a", Rank: 1, Labels: map[string]string{"tier": "silver"}},
+	{Name: "cy", Group: "b", Rank: 5},
+	{Name: "dee", Group: "a", Rank: 4, Labels: map[string]string{"tier": "gold"}},
+	{Name: "eve", Group: "c", Rank: 2},
+}
+
+// Run runs the conformance suite against the repositories returned by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("CreateGet", func(t *testing.T) { testCreateGet(t, factory(t)) })
+	t.Run("GetNotFound", func(t *testing.T) { testGetNotFound(t, factory(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, factory(t)) })
+	t.Run("UpdateNotFound", func(t *testing.T) { testUpdateNotFound(t, factory(t)) })
+	t.Run("Replace", func(t *testing.T) { testReplace(t, factory(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory(t)) })
+	t.Run("DeleteNotFound", func(t *testing.T) { testDeleteNotFound(t, factory(t)) })
+	t.Run("Revisions", func(t *testing.T) { testRevisions(t, factory(t)) })
+	t.Run("FilterOperators", func(t *testing.T) { testFilterOperators(t, factory(t)) })
+	t.Run("FilterCombinations", func(t *testing.T) { testFilterCombinations(t, factory(t)) })
+	t.Run("Sort", func(t *testing.T) { testSort(t, factory(t)) })
+	t.Run("SortStability", func(t *testing.T) { testSortStability(t, factory(t)) })
+	t.Run("Limit", func(t *testing.T) { testLimit(t, factory(t)) })
+	t.Run("QueryEach", func(t *testing.T) { testQueryEach(t, factory(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, factory(t)) })
+	t.Run("Projection", func(t *testing.T) { testProjection(t, factory(t)) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, factory(t)) })
+	t.Run("BatchAtomic", func(t *testing.T) { testBatchAtomic(t, factory(t)) })
+}
+
+func testCreateGet(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	want := fixtures[0]
+
+	id, _, err := repo.Create(ctx, want)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if trade.DocumentKey(id) == id {
+		t.Fatalf("Create returned %q, want a document _id", id)
+	}
+	want.ID = id
+
+	got, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", id, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get(%q) = %+v, want %+v", id, got, want)
+	}
+
+	got, err = repo.Get(ctx, trade.DocumentKey(id))
+	if err != nil {
+		t.Fatalf("Get(%q): %v", trade.DocumentKey(id), err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get(%q) = %+v, want %+v", trade.DocumentKey(id), got, want)
+	}
+}
+
+func testGetNotFound(t *testing.T, repo Repository[Document]) {
+	_, err := repo.Get(context.Background(), "missing")
+	assertNotFound(t, "Get", err)
+}
+
+func testUpdate(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	id := create(t, repo, fixtures[0])[0]
+
+	got, err := repo.Update(ctx, id, Document{Name: "ada lovelace", Labels: map[string]string{"team": "engines"}})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	want := Document{
+		ID:     id,
+		Name:   "ada lovelace",
+		Group:  fixtures[0].Group,
+		Rank:   fixtures[0].Rank,
+		Labels: map[string]string{"tier": "gold", "team": "engines"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Update returned %+v, want %+v", got, want)
+	}
+
+	got, err = repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get after Update = %+v, want %+v", got, want)
+	}
+}
+
+func testUpdateNotFound(t *testing.T, repo Repository[Document]) {
+	_, err := repo.Update(context.Background(), "missing", Document{Name: "x"})
+	assertNotFound(t, "Update", err)
+}
+
+func testReplace(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	id := create(t, repo, fixtures[0])[0]
+
+	got, err := repo.Replace(ctx, id, Document{Name: "ada lovelace", Labels: map[string]string{"team": "engines"}})
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	want := Document{ID: id, Name: "ada lovelace", Labels: map[string]string{"team": "engines"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Replace returned %+v, want %+v", got, want)
+	}
+
+	got, err = repo.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get after Replace = %+v, want %+v", got, want)
+	}
+
+	_, err = repo.Replace(ctx, "missing", Document{Name: "x"})
+	assertNotFound(t, "Replace", err)
+}
+
+func testDelete(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	ids := create(t, repo, fixtures[:2]...)
+
+	if err := repo.Delete(ctx, ids[0]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err := repo.Get(ctx, ids[0])
+	assertNotFound(t, "Get after Delete", err)
+
+	if _, err = repo.Get(ctx, ids[1]); err != nil {
+		t.Errorf("Get of a document that was not deleted: %v", err)
+	}
+}
+
+func testDeleteNotFound(t *testing.T, repo Repository[Document]) {
+	err := repo.Delete(context.Background(), "missing")
+	assertNotFound(t, "Delete", err)
+}
+
+func testBatch(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	ids := create(t, repo, fixtures[:2]...)
+
+	results, err := repo.Batch(ctx, []trade.BatchOperation[Document]{
+		{Op: trade.BATCH_CREATE, Data: fixtures[2]},
+		{Op: trade.BATCH_UPDATE, ID: ids[0], Data: Document{Rank: 9}},
+		{Op: trade.BATCH_DELETE, ID: ids[1]},
+		{Op: trade.BATCH_UPDATE, ID: "missing", Data: Document{Rank: 9}},
+		{Op: trade.BATCH_CREATE, Data: fixtures[3]},
+	}, false)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Batch returned %d results, want 5", len(results))
+	}
+	for i, result := range results {
+		if i != 3 && result.Err != nil {
+			t.Errorf("operation %d of the batch failed: %v", i, result.Err)
+		}
+	}
+	if results[0].Data.Name != fixtures[2].Name || results[0].ID == "" || results[0].Data.ID != results[0].ID {
+		t.Errorf("created document is %s %+v", results[0].ID, results[0].Data)
+	}
+	if results[1].Data.Rank != 9 || results[1].Data.Name != fixtures[0].Name {
+		t.Errorf("updated document is %+v", results[1].Data)
+	}
+	assertNotFound(t, "Update in a batch", results[3].Err)
+
+	_, err = repo.Get(ctx, ids[1])
+	assertNotFound(t, "Get after a batch deletion", err)
+	assertNames(t, repo, trade.NewQuery().Sort(trade.SortField{Field: "name", Direction: trade.SORT_ASC}), []string{"ada", "cy", "dee"})
+}
+
+func testBatchAtomic(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	ids := create(t, repo, fixtures[:2]...)
+
+	results, err := repo.Batch(ctx, []trade.BatchOperation[Document]{
+		{Op: trade.BATCH_CREATE, Data: fixtures[2]},
+		{Op: trade.BATCH_UPDATE, ID: ids[0], Data: Document{Rank: 9}},
+		{Op: trade.BATCH_DELETE, ID: ids[1]},
+		{Op: trade.BATCH_DELETE, ID: "missing"},
+	}, true)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	for i, result := range results[:3] {
+		if !errors.Is(result.Err, trade.ErrBatchAborted) {
+			t.Errorf("operation %d of a failed atomic batch returned %v, want %v", i, result.Err, trade.ErrBatchAborted)
+		}
+	}
+	assertNotFound(t, "Delete in an atomic batch", results[3].Err)
+
+	got, err := repo.Get(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Rank != fixtures[0].Rank {
+		t.Errorf("document updated by a failed atomic batch has rank %d, want %d", got.Rank, fixtures[0].Rank)
+	}
+	assertNames(t, repo, trade.NewQuery().Sort(trade.SortField{Field: "name", Direction: trade.SORT_ASC}), []string{"ada", "bob"})
+}
+
+func testRevisions(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	id := create(t, repo, fixtures[0])[0]
+	rev := revision(t, repo, id)
+
+	_, err := repo.Update(trade.WithRevision(ctx, rev+"x"), id, Document{Name: "stale"})
+	if !arango.IsPreconditionFailed(err) {
+		t.Errorf("Update of another revision returned %v, want precondition failed", err)
+	}
+	if _, err = repo.Update(trade.WithRevision(ctx, rev), id, Document{Name: "fresh"}); err != nil {
+		t.Fatalf("Update of the current revision: %v", err)
+	}
+	updated := revision(t, repo, id)
+	if updated == rev {
+		t.Errorf("Update kept revision %q", rev)
+	}
+
+	if _, err = repo.Replace(trade.WithRevision(ctx, rev), id, Document{Name: "stale"}); !arango.IsPreconditionFailed(err) {
+		t.Errorf("Replace of a replaced revision returned %v, want precondition failed", err)
+	}
+	if err = repo.Delete(trade.WithRevision(ctx, rev), id); !arango.IsPreconditionFailed(err) {
+		t.Errorf("Delete of a replaced revision returned %v, want precondition failed", err)
+	}
+	if err = repo.Delete(trade.WithRevision(ctx, updated), id); err != nil {
+		t.Errorf("Delete of the current revision: %v", err)
+	}
+}
+
+func testFilterOperators(t *testing.T, repo Repository[Document]) {
+	create(t, repo, fixtures...)
+
+	tests := []struct {
+		filter trade.FilterKey
+		want   []string
+	}{
+		{trade.NewFilterKey("rank", trade.Eq, 3), []string{"ada"}},
+		{trade.NewFilterKey("rank", trade.Neq, 3), []string{"bob", "cy", "dee", "eve"}},
+		{trade.NewFilterKey("rank", trade.Gt, 3), []string{"cy", "dee"}},
+		{trade.NewFilterKey("rank", trade.Lt, 3), []string{"bob", "eve"}},
+		{trade.NewFilterKey("rank", trade.Geq, 3), []string{"ada", "cy", "dee"}},
+		{trade.NewFilterKey("rank", trade.Leq, 3), []string{"ada", "bob", "eve"}},
+		{trade.NewFilterKey("group", trade.Eq, "a"), []string{"bob", "dee"}},
+		{trade.NewFilterKey("name", trade.Gt, "cy"), []string{"dee", "eve"}},
+		{trade.NewFilterKey("labels.tier", trade.Eq, "gold"), []string{"ada", "dee"}},
+		{trade.NewFilterKey("name", trade.In, []string{"bob", "cy", "zed"}), []string{"bob", "cy"}},
+		{trade.NewFilterKey("rank", trade.In, []int{2, 4}), []string{"dee", "eve"}},
+	}
+	for _, tt := range tests {
+		q := trade.NewQuery().Where(tt.filter).Sort(trade.SortField{Field: "name", Direction: trade.SORT_ASC})
+		assertNames(t, repo, q, tt.want)
+	}
+}
+
+func testFilterCombinations(t *testing.T, repo Repository[Document]) {
+	create(t, repo, fixtures...)
+	byName := trade.SortField{Field: "name", Direction: trade.SORT_ASC}
+
+	q := trade.NewQuery().
+		Where(trade.NewFilterKey("group", trade.Eq, "b"), trade.NewFilterKey("rank", trade.Gt, 3)).
+		Sort(byName)
+	assertNames(t, repo, q, []string{"cy"})
+
+	q = trade.NewQuery().
+		Where(trade.NewFilterKey("group", trade.Eq, "c")).
+		OrWhere(trade.NewFilterKey("rank", trade.Eq, 1)).
+		Sort(byName)
+	assertNames(t, repo, q, []string{"bob", "eve"})
+
+	q = trade.NewQuery().
+		Where(trade.NewFilterKey("group", trade.Eq, "a")).
+		OrWhere(trade.NewFilterKey("group", trade.Eq, "b")).
+		Where(trade.NewFilterKey("rank", trade.Geq, 4)).
+		Sort(byName)
+	assertNames(t, repo, q, []string{"cy", "dee"})
+
+	q = trade.NewQuery().
+		Where(trade.NewFilterKey("rank", trade.Leq, 3)).
+		WhereAny(trade.NewFilterKey("group", trade.Eq, "a"), trade.NewFilterKey("labels.tier", trade.Eq, "gold")).
+		Sort(byName)
+	assertNames(t, repo, q, []string{"ada", "bob"})
+}
+
+func testSort(t *testing.T, repo Repository[Document]) {
+	create(t, repo, fixtures...)
+
+	q := trade.NewQuery().Sort(trade.SortField{Field: "rank", Direction: trade.SORT_ASC})
+	assertNames(t, repo, q, []string{"bob", "eve", "ada", "dee", "cy"})
+
+	q = trade.NewQuery().Sort(trade.SortField{Field: "rank", Direction: trade.SORT_DESC})
+	assertNames(t, repo, q, []string{"cy", "dee", "ada", "eve", "bob"})
+
+	q = trade.NewQuery().Sort(
+		trade.SortField{Field: "group", Direction: trade.SORT_ASC},
+		trade.SortField{Field: "rank", Direction: trade.SORT_DESC},
+	)
+	assertNames(t, repo, q, []string{"dee", "bob", "cy", "ada", "eve"})
+}
+
+// testSortStability checks that a sort with ties returns the same order every
+// time it runs, so that clients paging through it neither skip nor repeat a
+// document.
+func testSortStability(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	create(t, repo, fixtures...)
+	create(t, repo, fixtures...)
+
+	q := trade.NewQuery().Sort(trade.SortField{Field: "group", Direction: trade.SORT_ASC})
+	first, err := repo.Query(ctx, q)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		again, err := repo.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if !reflect.DeepEqual(ids(again), ids(first)) {
+			t.Fatalf("sorted query returned %v then %v", ids(first), ids(again))
+		}
+	}
+
+	var paged []Document
+	for offset := 0; offset < len(first); offset += 3 {
+		page, err := repo.Query(ctx, q.Page(offset, 3))
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		paged = append(paged, page...)
+	}
+	if !reflect.DeepEqual(ids(paged), ids(first)) {
+		t.Errorf("pages of a sorted query returned %v, want %v", ids(paged), ids(first))
+	}
+}
+
+func testLimit(t *testing.T, repo Repository[Document]) {
+	create(t, repo, fixtures...)
+	byName := trade.SortField{Field: "name", Direction: trade.SORT_ASC}
+
+	assertNames(t, repo, trade.NewQuery().Sort(byName), []string{"ada", "bob", "cy", "dee", "eve"})
+	assertNames(t, repo, trade.NewQuery().Sort(byName).Page(0, 2), []string{"ada", "bob"})
+	assertNames(t, repo, trade.NewQuery().Sort(byName).Page(0, 0), []string{})
+	assertNames(t, repo, trade.NewQuery().Sort(byName).Page(0, 10), []string{"ada", "bob", "cy", "dee", "eve"})
+}
+
+// testQueryEach checks that QueryEach visits the results of Query in order and
+// stops at the first error of its callback.
+func testQueryEach(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	create(t, repo, fixtures...)
+	q := trade.NewQuery().Sort(trade.SortField{Field: "name", Direction: trade.SORT_DESC}).Page(1, 3)
+
+	want, err := repo.Query(ctx, q)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	var got []Document
+	if err = repo.QueryEach(ctx, q, func(doc Document) error {
+		got = append(got, doc)
+		return nil
+	}); err != nil {
+		t.Fatalf("QueryEach: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryEach visited %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err = repo.QueryEach(ctx, q, func(doc Document) error {
+		visited++
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Errorf("QueryEach returned %v after %d documents, want %v after 1", err, visited, stop)
+	}
+}
+
+func testPagination(t *testing.T, repo Repository[Document]) {
+	ctx := context.Background()
+	create(t, repo, fixtures...)
+	byName := trade.SortField{Field: "name", Direction: trade.SORT_ASC}
+
+	assertNames(t, repo, trade.NewQuery().Sort(byName).Page(2, 2), []string{"cy", "dee"})
+	assertNames(t, repo, trade.NewQuery().Sort(byName).Page(4, 2), []string{"eve"})
+	assertNames(t, repo, trade.NewQuery().Sort(byName).Page(5, 2), []string{})
+
+	q := trade.NewQuery().Sort(byName).Page(0, 2)
+	names := []string{}
+	for pages := 0; ; pages++ {
+		if pages > len(fixtures) {
+			t.Fatalf("cursor did not reach the final page")
+		}
+		page, err := repo.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		for _, doc := range page {
+			names = append(names, doc.Name)
+		}
+
+		next := trade.NextCursor(q, len(page))
+		if next == "" {
+			break
+		}
+		offset, err := trade.DecodeCursor(next)
+		if err != nil {
+			t.Fatalf("DecodeCursor(%q): %v", next, err)
+		}
+		q = q.Page(offset, q.Limit)
+	}
+	want := []string{"ada", "bob", "cy", "dee", "eve"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("following cursors returned %v, want %v", names, want)
+	}
+}
+
+func testProjection(t *testing.T, repo Repository[Document]) {
+	create(t, repo, fixtures...)
+
+	q := trade.NewQuery().
+		Where(trade.NewFilterKey("group", trade.Eq, "a")).
+		Sort(trade.SortField{Field: "name", Direction: trade.SORT_ASC}).
+		Keep("name", "rank")
+	got, err := repo.QueryRaw(context.Background(), q)
+	if err != nil {
+		t.Fatalf("QueryRaw: %v", err)
+	}
+	want := []map[string]interface{}{
+		{"name": "bob", "rank": float64(1)},
+		{"name": "dee", "rank": float64(4)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryRaw = %v, want %v", got, want)
+	}
+}
+
+// create creates docs in order and returns their _ids.
+func create(t *testing.T, repo Repository[Document], docs ...Document) []string {
+	t.Helper()
+	created := make([]string, len(docs))
+	for i, doc := range docs {
+		id, _, err := repo.Create(context.Background(), doc)
+		if err != nil {
+			t.Fatalf("Create(%+v): %v", doc, err)
+		}
+		created[i] = id
+	}
+	return created
+}
+
+// assertNames checks that q returns the documents named want in order.
+// revision returns the _rev of the document with id.
+func revision(t *testing.T, repo Repository[Document], id string) string {
+	t.Helper()
+	docs, err := repo.QueryRaw(context.Background(), trade.NewQuery().Where(trade.NewFilterKey("_id", trade.Eq, id)))
+	if err != nil {
+		t.Fatalf("QueryRaw: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("QueryRaw returned %d documents with _id %s", len(docs), id)
+	}
+	rev, _ := docs[0]["_rev"].(string)
+	if rev == "" {
+		t.Fatalf("document %s has no _rev", id)
+	}
+	return rev
+}
+
+func assertNames(t *testing.T, repo Repository[Document], q trade.Query, want []string) {
+	t.Helper()
+	docs, err := repo.Query(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Query(%+v): %v", q, err)
+	}
+	got := make([]string, len(docs))
+	for i, doc := range docs {
+		got[i] = doc.Name
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query(%+v) returned %v, want %v", q, got, want)
+	}
+}
+
+func assertNotFound(t *testing.T, op string, err error) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("%s of a missing document succeeded", op)
+	}
+	if !arango.IsNotFoundGeneral(err) {
+		t.Errorf("%s of a missing document returned %v, want a not found error", op, err)
+	}
+}
+
+func ids(docs []Document) []string {
+	out := make([]string, len(docs))
+	for i, doc := range docs {
+		out[i] = doc.ID
+	}
+	return out
+}
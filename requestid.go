@@ -0,0 +1,46 @@
+package trade
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// REQUEST_ID_HEADER is the header carrying the ID of a request, in both
+// directions.
+var REQUEST_ID_HEADER = "X-Request-ID"
+
+// requestIDPattern matches the request IDs accepted from clients and proxies.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// RequestID is chi middleware that identifies each request, so that error
+// responses can be traced to the logs of the request. The ID sent by a client
+// or proxy in the X-Request-ID header is kept if it is well formed, otherwise
+// a random ID is assigned. The ID is echoed in the response header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the ID RequestID assigned to the request with
+// context ctx, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,119 @@
+package trade
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+type revisionKey struct{}
+
+// WithRevision returns a copy of ctx that makes Update, Replace and Delete of
+// every repository fail with a precondition failed error, as reported by
+// arangodriver.IsPreconditionFailed, unless the document's _rev is rev.
+func WithRevision(ctx context.Context, rev string) context.Context {
+	return context.WithValue(ctx, revisionKey{}, rev)
+}
+
+// revisionFromContext returns the revision set on ctx with WithRevision, if
+// any.
+func revisionFromContext(ctx context.Context) (string, bool) {
+	rev, ok := ctx.Value(revisionKey{}).(string)
+	return rev, ok
+}
+
+// withoutRevision returns a copy of ctx that no longer makes writes
+// conditional on the revision set with WithRevision.
+func withoutRevision(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revisionKey{}, nil)
+}
+
+// checkRevision returns a precondition failed error if ctx requires another
+// revision than that of doc.
+func checkRevision(ctx context.Context, doc map[string]interface{}) error {
+	if rev, ok := revisionFromContext(ctx); ok && rev != doc["_rev"] {
+		return newPreconditionFailedError()
+	}
+	return nil
+}
+
+// lastRevision holds the latest revision issued by newRevision.
+var lastRevision atomic.Int64
+
+// newRevision returns a new _rev for the embedded backends. Revisions are
+// derived from the clock, like those of ArangoDB, so they stay unique across
+// restarts of a process reopening the same database.
+func newRevision() string {
+	for {
+		last := lastRevision.Load()
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if lastRevision.CompareAndSwap(last, next) {
+			return "_" + strconv.FormatInt(next, 36)
+		}
+	}
+}
+
+// newPreconditionFailedError returns the error ArangoDB responds with when a
+// write is conditional on another revision than that of the document.
+func newPreconditionFailedError() error {
+	return arangodriver.ArangoError{
+		HasError:     true,
+		Code:         http.StatusPreconditionFailed,
+		ErrorNum:     arangodriver.ErrArangoConflict,
+		ErrorMessage: "conflict, _rev values do not match",
+	}
+}
+
+// ETag returns the entity tag of a document with revision rev.
+func ETag(rev string) string {
+	return strconv.Quote(rev)
+}
+
+// IfMatch returns the revision the If-Match header of r makes a write
+// conditional on, or "" if there is no condition. Only a single entity tag is
+// supported; "*" matches any existing document and so is no condition.
+func IfMatch(r *http.Request) string {
+	tag := strings.TrimSpace(r.Header.Get("If-Match"))
+	if tag == "*" {
+		return ""
+	}
+	return strings.Trim(tag, `"`)
+}
+
+// WithIfMatch returns a copy of ctx conditional, as with WithRevision, on the
+// revision in the If-Match header of r. If r has no condition ctx is returned
+// unchanged.
+func WithIfMatch(ctx context.Context, r *http.Request) context.Context {
+	if rev := IfMatch(r); rev != "" {
+		return WithRevision(ctx, rev)
+	}
+	return ctx
+}
+
+// NotModified sets the ETag of a document with revision rev on w and reports
+// whether the If-None-Match header of r matches it, in which case it responds
+// with 304 Not Modified.
+func NotModified(w http.ResponseWriter, r *http.Request, rev string) bool {
+	if rev == "" {
+		return false
+	}
+	etag := ETag(rev)
+	w.Header().Set("ETag", etag)
+
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package trade
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// Schema is a versioned manifest of the collections, indexes, document
+// validation rules and graphs a database is expected to contain. Each version
+// is applied once, in order, by Migrate, so a version that has been released
+// must never be edited; changes are made by appending a new version.
+type Schema struct {
+	Versions []SchemaVersion `json:"versions"`
+}
+
+// SchemaVersion is a single migration of a Schema.
+type SchemaVersion struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	// DocumentCollections and EdgeCollections are created if they do not exist
+	// and then have their indexes and validation rules applied.
+	DocumentCollections []CollectionSchema `json:"documentCollections,omitempty"`
+	EdgeCollections     []CollectionSchema `json:"edgeCollections,omitempty"`
+	Graphs              []GraphSchema      `json:"graphs,omitempty"`
+}
+
+// CollectionSchema describes a single collection of a SchemaVersion.
+type CollectionSchema struct {
+	CollectionName string        `json:"collectionName"`
+	Indexes        []IndexSchema `json:"indexes,omitempty"`
+	// Validation replaces the collection's document validation rule if set.
+	Validation *ValidationSchema `json:"validation,omitempty"`
+}
+
+// IndexType is the type of an IndexSchema.
+type IndexType string
+
+// Supported index types.
+var (
+	INDEX_PERSISTENT = IndexType("persistent")
+	INDEX_TTL        = IndexType("ttl")
+)
+
+// IndexSchema describes an index of a collection. A persistent index may be
+// unique or sparse. A ttl index removes documents ExpireAfter seconds after the
+// time held in its single field.
+type IndexSchema struct {
+	Name        string    `json:"name,omitempty"`
+	Type        IndexType `json:"type"`
+	Fields      []string  `json:"fields"`
+	Unique      bool      `json:"unique,omitempty"`
+	Sparse      bool      `json:"sparse,omitempty"`
+	ExpireAfter int       `json:"expireAfter,omitempty"`
+}
+
+// ValidationSchema is a JSON schema that documents of a collection must
+// satisfy. Level controls which writes are validated and defaults to strict.
+type ValidationSchema struct {
+	Rule    json.RawMessage                    `json:"rule"`
+	Level   arangodriver.CollectionSchemaLevel `json:"level,omitempty"`
+	Message string                             `json:"message,omitempty"`
+}
+
+// GraphSchema describes a named graph.
+type GraphSchema struct {
+	Name            string                        `json:"name"`
+	EdgeDefinitions []arangodriver.EdgeDefinition `json:"edgeDefinitions"`
+}
+
+// LoadSchema reads and validates the schema manifest at path.
+func LoadSchema(path string) (Schema, error) {
+	var schema Schema
+	schemaF, err := os.Open(path)
+	if err != nil {
+		return schema, err
+	}
+	defer schemaF.Close()
+
+	schemaRaw, err := io.ReadAll(schemaF)
+	if err != nil {
+		return schema, err
+	}
+
+	if err = json.Unmarshal(schemaRaw, &schema); err != nil {
+		return schema, fmt.Errorf("invalid schema %s: %w", path, err)
+	}
+	if err = schema.Validate(); err != nil {
+		return schema, fmt.Errorf("invalid schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// Validate checks that versions are numbered from 1 without gaps and that
+// every collection, index and graph is fully described.
+func (s Schema) Validate() error {
+	if len(s.Versions) < 1 {
+		return fmt.Errorf("schema has no versions")
+	}
+
+	for i, v := range s.Versions {
+		if v.Version != i+1 {
+			return fmt.Errorf("version %d is out of order, expected version %d", v.Version, i+1)
+		}
+
+		for _, c := range append(append([]CollectionSchema{}, v.DocumentCollections...), v.EdgeCollections...) {
+			if c.CollectionName == "" {
+				return fmt.Errorf("version %d: collection is missing collectionName", v.Version)
+			}
+			for _, idx := range c.Indexes {
+				if err := idx.validate(); err != nil {
+					return fmt.Errorf("version %d: collection %s: %w", v.Version, c.CollectionName, err)
+				}
+			}
+			if c.Validation != nil && !json.Valid(c.Validation.Rule) {
+				return fmt.Errorf("version %d: collection %s: validation rule is not valid JSON", v.Version, c.CollectionName)
+			}
+		}
+
+		for _, g := range v.Graphs {
+			if g.Name == "" || len(g.EdgeDefinitions) < 1 {
+				return fmt.Errorf("version %d: graphs need a name and edge definitions", v.Version)
+			}
+		}
+	}
+	return nil
+}
+
+func (idx IndexSchema) validate() error {
+	if len(idx.Fields) < 1 {
+		return fmt.Errorf("index has no fields")
+	}
+	switch idx.Type {
+	case INDEX_PERSISTENT:
+		if idx.ExpireAfter != 0 {
+			return fmt.Errorf("expireAfter is only valid on ttl indexes")
+		}
+	case INDEX_TTL:
+		if len(idx.Fields) != 1 || idx.Unique {
+			return fmt.Errorf("ttl indexes must have exactly one field and cannot be unique")
+		}
+	default:
+		return fmt.Errorf("unsupported index type %q", idx.Type)
+	}
+	return nil
+}
+
+// Latest returns the number of the newest version of s.
+func (s Schema) Latest() int {
+	return len(s.Versions)
+}
+
+// Collections returns every collection declared by any version of s, each
+// named once, in the order they are first declared.
+func (s Schema) Collections() (documentCollections, edgeCollections []string) {
+	seen := map[string]bool{}
+	for _, v := range s.Versions {
+		for _, c := range v.DocumentCollections {
+			if !seen[c.CollectionName] {
+				seen[c.CollectionName] = true
+				documentCollections = append(documentCollections, c.CollectionName)
+			}
+		}
+		for _, c := range v.EdgeCollections {
+			if !seen[c.CollectionName] {
+				seen[c.CollectionName] = true
+				edgeCollections = append(edgeCollections, c.CollectionName)
+			}
+		}
+		for _, g := range v.Graphs {
+			for _, def := range g.EdgeDefinitions {
+				if !seen[def.Collection] {
+					seen[def.Collection] = true
+					edgeCollections = append(edgeCollections, def.Collection)
+				}
+			}
+		}
+	}
+	return documentCollections, edgeCollections
+}
+
+// Pending returns the versions of s that are not in applied, oldest first.
+func (s Schema) Pending(applied []int) []SchemaVersion {
+	done := map[int]bool{}
+	for _, v := range applied {
+		done[v] = true
+	}
+
+	pending := []SchemaVersion{}
+	for _, v := range s.Versions {
+		if !done[v.Version] {
+			pending = append(pending, v)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,249 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+var (
+	// USER_SEARCH_VIEW_NAME is the name of the ArangoSearch view indexing users.
+	USER_SEARCH_VIEW_NAME = "usersSearch"
+	// USER_SEARCH_FIELDS are the user fields indexed by the user search view.
+	USER_SEARCH_FIELDS = []string{"name", "email", "phoneNumber"}
+	// SEARCH_ANALYZER_NAME is the name of the analyzer search views tokenize
+	// their fields with.
+	SEARCH_ANALYZER_NAME = "search_text"
+	// SEARCH_ANALYZER splits text into lower case, accent free words without
+	// stemming so that prefix and fuzzy matches operate on whole words.
+	SEARCH_ANALYZER = arangodriver.ArangoSearchAnalyzerDefinition{
+		Name: SEARCH_ANALYZER_NAME,
+		Type: arangodriver.ArangoSearchAnalyzerTypeText,
+		Properties: arangodriver.ArangoSearchAnalyzerProperties{
+			Locale:    "en",
+			Case:      arangodriver.ArangoSearchCaseLower,
+			Accent:    new(bool),
+			Stemming:  new(bool),
+			Stopwords: []string{},
+		},
+		Features: []arangodriver.ArangoSearchAnalyzerFeature{
+			arangodriver.ArangoSearchAnalyzerFeatureFrequency,
+			arangodriver.ArangoSearchAnalyzerFeatureNorm,
+			arangodriver.ArangoSearchAnalyzerFeaturePosition,
+		},
+	}
+	// SEARCH_MAX_DISTANCE is the largest Levenshtein distance at which a word
+	// still counts as a fuzzy match.
+	SEARCH_MAX_DISTANCE = 1
+)
+
+// ensureSearchView creates the analyzer and the view named viewName over
+// fields of collectionName if they do not already exist.
+func ensureSearchView(ctx context.Context, db arangodriver.Database, viewName, collectionName string, fields []string) error {
+	if _, _, err := db.EnsureAnalyzer(ctx, SEARCH_ANALYZER); err != nil {
+		return err
+	}
+
+	exists, err := db.ViewExists(ctx, viewName)
+	if err != nil || exists {
+		return err
+	}
+
+	linkFields := arangodriver.ArangoSearchFields{}
+	for _, field := range fields {
+		linkFields[field] = arangodriver.ArangoSearchElementProperties{
+			Analyzers: []string{SEARCH_ANALYZER_NAME},
+		}
+	}
+	_, err = db.CreateArangoSearchView(ctx, viewName, &arangodriver.ArangoSearchViewProperties{
+		Links: arangodriver.ArangoSearchLinks{
+			collectionName: arangodriver.ArangoSearchElementProperties{
+				Fields: linkFields,
+			},
+		},
+	})
+	return err
+}
+
+// ArangoSearchView runs ranked full-text searches over the fields of an
+// ArangoSearch view whose documents are of type T.
+type ArangoSearchView[T any] struct {
+	database arangodriver.Database
+	viewName string
+	fields   []string
+}
+
+func NewArangoSearchView[T any](db arangodriver.Database, viewName string, fields []string) *ArangoSearchView[T] {
+	return &ArangoSearchView[T]{
+		database: db,
+		viewName: viewName,
+		fields:   fields,
+	}
+}
+
+// Search returns at most limit documents matching any word of q ordered by
+// relevance. Exact word matches rank above prefix matches which rank above
+// fuzzy matches.
+func (v *ArangoSearchView[T]) Search(ctx context.Context, q string, limit int) ([]T, error) {
+	var err error
+	results := []T{}
+
+	tokens := Tokenize(q)
+	if len(tokens) < 1 {
+		return results, nil
+	}
+
+	bindVars := map[string]interface{}{
+		"limit":    limit,
+		"distance": SEARCH_MAX_DISTANCE,
+		"analyzer": SEARCH_ANALYZER_NAME,
+	}
+	clauses := []string{}
+	for i, token := range tokens {
+		tokenVar := fmt.Sprintf("t%d", i)
+		bindVars[tokenVar] = token
+		for _, field := range v.fields {
+			clauses = append(clauses,
+				fmt.Sprintf("BOOST(x.%s == @%s, 3)", field, tokenVar),
+				fmt.Sprintf("BOOST(STARTS_WITH(x.%s, @%s), 2)", field, tokenVar),
+				fmt.Sprintf("LEVENSHTEIN_MATCH(x.%s, @%s, @distance)", field, tokenVar),
+			)
+		}
+	}
+
+	query := fmt.Sprintf(`FOR x IN %s
+	SEARCH ANALYZER(%s, @analyzer)
+	SORT BM25(x) DESC
+	LIMIT @limit
+	RETURN x`, v.viewName, strings.Join(clauses, "\n\t\tOR "))
+
+	cur, err := v.database.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	for cur.HasMore() {
+		var data T
+		if _, err = cur.ReadDocument(ctx, &data); err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
+
+// Tokenize splits q into lower case words the same way SEARCH_ANALYZER splits
+// indexed text.
+func Tokenize(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// MemorySearchView runs ranked full-text searches in process over the fields of
+// the documents of type T in a DocumentStore collection, scoring them the same
+// way as ArangoSearchView.
+type MemorySearchView[T any] struct {
+	database       DocumentStore
+	collectionName string
+	fields         []string
+}
+
+func NewMemorySearchView[T any](db DocumentStore, collectionName string, fields []string) *MemorySearchView[T] {
+	return &MemorySearchView[T]{
+		database:       db,
+		collectionName: collectionName,
+		fields:         fields,
+	}
+}
+
+// Search returns at most limit documents matching any word of q ordered by
+// relevance. Exact word matches rank above prefix matches which rank above
+// fuzzy matches.
+func (v *MemorySearchView[T]) Search(ctx context.Context, q string, limit int) ([]T, error) {
+	tokens := Tokenize(q)
+	if len(tokens) < 1 {
+		return []T{}, nil
+	}
+
+	type scored struct {
+		doc   map[string]interface{}
+		score int
+	}
+	docs, err := v.database.Documents(v.collectionName)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []scored{}
+	for _, doc := range docs {
+		score := 0
+		for _, field := range v.fields {
+			text, _ := lookupField(doc, field).(string)
+			for _, word := range Tokenize(text) {
+				for _, token := range tokens {
+					switch {
+					case word == token:
+						score += 3
+					case strings.HasPrefix(word, token):
+						score += 2
+					case levenshtein(word, token) <= SEARCH_MAX_DISTANCE:
+						score++
+					}
+				}
+			}
+		}
+		if score > 0 {
+			matches = append(matches, scored{doc: doc, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	results := []T{}
+	for i := 0; i < len(matches) && i < limit; i++ {
+		data, err := fromDocument[T](matches[i].doc)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
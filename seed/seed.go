@@ -0,0 +1,262 @@
+// Package seed loads fixture data, such as db/seed.json, into a database.
+//
+// Records of a seed file may name themselves with ref and reference each other
+// by that name prefixed with @, for example an account with owner "@ada" is
+// owned by the user with ref "ada". References resolve to the _ids generated
+// when the records are created, so a file never depends on the keys of a
+// particular database. A reference without the @ prefix is used as a literal
+// _id or _key of an existing document.
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// Repository is the API for the datastore of a seeded resource.
+type Repository[T any] interface {
+	Create(ctx context.Context, data T) (string, T, error)
+	Query(ctx context.Context, q trade.Query) ([]T, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Settler is the API for posting transactions, which moves their quantities
+// between the balances of the accounts involved.
+type Settler interface {
+	Settle(ctx context.Context, t trade.Transaction) (string, trade.Transaction, error)
+}
+
+// File is the contents of a seed file.
+type File struct {
+	Users        []User        `json:"users"`
+	Accounts     []Account     `json:"accounts"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// User is a user record of a seed file. Roles are granted to the user as is.
+type User struct {
+	Ref         string   `json:"ref,omitempty"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	PhoneNumber string   `json:"phoneNumber"`
+	Roles       []string `json:"roles,omitempty"`
+}
+
+// Account is an account record of a seed file. Balances are the opening
+// balances of the account and Reputation defaults to that of an account
+// created through the API.
+type Account struct {
+	Ref        string             `json:"ref,omitempty"`
+	Owner      string             `json:"owner"`
+	Balances   map[string]float64 `json:"balances"`
+	Reputation *int               `json:"reputation,omitempty"`
+}
+
+// Transaction is a transaction record of a seed file. Transactions are settled
+// in the order they are listed, so each must be covered by the opening
+// balances and the transactions before it. Timestamp defaults to the time the
+// transaction is settled.
+type Transaction struct {
+	Sender     string             `json:"sender"`
+	Recipient  string             `json:"recipient"`
+	Quantities map[string]float64 `json:"quantities"`
+	Timestamp  time.Time          `json:"timestamp"`
+}
+
+// DEFAULT_REPUTATION is the reputation of seeded accounts that do not set one.
+var DEFAULT_REPUTATION = 100
+
+var (
+	ErrUnknownRef   = errors.New("unknown ref")
+	ErrDuplicateRef = errors.New("duplicate ref")
+)
+
+// Decode reads a seed file from r. Unknown fields are rejected so that typos
+// in hand written files are not silently ignored.
+func Decode(r io.Reader) (File, error) {
+	var f File
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&f)
+	return f, err
+}
+
+// Result summarizes a load.
+type Result struct {
+	Users        int `json:"users"`
+	Accounts     int `json:"accounts"`
+	Transactions int `json:"transactions"`
+	// Refs maps the ref of every named record to the _id it was created with.
+	Refs map[string]string `json:"refs"`
+}
+
+// Loader loads seed files into a set of repositories.
+type Loader struct {
+	users        Repository[trade.User]
+	accounts     Repository[trade.Account]
+	transactions Repository[trade.Transaction]
+	settler      Settler
+}
+
+// New returns a Loader that creates users and accounts in the given
+// repositories and posts transactions through settler, whose writes land in
+// transactions.
+func New(users Repository[trade.User], accounts Repository[trade.Account], transactions Repository[trade.Transaction], settler Settler) *Loader {
+	return &Loader{
+		users:        users,
+		accounts:     accounts,
+		transactions: transactions,
+		settler:      settler,
+	}
+}
+
+// Load creates the users, then the accounts, then settles the transactions of
+// f. Loading stops at the first record that fails, leaving the records before
+// it in place, and the error names the failed record.
+func (l *Loader) Load(ctx context.Context, f File) (Result, error) {
+	res := Result{Refs: map[string]string{}}
+
+	for i, u := range f.Users {
+		id, _, err := l.users.Create(ctx, trade.User{Name: u.Name, Email: u.Email, PhoneNumber: u.PhoneNumber, Roles: append([]string{}, u.Roles...)})
+		if err != nil {
+			return res, fmt.Errorf("users[%d]: %w", i, err)
+		}
+		if err = res.name(u.Ref, id); err != nil {
+			return res, fmt.Errorf("users[%d]: %w", i, err)
+		}
+		res.Users++
+	}
+
+	for i, a := range f.Accounts {
+		owner, err := res.resolve(a.Owner, "users")
+		if err != nil {
+			return res, fmt.Errorf("accounts[%d]: owner: %w", i, err)
+		}
+		account := trade.Account{
+			Owner:             owner,
+			Balances:          a.Balances,
+			Reputation:        DEFAULT_REPUTATION,
+			CreationTimestamp: time.Now(),
+		}
+		if account.Balances == nil {
+			account.Balances = map[string]float64{}
+		}
+		if a.Reputation != nil {
+			account.Reputation = *a.Reputation
+		}
+
+		id, _, err := l.accounts.Create(ctx, account)
+		if err != nil {
+			return res, fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+		if err = res.name(a.Ref, id); err != nil {
+			return res, fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+		res.Accounts++
+	}
+
+	for i, t := range f.Transactions {
+		sender, err := res.resolve(t.Sender, "accounts")
+		if err != nil {
+			return res, fmt.Errorf("transactions[%d]: sender: %w", i, err)
+		}
+		recipient, err := res.resolve(t.Recipient, "accounts")
+		if err != nil {
+			return res, fmt.Errorf("transactions[%d]: recipient: %w", i, err)
+		}
+
+		_, _, err = l.settler.Settle(ctx, trade.Transaction{
+			Sender:     sender,
+			Recipient:  recipient,
+			Quantities: t.Quantities,
+			Timestamp:  t.Timestamp.UTC(),
+		})
+		if err != nil {
+			return res, fmt.Errorf("transactions[%d]: %w", i, err)
+		}
+		res.Transactions++
+	}
+
+	return res, nil
+}
+
+// Reset deletes every transaction, account and user so that a file can be
+// loaded into a clean database. It is meant for local environments and
+// returns the number of documents deleted.
+func (l *Loader) Reset(ctx context.Context) (int, error) {
+	n := 0
+
+	transactions, err := l.transactions.Query(ctx, trade.NewQuery())
+	if err != nil {
+		return n, err
+	}
+	for _, t := range transactions {
+		if err = l.transactions.Delete(ctx, t.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	accounts, err := l.accounts.Query(ctx, trade.NewQuery())
+	if err != nil {
+		return n, err
+	}
+	for _, a := range accounts {
+		if err = l.accounts.Delete(ctx, a.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	users, err := l.users.Query(ctx, trade.NewQuery())
+	if err != nil {
+		return n, err
+	}
+	for _, u := range users {
+		if err = l.users.Delete(ctx, u.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// name records that the record named ref was created with _id id. Records
+// without a ref cannot be referenced.
+func (res *Result) name(ref, id string) error {
+	if ref == "" {
+		return nil
+	}
+	if _, ok := res.Refs[ref]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateRef, ref)
+	}
+	res.Refs[ref] = id
+	return nil
+}
+
+// resolve returns the _id referenced by s, which is either @ followed by the
+// ref of a loaded record or the _id or _key of a document in collectionName.
+func (res *Result) resolve(s, collectionName string) (string, error) {
+	if ref, ok := strings.CutPrefix(s, "@"); ok {
+		id, ok := res.Refs[ref]
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrUnknownRef, s)
+		}
+		if !strings.HasPrefix(id, collectionName+"/") {
+			return "", fmt.Errorf("%w: %s is not in %s", ErrUnknownRef, s, collectionName)
+		}
+		return id, nil
+	}
+	if s == "" {
+		return "", fmt.Errorf("%w: reference is empty", ErrUnknownRef)
+	}
+	return trade.DocumentID(collectionName, s), nil
+}
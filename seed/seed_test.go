@@ -0,0 +1,95 @@
+package seed_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/seed"
+)
+
+func newLoader() (*seed.Loader, *trade.MemoryRepository[trade.Account]) {
+	db := trade.NewMemoryDatabase()
+	users := trade.NewMemoryRepository[trade.User](db, "users")
+	accounts := trade.NewMemoryRepository[trade.Account](db, "accounts")
+	transactions := trade.NewMemoryRepository[trade.Transaction](db, "transactions")
+	return seed.New(users, accounts, transactions, trade.NewSettler(accounts, transactions)), accounts
+}
+
+func TestLoadSeedFile(t *testing.T) {
+	ctx := context.Background()
+	f, err := os.Open("../db/seed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := seed.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loader, accounts := newLoader()
+	res, err := loader.Load(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Users != len(data.Users) || res.Accounts != len(data.Accounts) || res.Transactions != len(data.Transactions) {
+		t.Errorf("Load returned %+v", res)
+	}
+
+	sarah, err := accounts.Get(ctx, res.Refs["sarah-main"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sarah.Owner != res.Refs["sarah"] {
+		t.Errorf("sarah-main is owned by %s, want %s", sarah.Owner, res.Refs["sarah"])
+	}
+	if sarah.Balances["dollars"] != 220 || sarah.Balances["apples"] != 6 {
+		t.Errorf("sarah-main balances after settlement are %v", sarah.Balances)
+	}
+
+	// Reset deletes everything that was loaded
+	n, err := loader.Reset(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := res.Users + res.Accounts + res.Transactions; n != want {
+		t.Errorf("Reset deleted %d documents, want %d", n, want)
+	}
+	if remaining, _ := accounts.Query(ctx, trade.NewQuery()); len(remaining) != 0 {
+		t.Errorf("Reset left %d accounts", len(remaining))
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		err  error
+	}{
+		{"unknown ref", `{"users": [{"ref": "a"}], "accounts": [{"owner": "@b"}]}`, seed.ErrUnknownRef},
+		{"duplicate ref", `{"users": [{"ref": "a"}, {"ref": "a"}]}`, seed.ErrDuplicateRef},
+		{"ref to wrong collection", `{"users": [{"ref": "a"}], "accounts": [{"ref": "b", "owner": "@a"}, {"owner": "@b"}]}`, seed.ErrUnknownRef},
+		{"empty owner", `{"accounts": [{"owner": ""}]}`, seed.ErrUnknownRef},
+		{"insufficient funds", `{"users": [{"ref": "a"}],
+			"accounts": [{"ref": "x", "owner": "@a", "balances": {"dollars": 1}}, {"ref": "y", "owner": "@a"}],
+			"transactions": [{"sender": "@x", "recipient": "@y", "quantities": {"dollars": 2}}]}`, trade.ErrInsufficientFunds},
+	}
+	for _, tt := range tests {
+		data, err := seed.Decode(strings.NewReader(tt.file))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		loader, _ := newLoader()
+		if _, err = loader.Load(context.Background(), data); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Load returned %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	if _, err := seed.Decode(strings.NewReader(`{"users": [{"nmae": "typo"}]}`)); err == nil {
+		t.Errorf("Decode accepted an unknown field")
+	}
+}
@@ -0,0 +1,313 @@
+package trade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	arangodriver "github.com/arangodb/go-driver"
+)
+
+// Errors returned by Settler.Settle. Each is wrapped with the detail of what
+// was wrong with the transaction.
+var (
+	ErrInvalidTransaction = errors.New("invalid transaction")
+	ErrUnknownAccount     = errors.New("account does not exist")
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+)
+
+// SettlementRepository is the API a Settler reads and writes documents of type
+// T through.
+type SettlementRepository[T any] interface {
+	Create(ctx context.Context, data T) (string, T, error)
+	Get(ctx context.Context, id string) (T, error)
+	Update(ctx context.Context, id string, data T) (T, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Settler posts transactions. Settling a transaction moves its quantities from
+// the sender's balances to the recipient's and records it, so that either all
+// of these writes happen or none of them do.
+type Settler struct {
+	accounts     SettlementRepository[Account]
+	transactions SettlementRepository[Transaction]
+	// atomically runs fn without any other settlement interleaving with it,
+	// discarding its writes if it returns an error where the backend allows.
+	atomically func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// NewSettler returns a Settler over repositories of a single process database,
+// such as the memory and bolt backends, that serializes settlements with a
+// mutex. If a write fails part way the writes already made are reverted.
+func NewSettler(accounts SettlementRepository[Account], transactions SettlementRepository[Transaction]) *Settler {
+	var mu sync.Mutex
+	return &Settler{
+		accounts:     accounts,
+		transactions: transactions,
+		atomically: func(ctx context.Context, fn func(ctx context.Context) error) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(ctx)
+		},
+	}
+}
+
+// NewArangoSettler returns a Settler that settles each transaction in an
+// ArangoDB stream transaction holding an exclusive lock on the accounts
+// collection.
+func NewArangoSettler(db arangodriver.Database, accountCollectionName, transactionCollectionName string) *Settler {
+	return &Settler{
+		accounts:     NewArangoRepository[Account](db, accountCollectionName),
+		transactions: NewArangoRepository[Transaction](db, transactionCollectionName),
+		atomically: func(ctx context.Context, fn func(ctx context.Context) error) error {
+			trx, err := db.BeginTransaction(ctx, arangodriver.TransactionCollections{
+				Exclusive: []string{accountCollectionName},
+				Write:     []string{transactionCollectionName},
+			}, nil)
+			if err != nil {
+				return err
+			}
+
+			if err = fn(arangodriver.WithTransactionID(ctx, trx)); err != nil {
+				db.AbortTransaction(ctx, trx, nil)
+				return err
+			}
+			return db.CommitTransaction(ctx, trx, nil)
+		},
+	}
+}
+
+// Settle validates t, applies it to the balances of its sender and recipient
+// and records it. Sender and Recipient may be account _keys or _ids. Returns
+// the _id of the recorded transaction.
+func (s *Settler) Settle(ctx context.Context, t Transaction) (string, Transaction, error) {
+	var id string
+	t, err := prepareTransaction(t)
+	if err != nil {
+		return "", t, err
+	}
+
+	err = s.atomically(ctx, func(ctx context.Context) error {
+		id, _, err = s.settle(ctx, t)
+		return err
+	})
+	if err != nil {
+		return "", t, err
+	}
+
+	t.ID = id
+	return id, t, nil
+}
+
+// SettleBatch settles ts in order. Unless atomic each transaction is settled
+// on its own as by Settle, so that the ones that fail do not prevent the
+// others. An atomic batch is settled all at once, and if any transaction fails
+// the ones settled before it are reverted.
+func (s *Settler) SettleBatch(ctx context.Context, ts []Transaction, atomic bool) ([]BatchResult[Transaction], error) {
+	results := make([]BatchResult[Transaction], len(ts))
+	if !atomic {
+		for i, t := range ts {
+			id, t, err := s.Settle(ctx, t)
+			results[i] = BatchResult[Transaction]{ID: id, Data: t, Err: err}
+		}
+		return results, nil
+	}
+
+	err := s.atomically(ctx, func(ctx context.Context) error {
+		undos := []func(ctx context.Context){}
+		for i, t := range ts {
+			t, err := prepareTransaction(t)
+			var id string
+			var undo func(ctx context.Context)
+			if err == nil {
+				id, undo, err = s.settle(ctx, t)
+			}
+			if err != nil {
+				results[i].Err = err
+				for j := len(undos) - 1; j >= 0; j-- {
+					undos[j](ctx)
+				}
+				return ErrBatchAborted
+			}
+
+			t.ID = id
+			results[i] = BatchResult[Transaction]{ID: id, Data: t}
+			undos = append(undos, undo)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrBatchAborted) {
+		return AbortBatch(results), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// prepareTransaction validates t and returns it with the _ids of its accounts
+// and a timestamp.
+func prepareTransaction(t Transaction) (Transaction, error) {
+	if err := validateTransaction(t); err != nil {
+		return t, err
+	}
+
+	t.Sender = DocumentID("accounts", t.Sender)
+	t.Recipient = DocumentID("accounts", t.Recipient)
+	if t.Timestamp.IsZero() {
+		t.Timestamp = time.Now()
+	}
+	// Timestamps are stored in UTC so that they sort and compare as strings
+	t.Timestamp = t.Timestamp.UTC()
+	return t, nil
+}
+
+// settle applies the prepared transaction t and records it, and returns its
+// _id and a function reverting it. Must be called by a function run
+// atomically.
+func (s *Settler) settle(ctx context.Context, t Transaction) (string, func(ctx context.Context), error) {
+	undoTransfer, err := s.transfer(ctx, t.Sender, t.Recipient, t.Quantities)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, _, err := s.transactions.Create(ctx, t)
+	if err != nil {
+		undoTransfer(ctx)
+		return "", nil, err
+	}
+
+	undo := func(ctx context.Context) {
+		s.transactions.Delete(ctx, id)
+		undoTransfer(ctx)
+	}
+	return id, undo, nil
+}
+
+// Reverse deletes the transaction with id, which may be a _key or an _id, and
+// moves its quantities back from the recipient's balances to the sender's, so
+// that either both happen or neither does. It fails with ErrInsufficientFunds
+// if the recipient no longer holds the quantities. A revision set on ctx with
+// WithRevision is required of the transaction only.
+func (s *Settler) Reverse(ctx context.Context, id string) error {
+	return s.atomically(ctx, func(ctx context.Context) error {
+		t, err := s.transactions.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if rev, ok := revisionFromContext(ctx); ok && rev != t.Rev {
+			return newPreconditionFailedError()
+		}
+
+		ctx = withoutRevision(ctx)
+		undo, err := s.transfer(ctx, t.Recipient, t.Sender, t.Quantities)
+		if err != nil {
+			return err
+		}
+		if err = s.transactions.Delete(ctx, id); err != nil {
+			undo(ctx)
+			return err
+		}
+		return nil
+	})
+}
+
+// transfer moves quantities from the balances of the account with _id from to
+// those of the account with _id to, and returns a function moving them back.
+// Must be called by a function run atomically.
+func (s *Settler) transfer(ctx context.Context, from, to string, quantities map[string]float64) (func(ctx context.Context), error) {
+	sender, err := s.account(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := s.account(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	debited, credited := copyBalances(sender.Balances), copyBalances(recipient.Balances)
+	for currency, quantity := range quantities {
+		if debited[currency] < quantity {
+			return nil, fmt.Errorf("%w: %s holds %v %s, needs %v", ErrInsufficientFunds, from, debited[currency], currency, quantity)
+		}
+		debited[currency] -= quantity
+		credited[currency] += quantity
+	}
+
+	senderBalances, recipientBalances := sender.Balances, recipient.Balances
+	sender.Balances = debited
+	if _, err = s.accounts.Update(ctx, from, sender); err != nil {
+		return nil, err
+	}
+	recipient.Balances = credited
+	if _, err = s.accounts.Update(ctx, to, recipient); err != nil {
+		s.revert(ctx, from, sender, senderBalances)
+		return nil, err
+	}
+
+	undo := func(ctx context.Context) {
+		s.revert(ctx, to, recipient, recipientBalances)
+		s.revert(ctx, from, sender, senderBalances)
+	}
+	return undo, nil
+}
+
+// account returns the account with _id id, or ErrUnknownAccount if it does not
+// exist.
+func (s *Settler) account(ctx context.Context, id string) (Account, error) {
+	a, err := s.accounts.Get(ctx, id)
+	if err != nil {
+		if arangodriver.IsNotFoundGeneral(err) {
+			return a, fmt.Errorf("%w: %s", ErrUnknownAccount, id)
+		}
+		return a, err
+	}
+	return a, nil
+}
+
+// revert restores the balances of account a, with _id id, to balances after a
+// failed settlement. Updates merge balances, so currencies the settlement added
+// are zeroed rather than removed. Backends that run settlements in a database
+// transaction discard the failed writes anyway, so a failure to revert is not
+// reported.
+func (s *Settler) revert(ctx context.Context, id string, a Account, balances map[string]float64) {
+	restored := copyBalances(balances)
+	for currency := range a.Balances {
+		if _, ok := restored[currency]; !ok {
+			restored[currency] = 0
+		}
+	}
+	a.Balances = restored
+	s.accounts.Update(ctx, id, a)
+}
+
+// validateTransaction checks that t moves positive, finite quantities between
+// two different accounts.
+func validateTransaction(t Transaction) error {
+	if t.Sender == "" || t.Recipient == "" {
+		return fmt.Errorf("%w: sender and recipient are required", ErrInvalidTransaction)
+	}
+	if DocumentID("accounts", t.Sender) == DocumentID("accounts", t.Recipient) {
+		return fmt.Errorf("%w: sender and recipient must be different accounts", ErrInvalidTransaction)
+	}
+	if len(t.Quantities) == 0 {
+		return fmt.Errorf("%w: quantities are required", ErrInvalidTransaction)
+	}
+	for currency, quantity := range t.Quantities {
+		if !(quantity > 0) || math.IsInf(quantity, 1) {
+			return fmt.Errorf("%w: quantity of %s must be a positive number", ErrInvalidTransaction, currency)
+		}
+	}
+	return nil
+}
+
+func copyBalances(balances map[string]float64) map[string]float64 {
+	out := make(map[string]float64, len(balances))
+	for currency, quantity := range balances {
+		out[currency] = quantity
+	}
+	return out
+}
@@ -0,0 +1,190 @@
+package trade_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	arangodriver "github.com/arangodb/go-driver"
+	"github.com/gabriel-ross/trade"
+)
+
+func TestSettler(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		db := trade.NewMemoryDatabase()
+		accounts := trade.NewMemoryRepository[trade.Account](db, "accounts")
+		transactions := trade.NewMemoryRepository[trade.Transaction](db, "transactions")
+		testSettler(t, trade.NewSettler(accounts, transactions), accounts, transactions)
+	})
+
+	t.Run("arango", func(t *testing.T) {
+		ctx := context.Background()
+		cl, err := trade.NewArangoClient(arangoEndpoints(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := cl.DriverClient.CreateDatabase(ctx, "trade_settlement_test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Remove(ctx) })
+		if _, err = db.CreateCollection(ctx, "accounts", nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.CreateCollection(ctx, "transactions", &arangodriver.CreateCollectionOptions{Type: arangodriver.CollectionTypeEdge}); err != nil {
+			t.Fatal(err)
+		}
+
+		testSettler(t, trade.NewArangoSettler(db, "accounts", "transactions"),
+			trade.NewArangoRepository[trade.Account](db, "accounts"),
+			trade.NewArangoRepository[trade.Transaction](db, "transactions"))
+	})
+}
+
+func testSettler(t *testing.T, s *trade.Settler, accounts trade.SettlementRepository[trade.Account], transactions interface {
+	Query(ctx context.Context, q trade.Query) ([]trade.Transaction, error)
+}) {
+	ctx := context.Background()
+	sender, _, err := accounts.Create(ctx, trade.Account{Owner: "users/1", Balances: map[string]float64{"dollars": 10, "apples": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, _, err := accounts.Create(ctx, trade.Account{Owner: "users/2", Balances: map[string]float64{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		sender     string
+		recipient  string
+		quantities map[string]float64
+		err        error
+	}{
+		{"settles", trade.DocumentKey(sender), recipient, map[string]float64{"dollars": 4}, nil},
+		{"insufficient funds", sender, recipient, map[string]float64{"dollars": 1, "apples": 2}, trade.ErrInsufficientFunds},
+		{"unknown account", sender, "accounts/missing", map[string]float64{"dollars": 1}, trade.ErrUnknownAccount},
+		{"self transfer", sender, sender, map[string]float64{"dollars": 1}, trade.ErrInvalidTransaction},
+		{"negative quantity", sender, recipient, map[string]float64{"dollars": -1}, trade.ErrInvalidTransaction},
+		{"no quantities", sender, recipient, nil, trade.ErrInvalidTransaction},
+	}
+	var settled string
+	for _, tt := range tests {
+		id, _, err := s.Settle(ctx, trade.Transaction{Sender: tt.sender, Recipient: tt.recipient, Quantities: tt.quantities})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Settle returned %v, want %v", tt.name, err, tt.err)
+		}
+		if err == nil && id == "" {
+			t.Errorf("%s: Settle returned no _id", tt.name)
+		}
+		if err == nil {
+			settled = id
+		}
+	}
+
+	// Only the first transaction was applied and recorded
+	want := map[string]map[string]float64{
+		sender:    {"dollars": 6, "apples": 1},
+		recipient: {"dollars": 4},
+	}
+	for id, balances := range want {
+		a, err := accounts.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for currency, quantity := range balances {
+			if a.Balances[currency] != quantity {
+				t.Errorf("%s holds %v %s, want %v", id, a.Balances[currency], currency, quantity)
+			}
+		}
+	}
+	recorded, err := transactions.Query(ctx, trade.NewQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].Sender != sender || recorded[0].Recipient != recipient {
+		t.Errorf("recorded transactions %+v", recorded)
+	}
+
+	// The second transaction of each batch spends what the first one received
+	batch := []trade.Transaction{
+		{Sender: sender, Recipient: recipient, Quantities: map[string]float64{"dollars": 5}},
+		{Sender: recipient, Recipient: sender, Quantities: map[string]float64{"dollars": 9}},
+		{Sender: sender, Recipient: recipient, Quantities: map[string]float64{"apples": 2}},
+	}
+	results, err := s.SettleBatch(ctx, batch, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err, trade.ErrBatchAborted) || !errors.Is(results[1].Err, trade.ErrBatchAborted) || !errors.Is(results[2].Err, trade.ErrInsufficientFunds) {
+		t.Errorf("failed atomic batch returned %v, %v and %v", results[0].Err, results[1].Err, results[2].Err)
+	}
+	results, err = s.SettleBatch(ctx, batch[:2], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Err != nil || result.ID == "" {
+			t.Errorf("transaction %d of an atomic batch returned %q, %v", i, result.ID, result.Err)
+		}
+	}
+
+	want = map[string]map[string]float64{
+		sender:    {"dollars": 10, "apples": 1},
+		recipient: {"dollars": 0},
+	}
+	for id, balances := range want {
+		a, err := accounts.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for currency, quantity := range balances {
+			if a.Balances[currency] != quantity {
+				t.Errorf("after the batches %s holds %v %s, want %v", id, a.Balances[currency], currency, quantity)
+			}
+		}
+	}
+	if recorded, err = transactions.Query(ctx, trade.NewQuery()); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 3 {
+		t.Errorf("recorded %d transactions after the batches, want 3", len(recorded))
+	}
+
+	// Reversing the first transaction needs what the recipient sent back
+	if err = s.Reverse(ctx, settled); !errors.Is(err, trade.ErrInsufficientFunds) {
+		t.Errorf("Reverse without funds returned %v, want %v", err, trade.ErrInsufficientFunds)
+	}
+	if err = s.Reverse(trade.WithRevision(ctx, "stale"), results[1].ID); !arangodriver.IsPreconditionFailed(err) {
+		t.Errorf("Reverse of a stale revision returned %v", err)
+	}
+	if err = s.Reverse(ctx, "transactions/missing"); !arangodriver.IsNotFoundGeneral(err) {
+		t.Errorf("Reverse of a missing transaction returned %v", err)
+	}
+	for _, id := range []string{results[1].ID, trade.DocumentKey(settled)} {
+		if err = s.Reverse(ctx, id); err != nil {
+			t.Fatalf("Reverse(%s): %v", id, err)
+		}
+	}
+	want = map[string]map[string]float64{
+		sender:    {"dollars": 5, "apples": 1},
+		recipient: {"dollars": 5},
+	}
+	for id, balances := range want {
+		a, err := accounts.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for currency, quantity := range balances {
+			if a.Balances[currency] != quantity {
+				t.Errorf("after the reversals %s holds %v %s, want %v", id, a.Balances[currency], currency, quantity)
+			}
+		}
+	}
+	if recorded, err = transactions.Query(ctx, trade.NewQuery()); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].ID != results[0].ID {
+		t.Errorf("recorded transactions after the reversals are %+v, want %s", recorded, results[0].ID)
+	}
+}
@@ -0,0 +1,136 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// CurrencyMix is the share of transactions denominated in each currency. The
+// shares need not sum to one.
+type CurrencyMix map[string]float64
+
+// ParseCurrencyMix parses a mix written as comma separated currency=share
+// pairs, for example "dollars=0.8,apples=0.2".
+func ParseCurrencyMix(s string) (CurrencyMix, error) {
+	mix := CurrencyMix{}
+	for _, pair := range strings.Split(s, ",") {
+		currency, share, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || currency == "" {
+			return nil, fmt.Errorf("invalid currency mix element %q, want currency=share", pair)
+		}
+		v, err := strconv.ParseFloat(share, 64)
+		if err != nil || !(v > 0) || math.IsInf(v, 1) {
+			return nil, fmt.Errorf("invalid share %q of %s", share, currency)
+		}
+		mix[currency] = v
+	}
+	return mix, nil
+}
+
+// String formats m in the form read by ParseCurrencyMix.
+func (m CurrencyMix) String() string {
+	pairs := []string{}
+	for _, currency := range m.currencies() {
+		pairs = append(pairs, currency+"="+strconv.FormatFloat(m[currency], 'g', -1, 64))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// currencies returns the currencies of m in a fixed order so that generation
+// is reproducible.
+func (m CurrencyMix) currencies() []string {
+	currencies := make([]string, 0, len(m))
+	for currency := range m {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// Generator generates synthetic users, accounts and transactions. A Generator
+// is deterministic for a given Options.Seed and is not safe for concurrent use.
+type Generator struct {
+	opts       Options
+	rand       *rand.Rand
+	currencies []string
+	weights    []float64
+	// counterparties draws the rank of an account by how often it trades, and
+	// ranks maps a rank to an account, so that the busiest accounts are spread
+	// across owners.
+	counterparties *rand.Zipf
+	ranks          []int
+}
+
+// NewGenerator returns a Generator for opts, which must be valid.
+func NewGenerator(opts Options) *Generator {
+	r := rand.New(rand.NewSource(opts.Seed))
+	g := &Generator{
+		opts:           opts,
+		rand:           r,
+		currencies:     opts.Currencies.currencies(),
+		counterparties: rand.NewZipf(r, opts.Skew, 1, uint64(opts.Accounts-1)),
+		ranks:          r.Perm(opts.Accounts),
+	}
+	total := 0.0
+	for _, currency := range g.currencies {
+		total += opts.Currencies[currency]
+		g.weights = append(g.weights, total)
+	}
+	return g
+}
+
+// User returns the i-th generated user.
+func (g *Generator) User(i int) trade.User {
+	return trade.User{
+		Name:        fmt.Sprintf("sim user %d", i),
+		Email:       fmt.Sprintf("sim.user.%d@example.com", i),
+		PhoneNumber: fmt.Sprintf("+1555%03d%04d", i/10000%1000, i%10000),
+	}
+}
+
+// Account returns an account owned by a user drawn uniformly from owners and
+// funded with the opening balance in every currency of the mix.
+func (g *Generator) Account(owners []string) trade.Account {
+	balances := map[string]float64{}
+	for _, currency := range g.currencies {
+		balances[currency] = g.opts.OpeningBalance
+	}
+	return trade.Account{
+		Owner:             owners[g.rand.Intn(len(owners))],
+		Balances:          balances,
+		Reputation:        100,
+		CreationTimestamp: time.Now(),
+	}
+}
+
+// Transaction returns a transaction between two different accounts of
+// accounts. Both counterparties are drawn from a power law, so a few accounts
+// take part in most transactions. The currency is drawn from the mix and the
+// quantity is exponentially distributed around Options.MeanQuantity.
+func (g *Generator) Transaction(accounts []string) trade.Transaction {
+	sender := g.counterparty(accounts)
+	recipient := g.counterparty(accounts)
+	for recipient == sender {
+		recipient = g.counterparty(accounts)
+	}
+
+	currency := g.currencies[sort.SearchFloat64s(g.weights, g.rand.Float64()*g.weights[len(g.weights)-1])]
+	quantity := math.Max(0.01, math.Round(g.rand.ExpFloat64()*g.opts.MeanQuantity*100)/100)
+
+	return trade.Transaction{
+		Sender:     sender,
+		Recipient:  recipient,
+		Quantities: map[string]float64{currency: quantity},
+	}
+}
+
+func (g *Generator) counterparty(accounts []string) string {
+	return accounts[g.ranks[g.counterparties.Uint64()]]
+}
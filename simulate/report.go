@@ -0,0 +1,140 @@
+package simulate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Report holds the statistics of each phase of a simulation.
+type Report struct {
+	Users        Stats `json:"users"`
+	Accounts     Stats `json:"accounts"`
+	Transactions Stats `json:"transactions"`
+}
+
+// Stats summarizes the requests of one phase of a simulation. Latencies are
+// those of every request, whatever its outcome.
+type Stats struct {
+	Requests int `json:"requests"`
+	// Succeeded, Rejected and Failed count the requests that succeeded, were
+	// refused with ErrRejected and failed with any other error.
+	Succeeded int           `json:"succeeded"`
+	Rejected  int           `json:"rejected"`
+	Failed    int           `json:"failed"`
+	Elapsed   time.Duration `json:"elapsed"`
+	// Throughput is the number of requests completed per second.
+	Throughput float64       `json:"throughput"`
+	P50        time.Duration `json:"p50"`
+	P90        time.Duration `json:"p90"`
+	P99        time.Duration `json:"p99"`
+	Max        time.Duration `json:"max"`
+
+	firstErr error
+}
+
+// Write writes r to w as a table with a row per phase, followed by the first
+// error of each phase that had one.
+func (r Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "phase\trequests\tok\trejected\tfailed\treq/s\tp50\tp90\tp99\tmax\t")
+	for _, p := range r.phases() {
+		s := p.stats
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\t\n",
+			p.name, s.Requests, s.Succeeded, s.Rejected, s.Failed, s.Throughput,
+			round(s.P50), round(s.P90), round(s.P99), round(s.Max))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, p := range r.phases() {
+		if p.stats.firstErr != nil {
+			if _, err := fmt.Fprintf(w, "first %s error: %v\n", p.name, p.stats.firstErr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type phase struct {
+	name  string
+	stats Stats
+}
+
+func (r Report) phases() []phase {
+	return []phase{{"users", r.Users}, {"accounts", r.Accounts}, {"transactions", r.Transactions}}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+// recorder collects the latencies and outcomes of requests made concurrently.
+type recorder struct {
+	mu        sync.Mutex
+	start     time.Time
+	latencies []time.Duration
+	counts    Stats
+}
+
+func newRecorder() *recorder {
+	return &recorder{start: time.Now()}
+}
+
+func (rec *recorder) record(latency time.Duration, err error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.latencies = append(rec.latencies, latency)
+	switch {
+	case err == nil:
+		rec.counts.Succeeded++
+	case errors.Is(err, ErrRejected):
+		rec.counts.Rejected++
+	default:
+		rec.counts.Failed++
+		if rec.counts.firstErr == nil {
+			rec.counts.firstErr = err
+		}
+	}
+}
+
+// stats returns the statistics of the requests recorded so far.
+func (rec *recorder) stats() Stats {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	s := rec.counts
+	s.Requests = len(rec.latencies)
+	s.Elapsed = time.Since(rec.start)
+	if s.Elapsed > 0 {
+		s.Throughput = float64(s.Requests) / s.Elapsed.Seconds()
+	}
+
+	latencies := append([]time.Duration{}, rec.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	s.P50 = percentile(latencies, 0.50)
+	s.P90 = percentile(latencies, 0.90)
+	s.P99 = percentile(latencies, 0.99)
+	s.Max = percentile(latencies, 1)
+	return s
+}
+
+// percentile returns the q-th quantile of sorted using the nearest rank.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
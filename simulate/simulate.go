@@ -0,0 +1,187 @@
+// Package simulate generates synthetic trading data and drives it through the
+// repositories or the HTTP API of the application, measuring the throughput
+// and latency of each kind of request for capacity planning.
+//
+// A simulation runs in two phases. It first creates the users and the funded
+// accounts, then settles a stream of transactions between the accounts from a
+// pool of concurrent workers, optionally capped at a fixed rate.
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// Options configures a simulation.
+type Options struct {
+	Users        int
+	Accounts     int
+	Transactions int
+	// Rate is the number of transactions started per second. If zero
+	// transactions are started as fast as the workers accept them.
+	Rate float64
+	// Concurrency is the number of requests in flight at once.
+	Concurrency int
+	Currencies  CurrencyMix
+	// Skew is the exponent of the power law counterparties are drawn from and
+	// must be greater than one. The higher it is the more transactions involve
+	// the busiest accounts.
+	Skew           float64
+	OpeningBalance float64
+	MeanQuantity   float64
+	Seed           int64
+}
+
+// DefaultOptions returns the options of a small simulation.
+func DefaultOptions() Options {
+	return Options{
+		Users:          100,
+		Accounts:       200,
+		Transactions:   10000,
+		Concurrency:    8,
+		Currencies:     CurrencyMix{"dollars": 0.8, "apples": 0.2},
+		Skew:           1.2,
+		OpeningBalance: 1000,
+		MeanQuantity:   10,
+		Seed:           1,
+	}
+}
+
+// Validate checks that opts describe a simulation that can run.
+func (opts Options) Validate() error {
+	switch {
+	case opts.Users < 1:
+		return fmt.Errorf("at least one user is required")
+	case opts.Accounts < 2:
+		return fmt.Errorf("at least two accounts are required")
+	case opts.Transactions < 0:
+		return fmt.Errorf("the number of transactions cannot be negative")
+	case opts.Rate < 0 || math.IsInf(opts.Rate, 1):
+		return fmt.Errorf("the rate must be a non-negative number")
+	case opts.Concurrency < 1:
+		return fmt.Errorf("concurrency must be at least one")
+	case len(opts.Currencies) == 0:
+		return fmt.Errorf("at least one currency is required")
+	case !(opts.Skew > 1):
+		return fmt.Errorf("skew must be greater than one")
+	case !(opts.OpeningBalance >= 0):
+		return fmt.Errorf("the opening balance cannot be negative")
+	case !(opts.MeanQuantity > 0):
+		return fmt.Errorf("the mean quantity must be positive")
+	}
+	return nil
+}
+
+// ErrRejected is returned by a Target when the application refuses a request
+// on its merits, for example a transaction the sender cannot cover. Rejections
+// are counted apart from errors since they are expected under load.
+var ErrRejected = errors.New("rejected")
+
+// Target is the API a simulation drives. Each method returns the _id of the
+// document it created.
+type Target interface {
+	CreateUser(ctx context.Context, u trade.User) (string, error)
+	CreateAccount(ctx context.Context, a trade.Account) (string, error)
+	Settle(ctx context.Context, t trade.Transaction) (string, error)
+}
+
+// Run runs the simulation described by opts against target. It stops early if
+// ctx is cancelled or setup fails, returning the report so far.
+func Run(ctx context.Context, target Target, opts Options) (Report, error) {
+	report := Report{}
+	if err := opts.Validate(); err != nil {
+		return report, err
+	}
+	g := NewGenerator(opts)
+
+	users := make([]string, 0, opts.Users)
+	report.Users = measure(opts.Users, func(i int) error {
+		id, err := target.CreateUser(ctx, g.User(i))
+		if err == nil {
+			users = append(users, id)
+		}
+		return err
+	})
+	if len(users) == 0 {
+		return report, fmt.Errorf("no users could be created: %w", report.Users.firstErr)
+	}
+
+	accounts := make([]string, 0, opts.Accounts)
+	report.Accounts = measure(opts.Accounts, func(i int) error {
+		id, err := target.CreateAccount(ctx, g.Account(users))
+		if err == nil {
+			accounts = append(accounts, id)
+		}
+		return err
+	})
+	if len(accounts) < 2 {
+		return report, fmt.Errorf("fewer than two accounts could be created: %w", report.Accounts.firstErr)
+	}
+
+	report.Transactions = settle(ctx, target, g, accounts, opts)
+	return report, ctx.Err()
+}
+
+// measure runs op n times in sequence and returns its statistics.
+func measure(n int, op func(i int) error) Stats {
+	rec := newRecorder()
+	for i := 0; i < n; i++ {
+		start := time.Now()
+		err := op(i)
+		rec.record(time.Since(start), err)
+	}
+	return rec.stats()
+}
+
+// settle settles opts.Transactions generated transactions between accounts
+// from opts.Concurrency workers and returns their statistics.
+func settle(ctx context.Context, target Target, g *Generator, accounts []string, opts Options) Stats {
+	jobs := make(chan trade.Transaction)
+	rec := newRecorder()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				start := time.Now()
+				_, err := target.Settle(ctx, t)
+				rec.record(time.Since(start), err)
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+produce:
+	for i := 0; i < opts.Transactions; i++ {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				break produce
+			}
+		}
+		select {
+		case jobs <- g.Transaction(accounts):
+		case <-ctx.Done():
+			break produce
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return rec.stats()
+}
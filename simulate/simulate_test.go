@@ -0,0 +1,101 @@
+package simulate_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gabriel-ross/trade/app"
+	"github.com/gabriel-ross/trade/simulate"
+)
+
+func TestGenerator(t *testing.T) {
+	opts := simulate.DefaultOptions()
+	opts.Currencies = simulate.CurrencyMix{"dollars": 3, "apples": 1}
+	accounts := make([]string, opts.Accounts)
+	for i := range accounts {
+		accounts[i] = fmt.Sprintf("accounts/%d", i)
+	}
+
+	a, b := simulate.NewGenerator(opts), simulate.NewGenerator(opts)
+	trades := map[string]int{}
+	currencies := map[string]int{}
+	n := 20000
+	for i := 0; i < n; i++ {
+		tr := a.Transaction(accounts)
+		if other := b.Transaction(accounts); !reflect.DeepEqual(tr, other) {
+			t.Fatalf("generators with the same seed diverged: %+v != %+v", tr, other)
+		}
+		if tr.Sender == tr.Recipient {
+			t.Fatalf("transaction %d sends to itself", i)
+		}
+		trades[tr.Sender]++
+		trades[tr.Recipient]++
+		for currency, quantity := range tr.Quantities {
+			currencies[currency]++
+			if quantity <= 0 {
+				t.Fatalf("transaction %d has quantity %v", i, quantity)
+			}
+		}
+	}
+
+	// Counterparties follow a power law, so the busiest account trades far
+	// more than an even share
+	busiest := 0
+	for _, count := range trades {
+		if count > busiest {
+			busiest = count
+		}
+	}
+	if even := 2 * n / len(accounts); busiest < 10*even {
+		t.Errorf("busiest account made %d trades, an even share is %d", busiest, even)
+	}
+
+	if share := float64(currencies["dollars"]) / float64(n); share < 0.72 || share > 0.78 {
+		t.Errorf("%.2f of transactions are in dollars, want 0.75", share)
+	}
+}
+
+func TestParseCurrencyMix(t *testing.T) {
+	mix, err := simulate.ParseCurrencyMix("dollars=0.8, apples=0.2")
+	if err != nil || !reflect.DeepEqual(mix, simulate.CurrencyMix{"dollars": 0.8, "apples": 0.2}) {
+		t.Errorf("ParseCurrencyMix returned %v, %v", mix, err)
+	}
+	for _, s := range []string{"", "dollars", "dollars=", "dollars=-1", "=1"} {
+		if _, err = simulate.ParseCurrencyMix(s); err == nil {
+			t.Errorf("ParseCurrencyMix(%q) succeeded", s)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	opts := simulate.DefaultOptions()
+	opts.Users, opts.Accounts, opts.Transactions = 5, 10, 200
+
+	a := app.New(app.Config{DB_BACKEND: app.BACKEND_MEMORY, AUTH_DISABLED: true})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	targets := map[string]simulate.Target{
+		"repository": a.SimulationTarget(),
+		"http":       simulate.NewHTTPTarget(srv.URL, srv.Client()),
+	}
+	for name, target := range targets {
+		report, err := simulate.Run(context.Background(), target, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if report.Users.Succeeded != opts.Users || report.Accounts.Succeeded != opts.Accounts {
+			t.Errorf("%s: created %d users and %d accounts", name, report.Users.Succeeded, report.Accounts.Succeeded)
+		}
+		s := report.Transactions
+		if s.Requests != opts.Transactions || s.Failed != 0 || s.Succeeded == 0 {
+			t.Errorf("%s: transactions %+v", name, s)
+		}
+		if s.P50 > s.P90 || s.P90 > s.P99 || s.P99 > s.Max {
+			t.Errorf("%s: percentiles out of order %+v", name, s)
+		}
+	}
+}
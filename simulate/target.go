@@ -0,0 +1,143 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gabriel-ross/trade"
+)
+
+// Repository is the API for the datastore of a simulated resource.
+type Repository[T any] interface {
+	Create(ctx context.Context, data T) (string, T, error)
+}
+
+// Settler is the API for posting transactions, which moves their quantities
+// between the balances of the accounts involved.
+type Settler interface {
+	Settle(ctx context.Context, t trade.Transaction) (string, trade.Transaction, error)
+}
+
+// repositoryTarget drives the repositories and settler of an application
+// directly, measuring the datastore and settlement path without HTTP.
+type repositoryTarget struct {
+	users    Repository[trade.User]
+	accounts Repository[trade.Account]
+	settler  Settler
+}
+
+// NewRepositoryTarget returns a Target that creates users and accounts in the
+// given repositories and settles transactions through settler.
+func NewRepositoryTarget(users Repository[trade.User], accounts Repository[trade.Account], settler Settler) Target {
+	return &repositoryTarget{
+		users:    users,
+		accounts: accounts,
+		settler:  settler,
+	}
+}
+
+func (t *repositoryTarget) CreateUser(ctx context.Context, u trade.User) (string, error) {
+	id, _, err := t.users.Create(ctx, u)
+	return id, err
+}
+
+func (t *repositoryTarget) CreateAccount(ctx context.Context, a trade.Account) (string, error) {
+	id, _, err := t.accounts.Create(ctx, a)
+	return id, err
+}
+
+func (t *repositoryTarget) Settle(ctx context.Context, tr trade.Transaction) (string, error) {
+	id, _, err := t.settler.Settle(ctx, tr)
+	if errors.Is(err, trade.ErrInsufficientFunds) || errors.Is(err, trade.ErrUnknownAccount) || errors.Is(err, trade.ErrInvalidTransaction) {
+		return "", fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return id, err
+}
+
+// httpTarget drives the HTTP API of a running server.
+type httpTarget struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPTarget returns a Target that sends requests to the server at baseURL
+// with client, or http.DefaultClient if client is nil.
+func NewHTTPTarget(baseURL string, client *http.Client) Target {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpTarget{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}
+}
+
+func (t *httpTarget) CreateUser(ctx context.Context, u trade.User) (string, error) {
+	return t.post(ctx, "/users", map[string]interface{}{
+		"name":        u.Name,
+		"email":       u.Email,
+		"phoneNumber": u.PhoneNumber,
+	})
+}
+
+func (t *httpTarget) CreateAccount(ctx context.Context, a trade.Account) (string, error) {
+	return t.post(ctx, "/accounts", map[string]interface{}{
+		"owner":    a.Owner,
+		"balances": a.Balances,
+	})
+}
+
+func (t *httpTarget) Settle(ctx context.Context, tr trade.Transaction) (string, error) {
+	return t.post(ctx, "/transactions", map[string]interface{}{
+		"sender":     tr.Sender,
+		"recipient":  tr.Recipient,
+		"quantities": tr.Quantities,
+	})
+}
+
+// post posts body to path and returns the _id of the created resource. A
+// 422 Unprocessable Entity response is returned as ErrRejected.
+func (t *httpTarget) post(ctx context.Context, path string, body interface{}) (string, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+path, bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	raw, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		return "", fmt.Errorf("%w: POST %s: %s", ErrRejected, path, bytes.TrimSpace(raw))
+	case resp.StatusCode != http.StatusCreated:
+		return "", fmt.Errorf("POST %s returned %s: %s", path, resp.Status, bytes.TrimSpace(raw))
+	}
+
+	var created struct {
+		Data struct {
+			ID string `json:"_id"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(raw, &created); err != nil {
+		return "", fmt.Errorf("POST %s returned invalid JSON: %w", path, err)
+	}
+	return created.Data.ID, nil
+}
@@ -4,9 +4,24 @@ import "time"
 
 // Transaction represents a transaction between two accounts.
 type Transaction struct {
-	Sender     string             `json:"_from"`
-	Recipient  string             `json:"_to"`
+	Sender     string             `json:"_from" validate:"required"`
+	Recipient  string             `json:"_to" validate:"required"`
 	ID         string             `json:"_id"`
-	Quantities map[string]float64 `json:"quantities"`
+	Rev        string             `json:"_rev,omitempty"`
+	Quantities map[string]float64 `json:"quantities" validate:"required,currencies,positive"`
 	Timestamp  time.Time          `json:"timestamp"`
 }
+
+// Validate checks that t moves quantities between two different accounts.
+func (t Transaction) Validate() []FieldError {
+	return ValidateTransfer("_to", t.Sender, t.Recipient)
+}
+
+// ValidateTransfer returns a failure of the field recipientField unless
+// sender and recipient, account _keys or _ids, are different accounts.
+func ValidateTransfer(recipientField, sender, recipient string) []FieldError {
+	if sender != "" && DocumentID("accounts", sender) == DocumentID("accounts", recipient) {
+		return []FieldError{{Field: recipientField, Code: CODE_SAME_ACCOUNT, Message: "must be another account than the sender"}}
+	}
+	return nil
+}
@@ -41,7 +41,7 @@ func (r *TransactionRepository) Update(ctx context.Context, data trade.Transacti
 }
 
 func (r *TransactionRepository) Delete(ctx context.Context, id string) error {
-	data, err := r.Get(ctx, id)
+	_, err := r.Get(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -2,31 +2,63 @@ package transaction
 
 import (
 	"context"
-	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	arango "github.com/arangodb/go-driver"
 	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/auth"
+	"github.com/gabriel-ross/trade/ledger"
 	"github.com/go-chi/chi"
 )
 
 // request represents a request body containing transaction data.
 type request struct {
-	Quantities map[string]float64 `json:"quantities"`
-	Sender     string             `json:"sender"`
-	Recipient  string             `json:"recipient"`
+	Quantities map[string]float64 `json:"quantities" validate:"required,currencies,positive"`
+	Sender     string             `json:"sender" validate:"required"`
+	Recipient  string             `json:"recipient" validate:"required"`
 }
 
-type response[T trade.Transaction | []trade.Transaction] struct {
-	Data T `json:"data"`
+// Validate checks that the request moves quantities between two different
+// accounts.
+func (req request) Validate() []trade.FieldError {
+	return trade.ValidateTransfer("recipient", req.Sender, req.Recipient)
 }
 
-func newResponse[T trade.Transaction | []trade.Transaction](data T) response[T] {
+// relations are the transaction fields that can be expanded with ?expand=.
+var relations = map[string]trade.Relation{
+	"sender":    {Field: "_from", CollectionName: "accounts"},
+	"recipient": {Field: "_to", CollectionName: "accounts"},
+}
+
+// immutable are the transaction fields a patch may not change. Settled
+// transactions have moved their quantities between the balances of their
+// accounts, which a patch would not move back.
+var immutable = []string{"_id", "_rev", "_from", "_to", "quantities", "timestamp"}
+
+type response[T trade.Transaction | []trade.Transaction | map[string]interface{} | []map[string]interface{}] struct {
+	Data T      `json:"data"`
+	Next string `json:"next,omitempty"`
+}
+
+func newResponse[T trade.Transaction | []trade.Transaction | map[string]interface{} | []map[string]interface{}](data T) response[T] {
 	return response[T]{Data: data}
 }
 
+// newPageResponse returns a response holding a single page of results and the
+// cursor of the page after it.
+func newPageResponse[T trade.Transaction | []trade.Transaction | map[string]interface{} | []map[string]interface{}](data T, next string) response[T] {
+	return response[T]{Data: data, Next: next}
+}
+
+// handleCreate posts a transaction. Callers may only send from accounts they
+// own unless they may write the resources of any user.
 func (s *service) handleCreate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
@@ -38,6 +70,32 @@ func (s *service) handleCreate() http.HandlerFunc {
 			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
 			return
 		}
+		reqData.Timestamp = time.Now().UTC()
+
+		if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_WRITE_ANY); restricted {
+			owned, err := s.owns(ctx, self, reqData.Sender)
+			if err != nil {
+				s.renderSettlementError(w, r, err)
+				return
+			}
+			if !owned {
+				err = fmt.Errorf("%w: %s is not an account of the caller", auth.ErrForbidden, trade.DocumentID("accounts", reqData.Sender))
+				s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+				return
+			}
+		}
+
+		if s.settler != nil {
+			id, resp, err := s.settler.Settle(ctx, reqData)
+			if err != nil {
+				s.renderSettlementError(w, r, err)
+				return
+			}
+
+			resp.ID = id
+			s.renderer.RenderJSON(w, r, http.StatusCreated, newResponse(resp))
+			return
+		}
 
 		id, resp, err := s.database.Create(ctx, reqData)
 		if err != nil {
@@ -56,21 +114,51 @@ func (s *service) handleList() http.HandlerFunc {
 		ctx := context.TODO()
 
 		urlQueryParams := []string{"id", "_from", "_to", "timestamp"}
-		query, err := trade.BuildFilterQueryFromURLParams(trade.NewArangoQueryBuilder("users"), r, urlQueryParams, trade.NewPaginate(r))
-
+		query, err := trade.QueryFromURLParams[trade.Transaction](r, urlQueryParams, relations)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
 			return
 		}
 
-		resp, err := s.database.Query(ctx, query.String())
+		// Restricted callers only see transactions involving their accounts
+		if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_READ_ANY); restricted {
+			owned, err := s.ownedAccounts(ctx, self)
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+			query = query.WhereAny(
+				trade.NewFilterKey("_from", trade.In, owned),
+				trade.NewFilterKey("_to", trade.In, owned),
+			)
+		}
+
+		if query.IsProjected() {
+			resp, err := s.database.QueryRaw(ctx, query)
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+
+			s.renderer.RenderJSON(w, r, http.StatusOK, newPageResponse(resp, trade.NextCursor(query, len(resp))))
+			return
+		}
+
+		// Large exports are streamed from the cursor rather than paged in memory
+		if s.renderer.Streams(r) {
+			s.renderer.RenderStream(w, r, http.StatusOK, func(emit func(row interface{}) error) error {
+				return s.database.QueryEach(ctx, query, func(t trade.Transaction) error { return emit(t) })
+			})
+			return
+		}
+
+		resp, err := s.database.Query(ctx, query)
 		if err != nil {
 			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
 
-		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+		s.renderer.RenderJSON(w, r, http.StatusOK, newPageResponse(resp, trade.NextCursor(query, len(resp))))
 	}
 }
 
@@ -79,20 +167,72 @@ func (s *service) handleGet() http.HandlerFunc {
 		var err error
 		ctx := context.TODO()
 
-		resp, err := s.database.Get(ctx, chi.URLParam(r, "id"))
+		query, err := trade.QueryFromURLParams[trade.Transaction](r, nil, relations)
 		if err != nil {
-			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		transaction, err := s.database.Get(ctx, chi.URLParam(r, "id"))
+		if err != nil {
+			if arango.IsNotFoundGeneral(err) {
+				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+				return
+			} else {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+		}
+		if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_READ_ANY); restricted {
+			owned, err := s.owns(ctx, self, transaction.Sender, transaction.Recipient)
+			if err != nil && !errors.Is(err, trade.ErrUnknownAccount) {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+			if !owned {
+				err = fmt.Errorf("%w: %s does not involve an account of the caller", auth.ErrForbidden, transaction.ID)
+				s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+				return
+			}
+		}
+		// Expanded accounts have revisions of their own
+		if len(query.Relations) == 0 && trade.NotModified(w, r, transaction.Rev) {
+			return
+		}
+
+		if query.IsProjected() {
+			query = query.Where(trade.NewFilterKey("_key", trade.Eq, trade.DocumentKey(chi.URLParam(r, "id")))).Page(0, 1)
+			resp, err := s.database.QueryRaw(ctx, query)
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+			if len(resp) < 1 {
+				s.renderer.RenderError(w, r, nil, http.StatusNotFound, "%s not found", chi.URLParam(r, "id"))
+				return
+			}
+
+			s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp[0]))
 			return
 		}
 
-		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(transaction))
 	}
 }
 
+// handlePut replaces a transaction. Like a patch it may not change any field
+// of a settled transaction, so the replacement must repeat the sender,
+// recipient and quantities as they are.
 func (s *service) handlePut() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		ctx := context.TODO()
+
+		// Posted transactions are amended or reversed by administrators only
+		if err = auth.Authorize(r.Context(), auth.PERMISSION_WRITE_ANY, ""); err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+			return
+		}
 		data := trade.Transaction{}
 
 		err = bindRequest(r, &data)
@@ -101,56 +241,345 @@ func (s *service) handlePut() http.HandlerFunc {
 			return
 		}
 
-		_, err = s.database.Update(ctx, chi.URLParam(r, "id"), data)
+		existing, err := s.database.Get(ctx, chi.URLParam(r, "id"))
 		if err != nil {
 			if arango.IsNotFoundGeneral(err) {
 				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
-				return
 			} else {
 				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
-				return
 			}
+			return
+		}
+		if rev := trade.IfMatch(r); rev != "" && rev != existing.Rev {
+			s.renderer.RenderError(w, r, errStaleRevision, http.StatusPreconditionFailed, "%s", errStaleRevision.Error())
+			return
+		}
+		if field := changedField(existing, data); field != "" {
+			s.renderPatchError(w, r, fmt.Errorf("%w: %s", trade.ErrImmutableField, field))
+			return
 		}
 
+		w.Header().Set("ETag", trade.ETag(existing.Rev))
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+// handleDelete reverses a transaction, moving its quantities back to the
+// sender as it is deleted, so that balances keep matching the transactions
+// recorded.
 func (s *service) handleDelete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		ctx := context.TODO()
 
-		err = s.database.Delete(ctx, chi.URLParam(r, "id"))
+		// Posted transactions are amended or reversed by administrators only
+		if err = auth.Authorize(r.Context(), auth.PERMISSION_WRITE_ANY, ""); err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+			return
+		}
+
+		if s.settler != nil {
+			err = s.settler.Reverse(trade.WithIfMatch(ctx, r), chi.URLParam(r, "id"))
+		} else {
+			err = s.database.Delete(trade.WithIfMatch(ctx, r), chi.URLParam(r, "id"))
+		}
+		if err != nil {
+			if arango.IsNotFoundGeneral(err) {
+				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+			} else if arango.IsPreconditionFailed(err) {
+				s.renderer.RenderError(w, r, err, http.StatusPreconditionFailed, "%s", err.Error())
+			} else {
+				s.renderSettlementError(w, r, err)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handlePatch applies a JSON Merge Patch or JSON Patch to a transaction.
+func (s *service) handlePatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		id := chi.URLParam(r, "id")
+
+		// Posted transactions are amended by administrators only
+		if err = auth.Authorize(r.Context(), auth.PERMISSION_WRITE_ANY, ""); err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+			return
+		}
+
+		existing, err := s.database.Get(ctx, id)
+		if err != nil {
+			if arango.IsNotFoundGeneral(err) {
+				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+			} else {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			}
+			return
+		}
+
+		data, err := trade.Patch(r, existing, immutable...)
+		if err != nil {
+			s.renderPatchError(w, r, err)
+			return
+		}
+
+		// Replace only the revision the patch was applied to
+		rev := trade.IfMatch(r)
+		if rev == "" {
+			rev = existing.Rev
+		}
+		data, err = s.database.Replace(trade.WithRevision(ctx, rev), id, data)
 		if err != nil {
 			if arango.IsNotFoundGeneral(err) {
 				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
 				return
+			} else if arango.IsPreconditionFailed(err) {
+				s.renderer.RenderError(w, r, err, http.StatusPreconditionFailed, "%s", err.Error())
+				return
 			} else {
 				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 				return
 			}
 		}
 
-		w.WriteHeader(http.StatusNoContent)
+		w.Header().Set("ETag", trade.ETag(data.Rev))
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(data))
 	}
 }
 
+// batchOperation returns the transaction a batch item posts, authorized and
+// validated like a single transaction, or the error failing it. Posted
+// transactions are amended on their own routes, so a batch may only create
+// them.
+func (s *service) batchOperation(ctx context.Context, r *http.Request, item trade.BatchItem) (trade.BatchOperation[trade.Transaction], error) {
+	op := trade.BatchOperation[trade.Transaction]{Op: item.Op, ID: item.ID}
+	if item.Op != trade.BATCH_CREATE {
+		return op, &trade.ValidationError{Errors: []trade.FieldError{
+			{Field: "op", Code: trade.CODE_UNKNOWN_OPERATION, Message: "must be " + trade.BATCH_CREATE + ", transactions are amended on their own routes"},
+		}}
+	}
+	var reqBody request
+	if err := item.Decode(&reqBody); err != nil {
+		return op, err
+	}
+	op.Data = trade.Transaction{Quantities: reqBody.Quantities, Sender: reqBody.Sender, Recipient: reqBody.Recipient, Timestamp: time.Now().UTC()}
+
+	if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_WRITE_ANY); restricted {
+		owned, err := s.owns(ctx, self, op.Data.Sender)
+		if err != nil {
+			return op, err
+		}
+		if !owned {
+			err = fmt.Errorf("%w: %s is not an account of the caller", auth.ErrForbidden, trade.DocumentID("accounts", op.Data.Sender))
+			return op, trade.WithErrorKind(err, trade.ERROR_FORBIDDEN)
+		}
+	}
+	return op, nil
+}
+
+// writeBatch settles the transactions of ops in their order, or only records
+// them without a settler.
+func (s *service) writeBatch(ctx context.Context, ops []trade.BatchOperation[trade.Transaction], atomic bool) ([]trade.BatchResult[trade.Transaction], error) {
+	if s.settler == nil {
+		return s.database.Batch(ctx, ops, atomic)
+	}
+	ts := make([]trade.Transaction, len(ops))
+	for i, op := range ops {
+		ts[i] = op.Data
+	}
+	return s.settler.SettleBatch(ctx, ts, atomic)
+}
+
+// handleExport streams the transactions sent or received by ?account= from
+// ?from= until ?to=, oldest first, as CSV or JSON Lines. The format is chosen
+// with ?format=csv or ?format=jsonl, or else by the Accept header. Restricted
+// callers may only export the transactions of accounts they own.
+func (s *service) handleExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		filter, format, err := exportParams(r)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		query := filter.Query()
+
+		if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_READ_ANY); restricted {
+			if filter.Account == "" {
+				owned, err := s.ownedAccounts(ctx, self)
+				if err != nil {
+					s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+					return
+				}
+				query = query.WhereAny(
+					trade.NewFilterKey("_from", trade.In, owned),
+					trade.NewFilterKey("_to", trade.In, owned),
+				)
+			} else if owned, err := s.owns(ctx, self, filter.Account); err != nil || !owned {
+				if err == nil || errors.Is(err, trade.ErrUnknownAccount) {
+					err = fmt.Errorf("%w: %s is not an account of the caller", auth.ErrForbidden, trade.DocumentID("accounts", filter.Account))
+					s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+					return
+				}
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", ledger.MEDIA_TYPES[format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions.%s"`, format))
+		out, _ := ledger.NewWriter(w, format)
+		n, err := ledger.Export(ctx, s.database, query, out)
+		switch {
+		case err != nil && n == 0:
+			w.Header().Del("Content-Disposition")
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+		case err != nil:
+			// The client sees an aborted response rather than a truncated export
+			log.Printf("%s %s: request %s: export failed: %v", r.Method, r.URL.Path, trade.RequestIDFromContext(r.Context()), err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// exportParams returns the filter and format of an export from the URL
+// parameters and Accept header of r.
+func exportParams(r *http.Request) (ledger.Filter, string, error) {
+	params := r.URL.Query()
+	filter := ledger.Filter{Account: params.Get("account")}
+	var errs []trade.FieldError
+	for name, bound := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if val := params.Get(name); val != "" {
+			t, err := ledger.ParseTime(val)
+			if err != nil {
+				errs = append(errs, trade.FieldError{Field: name, Code: trade.CODE_INVALID_TYPE, Message: "must be a date or an RFC 3339 timestamp"})
+			}
+			*bound = t
+		}
+	}
+
+	format := ledger.FORMAT_JSONL
+	if val := params.Get("format"); val != "" {
+		var err error
+		if format, err = ledger.ParseFormat(val); err != nil {
+			errs = append(errs, trade.FieldError{Field: "format", Code: trade.CODE_INVALID_TYPE, Message: "must be " + ledger.FORMAT_CSV + " or " + ledger.FORMAT_JSONL})
+		}
+	} else if strings.Contains(r.Header.Get("Accept"), trade.MEDIA_TYPE_CSV) {
+		format = ledger.FORMAT_CSV
+	}
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return filter, format, &trade.ValidationError{Errors: errs}
+	}
+	return filter, format, nil
+}
+
+// renderPatchError renders an error returned by applying a patch to a
+// transaction.
+func (s *service) renderPatchError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, trade.ErrUnsupportedPatch):
+		s.renderer.RenderError(w, r, err, http.StatusUnsupportedMediaType, "%s", err.Error())
+	case errors.Is(err, trade.ErrInvalidPatch):
+		s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+	case errors.Is(err, trade.ErrImmutableField):
+		s.renderer.RenderError(w, r, err, http.StatusUnprocessableEntity, "%s", err.Error())
+	case errors.Is(err, trade.ErrPatchTestFailed):
+		s.renderer.RenderError(w, r, err, http.StatusConflict, "%s", err.Error())
+	default:
+		s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+	}
+}
+
+// renderSettlementError renders an error returned by Settler.Settle.
+func (s *service) renderSettlementError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, trade.ErrInvalidTransaction), errors.Is(err, trade.ErrUnknownAccount), errors.Is(err, trade.ErrInsufficientFunds):
+		s.renderer.RenderError(w, r, err, http.StatusUnprocessableEntity, "%s", err.Error())
+	default:
+		s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+	}
+}
+
+// owns reports whether the user with _id owner owns any of the accounts
+// identified by ids. Unknown accounts are owned by no one; if none of ids is
+// known the returned error wraps trade.ErrUnknownAccount.
+func (s *service) owns(ctx context.Context, owner string, ids ...string) (bool, error) {
+	if s.accounts == nil {
+		return false, nil
+	}
+
+	known := 0
+	for _, id := range ids {
+		account, err := s.accounts.Get(ctx, id)
+		if arango.IsNotFoundGeneral(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		known++
+		if account.Owner == owner {
+			return true, nil
+		}
+	}
+	if known == 0 {
+		return false, fmt.Errorf("%w: %s", trade.ErrUnknownAccount, strings.Join(ids, ", "))
+	}
+	return false, nil
+}
+
+// ownedAccounts returns the _ids of the accounts owned by the user with _id
+// owner.
+func (s *service) ownedAccounts(ctx context.Context, owner string) ([]string, error) {
+	ids := []string{}
+	if s.accounts == nil {
+		return ids, nil
+	}
+
+	accounts, err := s.accounts.Query(ctx, trade.NewQuery().Where(trade.NewFilterKey("owner", trade.Eq, owner)))
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		ids = append(ids, account.ID)
+	}
+	return ids, nil
+}
+
 // bindRequest is a helper function for binding data from a request to a
 // transaction object.
 func bindRequest(r *http.Request, t *trade.Transaction) error {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
+	var reqBody request
+	if err := trade.DecodeRequest(r, &reqBody); err != nil {
 		return err
 	}
 
-	var reqBody request
-	err = json.Unmarshal(body, &reqBody)
-
 	t.Quantities = reqBody.Quantities
 	t.Sender = reqBody.Sender
 	t.Recipient = reqBody.Recipient
-	t.Timestamp = time.Now()
 
 	return nil
 }
+
+var errStaleRevision = errors.New("transaction has been modified since the revision in If-Match")
+
+// changedField returns the JSON name of the first field of the transaction
+// existing that data, bound from a request, changes, or "" if it changes none.
+func changedField(existing, data trade.Transaction) string {
+	switch {
+	case trade.DocumentID("accounts", data.Sender) != existing.Sender:
+		return "_from"
+	case trade.DocumentID("accounts", data.Recipient) != existing.Recipient:
+		return "_to"
+	case !reflect.DeepEqual(data.Quantities, existing.Quantities):
+		return "quantities"
+	}
+	return ""
+}
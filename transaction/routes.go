@@ -11,6 +11,7 @@ func (s *service) Routes() chi.Router {
 	r.Route("/{id}", func(r chi.Router) {
 		r.Get("/", s.handleGet())
 		r.Put("/", s.handlePut())
+		r.Patch("/", s.handlePatch())
 		r.Delete("/", s.handleDelete())
 	})
 
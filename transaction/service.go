@@ -11,14 +11,35 @@ import (
 // Repository is the API for the Transaction datastore.
 type Repository interface {
 	Create(ctx context.Context, t trade.Transaction) (string, trade.Transaction, error)
-	Query(ctx context.Context, query string) ([]trade.Transaction, error)
+	Query(ctx context.Context, q trade.Query) ([]trade.Transaction, error)
+	QueryEach(ctx context.Context, q trade.Query, fn func(t trade.Transaction) error) error
+	QueryRaw(ctx context.Context, q trade.Query) ([]map[string]interface{}, error)
 	Get(ctx context.Context, id string) (trade.Transaction, error)
 	Update(ctx context.Context, id string, t trade.Transaction) (trade.Transaction, error)
+	Replace(ctx context.Context, id string, t trade.Transaction) (trade.Transaction, error)
 	Delete(ctx context.Context, id string) error
+	Batch(ctx context.Context, ops []trade.BatchOperation[trade.Transaction], atomic bool) ([]trade.BatchResult[trade.Transaction], error)
+}
+
+// Settler is the API for posting and reversing transactions, which moves
+// their quantities between the balances of the accounts involved.
+type Settler interface {
+	Settle(ctx context.Context, t trade.Transaction) (string, trade.Transaction, error)
+	SettleBatch(ctx context.Context, ts []trade.Transaction, atomic bool) ([]trade.BatchResult[trade.Transaction], error)
+	Reverse(ctx context.Context, id string) error
+}
+
+// AccountRepository is the API for looking up the accounts transactions move
+// quantities between, to check who owns them.
+type AccountRepository interface {
+	Get(ctx context.Context, id string) (trade.Account, error)
+	Query(ctx context.Context, q trade.Query) ([]trade.Account, error)
 }
 
 type Renderer interface {
 	RenderJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, body interface{})
+	Streams(r *http.Request) bool
+	RenderStream(w http.ResponseWriter, r *http.Request, httpStatusCode int, stream func(emit func(row interface{}) error) error)
 	RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any)
 }
 
@@ -27,6 +48,8 @@ type Renderer interface {
 type service struct {
 	router   chi.Router
 	database Repository
+	settler  Settler
+	accounts AccountRepository
 	renderer Renderer
 }
 
@@ -38,10 +61,12 @@ func New(r chi.Router, endpoint string, database Repository, renderer Renderer,
 		renderer: renderer,
 	}
 	r.Mount(endpoint, svc.Routes())
+	r.Get(endpoint+":export", svc.handleExport())
 
 	for _, option := range options {
 		option(svc)
 	}
+	r.Post(endpoint+":batch", trade.HandleBatch(svc.renderer, svc.batchOperation, svc.writeBatch))
 
 	return svc
 }
@@ -53,3 +78,22 @@ func WithRepository(repo Repository) func(*service) {
 		s.database = repo
 	}
 }
+
+// WithSettler is a functional option for configuring the settler a transaction
+// service posts new transactions through. Without one transactions are stored
+// without affecting account balances.
+func WithSettler(settler Settler) func(*service) {
+	return func(s *service) {
+		s.settler = settler
+	}
+}
+
+// WithAccountRepository is a functional option for configuring the repository
+// a transaction service checks account ownership against. Without one only
+// callers allowed to act on the resources of any user can read or create
+// transactions.
+func WithAccountRepository(repo AccountRepository) func(*service) {
+	return func(s *service) {
+		s.accounts = repo
+	}
+}
@@ -1,9 +1,12 @@
 package trade
 
-// User represents a user.
+// User represents a user. Roles grant the user access to the resources of
+// other users.
 type User struct {
-	ID          string `json:"_id"`
-	Name        string `json:"name"`
-	Email       string `json:"email"`
-	PhoneNumber string `json:"phoneNumber"`
+	ID          string   `json:"_id"`
+	Rev         string   `json:"_rev,omitempty"`
+	Name        string   `json:"name" validate:"required"`
+	Email       string   `json:"email" validate:"email"`
+	PhoneNumber string   `json:"phoneNumber" validate:"e164"`
+	Roles       []string `json:"roles"`
 }
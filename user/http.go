@@ -2,36 +2,64 @@ package user
 
 import (
 	"context"
-	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"net/http"
 
 	arango "github.com/arangodb/go-driver"
 	"github.com/gabriel-ross/trade"
+	"github.com/gabriel-ross/trade/auth"
 	"github.com/go-chi/chi"
 )
 
 // request represents a request body containing user data.
 type request struct {
-	Name        string `json:"name"`
-	Email       string `json:"email"`
-	PhoneNumber string `json:"phoneNumber"`
+	Name        string `json:"name" validate:"required"`
+	Email       string `json:"email" validate:"email"`
+	PhoneNumber string `json:"phoneNumber" validate:"e164"`
 }
 
-type response[T trade.User | []trade.User] struct {
-	Data T `json:"data"`
+// rolesRequest represents a request body replacing the roles of a user.
+type rolesRequest struct {
+	Roles []string `json:"roles"`
 }
 
-func newResponse[T trade.User | []trade.User](data T) response[T] {
+// immutable are the user fields a patch may not change.
+var immutable = []string{"_id", "_rev", "roles"}
+
+// netWorth is the total balance of each currency across a user's accounts.
+type netWorth struct {
+	Accounts int                `json:"accounts"`
+	Balances map[string]float64 `json:"balances"`
+}
+
+type response[T trade.User | []trade.User | map[string]interface{} | []map[string]interface{} | []trade.Account | netWorth] struct {
+	Data T      `json:"data"`
+	Next string `json:"next,omitempty"`
+}
+
+func newResponse[T trade.User | []trade.User | map[string]interface{} | []map[string]interface{} | []trade.Account | netWorth](data T) response[T] {
 	return response[T]{Data: data}
 }
 
+// newPageResponse returns a response holding a single page of results and the
+// cursor of the page after it.
+func newPageResponse[T trade.User | []trade.User | map[string]interface{} | []map[string]interface{} | []trade.Account | netWorth](data T, next string) response[T] {
+	return response[T]{Data: data, Next: next}
+}
+
+// handleCreate creates a user. Only callers allowed to write the resources of
+// any user may create users.
 func (s *service) handleCreate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		ctx := context.TODO()
 		reqData := trade.User{}
 
+		if !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, "") {
+			return
+		}
+
 		err = bindRequest(r, &reqData)
 		if err != nil {
 			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
@@ -61,19 +89,84 @@ func (s *service) handleList() http.HandlerFunc {
 		ctx := context.TODO()
 
 		urlQueryParams := []string{"id", "name", "email", "phoneNumber"}
-		query, err := trade.BuildFilterQueryFromURLParams(trade.NewArangoQueryBuilder("users"), r, urlQueryParams, trade.NewPaginate(r))
+		query, err := trade.QueryFromURLParams[trade.User](r, urlQueryParams, nil)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_READ_ANY); restricted {
+			query = query.Where(trade.NewFilterKey("_id", trade.Eq, self))
+		}
+
+		if query.IsProjected() {
+			resp, err := s.database.QueryRaw(ctx, query)
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+
+			s.renderer.RenderJSON(w, r, http.StatusOK, newPageResponse(resp, trade.NextCursor(query, len(resp))))
+			return
+		}
+
+		// Large exports are streamed from the cursor rather than paged in memory
+		if s.renderer.Streams(r) {
+			s.renderer.RenderStream(w, r, http.StatusOK, func(emit func(row interface{}) error) error {
+				return s.database.QueryEach(ctx, query, func(u trade.User) error { return emit(u) })
+			})
+			return
+		}
 
+		resp, err := s.database.Query(ctx, query)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
 
-		resp, err := s.database.Query(ctx, query.String())
+		s.renderer.RenderJSON(w, r, http.StatusOK, newPageResponse(resp, trade.NextCursor(query, len(resp))))
+	}
+}
+
+// handleSearch returns the users whose name, email or phone number best match
+// the words of ?q=, tolerating typos and partial words.
+func (s *service) handleSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+
+		if s.searcher == nil {
+			err = errors.New("user search is not configured")
+			s.renderer.RenderError(w, r, err, http.StatusNotImplemented, "%s", err.Error())
+			return
+		}
+
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			err = errors.New("missing search query q")
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		p, err := trade.NewPaginate(r)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		resp, err := s.searcher.Search(ctx, q, p.Limit)
 		if err != nil {
 			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 			return
 		}
+		if self, restricted := auth.Restrict(r.Context(), auth.PERMISSION_READ_ANY); restricted {
+			visible := []trade.User{}
+			for _, u := range resp {
+				if u.ID == self {
+					visible = append(visible, u)
+				}
+			}
+			resp = visible
+		}
 
 		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
 	}
@@ -84,29 +177,177 @@ func (s *service) handleGet() http.HandlerFunc {
 		var err error
 		ctx := context.TODO()
 
-		resp, err := s.database.Get(ctx, chi.URLParam(r, "id"))
+		if !s.authorize(w, r, auth.PERMISSION_READ_ANY, chi.URLParam(r, "id")) {
+			return
+		}
+
+		u, err := s.database.Get(ctx, chi.URLParam(r, "id"))
 		if err != nil {
-			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			if arango.IsNotFoundGeneral(err) {
+				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+				return
+			} else {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+		}
+		if trade.NotModified(w, r, u.Rev) {
 			return
 		}
 
-		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+		query := trade.NewQuery().Keep(trade.FieldsFromURLParams(r)...)
+
+		if query.IsProjected() {
+			query = query.Where(trade.NewFilterKey("_key", trade.Eq, trade.DocumentKey(chi.URLParam(r, "id")))).Page(0, 1)
+			resp, err := s.database.QueryRaw(ctx, query)
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+			if len(resp) < 1 {
+				s.renderer.RenderError(w, r, nil, http.StatusNotFound, "%s not found", chi.URLParam(r, "id"))
+				return
+			}
+
+			s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp[0]))
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(u))
 	}
 }
 
+// handlePut replaces the details of a user. With an If-Match header the user
+// is only replaced if it is still at that revision.
 func (s *service) handlePut() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		ctx := context.TODO()
 		data := trade.User{}
 
+		if !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, chi.URLParam(r, "id")) {
+			return
+		}
+
 		err = bindRequest(r, &data)
 		if err != nil {
 			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
 			return
 		}
 
-		_, err = s.database.Update(ctx, chi.URLParam(r, "id"), data)
+		// Roles are only changed through their own route
+		existing, err := s.database.Get(ctx, chi.URLParam(r, "id"))
+		if err == nil {
+			data.Roles = existing.Roles
+			data, err = s.database.Update(trade.WithIfMatch(ctx, r), chi.URLParam(r, "id"), data)
+		}
+		if err != nil {
+			s.renderWriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", trade.ETag(data.Rev))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDelete deletes a user. A user that still owns accounts is only deleted
+// along with their accounts when ?cascade=true, otherwise the request
+// conflicts. Accounts that sent or received transactions are never deleted.
+func (s *service) handleDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		id := chi.URLParam(r, "id")
+
+		if !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, id) {
+			return
+		}
+
+		var owned []trade.Account
+		if s.accounts != nil {
+			owned, err = s.ownedAccounts(ctx, id, trade.NewQuery())
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+
+			if len(owned) > 0 && r.URL.Query().Get("cascade") != "true" {
+				s.renderer.RenderError(w, r, nil, http.StatusConflict, "user %s owns %d accounts, retry with ?cascade=true to delete them", id, len(owned))
+				return
+			}
+
+			// Deleting accounts would leave their transactions pointing at nothing
+			if len(owned) > 0 && s.transactions != nil {
+				ids := make([]string, len(owned))
+				for i, account := range owned {
+					ids[i] = account.ID
+				}
+				transactions, err := s.transactions.Query(ctx, trade.NewQuery().WhereAny(
+					trade.NewFilterKey("_from", trade.In, ids),
+					trade.NewFilterKey("_to", trade.In, ids),
+				).Page(0, 1))
+				if err != nil {
+					s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+					return
+				}
+				if len(transactions) > 0 {
+					s.renderer.RenderError(w, r, nil, http.StatusConflict, "accounts of user %s have transactions, such as %s, and cannot be deleted", id, transactions[0].ID)
+					return
+				}
+			}
+		}
+
+		// The user is deleted before its accounts, so that a stale revision
+		// fails the request before anything is deleted
+		err = s.database.Delete(trade.WithIfMatch(ctx, r), id)
+		if err != nil {
+			s.renderWriteError(w, r, err)
+			return
+		}
+
+		if len(owned) > 0 {
+			// The accounts are deleted together or not at all
+			ops := make([]trade.BatchOperation[trade.Account], len(owned))
+			for i, account := range owned {
+				ops[i] = trade.BatchOperation[trade.Account]{Op: trade.BATCH_DELETE, ID: account.ID}
+			}
+			results, err := s.accounts.Batch(ctx, ops, true)
+			if err == nil {
+				for _, result := range results {
+					if result.Err != nil && !errors.Is(result.Err, trade.ErrBatchAborted) {
+						err = result.Err
+						break
+					}
+				}
+			}
+			if err != nil {
+				s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGetAccounts lists the accounts owned by a user.
+func (s *service) handleGetAccounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		id := chi.URLParam(r, "id")
+
+		if !s.authorize(w, r, auth.PERMISSION_READ_ANY, id) {
+			return
+		}
+
+		if s.accounts == nil {
+			s.renderer.RenderError(w, r, errNoAccounts, http.StatusNotImplemented, "%s", errNoAccounts.Error())
+			return
+		}
+
+		_, err = s.database.Get(ctx, id)
 		if err != nil {
 			if arango.IsNotFoundGeneral(err) {
 				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
@@ -117,16 +358,40 @@ func (s *service) handlePut() http.HandlerFunc {
 			}
 		}
 
-		w.WriteHeader(http.StatusNoContent)
+		query, err := trade.QueryFromURLParams[trade.Account](r, nil, nil)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		resp, err := s.ownedAccounts(ctx, id, query)
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newPageResponse(resp, trade.NextCursor(query, len(resp))))
 	}
 }
 
-func (s *service) handleDelete() http.HandlerFunc {
+// handleGetNetWorth sums the balances of every account owned by a user per
+// currency.
+func (s *service) handleGetNetWorth() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		ctx := context.TODO()
+		id := chi.URLParam(r, "id")
+
+		if !s.authorize(w, r, auth.PERMISSION_READ_ANY, id) {
+			return
+		}
+
+		if s.accounts == nil {
+			s.renderer.RenderError(w, r, errNoAccounts, http.StatusNotImplemented, "%s", errNoAccounts.Error())
+			return
+		}
 
-		err = s.database.Delete(ctx, chi.URLParam(r, "id"))
+		_, err = s.database.Get(ctx, id)
 		if err != nil {
 			if arango.IsNotFoundGeneral(err) {
 				s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
@@ -137,47 +402,208 @@ func (s *service) handleDelete() http.HandlerFunc {
 			}
 		}
 
-		w.WriteHeader(http.StatusNoContent)
+		owned, err := s.ownedAccounts(ctx, id, trade.NewQuery())
+		if err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			return
+		}
+
+		resp := netWorth{
+			Accounts: len(owned),
+			Balances: map[string]float64{},
+		}
+		for _, account := range owned {
+			for currency, balance := range account.Balances {
+				resp.Balances[currency] += balance
+			}
+		}
+
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
 	}
 }
 
-func (s *service) handleGetAccounts() http.HandlerFunc {
+// handlePatch applies a JSON Merge Patch or JSON Patch to a user. Roles are
+// only changed through their own route.
+func (s *service) handlePatch() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var err error
 		ctx := context.TODO()
+		id := chi.URLParam(r, "id")
 
-		query := trade.NewArangoQueryBuilder("accounts").Filter(trade.NewFilterKey("id", trade.Eq, chi.URLParam(r, "id"))).Paginate(trade.NewPaginate(r)).Done()
+		if !s.authorize(w, r, auth.PERMISSION_WRITE_ANY, id) {
+			return
+		}
 
+		existing, err := s.database.Get(ctx, id)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			s.renderWriteError(w, r, err)
 			return
 		}
 
-		resp, err := s.database.Query(ctx, query.String())
+		data, err := trade.Patch(r, existing, immutable...)
 		if err != nil {
-			s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+			s.renderPatchError(w, r, err)
 			return
 		}
 
-		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(resp))
+		// Replace only the revision the patch was applied to
+		rev := trade.IfMatch(r)
+		if rev == "" {
+			rev = existing.Rev
+		}
+		data, err = s.database.Replace(trade.WithRevision(ctx, rev), id, data)
+		if err != nil {
+			s.renderWriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", trade.ETag(data.Rev))
+		s.renderer.RenderJSON(w, r, http.StatusOK, newResponse(data))
+	}
+}
+
+// handlePutRoles replaces the roles of a user. Only callers allowed to manage
+// roles may change them.
+func (s *service) handlePutRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := context.TODO()
+		id := chi.URLParam(r, "id")
+
+		if !s.authorize(w, r, auth.PERMISSION_MANAGE_ROLES, "") {
+			return
+		}
+
+		var reqData rolesRequest
+		if err = trade.DecodeRequest(r, &reqData); err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		if err = auth.ValidateRoles(reqData.Roles); err != nil {
+			s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+
+		u, err := s.database.Get(ctx, id)
+		if err == nil {
+			u.Roles = append([]string{}, reqData.Roles...)
+			u, err = s.database.Update(trade.WithIfMatch(ctx, r), id, u)
+		}
+		if err != nil {
+			s.renderWriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", trade.ETag(u.Rev))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// batchOperation returns the creation, update or deletion of a user that a
+// batch item stands for, or the error failing it. Each is authorized and
+// validated like a request for a single user.
+func (s *service) batchOperation(ctx context.Context, r *http.Request, item trade.BatchItem) (trade.BatchOperation[trade.User], error) {
+	op := trade.BatchOperation[trade.User]{Op: item.Op, ID: item.ID}
+	var reqBody request
+	if err := item.Decode(&reqBody); err != nil {
+		return op, err
+	}
+
+	owner := ""
+	if item.Op != trade.BATCH_CREATE {
+		owner = trade.DocumentID("users", item.ID)
+	}
+	if err := auth.Authorize(r.Context(), auth.PERMISSION_WRITE_ANY, owner); err != nil {
+		return op, trade.WithErrorKind(err, trade.ERROR_FORBIDDEN)
+	}
+
+	op.Data = trade.User{Name: reqBody.Name, Email: reqBody.Email, PhoneNumber: reqBody.PhoneNumber, Roles: []string{}}
+	switch item.Op {
+	case trade.BATCH_UPDATE:
+		// Roles are only changed through their own route
+		existing, err := s.database.Get(ctx, item.ID)
+		if err != nil {
+			return op, err
+		}
+		op.Data.Roles = existing.Roles
+	case trade.BATCH_DELETE:
+		// Accounts are only deleted along with their owner on its own route
+		if s.accounts != nil {
+			owned, err := s.ownedAccounts(ctx, item.ID, trade.NewQuery().Page(0, 1))
+			if err != nil {
+				return op, err
+			}
+			if len(owned) > 0 {
+				err = fmt.Errorf("user %s owns accounts, delete it with ?cascade=true to delete them", item.ID)
+				return op, trade.WithErrorKind(err, trade.ERROR_CONFLICT)
+			}
+		}
+	}
+	return op, nil
+}
+
+// renderWriteError renders an error returned by a write to a user.
+func (s *service) renderWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case arango.IsNotFoundGeneral(err):
+		s.renderer.RenderError(w, r, err, http.StatusNotFound, "%s", err.Error())
+	case arango.IsPreconditionFailed(err):
+		s.renderer.RenderError(w, r, err, http.StatusPreconditionFailed, "%s", err.Error())
+	default:
+		s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
 	}
 }
 
+// renderPatchError renders an error returned by applying a patch to a user.
+func (s *service) renderPatchError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, trade.ErrUnsupportedPatch):
+		s.renderer.RenderError(w, r, err, http.StatusUnsupportedMediaType, "%s", err.Error())
+	case errors.Is(err, trade.ErrInvalidPatch):
+		s.renderer.RenderError(w, r, err, http.StatusBadRequest, "%s", err.Error())
+	case errors.Is(err, trade.ErrImmutableField):
+		s.renderer.RenderError(w, r, err, http.StatusUnprocessableEntity, "%s", err.Error())
+	case errors.Is(err, trade.ErrPatchTestFailed):
+		s.renderer.RenderError(w, r, err, http.StatusConflict, "%s", err.Error())
+	default:
+		s.renderer.RenderError(w, r, err, http.StatusInternalServerError, "%s", err.Error())
+	}
+}
+
+// authorize renders 403 Forbidden and returns false unless the caller of r is
+// the user with id or holds perm.
+func (s *service) authorize(w http.ResponseWriter, r *http.Request, perm auth.Permission, id string) bool {
+	owner := ""
+	if id != "" {
+		owner = trade.DocumentID("users", id)
+	}
+	if err := auth.Authorize(r.Context(), perm, owner); err != nil {
+		s.renderer.RenderError(w, r, err, http.StatusForbidden, "%s", err.Error())
+		return false
+	}
+	return true
+}
+
+var errNoAccounts = errors.New("user accounts are not configured")
+
+// ownedAccounts returns the accounts matching q that are owned by the user
+// with id.
+func (s *service) ownedAccounts(ctx context.Context, id string, q trade.Query) ([]trade.Account, error) {
+	return s.accounts.Query(ctx, q.Where(trade.NewFilterKey("owner", trade.Eq, trade.DocumentID("users", id))))
+}
+
 // bindRequest is a helper function for binding data from a request to a user
 // object.
 func bindRequest(r *http.Request, u *trade.User) error {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
+	var reqBody request
+	if err := trade.DecodeRequest(r, &reqBody); err != nil {
 		return err
 	}
 
-	var reqBody request
-	err = json.Unmarshal(body, &reqBody)
-
 	u.Name = reqBody.Name
 	u.Email = reqBody.Email
 	u.PhoneNumber = reqBody.PhoneNumber
+	u.Roles = []string{}
 
 	return nil
 }
@@ -8,11 +8,15 @@ func (s *service) Routes() chi.Router {
 
 	r.Post("/", s.handleCreate())
 	r.Get("/", s.handleList())
+	r.Get("/search", s.handleSearch())
 	r.Route("/{id}", func(r chi.Router) {
 		r.Get("/", s.handleGet())
 		r.Put("/", s.handlePut())
+		r.Patch("/", s.handlePatch())
 		r.Delete("/", s.handleDelete())
 		r.Get("/accounts", s.handleGetAccounts())
+		r.Get("/networth", s.handleGetNetWorth())
+		r.Put("/roles", s.handlePutRoles())
 	})
 
 	return r
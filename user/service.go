@@ -14,23 +14,49 @@ import (
 // Repository is the API for the User datastore.
 type Repository interface {
 	Create(ctx context.Context, u trade.User) (string, trade.User, error)
-	Query(ctx context.Context, query string) ([]trade.User, error)
+	Query(ctx context.Context, q trade.Query) ([]trade.User, error)
+	QueryEach(ctx context.Context, q trade.Query, fn func(u trade.User) error) error
+	QueryRaw(ctx context.Context, q trade.Query) ([]map[string]interface{}, error)
 	Get(ctx context.Context, id string) (trade.User, error)
 	Update(ctx context.Context, id string, u trade.User) (trade.User, error)
+	Replace(ctx context.Context, id string, u trade.User) (trade.User, error)
 	Delete(ctx context.Context, id string) error
+	Batch(ctx context.Context, ops []trade.BatchOperation[trade.User], atomic bool) ([]trade.BatchResult[trade.User], error)
+}
+
+// AccountRepository is the API for the datastore of the accounts users own.
+type AccountRepository interface {
+	Query(ctx context.Context, q trade.Query) ([]trade.Account, error)
+	Batch(ctx context.Context, ops []trade.BatchOperation[trade.Account], atomic bool) ([]trade.BatchResult[trade.Account], error)
+}
+
+// TransactionRepository is the API for the datastore of the transactions
+// between the accounts users own.
+type TransactionRepository interface {
+	Query(ctx context.Context, q trade.Query) ([]trade.Transaction, error)
+}
+
+// Searcher is the API for ranked full-text search over users.
+type Searcher interface {
+	Search(ctx context.Context, q string, limit int) ([]trade.User, error)
 }
 
 type Renderer interface {
 	RenderJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, body interface{})
+	Streams(r *http.Request) bool
+	RenderStream(w http.ResponseWriter, r *http.Request, httpStatusCode int, stream func(emit func(row interface{}) error) error)
 	RenderError(w http.ResponseWriter, r *http.Request, svrErr error, code int, format string, args ...any)
 }
 
 // Service houses the API and necessary dependencies for interacting with user
 // resources.
 type service struct {
-	router   chi.Router
-	database Repository
-	renderer Renderer
+	router       chi.Router
+	database     Repository
+	accounts     AccountRepository
+	transactions TransactionRepository
+	searcher     Searcher
+	renderer     Renderer
 }
 
 // New mounts the user routes on r at endpoint and returns a new user service.
@@ -45,6 +71,8 @@ func New(r chi.Router, endpoint string, database Repository, renderer Renderer,
 	for _, option := range options {
 		option(svc)
 	}
+	// The batch route binds the repository, which an option may replace
+	r.Post(endpoint+":batch", trade.HandleBatch(svc.renderer, svc.batchOperation, svc.database.Batch))
 
 	return svc
 }
@@ -56,3 +84,28 @@ func WithRepository(repo Repository) func(*service) {
 		s.database = repo
 	}
 }
+
+// WithAccountRepository is a functional option for configuring the repository a
+// user service looks up the accounts owned by its users in.
+func WithAccountRepository(repo AccountRepository) func(*service) {
+	return func(s *service) {
+		s.accounts = repo
+	}
+}
+
+// WithTransactionRepository is a functional option for configuring the
+// repository a user service checks for the transactions of accounts before
+// deleting them along with their owner.
+func WithTransactionRepository(repo TransactionRepository) func(*service) {
+	return func(s *service) {
+		s.transactions = repo
+	}
+}
+
+// WithSearcher is a functional option for configuring the searcher backing a
+// user service's search endpoint.
+func WithSearcher(searcher Searcher) func(*service) {
+	return func(s *service) {
+		s.searcher = searcher
+	}
+}
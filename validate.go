@@ -0,0 +1,305 @@
+package trade
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Machine-readable codes of the field errors of a ValidationError.
+var (
+	CODE_MALFORMED         = "malformed"
+	CODE_UNKNOWN_FIELD     = "unknown_field"
+	CODE_INVALID_TYPE      = "invalid_type"
+	CODE_REQUIRED          = "required"
+	CODE_INVALID_EMAIL     = "invalid_email"
+	CODE_INVALID_PHONE     = "invalid_phone"
+	CODE_NOT_POSITIVE      = "not_positive"
+	CODE_NEGATIVE          = "negative"
+	CODE_UNKNOWN_CURRENCY  = "unknown_currency"
+	CODE_SAME_ACCOUNT      = "same_account"
+	CODE_UNKNOWN_OPERATION = "unknown_operation"
+)
+
+// FieldError describes why a field of a request is invalid. Field is the JSON
+// name of the field, followed by the key for a member of a map, or empty if
+// the request as a whole is invalid.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request fails validation. It lists every
+// failing field.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		if fe.Field == "" {
+			msgs[i] = fe.Message
+		} else {
+			msgs[i] = fe.Field + ": " + fe.Message
+		}
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+// Validator is implemented by types with rules that span several fields.
+// Validate calls it after checking the rules of each field.
+type Validator interface {
+	Validate() []FieldError
+}
+
+// e164 matches phone numbers in E.164 format, such as +14155552671.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// rules are the validation rules that fields can be tagged with, as in
+// `validate:"required,email"`. Each returns the code and message of the
+// failure of v, or "" if v is valid. Rules other than required pass empty
+// values, and those on maps apply to each member.
+var rules = map[string]func(v reflect.Value) (string, string){
+	"required": func(v reflect.Value) (string, string) {
+		if isEmpty(v) {
+			return CODE_REQUIRED, "is required"
+		}
+		return "", ""
+	},
+	"email": func(v reflect.Value) (string, string) {
+		if addr, err := mail.ParseAddress(v.String()); err != nil || addr.Address != v.String() {
+			return CODE_INVALID_EMAIL, "must be an email address"
+		}
+		return "", ""
+	},
+	"e164": func(v reflect.Value) (string, string) {
+		if !e164.MatchString(v.String()) {
+			return CODE_INVALID_PHONE, "must be a phone number in E.164 format, such as +14155552671"
+		}
+		return "", ""
+	},
+	"positive": func(v reflect.Value) (string, string) {
+		if f := v.Float(); !(f > 0) || math.IsInf(f, 1) {
+			return CODE_NOT_POSITIVE, "must be a positive number"
+		}
+		return "", ""
+	},
+	"nonnegative": func(v reflect.Value) (string, string) {
+		if f := v.Float(); !(f >= 0) || math.IsInf(f, 1) {
+			return CODE_NEGATIVE, "must be a non-negative number"
+		}
+		return "", ""
+	},
+}
+
+// keyRules are the validation rules that apply to the keys of maps.
+var keyRules = map[string]func(key string) (string, string){
+	"currencies": func(key string) (string, string) {
+		if _, err := ParseCurrency(key); err != nil {
+			return CODE_UNKNOWN_CURRENCY, err.Error()
+		}
+		return "", ""
+	},
+}
+
+// Validate checks the fields of the struct v against the rules they are
+// tagged with and, if v is a Validator, its rules across fields. Returns a
+// ValidationError listing every failure, or nil if v is valid.
+func Validate(v interface{}) error {
+	if errs := validate(v, nil); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validate returns the failures of the fields of v that are not in skip.
+func validate(v interface{}, skip map[string]bool) []FieldError {
+	var errs []FieldError
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for name, field := range jsonFields(rv.Type()) {
+		tag := field.Tag.Get("validate")
+		if tag == "" || skip[name] {
+			continue
+		}
+		errs = append(errs, validateField(name, rv.FieldByIndex(field.Index), strings.Split(tag, ","))...)
+	}
+	if validator, ok := v.(Validator); ok {
+		for _, fe := range validator.Validate() {
+			if !skip[fe.Field] {
+				errs = append(errs, fe)
+			}
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+// validateField checks the value v of the field name against rules.
+func validateField(name string, v reflect.Value, tagged []string) []FieldError {
+	var errs []FieldError
+	for _, rule := range tagged {
+		if rule == "required" {
+			if code, msg := rules[rule](v); code != "" {
+				return []FieldError{{Field: name, Code: code, Message: msg}}
+			}
+			continue
+		}
+		if isEmpty(v) {
+			continue
+		}
+
+		if keyRule, ok := keyRules[rule]; ok && v.Kind() == reflect.Map {
+			for _, key := range sortedKeys(v) {
+				if code, msg := keyRule(key.String()); code != "" {
+					errs = append(errs, FieldError{Field: name + "." + key.String(), Code: code, Message: msg})
+				}
+			}
+			continue
+		}
+
+		check, ok := rules[rule]
+		if !ok {
+			panic(fmt.Sprintf("trade: unknown validation rule %q of field %s", rule, name))
+		}
+		if v.Kind() != reflect.Map {
+			if code, msg := check(v); code != "" {
+				errs = append(errs, FieldError{Field: name, Code: code, Message: msg})
+			}
+			continue
+		}
+		for _, key := range sortedKeys(v) {
+			if code, msg := check(v.MapIndex(key)); code != "" {
+				errs = append(errs, FieldError{Field: name + "." + key.String(), Code: code, Message: msg})
+			}
+		}
+	}
+	return errs
+}
+
+// DecodeRequest decodes the JSON object in the body of r into the struct v and
+// validates it. Unknown fields and values of the wrong type are reported along
+// with the failures of Validate in a single ValidationError.
+func DecodeRequest(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return DecodeJSON(body, v)
+}
+
+// DecodeJSON decodes and validates the JSON object body into the struct v,
+// reporting failures like DecodeRequest.
+func DecodeJSON(body []byte, v interface{}) error {
+	var err error
+	malformed := func(msg string) error {
+		return &ValidationError{Errors: []FieldError{{Code: CODE_MALFORMED, Message: msg}}}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return malformed("request body is required")
+	}
+
+	var members map[string]json.RawMessage
+	if err = json.Unmarshal(body, &members); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return malformed("request body must be a JSON object")
+		}
+		return malformed("request body is not valid JSON: " + err.Error())
+	}
+
+	var errs []FieldError
+	reported := map[string]bool{}
+	fields := jsonFields(reflect.Indirect(reflect.ValueOf(v)).Type())
+	for name := range members {
+		if _, ok := fields[name]; !ok {
+			errs = append(errs, FieldError{Field: name, Code: CODE_UNKNOWN_FIELD, Message: "is not a known field"})
+			reported[name] = true
+		}
+	}
+
+	// Unmarshal reports only the first value of the wrong type, but carries on
+	// decoding the others
+	if err = json.Unmarshal(body, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return malformed(err.Error())
+		}
+		name, _, _ := strings.Cut(typeErr.Field, ".")
+		errs = append(errs, FieldError{Field: name, Code: CODE_INVALID_TYPE, Message: "must be " + jsonType(typeErr.Type)})
+		reported[name] = true
+	}
+
+	errs = append(errs, validate(v, reported)...)
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// jsonFields returns the fields of the struct type t by their JSON names.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// jsonType describes the JSON values that decode into t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
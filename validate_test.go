@@ -0,0 +1,108 @@
+package trade_test
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gabriel-ross/trade"
+)
+
+type transfer struct {
+	Sender     string             `json:"sender" validate:"required"`
+	Recipient  string             `json:"recipient" validate:"required"`
+	Quantities map[string]float64 `json:"quantities" validate:"required,currencies,positive"`
+	Memo       string             `json:"memo"`
+}
+
+func (t transfer) Validate() []trade.FieldError {
+	return trade.ValidateTransfer("recipient", t.Sender, t.Recipient)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []trade.FieldError
+	}{
+		{"valid user", trade.User{Name: "Ada", Email: "ada@example.com", PhoneNumber: "+14155552671"}, nil},
+		{"optional fields", trade.User{Name: "Ada"}, nil},
+		{"invalid user", &trade.User{Name: " ", Email: "ada", PhoneNumber: "415-555-2671"}, []trade.FieldError{
+			{Field: "email", Code: trade.CODE_INVALID_EMAIL},
+			{Field: "name", Code: trade.CODE_REQUIRED},
+			{Field: "phoneNumber", Code: trade.CODE_INVALID_PHONE},
+		}},
+		{"display name email", trade.User{Name: "Ada", Email: "Ada <ada@example.com>"}, []trade.FieldError{
+			{Field: "email", Code: trade.CODE_INVALID_EMAIL},
+		}},
+		{"valid transfer", transfer{Sender: "1", Recipient: "2", Quantities: map[string]float64{"dollars": 1}}, nil},
+		{"invalid transfer", transfer{Sender: "accounts/1", Recipient: "1", Quantities: map[string]float64{"dollars": 0, "euros": 1}}, []trade.FieldError{
+			{Field: "quantities.dollars", Code: trade.CODE_NOT_POSITIVE},
+			{Field: "quantities.euros", Code: trade.CODE_UNKNOWN_CURRENCY},
+			{Field: "recipient", Code: trade.CODE_SAME_ACCOUNT},
+		}},
+		{"empty transfer", transfer{}, []trade.FieldError{
+			{Field: "quantities", Code: trade.CODE_REQUIRED},
+			{Field: "recipient", Code: trade.CODE_REQUIRED},
+			{Field: "sender", Code: trade.CODE_REQUIRED},
+		}},
+		{"negative balance", trade.Account{Owner: "users/1", Balances: map[string]float64{"apples": -1}}, []trade.FieldError{
+			{Field: "balances.apples", Code: trade.CODE_NEGATIVE},
+		}},
+	}
+	for _, tt := range tests {
+		if got := codes(trade.Validate(tt.value)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Validate returned %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       []trade.FieldError
+	}{
+		{"valid", `{"sender": "1", "recipient": "2", "quantities": {"apples": 2}, "memo": "rent"}`, nil},
+		{"empty body", ``, []trade.FieldError{{Code: trade.CODE_MALFORMED}}},
+		{"invalid JSON", `{"sender": `, []trade.FieldError{{Code: trade.CODE_MALFORMED}}},
+		{"not an object", `[1, 2]`, []trade.FieldError{{Code: trade.CODE_MALFORMED}}},
+		{"every failing field", `{"sendr": "1", "recipient": 2, "quantities": {"apples": -2}}`, []trade.FieldError{
+			{Field: "quantities.apples", Code: trade.CODE_NOT_POSITIVE},
+			{Field: "recipient", Code: trade.CODE_INVALID_TYPE},
+			{Field: "sender", Code: trade.CODE_REQUIRED},
+			{Field: "sendr", Code: trade.CODE_UNKNOWN_FIELD},
+		}},
+		{"wrong member type", `{"sender": "1", "recipient": "2", "quantities": {"apples": "2"}}`, []trade.FieldError{
+			{Field: "quantities", Code: trade.CODE_INVALID_TYPE},
+		}},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodPost, "/transactions", strings.NewReader(tt.body))
+		var v transfer
+		if got := codes(trade.DecodeRequest(r, &v)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: DecodeRequest returned %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// codes returns the fields and codes of the failures of the ValidationError
+// err, without their messages.
+func codes(err error) []trade.FieldError {
+	if err == nil {
+		return nil
+	}
+	var validationErr *trade.ValidationError
+	if !errors.As(err, &validationErr) {
+		return []trade.FieldError{{Message: err.Error()}}
+	}
+	out := make([]trade.FieldError, len(validationErr.Errors))
+	for i, fe := range validationErr.Errors {
+		if fe.Message == "" {
+			fe.Code = "no message"
+		}
+		out[i] = trade.FieldError{Field: fe.Field, Code: fe.Code}
+	}
+	return out
+}